@@ -0,0 +1,144 @@
+// /anthalib/driver/liquidhandling/airgaps.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+import (
+	"fmt"
+	"github.com/antha-lang/antha/antha/anthalib/wtype"
+	"github.com/antha-lang/antha/antha/anthalib/wunit"
+	"github.com/antha-lang/antha/antha/anthalib/wutil"
+)
+
+// what air gaps are aspirated and dispensed as, so nothing counts them
+// as a liquid
+const AirGap = "air"
+
+// how much air to take up either side of the liquid, policies ask for
+//	ASP_LEADING_AIR		air taken up before the liquid and let out
+//				after it to clear the tip
+//	ASP_TRAILING_AIR	air taken up after the liquid so nothing drips
+//				on the way, let out before dispensing
+// neither is taken if the tips can't hold both of them and the liquid
+func airGaps(pol LHPolicy, prms *wtype.LHChannelParameter, vols []*wunit.Volume) (leading, trailing float64) {
+	leading, _ = policy_float(pol, "ASP_LEADING_AIR")
+	trailing, _ = policy_float(pol, "ASP_TRAILING_AIR")
+
+	if leading < 0.0 {
+		leading = 0.0
+	}
+
+	if trailing < 0.0 {
+		trailing = 0.0
+	}
+
+	if leading+trailing == 0.0 || prms == nil {
+		return
+	}
+
+	max := prms.Maxvol.ConvertTo(wunit.ParsePrefixedUnit("ul"))
+
+	for _, v := range vols {
+		if v.ConvertTo(wunit.ParsePrefixedUnit("ul"))+leading+trailing > max {
+			wutil.Warn(fmt.Sprintf("%s tips can't hold %s with %v ul of air gaps, leaving them out", prms.Name, v.ToString(), leading+trailing))
+			return 0.0, 0.0
+		}
+	}
+
+	return
+}
+
+// how much air to blow out in ul after dispensing, policies ask for
+//	BLOWOUTVOLUME		in BLOWOUTVOLUMEUNIT, ul if not set
+// no more than the tips can hold is blown out
+func blowoutVolume(pol LHPolicy, prms *wtype.LHChannelParameter) float64 {
+	bov, _ := policy_float(pol, "BLOWOUTVOLUME")
+
+	if bov <= 0.0 {
+		return 0.0
+	}
+
+	unit, ok := pol["BLOWOUTVOLUMEUNIT"].(string)
+
+	if !ok {
+		unit = "ul"
+	}
+
+	v := wunit.NewVolume(bov, unit)
+	ul := v.ConvertTo(wunit.ParsePrefixedUnit("ul"))
+
+	if prms == nil {
+		return ul
+	}
+
+	max := prms.Maxvol.ConvertTo(wunit.ParsePrefixedUnit("ul"))
+
+	if ul > max {
+		wutil.Warn(fmt.Sprintf("%s tips can't blow out %s, blowing out %v ul", prms.Name, v.ToString(), max))
+		return max
+	}
+
+	return ul
+}
+
+// just above the wells, where we go in slowly from and let air out
+func wellTopMove(head, multi int, pos, wells, platetypes []string, wellvols []*wunit.Volume) *MoveInstruction {
+	mov := NewMoveInstruction()
+	mov.Head = head
+	mov.Pos = pos
+	mov.Plt = platetypes
+	mov.Well = wells
+	mov.WVolume = wellvols
+	for i := 0; i < multi; i++ {
+		mov.Reference = append(mov.Reference, 1)
+		mov.OffsetZ = append(mov.OffsetZ, 5.0)
+	}
+	return mov
+}
+
+func airAspirate(head, multi int, platetypes []string, ul float64) *AspirateInstruction {
+	asp := NewAspirateInstruction()
+	asp.Head = head
+	asp.Multi = multi
+	asp.Plt = platetypes
+	for i := 0; i < multi; i++ {
+		v := wunit.NewVolume(ul, "ul")
+		asp.Volume = append(asp.Volume, &v)
+		asp.What = append(asp.What, AirGap)
+		asp.LLF = append(asp.LLF, false)
+	}
+	return asp
+}
+
+func airDispense(head, multi int, platetypes []string, ul float64) *DispenseInstruction {
+	dsp := NewDispenseInstruction()
+	dsp.Head = head
+	dsp.Multi = multi
+	dsp.Plt = platetypes
+	for i := 0; i < multi; i++ {
+		v := wunit.NewVolume(ul, "ul")
+		dsp.Volume = append(dsp.Volume, &v)
+		dsp.What = append(dsp.What, AirGap)
+		dsp.LLF = append(dsp.LLF, false)
+	}
+	return dsp
+}
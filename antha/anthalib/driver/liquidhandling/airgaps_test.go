@@ -0,0 +1,176 @@
+// /anthalib/driver/liquidhandling/airgaps_test.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/antha-lang/antha/antha/anthalib/driver/liquidhandling"
+	"github.com/antha-lang/antha/antha/anthalib/factory"
+	"github.com/antha-lang/antha/antha/anthalib/wtype"
+	"github.com/antha-lang/antha/antha/anthalib/wunit"
+)
+
+func TestAirGapsAndBlowout(t *testing.T) {
+	params := factory.GetLiquidhandlerByType("GilsonPipetmax")
+	params.AddPlate("position_4", factory.GetPlateByType("DSW96"))
+	params.AddPlate("position_7", factory.GetPlateByType("pcrplate"))
+
+	channel := func(max float64) *wtype.LHChannelParameter {
+		minvol, maxvol := wunit.NewVolume(0.5, "ul"), wunit.NewVolume(max, "ul")
+		minspd, maxspd := wunit.NewFlowRate(0.1, "ml/min"), wunit.NewFlowRate(10.0, "ml/min")
+		return wtype.NewLHChannelParameter("test", &minvol, &maxvol, &minspd, &maxspd, 1, false, wtype.LHVChannel, 1)
+	}
+
+	// suck, blow and reset the way a single channel transfer does
+
+	transfer := func(policies *liquidhandling.LHPolicyRuleSet, what string, vol, max float64) ([]liquidhandling.TerminalRobotInstruction, string) {
+		v := wunit.NewVolume(vol, "ul")
+		fv := wunit.NewVolume(1000.0, "ul")
+		tv := wunit.NewVolume(20.0, "ul")
+		tp := liquidhandling.TransferParams{What: what, PltFrom: "position_4", PltTo: "position_7", WellFrom: "A1", WellTo: "A1", Volume: &v, FPlateType: "DSW96", TPlateType: "pcrplate", FVolume: &fv, TVolume: &tv, Channel: channel(max)}
+
+		suck := liquidhandling.NewSuckInstruction()
+		suck.AddTransferParams(tp)
+		blow := liquidhandling.NewBlowInstruction()
+		blow.AddTransferParams(tp)
+		reset := liquidhandling.NewResetInstruction()
+		reset.AddTransferParams(tp)
+
+		instrx := make([]liquidhandling.TerminalRobotInstruction, 0)
+		types := make([]string, 0)
+		for _, ins := range []liquidhandling.RobotInstruction{suck, blow, reset} {
+			switch ins := ins.(type) {
+			case *liquidhandling.SuckInstruction:
+				ins.Multi, ins.Head, ins.Prms = 1, 1, tp.Channel
+			case *liquidhandling.BlowInstruction:
+				ins.Multi, ins.Head, ins.Prms = 1, 1, tp.Channel
+			case *liquidhandling.ResetInstruction:
+				ins.Prms = tp.Channel
+			}
+			for _, r := range liquidhandling.NewRobotInstructionSet(ins).Generate(policies, params) {
+				instrx = append(instrx, r.(liquidhandling.TerminalRobotInstruction))
+				types = append(types, liquidhandling.Robotinstructionnames[r.InstructionType()])
+			}
+		}
+		return instrx, strings.Join(types, " ")
+	}
+
+	simulate := func(instrx []liquidhandling.TerminalRobotInstruction) []string {
+		ds, err := liquidhandling.SimulateInstructions(instrx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ds.Effects
+	}
+
+	// culture goes in under the surface and mustn't have air put into
+	// it, so the air gaps come out above the well
+
+	policies := liquidhandling.GetLHPolicyForTest()
+	policies.Policies["culture"]["ASP_LEADING_AIR"] = 5.0
+	policies.Policies["culture"]["ASP_TRAILING_AIR"] = 2
+
+	instrx, types := transfer(policies, "culture", 10.0, 200.0)
+
+	if e := "MOV ASP MOV SPS ASP SPS MOV ASP MOV DSP MOV SPS DSP SPS MOV DSP MOV BLO MOV PTZ"; types != e {
+		t.Errorf("expected %s got %s", e, types)
+	}
+
+	air := 0
+	for _, e := range simulate(instrx) {
+		if !strings.HasPrefix(e, "dispense") {
+			continue
+		}
+
+		if strings.Contains(e, "[air]") || strings.Contains(e, "[true]") {
+			air += 1
+			if !strings.Contains(e, "[A1] [1] ") {
+				t.Errorf("air should only come out above the well: %s", e)
+			}
+		} else if !strings.Contains(e, "[A1] [0] ") {
+			t.Errorf("culture should be dispensed from the bottom: %s", e)
+		}
+	}
+
+	if air != 3 {
+		t.Errorf("expected two air gaps and a blowout, got %d", air)
+	}
+
+	// even if the policy says to blow out in the liquid
+
+	policies.Policies["culture"]["BLOWOUTREFERENCE"] = 0
+
+	instrx, _ = transfer(policies, "culture", 10.0, 200.0)
+
+	for i, ins := range instrx {
+		if _, ok := ins.(*liquidhandling.BlowoutInstruction); ok {
+			if mov := instrx[i-1].(*liquidhandling.MoveInstruction); mov.Reference[0] != 1 || mov.OffsetZ[0] != 0.0 {
+				t.Errorf("expected to blow out at the top, got %v %v", mov.Reference, mov.OffsetZ)
+			}
+		}
+	}
+
+	// otherwise the leading air follows the liquid straight out
+
+	policies.Policies["culture"]["NO_AIR_DISPENSE"] = false
+
+	if _, types = transfer(policies, "culture", 10.0, 200.0); !strings.Contains(types, "MOV SPS DSP SPS DSP MOV BLO") {
+		t.Errorf("expected the leading air gap where we dispensed, got %s", types)
+	}
+
+	// no air gaps at all if the tip can't take them
+
+	if instrx, types = transfer(policies, "culture", 5.0, 10.0); types != "MOV SPS ASP SPS MOV SPS DSP SPS MOV BLO MOV PTZ" {
+		t.Errorf("expected no air gaps in a full tip, got %s", types)
+	}
+
+	// nor blow out more than they hold
+
+	if blo := instrx[9].(*liquidhandling.BlowoutInstruction); blo.Volume[0].ConvertTo(wunit.ParsePrefixedUnit("ul")) != 10.0 {
+		t.Errorf("expected to blow out no more than the 10 ul the tip holds, got %s", blo.Volume[0].ToString())
+	}
+
+	// glycerol touches off after the blowout, no blowout volume means
+	// no blowout
+
+	policies = liquidhandling.GetLHPolicyForTest()
+	policies.Policies["glycerol"]["TOUCHOFFSET"] = 1.5
+
+	instrx, types = transfer(policies, "glycerol", 10.0, 200.0)
+
+	if !strings.HasSuffix(types, "DSP SPS WAI MOV BLO MOV MOV PTZ") {
+		t.Errorf("expected blowout then touch off, got %s", types)
+	}
+
+	if mov := instrx[len(instrx)-3].(*liquidhandling.MoveInstruction); mov.Reference[0] != 0 || mov.OffsetZ[0] != 1.5 {
+		t.Errorf("expected to touch off 1.5 mm off the bottom, got %v %v", mov.Reference, mov.OffsetZ)
+	}
+
+	policies.Policies["glycerol"]["BLOWOUTVOLUME"] = 0.0
+
+	if _, types = transfer(policies, "glycerol", 10.0, 200.0); strings.Contains(types, "BLO") {
+		t.Errorf("expected no blowout, got %s", types)
+	}
+}
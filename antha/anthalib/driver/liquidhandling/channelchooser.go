@@ -0,0 +1,275 @@
+// /anthalib/driver/liquidhandling/channelchooser.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+import (
+	"fmt"
+	"github.com/antha-lang/antha/antha/anthalib/wtype"
+	"github.com/antha-lang/antha/antha/anthalib/wunit"
+	"sort"
+)
+
+// one way of doing a transfer: a loaded head, the adaptor it needs to
+// have on and the tips to use. Channel is what all three can do
+// together, i.e. the adaptor's range cut down to the tip's
+type LHChannelChoice struct {
+	Head    int
+	Adaptor *wtype.LHAdaptor
+	Tiptype string
+	Channel *wtype.LHChannelParameter
+	Change  bool // the adaptor isn't on the head yet
+	tip     *wtype.LHTip
+}
+
+func (c LHChannelChoice) SameAs(o LHChannelChoice) bool {
+	return c.Head == o.Head && c.Tiptype == o.Tiptype && adaptorName(c.Adaptor) == adaptorName(o.Adaptor)
+}
+
+// how many goes it takes to move vol
+func (c LHChannelChoice) Moves(vol *wunit.Volume) int {
+	return len(TransferVolumes(*vol, *c.Channel.Minvol, *c.Channel.Maxvol))
+}
+
+func adaptorName(ad *wtype.LHAdaptor) string {
+	if ad == nil {
+		return ""
+	}
+	return ad.Name
+}
+
+// adaptors on the shelf, i.e. not on any loaded head
+func (lhp *LHProperties) spareAdaptors() []*wtype.LHAdaptor {
+	loaded := make(map[string]bool, len(lhp.HeadsLoaded))
+	for _, head := range lhp.HeadsLoaded {
+		loaded[adaptorName(head.Adaptor)] = true
+	}
+
+	ret := make([]*wtype.LHAdaptor, 0, len(lhp.Adaptors))
+	for _, ad := range lhp.Adaptors {
+		if !loaded[ad.Name] {
+			ret = append(ret, ad)
+		}
+	}
+	return ret
+}
+
+// every combination of head, adaptor and tip which can move vol with at
+// least multi channels at once
+func ChannelOptions(vol *wunit.Volume, prms *LHProperties, multi int) []LHChannelChoice {
+	ret := make([]LHChannelChoice, 0, 4)
+
+	spare := prms.spareAdaptors()
+
+	for h, head := range prms.HeadsLoaded {
+		adaptors := []*wtype.LHAdaptor{head.Adaptor}
+		adaptors = append(adaptors, spare...)
+
+		for a, ad := range adaptors {
+			params := head.Params
+			if ad != nil {
+				params = ad.Params
+			} else if a > 0 {
+				continue
+			}
+
+			if params.Multi < multi {
+				continue
+			}
+
+			for _, tip := range prms.Tips {
+				ch := params.Dup()
+				ch.Head = head.Params.Head
+
+				if tip.MinVol.GreaterThan(ch.Minvol) {
+					ch.Minvol = tip.MinVol
+				}
+
+				if tip.MaxVol.LessThan(ch.Maxvol) {
+					ch.Maxvol = tip.MaxVol
+				}
+
+				if ch.Minvol.GreaterThan(ch.Maxvol) || ch.Minvol.GreaterThan(vol) {
+					continue
+				}
+
+				ret = append(ret, LHChannelChoice{Head: h, Adaptor: ad, Tiptype: tip.Type, Channel: ch, Change: a > 0, tip: tip})
+			}
+		}
+	}
+
+	return ret
+}
+
+// picks how to move vol: the fewest goes, then the smallest channel
+// and tips which can do it, then the tips asked for (the first in
+// prms.Tips). Changing an adaptor only happens if nothing already on a
+// head can do the job or the policy's ADAPTOR_CHANGE_MOVES says it
+// saves enough goes; of the adaptors which do it in the fewest goes the
+// one put on is the one which can do most of what's left of the plan so
+// it has the best chance of staying on
+func ChooseChannel(vol *wunit.Volume, prms *LHProperties, pol LHPolicy, multi int, current *LHChannelChoice) LHChannelChoice {
+	options := ChannelOptions(vol, prms, multi)
+
+	if len(options) == 0 {
+		panic(fmt.Sprintf("NO CHANNEL CAN MOVE %s", vol.ToString()))
+	}
+
+	preferred := ""
+	if len(prms.Tips) > 0 {
+		preferred = prms.Tips[0].Type
+	}
+
+	better := func(a, b LHChannelChoice) bool {
+		if ma, mb := a.Moves(vol), b.Moves(vol); ma != mb {
+			return ma < mb
+		}
+		if !a.Channel.Maxvol.EqualTo(b.Channel.Maxvol) {
+			return a.Channel.Maxvol.LessThan(b.Channel.Maxvol)
+		}
+		if !a.tip.MaxVol.EqualTo(b.tip.MaxVol) {
+			return a.tip.MaxVol.LessThan(b.tip.MaxVol)
+		}
+		if (a.Tiptype == preferred) != (b.Tiptype == preferred) {
+			return a.Tiptype == preferred
+		}
+		if a.Adaptor != nil && b.Adaptor != nil && !a.Adaptor.Params.Maxvol.EqualTo(b.Adaptor.Params.Maxvol) {
+			return a.Adaptor.Params.Maxvol.LessThan(b.Adaptor.Params.Maxvol)
+		}
+		return current != nil && a.SameAs(*current) && !b.SameAs(*current)
+	}
+
+	var stay, change *LHChannelChoice
+
+	for i, o := range options {
+		if !o.Change {
+			if stay == nil || better(o, *stay) {
+				stay = &options[i]
+			}
+		}
+	}
+
+	// adaptors are scored on the whole of the rest of the plan
+
+	coverage := make(map[string]int)
+	for _, o := range options {
+		if o.Change && coverage[adaptorName(o.Adaptor)] == 0 {
+			coverage[adaptorName(o.Adaptor)] = prms.expectedCoverage(o.Adaptor)
+		}
+	}
+
+	for i, o := range options {
+		if !o.Change {
+			continue
+		}
+		if change == nil {
+			change = &options[i]
+			continue
+		}
+		if ma, mb := o.Moves(vol), change.Moves(vol); ma != mb {
+			if ma < mb {
+				change = &options[i]
+			}
+			continue
+		}
+		ca, cb := coverage[adaptorName(o.Adaptor)], coverage[adaptorName(change.Adaptor)]
+		if ca > cb || (ca == cb && better(o, *change)) {
+			change = &options[i]
+		}
+	}
+
+	if stay == nil {
+		return *change
+	}
+
+	if change != nil {
+		saving, _ := pol["ADAPTOR_CHANGE_MOVES"].(int)
+		if saving > 0 && stay.Moves(vol)-change.Moves(vol) >= saving {
+			return *change
+		}
+	}
+
+	return *stay
+}
+
+// puts the adaptor for choice c on its head, if it isn't already there,
+// and returns the instruction to do so
+func (lhp *LHProperties) ChangeAdaptor(c LHChannelChoice) RobotInstruction {
+	if !c.Change {
+		return nil
+	}
+
+	head := lhp.HeadsLoaded[c.Head]
+	old := head.Adaptor
+	head.Adaptor = c.Adaptor
+
+	ins := NewStateChangeInstruction(head.Params, c.Channel)
+	if old != nil {
+		ins.OldState = old.Params
+	}
+	ins.Head = c.Channel.Head
+	ins.OldAdaptor = adaptorName(old)
+	ins.NewAdaptor = adaptorName(c.Adaptor)
+	return ins
+}
+
+// tells the channel chooser what the plan has to move so that adaptors
+// can be chosen to last
+func (lhp *LHProperties) ExpectTransfers(vols []*wunit.Volume) {
+	lhp.expected = make([]float64, 0, len(vols))
+	for _, v := range vols {
+		lhp.expected = append(lhp.expected, v.ConvertTo(wunit.ParsePrefixedUnit("ul")))
+	}
+	sort.Float64s(lhp.expected)
+}
+
+// crosses a transfer off the list of what's expected
+func (lhp *LHProperties) transferDone(vol *wunit.Volume) {
+	v := vol.ConvertTo(wunit.ParsePrefixedUnit("ul"))
+	i := sort.SearchFloat64s(lhp.expected, v)
+
+	if i < len(lhp.expected) && lhp.expected[i]-v < 0.000001 {
+		lhp.expected = append(lhp.expected[:i], lhp.expected[i+1:]...)
+	}
+}
+
+// how many of the transfers still expected the adaptor can do in one go
+func (lhp *LHProperties) expectedCoverage(ad *wtype.LHAdaptor) int {
+	n := 0
+	for _, v := range lhp.expected {
+		vol := wunit.NewVolume(v, "ul")
+		for _, tip := range lhp.Tips {
+			min, max := ad.Params.Minvol, ad.Params.Maxvol
+			if tip.MinVol.GreaterThan(min) {
+				min = tip.MinVol
+			}
+			if tip.MaxVol.LessThan(max) {
+				max = tip.MaxVol
+			}
+			if !min.GreaterThan(&vol) && !max.LessThan(&vol) {
+				n += 1
+				break
+			}
+		}
+	}
+	return n
+}
@@ -0,0 +1,112 @@
+// /anthalib/driver/liquidhandling/channelchooser_test.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/antha-lang/antha/antha/anthalib/driver/liquidhandling"
+	"github.com/antha-lang/antha/antha/anthalib/factory"
+	"github.com/antha-lang/antha/antha/anthalib/wunit"
+)
+
+func TestChannelChooser(t *testing.T) {
+	gilson := factory.GetLiquidhandlerByType("GilsonPipetmax")
+	pol := liquidhandling.MakeDefaultPolicy()
+
+	choose := func(prms *liquidhandling.LHProperties, v float64, current *liquidhandling.LHChannelChoice) (liquidhandling.LHChannelChoice, wunit.Volume) {
+		vol := wunit.NewVolume(v, "ul")
+		return liquidhandling.ChooseChannel(&vol, prms, pol, 1, current), vol
+	}
+
+	// small volumes go to the low volume head with the smallest tips,
+	// bigger ones to the high volume head
+
+	for _, tc := range []struct {
+		vol   float64
+		head  int
+		tip   string
+		moves int
+	}{
+		{0.5, 1, "Gilson10", 1},
+		{5.0, 1, "Gilson10", 1},
+		{40.0, 1, "Gilson50", 1},
+		{150.0, 0, "Gilson200", 1},
+		{1000.0, 0, "Gilson200", 6},
+	} {
+		c, vol := choose(gilson, tc.vol, nil)
+
+		if c.Head != tc.head || c.Tiptype != tc.tip || c.Change || c.Moves(&vol) > tc.moves {
+			t.Errorf("%v ul should be done on head %d with %s in %d moves, got head %d with %s in %d moves", tc.vol, tc.head, tc.tip, tc.moves, c.Head, c.Tiptype, c.Moves(&vol))
+		}
+	}
+
+	// the manual handler has to change adaptor to do small volumes and
+	// puts on the one which will do the most of the rest of the plan
+
+	manual := factory.GetLiquidhandlerByType("Manual")
+	for _, tt := range []string{"CyBio1000", "Gilson200", "Gilson10"} {
+		manual.Tips = append(manual.Tips, factory.GetTipboxByType(tt).Tips[0][0])
+	}
+
+	vols := make([]*wunit.Volume, 0, 4)
+	for _, v := range []float64{5.0, 15.0, 15.0, 15.0} {
+		vol := wunit.NewVolume(v, "ul")
+		vols = append(vols, &vol)
+	}
+	manual.ExpectTransfers(vols)
+
+	c, _ := choose(manual, 5.0, nil)
+
+	if !c.Change || c.Adaptor.Name != "P20" {
+		t.Fatalf("5 ul should need a change to the P20 for the rest of the plan, got %v to %s", c.Change, c.Adaptor.Name)
+	}
+
+	ins := manual.ChangeAdaptor(c)
+	types := make([]string, 0, 3)
+	for _, r := range ins.Generate(nil, manual) {
+		types = append(types, liquidhandling.Robotinstructionnames[r.InstructionType()])
+		for _, r2 := range r.Generate(nil, manual) {
+			types = append(types, liquidhandling.Robotinstructionnames[r2.InstructionType()])
+		}
+	}
+
+	if manual.HeadsLoaded[0].Adaptor.Name != "P20" || strings.Join(types, ",") != "CHA,UAD,LAD" {
+		t.Errorf("changing adaptor should swap the P1000 for the P20, got %s and %v", manual.HeadsLoaded[0].Adaptor.Name, types)
+	}
+
+	// once it's on it stays on unless changing saves enough moves
+
+	c, _ = choose(manual, 15.0, &c)
+
+	if c.Change || c.Adaptor.Name != "P20" {
+		t.Errorf("15 ul should stay on the P20, got %v to %s", c.Change, c.Adaptor.Name)
+	}
+
+	c, _ = choose(manual, 100.0, &c)
+
+	if !c.Change || c.Adaptor.Name != "P200" {
+		t.Errorf("100 ul is 5 goes on the P20 and should change to the P200, got %v to %s", c.Change, c.Adaptor.Name)
+	}
+}
@@ -31,6 +31,7 @@ package liquidhandling
 import (
 	"errors"
 	"fmt"
+	"github.com/antha-lang/antha/antha/anthalib/driver"
 	"github.com/antha-lang/antha/antha/anthalib/wtype"
 	"github.com/antha-lang/antha/antha/anthalib/wunit"
 	//	"github.com/antha-lang/antha/antha/anthalib/wutil"
@@ -49,6 +50,7 @@ type TransferParams struct {
 	FVolume    *wunit.Volume
 	TVolume    *wunit.Volume
 	Channel    *wtype.LHChannelParameter
+	TipType    string
 }
 
 func (tp TransferParams) ToString() string {
@@ -83,55 +85,6 @@ func NewMultiTransferParams(multi int) MultiTransferParams {
 	return v
 }
 
-func ChooseChannel(vol *wunit.Volume, prms *LHProperties) (*wtype.LHChannelParameter, string) {
-	// very quick and dirty, basically just makes sure the minimum volume is
-	// below the required volume
-
-	var headchosen *wtype.LHHead = nil
-
-	for _, head := range prms.HeadsLoaded {
-		if head.Params.Minvol.LessThan(vol) || head.Params.Minvol.EqualTo(vol) {
-			headchosen = head
-			break
-		}
-	}
-
-	if headchosen == nil {
-		panic("NO HEAD CHOSEN")
-	}
-
-	// check if we need to change adaptor
-
-	if headchosen.Adaptor.Params.Minvol.GreaterThan(vol) {
-		panic("ADAPTOR CHANGE NEEDED BUT NOT IMPLEMENTED")
-	}
-
-	// now get the requisite tip
-	// this is just a big bowl of wrong... </JeffGreene>
-	// need to make this more dependent on what's actually there
-	tiptype := ""
-	// get the closest to the min vol
-	d := 99999.0
-	for _, tip := range prms.Tips {
-		//if tip.MinVol.LessThan(vol) || tip.MinVol.EqualTo(vol) {
-		//	tiptype = tip.Type
-		//}
-
-		dif := vol.ConvertTo(tip.MinVol.Unit()) - tip.MinVol.RawValue()
-		if dif >= 0.0 && dif < d {
-			tiptype = tip.Type
-			d = dif
-		}
-
-	}
-
-	if tiptype == "" {
-		panic("NO TIP TYPE FOUND")
-	}
-
-	return headchosen.GetParams(), tiptype
-}
-
 type TransferInstruction struct {
 	Type       int
 	What       []string
@@ -158,7 +111,7 @@ func (ti *TransferInstruction) ToString() string {
 }
 
 func (ti *TransferInstruction) ParamSet(n int) TransferParams {
-	return TransferParams{ti.What[n], ti.PltFrom[n], ti.PltTo[n], ti.WellFrom[n], ti.WellTo[n], ti.Volume[n], ti.FPlateType[n], ti.TPlateType[n], ti.FVolume[n], ti.TVolume[n], nil}
+	return TransferParams{ti.What[n], ti.PltFrom[n], ti.PltTo[n], ti.WellFrom[n], ti.WellTo[n], ti.Volume[n], ti.FPlateType[n], ti.TPlateType[n], ti.FVolume[n], ti.TVolume[n], nil, ""}
 }
 
 func NewTransferInstruction(what, pltfrom, pltto, wellfrom, wellto, fplatetype, tplatetype []string, volume, fvolume, tvolume []*wunit.Volume) *TransferInstruction {
@@ -258,69 +211,180 @@ func (vs VolumeSet) MaxMultiTransferVolume() *wunit.Volume {
 	return ret
 }
 
-// CHECK PLATES
-func (ins *TransferInstruction) GetParallelSetsFor(channel *wtype.LHChannelParameter) [][]int {
+// finds sets of transfers which a multichannel head can do in one go:
+// the destinations must be the wells the channels line up with on the
+// same plate, the sources must either be likewise or share a single
+// well in a trough-type plate, and the liquid classes must match.
+// Volumes must be equal unless the channels can move independently.
+// prms is used to look up source plate geometry, if it is nil troughs are
+// not considered
+func (ins *TransferInstruction) GetParallelSetsFor(channel *wtype.LHChannelParameter, prms *LHProperties) [][]int {
 	// if the channel is not multi just return nil
 
-	if channel.Multi == 1 {
+	if channel.Multi <= 1 {
 		return nil
 	}
 
 	ret := make([][]int, 0)
 
-	tfrs := make([][][]int, 12)
+	// hash transfers by their destination
 
-	// hash out all transfers which are multiable
+	bydest := make(map[string][]int, len(ins.What))
 
 	for i, _ := range ins.What {
-		var tcoord int = -1
-		var fcoord int = -1
-		wcFrom := wtype.MakeWellCoordsA1(ins.WellFrom[i])
-		wcTo := wtype.MakeWellCoordsA1(ins.WellTo[i])
-
-		if channel.Orientation == wtype.LHVChannel {
-			// we hash on the X
-			tcoord = wcTo.X
-			fcoord = wcFrom.X
-		} else {
-			// horizontal orientation
-			// hash on the Y
-			tcoord = wcTo.Y
-			fcoord = wcFrom.Y
-		}
+		k := ins.PltTo[i] + ":" + ins.WellTo[i]
+		bydest[k] = append(bydest[k], i)
+	}
 
-		a := tfrs[fcoord]
+	used := make([]bool, len(ins.What))
 
-		if a == nil {
-			a = make([][]int, 12)
+	for i, _ := range ins.What {
+		if used[i] {
+			continue
 		}
 
-		t := a[tcoord]
+		set := ins.parallelSetFrom(i, channel, prms, bydest, used)
 
-		if t == nil {
-			t = make([]int, 0, 12)
+		if set == nil {
+			continue
+		}
+
+		for _, s := range set {
+			used[s] = true
 		}
 
-		t = append(t, i)
-		a[tcoord] = t
-		tfrs[fcoord] = a
+		ret = append(ret, set)
 	}
 
-	// now have we got any which are multiable?
+	if len(ret) == 0 {
+		return nil
+	}
 
-	for _, a := range tfrs {
-		for _, t := range a {
-			if len(t) == channel.Multi {
-				ret = append(ret, t)
+	return ret
+}
+
+// tries to build a set of transfers for the channel with transfer i on the
+// first channel
+func (ins *TransferInstruction) parallelSetFrom(i int, channel *wtype.LHChannelParameter, prms *LHProperties, bydest map[string][]int, used []bool) []int {
+	set := make([]int, 0, channel.Multi)
+	set = append(set, i)
+
+	wcFrom := wtype.MakeWellCoordsA1(ins.WellFrom[i])
+	wcTo := wtype.MakeWellCoordsA1(ins.WellTo[i])
+
+	fromplate := deckPlate(prms, ins.PltFrom[i])
+	trough := isTrough(fromplate, channel)
+
+	// how many wells apart the channels are on each plate
+
+	fstep := channelStep(fromplate, channel.Orientation)
+	tstep := channelStep(deckPlate(prms, ins.PltTo[i]), channel.Orientation)
+
+	if tstep == 0 || fstep == 0 && !trough {
+		return nil
+	}
+
+	for c := 1; c < channel.Multi; c++ {
+		to := offsetWellCoords(wcTo, c*tstep, channel.Orientation)
+		from := offsetWellCoords(wcFrom, c*fstep, channel.Orientation)
+
+		if trough {
+			from = wcFrom
+		}
+
+		found := -1
+
+		for _, j := range bydest[ins.PltTo[i]+":"+to.FormatA1()] {
+			if used[j] || ins.What[j] != ins.What[i] || ins.PltFrom[j] != ins.PltFrom[i] {
+				continue
+			}
+
+			if ins.WellFrom[j] != from.FormatA1() {
+				continue
+			}
+
+			if !channel.Independent && !sameVolume(ins.Volume[i], ins.Volume[j]) {
+				continue
 			}
+
+			found = j
+			break
+		}
+
+		if found == -1 {
+			return nil
 		}
+
+		set = append(set, found)
 	}
 
-	if len(ret) == 0 {
+	return set
+}
+
+// multichannel heads have their channels 9 mm apart, the well spacing
+// of a 96 well plate
+const channelPitch = 9.0
+
+// the plate at pos, nil if there isn't one or we don't know
+func deckPlate(prms *LHProperties, pos string) *wtype.LHPlate {
+	if prms == nil {
 		return nil
 	}
 
-	return ret
+	return prms.Plates[pos]
+}
+
+// is the plate a trough as seen by the channel i.e. does a single well
+// span all the channels
+func isTrough(plate *wtype.LHPlate, channel *wtype.LHChannelParameter) bool {
+	if plate == nil {
+		return false
+	}
+
+	if channel.Orientation == wtype.LHVChannel {
+		return plate.WlsY == 1
+	}
+
+	return plate.WlsX == 1
+}
+
+// how many wells along the plate one channel is from the next, 0 if
+// they don't line up with the wells. Plates we don't know or which
+// don't say how far apart their wells are are taken to match the head
+func channelStep(plate *wtype.LHPlate, orientation int) int {
+	if plate == nil {
+		return 1
+	}
+
+	pitch := plate.WellXOffset
+
+	if orientation == wtype.LHVChannel {
+		pitch = plate.WellYOffset
+	}
+
+	if pitch <= 0.0 {
+		return 1
+	}
+
+	step := channelPitch / pitch
+	n := math.Floor(step + 0.5)
+
+	if n < 1.0 || math.Abs(step-n) > 0.01 {
+		return 0
+	}
+
+	return int(n)
+}
+
+func offsetWellCoords(wc wtype.WellCoords, n, orientation int) wtype.WellCoords {
+	if orientation == wtype.LHVChannel {
+		return wtype.WellCoords{X: wc.X, Y: wc.Y + n}
+	}
+	return wtype.WellCoords{X: wc.X + n, Y: wc.Y}
+}
+
+func sameVolume(a, b *wunit.Volume) bool {
+	return math.Abs(a.ConvertTo(b.Unit())-b.RawValue()) < 0.000001
 }
 
 // helper thing
@@ -368,92 +432,145 @@ func (vs VolumeSet) GetACopy() []*wunit.Volume {
 	return r
 }
 
-func (ins *TransferInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties) []RobotInstruction {
+// splits out the transfers which a multichannel head can do together
+// if the policy allows, returning a block of these plus a transfer
+// instruction for whatever is left, either of which may be nil if
+// empty. Each set is done at the smallest volume in it, anything more
+// independent channels need is left over. Volumes on ins are kept up to
+// date with what the block does
+func (ins *TransferInstruction) MultichannelBlock(policy *LHPolicyRuleSet, prms *LHProperties) (*MultiChannelBlockInstruction, *TransferInstruction) {
 	pol := policy.GetPolicyFor(ins)
 
-	ret := make([]RobotInstruction, 0)
+	can_multi, ok := pol["CAN_MULTI"].(bool)
 
-	// if we can multi we do this first
+	multichannel := ChooseMultiChannel(prms)
 
-	if pol["CAN_MULTI"].(bool) {
-		// break out the sets of parallel instructions
-
-		// fix this HARD CODE here
-		parallelsets := ins.GetParallelSetsFor(prms.HeadsLoaded[0].Params)
-		mci := NewMultiChannelBlockInstruction()
-		mci.Multi = prms.HeadsLoaded[0].Params.Multi // TODO Remove Hard code here
-		mci.Prms = prms.HeadsLoaded[0].Params        // TODO Remove Hard code here
-
-		for _, set := range parallelsets {
-			// assemble the info
-
-			vols := NewVolumeSet(len(set))
-			fvols := NewVolumeSet(len(set))
-			tvols := NewVolumeSet(len(set))
-			What := make([]string, len(set))
-			PltFrom := make([]string, len(set))
-			PltTo := make([]string, len(set))
-			WellFrom := make([]string, len(set))
-			WellTo := make([]string, len(set))
-			FPlateType := make([]string, len(set))
-			TPlateType := make([]string, len(set))
-
-			for i, s := range set {
-				vols.Vols[i] = wunit.CopyVolume(ins.Volume[s])
-				fvols.Vols[i] = wunit.CopyVolume(ins.FVolume[s])
-				tvols.Vols[i] = wunit.CopyVolume(ins.TVolume[s])
-				What[i] = ins.What[s]
-				PltFrom[i] = ins.PltFrom[s]
-				PltTo[i] = ins.PltTo[s]
-				WellFrom[i] = ins.WellFrom[s]
-				WellTo[i] = ins.WellTo[s]
-				FPlateType[i] = ins.FPlateType[s]
-				TPlateType[i] = ins.TPlateType[s]
-			}
+	if !ok || !can_multi || multichannel == nil {
+		return nil, ins
+	}
 
-			// get the max transfer volume
+	// break out the sets of parallel instructions
 
-			maxvol := vols.MaxMultiTransferVolume()
+	parallelsets := ins.GetParallelSetsFor(multichannel, prms)
 
-			// now set the vols for the transfer and remove this from the instruction's volume
+	if len(parallelsets) == 0 {
+		return nil, ins
+	}
 
-			for i, _ := range vols.Vols {
-				vols.Vols[i] = wunit.CopyVolume(maxvol)
-				ins.Volume[set[i]].Subtract(maxvol)
+	mci := NewMultiChannelBlockInstruction()
+	mci.Multi = multichannel.Multi
+	mci.Prms = multichannel
 
-				// set the from and to volumes for the relevant part of the instruction
-				// NB -- this is a design issue which should probably be fixed: at the moment
-				// if we have two instructions which refer to the same underlying well their
-				// volume levels will not be in sync
-				// therefore this implementation is not correct as regards changes of underlying
-				// state
-				//... instead the right thing would be for all of these instructions to reference
-				// plate objects instead - this will work OK as long as we have a shared memory
-				// system... otherwise we'll need to use channels
-				ins.FVolume[set[i]].Subtract(maxvol)
-				ins.TVolume[set[i]].Add(maxvol)
-			}
+	for _, set := range parallelsets {
+		// assemble the info
 
-			tp := NewMultiTransferParams(mci.Multi)
-			tp.What = What
-			tp.Volume = vols.Vols
-			tp.FVolume = fvols.Vols
-			tp.TVolume = tvols.Vols
-			tp.PltFrom = PltFrom
-			tp.PltTo = PltTo
-			tp.WellFrom = WellFrom
-			tp.WellTo = WellTo
-			tp.FPlateType = FPlateType
-			tp.TPlateType = TPlateType
-
-			mci.AddTransferParams(tp)
+		vols := NewVolumeSet(len(set))
+		fvols := NewVolumeSet(len(set))
+		tvols := NewVolumeSet(len(set))
+		What := make([]string, len(set))
+		PltFrom := make([]string, len(set))
+		PltTo := make([]string, len(set))
+		WellFrom := make([]string, len(set))
+		WellTo := make([]string, len(set))
+		FPlateType := make([]string, len(set))
+		TPlateType := make([]string, len(set))
+
+		for i, s := range set {
+			vols.Vols[i] = wunit.CopyVolume(ins.Volume[s])
+			fvols.Vols[i] = wunit.CopyVolume(ins.FVolume[s])
+			tvols.Vols[i] = wunit.CopyVolume(ins.TVolume[s])
+			What[i] = ins.What[s]
+			PltFrom[i] = ins.PltFrom[s]
+			PltTo[i] = ins.PltTo[s]
+			WellFrom[i] = ins.WellFrom[s]
+			WellTo[i] = ins.WellTo[s]
+			FPlateType[i] = ins.FPlateType[s]
+			TPlateType[i] = ins.TPlateType[s]
 		}
 
-		if len(parallelsets) > 0 {
-			ret = append(ret, mci)
+		// get the max transfer volume
+
+		maxvol := vols.MaxMultiTransferVolume()
+
+		// now set the vols for the transfer and remove this from the instruction's volume
+
+		for i, _ := range vols.Vols {
+			vols.Vols[i] = wunit.CopyVolume(maxvol)
+			ins.Volume[set[i]].Subtract(maxvol)
+
+			// set the from and to volumes for the relevant part of the instruction
+			// NB -- this is a design issue which should probably be fixed: at the moment
+			// if we have two instructions which refer to the same underlying well their
+			// volume levels will not be in sync
+			// therefore this implementation is not correct as regards changes of underlying
+			// state
+			//... instead the right thing would be for all of these instructions to reference
+			// plate objects instead - this will work OK as long as we have a shared memory
+			// system... otherwise we'll need to use channels
+			ins.FVolume[set[i]].Subtract(maxvol)
+			ins.TVolume[set[i]].Add(maxvol)
 		}
+
+		tp := NewMultiTransferParams(mci.Multi)
+		tp.What = What
+		tp.Volume = vols.Vols
+		tp.FVolume = fvols.Vols
+		tp.TVolume = tvols.Vols
+		tp.PltFrom = PltFrom
+		tp.PltTo = PltTo
+		tp.WellFrom = WellFrom
+		tp.WellTo = WellTo
+		tp.FPlateType = FPlateType
+		tp.TPlateType = TPlateType
+
+		mci.AddTransferParams(tp)
+	}
+
+	// now whatever is left over
+
+	rest := &TransferInstruction{Type: TFR}
+
+	for i, _ := range ins.What {
+		if ins.Volume[i].LessThanFloat(0.001) {
+			continue
+		}
+
+		rest.What = append(rest.What, ins.What[i])
+		rest.PltFrom = append(rest.PltFrom, ins.PltFrom[i])
+		rest.PltTo = append(rest.PltTo, ins.PltTo[i])
+		rest.WellFrom = append(rest.WellFrom, ins.WellFrom[i])
+		rest.WellTo = append(rest.WellTo, ins.WellTo[i])
+		rest.FPlateType = append(rest.FPlateType, ins.FPlateType[i])
+		rest.TPlateType = append(rest.TPlateType, ins.TPlateType[i])
+		rest.Volume = append(rest.Volume, ins.Volume[i])
+		rest.FVolume = append(rest.FVolume, ins.FVolume[i])
+		rest.TVolume = append(rest.TVolume, ins.TVolume[i])
+	}
+
+	if len(rest.What) == 0 {
+		return mci, nil
 	}
 
+	return mci, rest
+}
+
+func (ins *TransferInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties) []RobotInstruction {
+	ret := make([]RobotInstruction, 0)
+
+	// if we can multi we do this first
+
+	mci, rest := ins.MultichannelBlock(policy, prms)
+
+	if mci != nil {
+		ret = append(ret, mci)
+	}
+
+	if rest == nil {
+		return ret
+	}
+
+	ins = rest
+
 	// mop up all the single instructions which are left
 	sci := NewSingleChannelBlockInstruction()
 	sci.Prms = prms.HeadsLoaded[0].Params // TODO Fix Hard Code Here
@@ -482,7 +599,10 @@ func (ins *TransferInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProper
 		ins.FVolume[i].Subtract(ins.Volume[i])
 		ins.TVolume[i].Add(ins.Volume[i])
 	}
-	ret = append(ret, sci)
+
+	if len(sci.What) > 0 {
+		ret = append(ret, sci)
+	}
 	return ret
 }
 
@@ -561,6 +681,8 @@ func (ins *SingleChannelBlockInstruction) GetParameter(name string) interface{}
 		return ins.TVolume
 	case "TOPLATETYPE":
 		return ins.TPlateType
+	case "MULTI":
+		return 1
 	case "INSTRUCTIONTYPE":
 		return ins.InstructionType()
 	}
@@ -570,25 +692,82 @@ func (ins *SingleChannelBlockInstruction) GetParameter(name string) interface{}
 func (ins *SingleChannelBlockInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties) []RobotInstruction {
 	pol := policy.GetPolicyFor(ins)
 	ret := make([]RobotInstruction, 0)
-	// get tips
-	channel, tiptype := ChooseChannel(ins.Volume[0], prms)
-	ret = append(ret, GetTips(tiptype, prms, channel, 1, false))
-	n_tip_uses := 0
+	tracker := prms.TipTracking()
+
+	// tips are only picked up when we need them: a tip which has been
+	// in anything other than the source has to be changed, as does one
+	// which isn't the right one for the volume
+	var tip *LHTipHistory
+	var channel LHChannelChoice
+	var current *LHChannelChoice
+
+	// change tips if we need to
+	changetips := func(t int, newchannel LHChannelChoice) {
+		if tip.CanAspirate(tracker, ins.What[t], ins.PltFrom[t], ins.WellFrom[t], pol) && newchannel.SameAs(channel) {
+			return
+		}
+		if tip != nil {
+			ret = append(ret, DropTips(channel.Tiptype, prms, channel.Channel, 1))
+		}
+		if cha := prms.ChangeAdaptor(newchannel); cha != nil {
+			ret = append(ret, cha)
+			newchannel.Change = false
+		}
+		ret = append(ret, GetTips(newchannel.Tiptype, prms, newchannel.Channel, 1, false))
+		tracker.UseTips(newchannel.Tiptype, 1)
+		channel = newchannel
+		current = &channel
+		tip = NewLHTipHistory()
+	}
+
+	multidispense := canMultiDispense(pol)
 
 	for t := 0; t < len(ins.Volume); t++ {
-		newchannel, newtiptype := ChooseChannel(ins.Volume[t], prms)
-		tvs := TransferVolumes(*ins.Volume[t], *channel.Minvol, *channel.Maxvol)
-		for _, vol := range tvs {
-			// change tips if we need to
-			if n_tip_uses > pol["TIP_REUSE_LIMIT"].(int) || channel != newchannel || newtiptype != tiptype {
-				// maybe wrap this as a ChangeTips function call
-				// these need parameters
-				ret = append(ret, DropTips(tiptype, prms, channel, 1))
-				ret = append(ret, GetTips(newtiptype, prms, newchannel, 1, false))
-				tiptype = newtiptype
-				channel = newchannel
-				n_tip_uses = 0
+		// can we do this and the next few from one aspirate?
+		if multidispense {
+			if n, newchannel := ins.multiDispenseGroup(t, prms, pol, current); n > 1 {
+				changetips(t, newchannel)
+
+				mdi := NewMultiDispenseInstruction()
+				mdi.What = []string{ins.What[t]}
+				mdi.PltFrom = []string{ins.PltFrom[t]}
+				mdi.WellFrom = []string{ins.WellFrom[t]}
+				mdi.FPlateType = []string{ins.FPlateType[t]}
+				mdi.FVolume = []*wunit.Volume{wunit.CopyVolume(ins.FVolume[t])}
+				mdi.Multi = 1
+				mdi.Prms = channel.Channel
+				mdi.TipType = channel.Tiptype
+
+				sum := wunit.NewVolume(0.0, "ul")
+				tip.Aspirate(tracker, ins.What[t], ins.PltFrom[t], ins.WellFrom[t])
+
+				for u := t; u < t+n; u++ {
+					prms.transferDone(ins.Volume[u])
+					mdi.PltTo = append(mdi.PltTo, []string{ins.PltTo[u]})
+					mdi.WellTo = append(mdi.WellTo, []string{ins.WellTo[u]})
+					mdi.Volume = append(mdi.Volume, []*wunit.Volume{wunit.CopyVolume(ins.Volume[u])})
+					mdi.TPlateType = append(mdi.TPlateType, []string{ins.TPlateType[u]})
+					mdi.TVolume = append(mdi.TVolume, []*wunit.Volume{wunit.CopyVolume(ins.TVolume[u])})
+					sum.Add(ins.Volume[u])
+
+					ins.FVolume[u].Subtract(ins.Volume[u])
+					ins.TVolume[u].Add(ins.Volume[u])
+					tip.Dispense(tracker, ins.What[u], ins.PltTo[u], ins.WellTo[u], pol)
+				}
+
+				mdi.Excess = []*wunit.Volume{multiDispenseExcess(pol, &sum)}
+
+				ret = append(ret, mdi)
+				t += n - 1
+				continue
 			}
+		}
+
+		newchannel := ChooseChannel(ins.Volume[t], prms, pol, 1, current)
+		prms.transferDone(ins.Volume[t])
+		tvs := TransferVolumes(*ins.Volume[t], *newchannel.Channel.Minvol, *newchannel.Channel.Maxvol)
+		for _, vol := range tvs {
+			changetips(t, newchannel)
 
 			stci := NewSingleChannelTransferInstruction()
 
@@ -602,17 +781,23 @@ func (ins *SingleChannelBlockInstruction) Generate(policy *LHPolicyRuleSet, prms
 			stci.TPlateType = ins.TPlateType[t]
 			stci.FVolume = wunit.CopyVolume(ins.FVolume[t])
 			stci.TVolume = wunit.CopyVolume(ins.TVolume[t])
-			stci.Prms = ins.Prms
+			stci.Prms = channel.Channel
+			stci.TipType = channel.Tiptype
 
 			ret = append(ret, stci)
 
 			ins.FVolume[t].Subtract(&vol)
 			ins.TVolume[t].Add(&vol)
-			n_tip_uses += 1
+
+			tip.Aspirate(tracker, ins.What[t], ins.PltFrom[t], ins.WellFrom[t])
+			tip.Dispense(tracker, ins.What[t], ins.PltTo[t], ins.WellTo[t], pol)
 		}
 
 	}
-	ret = append(ret, DropTips(tiptype, prms, channel, 1))
+
+	if tip != nil {
+		ret = append(ret, DropTips(channel.Tiptype, prms, channel.Channel, 1))
+	}
 
 	return ret
 }
@@ -694,6 +879,8 @@ func (ins *MultiChannelBlockInstruction) GetParameter(name string) interface{} {
 		return ins.TVolume
 	case "TOPLATETYPE":
 		return ins.TPlateType
+	case "MULTI":
+		return ins.Multi
 	case "INSTRUCTIONTYPE":
 		return ins.InstructionType()
 	}
@@ -703,12 +890,98 @@ func (ins *MultiChannelBlockInstruction) GetParameter(name string) interface{} {
 func (ins *MultiChannelBlockInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties) []RobotInstruction {
 	pol := policy.GetPolicyFor(ins)
 	ret := make([]RobotInstruction, 0)
-	// get some tips
-	channel, tiptype := ChooseChannel(ins.Volume[0][0], prms)
-	ret = append(ret, GetTips(tiptype, prms, channel, ins.Multi, false))
-	n_tip_uses := 0
+	tracker := prms.TipTracking()
+
+	// one history for each channel, nil until tips are loaded
+	var tips []*LHTipHistory
+	var channel LHChannelChoice
+	var current *LHChannelChoice
+
+	// enforce tip usage policy: every channel has to be fit to go back
+	// into its source
+	changetips := func(t int, newchannel LHChannelChoice) {
+		change := tips == nil || !newchannel.SameAs(channel)
+
+		for i := 0; i < len(ins.What[t]) && !change; i++ {
+			if !tips[i].CanAspirate(tracker, ins.What[t][i], ins.PltFrom[t][i], ins.WellFrom[t][i], pol) {
+				change = true
+			}
+		}
+
+		if !change {
+			return
+		}
+
+		// these need parameters
+		if tips != nil {
+			ret = append(ret, DropTips(channel.Tiptype, prms, channel.Channel, ins.Multi))
+		}
+		if cha := prms.ChangeAdaptor(newchannel); cha != nil {
+			ret = append(ret, cha)
+			newchannel.Change = false
+		}
+		ret = append(ret, GetTips(newchannel.Tiptype, prms, newchannel.Channel, ins.Multi, false))
+		tracker.UseTips(newchannel.Tiptype, ins.Multi)
+		channel = newchannel
+		current = &channel
+		tips = make([]*LHTipHistory, ins.Multi)
+		for i := 0; i < ins.Multi; i++ {
+			tips[i] = NewLHTipHistory()
+		}
+	}
+
+	multidispense := canMultiDispense(pol)
 
 	for t := 0; t < len(ins.Volume); t++ {
+		// can we do this and the next few from one aspirate?
+		if multidispense {
+			if n, newchannel := ins.multiDispenseGroup(t, prms, pol, current); n > 1 {
+				changetips(t, newchannel)
+
+				mdi := NewMultiDispenseInstruction()
+				mdi.What = ins.What[t]
+				mdi.PltFrom = ins.PltFrom[t]
+				mdi.WellFrom = ins.WellFrom[t]
+				mdi.FPlateType = ins.FPlateType[t]
+				mdi.Multi = ins.Multi
+				mdi.Prms = channel.Channel
+				mdi.TipType = channel.Tiptype
+
+				sums := NewVolumeSet(len(ins.What[t]))
+				for i, _ := range ins.What[t] {
+					mdi.FVolume = append(mdi.FVolume, wunit.CopyVolume(ins.FVolume[t][i]))
+					tips[i].Aspirate(tracker, ins.What[t][i], ins.PltFrom[t][i], ins.WellFrom[t][i])
+				}
+
+				for u := t; u < t+n; u++ {
+					vols := NewVolumeSet(len(ins.Volume[u]))
+					tvols := NewVolumeSet(len(ins.Volume[u]))
+
+					for i, _ := range ins.Volume[u] {
+						prms.transferDone(ins.Volume[u][i])
+						vols.Vols[i] = wunit.CopyVolume(ins.Volume[u][i])
+						tvols.Vols[i] = wunit.CopyVolume(ins.TVolume[u][i])
+						sums.Vols[i].Add(ins.Volume[u][i])
+						tips[i].Dispense(tracker, ins.What[u][i], ins.PltTo[u][i], ins.WellTo[u][i], pol)
+					}
+
+					mdi.PltTo = append(mdi.PltTo, ins.PltTo[u])
+					mdi.WellTo = append(mdi.WellTo, ins.WellTo[u])
+					mdi.Volume = append(mdi.Volume, vols.Vols)
+					mdi.TPlateType = append(mdi.TPlateType, ins.TPlateType[u])
+					mdi.TVolume = append(mdi.TVolume, tvols.Vols)
+				}
+
+				for _, v := range sums.Vols {
+					mdi.Excess = append(mdi.Excess, multiDispenseExcess(pol, v))
+				}
+
+				ret = append(ret, mdi)
+				t += n - 1
+				continue
+			}
+		}
+
 		tvols := NewVolumeSet(ins.Prms.Multi)
 		vols := NewVolumeSet(ins.Prms.Multi)
 		fvols := NewVolumeSet(ins.Prms.Multi)
@@ -718,25 +991,22 @@ func (ins *MultiChannelBlockInstruction) Generate(policy *LHPolicyRuleSet, prms
 		}
 
 		// choose tips
-		newchannel, newtiptype := ChooseChannel(ins.Volume[0][0], prms)
+		newchannel := ChooseChannel(ins.Volume[t][0], prms, pol, ins.Multi, current)
 
-		// load tips
+		for i := 0; i < len(ins.Volume[t]); i++ {
+			prms.transferDone(ins.Volume[t][i])
+		}
 
 		// split the transfer up
 		// NB we assume all volumes are equal here;
-		tvs := TransferVolumes(*ins.Volume[t][0], *newchannel.Minvol, *newchannel.Maxvol)
+		tvs := TransferVolumes(*ins.Volume[t][0], *newchannel.Channel.Minvol, *newchannel.Channel.Maxvol)
 
 		for _, vol := range tvs {
-			// enforce tip usage policy
-
-			if n_tip_uses > pol["TIP_REUSE_LIMIT"].(int) || newchannel != channel || newtiptype != tiptype {
-				// these need parameters
-				ret = append(ret, DropTips(tiptype, prms, channel, ins.Multi))
-				ret = append(ret, GetTips(newtiptype, prms, newchannel, ins.Multi, false))
-				n_tip_uses = 0
-			}
+			changetips(t, newchannel)
 
 			mci := NewMultiChannelTransferInstruction()
+			mci.What = ins.What[t]
+			mci.Multi = ins.Multi
 			vols.SetEqualTo(&vol)
 			mci.Volume = vols.GetACopy()
 			mci.FVolume = fvols.GetACopy()
@@ -747,19 +1017,25 @@ func (ins *MultiChannelBlockInstruction) Generate(policy *LHPolicyRuleSet, prms
 			mci.WellTo = ins.WellTo[t]
 			mci.FPlateType = ins.FPlateType[t]
 			mci.TPlateType = ins.TPlateType[t]
-			mci.Prms = ins.Prms
+			mci.Prms = channel.Channel
+			mci.TipType = channel.Tiptype
 
 			ret = append(ret, mci)
 
-			tiptype = newtiptype
-			channel = newchannel
 			fvols.Sub(&vol)
 			tvols.Add(&vol)
+
+			for i := 0; i < len(ins.What[t]); i++ {
+				tips[i].Aspirate(tracker, ins.What[t][i], ins.PltFrom[t][i], ins.WellFrom[t][i])
+				tips[i].Dispense(tracker, ins.What[t][i], ins.PltTo[t][i], ins.WellTo[t][i], pol)
+			}
 		}
 	}
 
 	// remove tips
-	ret = append(ret, DropTips(tiptype, prms, channel, ins.Multi))
+	if tips != nil {
+		ret = append(ret, DropTips(channel.Tiptype, prms, channel.Channel, ins.Multi))
+	}
 
 	return ret
 }
@@ -777,6 +1053,7 @@ type SingleChannelTransferInstruction struct {
 	FVolume    *wunit.Volume
 	TVolume    *wunit.Volume
 	Prms       *wtype.LHChannelParameter
+	TipType    string
 }
 
 func (scti *SingleChannelTransferInstruction) Params() TransferParams {
@@ -792,6 +1069,7 @@ func (scti *SingleChannelTransferInstruction) Params() TransferParams {
 	tp.FVolume = wunit.CopyVolume(scti.FVolume)
 	tp.TVolume = wunit.CopyVolume(scti.TVolume)
 	tp.Channel = scti.Prms
+	tp.TipType = scti.TipType
 	return tp
 }
 
@@ -832,6 +1110,10 @@ func (ins *SingleChannelTransferInstruction) GetParameter(name string) interface
 		return ins.TVolume
 	case "TOPLATETYPE":
 		return ins.TPlateType
+	case "MULTI":
+		return 1
+	case "TIPTYPE":
+		return ins.TipType
 	case "INSTRUCTIONTYPE":
 		return ins.InstructionType()
 	}
@@ -846,11 +1128,13 @@ func (ins *SingleChannelTransferInstruction) Generate(policy *LHPolicyRuleSet, p
 	suckinstruction.AddTransferParams(ins.Params())
 	suckinstruction.Multi = 1
 	suckinstruction.Prms = ins.Prms
+	suckinstruction.Head = ins.Prms.Head
 
 	blowinstruction := NewBlowInstruction()
 	blowinstruction.AddTransferParams(ins.Params())
 	blowinstruction.Multi = 1
 	blowinstruction.Prms = ins.Prms
+	blowinstruction.Head = ins.Prms.Head
 
 	ret = append(ret, suckinstruction)
 	ret = append(ret, blowinstruction)
@@ -879,6 +1163,7 @@ type MultiChannelTransferInstruction struct {
 	TVolume    []*wunit.Volume
 	Multi      int
 	Prms       *wtype.LHChannelParameter
+	TipType    string
 }
 
 func (scti *MultiChannelTransferInstruction) Params(k int) TransferParams {
@@ -894,6 +1179,7 @@ func (scti *MultiChannelTransferInstruction) Params(k int) TransferParams {
 	tp.FVolume = wunit.CopyVolume(scti.FVolume[k])
 	tp.TVolume = wunit.CopyVolume(scti.TVolume[k])
 	tp.Channel = scti.Prms
+	tp.TipType = scti.TipType
 	return tp
 }
 func NewMultiChannelTransferInstruction() *MultiChannelTransferInstruction {
@@ -943,6 +1229,10 @@ func (ins *MultiChannelTransferInstruction) GetParameter(name string) interface{
 		return ins.TVolume
 	case "TOPLATETYPE":
 		return ins.TPlateType
+	case "MULTI":
+		return ins.Multi
+	case "TIPTYPE":
+		return ins.TipType
 	case "INSTRUCTIONTYPE":
 		return ins.InstructionType()
 	}
@@ -960,7 +1250,10 @@ func (ins *MultiChannelTransferInstruction) Generate(policy *LHPolicyRuleSet, pr
 	blowinstruction.Multi = ins.Multi
 	suckinstruction.Prms = ins.Prms
 	blowinstruction.Prms = ins.Prms
+	suckinstruction.Head = ins.Prms.Head
+	blowinstruction.Head = ins.Prms.Head
 	resetinstruction := NewResetInstruction()
+	resetinstruction.Prms = ins.Prms
 
 	for i := 0; i < len(ins.Volume); i++ {
 		suckinstruction.AddTransferParams(ins.Params(i))
@@ -976,10 +1269,201 @@ func (ins *MultiChannelTransferInstruction) Generate(policy *LHPolicyRuleSet, pr
 	return ret
 }
 
+// one aspirate for several dispenses from the same sources, with some
+// extra taken up which goes back to the sources at the end
+type MultiDispenseInstruction struct {
+	Type       int
+	What       []string
+	PltFrom    []string
+	WellFrom   []string
+	FPlateType []string
+	FVolume    []*wunit.Volume
+	PltTo      [][]string
+	WellTo     [][]string
+	Volume     [][]*wunit.Volume
+	TPlateType [][]string
+	TVolume    [][]*wunit.Volume
+	Excess     []*wunit.Volume
+	Multi      int
+	Prms       *wtype.LHChannelParameter
+	TipType    string
+}
+
+func NewMultiDispenseInstruction() *MultiDispenseInstruction {
+	var v MultiDispenseInstruction
+	v.Type = MDT
+	v.What = make([]string, 0)
+	v.PltFrom = make([]string, 0)
+	v.WellFrom = make([]string, 0)
+	v.FPlateType = make([]string, 0)
+	v.FVolume = make([]*wunit.Volume, 0)
+	v.PltTo = make([][]string, 0)
+	v.WellTo = make([][]string, 0)
+	v.Volume = make([][]*wunit.Volume, 0)
+	v.TPlateType = make([][]string, 0)
+	v.TVolume = make([][]*wunit.Volume, 0)
+	v.Excess = make([]*wunit.Volume, 0)
+	return &v
+}
+
+func (ins *MultiDispenseInstruction) InstructionType() int {
+	return ins.Type
+}
+
+// everything each channel takes up, excess included
+func (ins *MultiDispenseInstruction) Totals() []*wunit.Volume {
+	ret := make([]*wunit.Volume, len(ins.What))
+	for i, _ := range ins.What {
+		ret[i] = wunit.CopyVolume(vol_at(ins.Excess, i))
+		for _, vols := range ins.Volume {
+			ret[i].Add(vol_at(vols, i))
+		}
+	}
+	return ret
+}
+
+func (ins *MultiDispenseInstruction) GetParameter(name string) interface{} {
+	switch name {
+	case "LIQUIDCLASS":
+		return ins.What
+	case "VOLUME":
+		return ins.Totals()
+	case "VOLUNT":
+		return nil
+	case "FROMPLATETYPE":
+		return ins.FPlateType
+	case "WELLFROMVOLUME":
+		return ins.FVolume
+	case "POSFROM":
+		return ins.PltFrom
+	case "POSTO":
+		return ins.PltTo
+	case "WELLFROM":
+		return ins.WellFrom
+	case "PARAMS":
+		return ins.Prms
+	case "PLATFORM":
+		return ins.Prms.Name
+	case "WELLTO":
+		return ins.WellTo
+	case "WELLTOVOLUME":
+		return ins.TVolume
+	case "TOPLATETYPE":
+		return ins.TPlateType
+	case "EXCESS":
+		return ins.Excess
+	case "MULTI":
+		return ins.Multi
+	case "TIPTYPE":
+		return ins.TipType
+	case "INSTRUCTIONTYPE":
+		return ins.InstructionType()
+	}
+	return nil
+}
+
+func (ins *MultiDispenseInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties) []RobotInstruction {
+	ret := make([]RobotInstruction, 0)
+
+	totals := ins.Totals()
+
+	suckinstruction := NewSuckInstruction()
+	suckinstruction.Multi = ins.Multi
+	suckinstruction.Prms = ins.Prms
+	suckinstruction.Head = ins.Prms.Head
+
+	for i, _ := range ins.What {
+		var tp TransferParams
+		tp.What = ins.What[i]
+		tp.PltFrom = ins.PltFrom[i]
+		tp.WellFrom = ins.WellFrom[i]
+		tp.Volume = totals[i]
+		tp.FPlateType = ins.FPlateType[i]
+		tp.FVolume = wunit.CopyVolume(ins.FVolume[i])
+		tp.Channel = ins.Prms
+		tp.TipType = ins.TipType
+		suckinstruction.AddTransferParams(tp)
+	}
+
+	ret = append(ret, suckinstruction)
+
+	// the tips aren't empty until the last dispense, or until the
+	// excess is back where it came from, so nothing is blown out before
+	// then
+
+	excess := false
+	for _, v := range ins.Excess {
+		if v.RawValue() > 0.0 {
+			excess = true
+		}
+	}
+
+	resetinstruction := NewResetInstruction()
+	resetinstruction.Prms = ins.Prms
+
+	for d := 0; d < len(ins.Volume); d++ {
+		blowinstruction := NewBlowInstruction()
+		blowinstruction.Multi = ins.Multi
+		blowinstruction.Prms = ins.Prms
+		blowinstruction.Head = ins.Prms.Head
+		blowinstruction.Partial = excess || d != len(ins.Volume)-1
+
+		for i, _ := range ins.What {
+			var tp TransferParams
+			tp.What = ins.What[i]
+			tp.PltTo = ins.PltTo[d][i]
+			tp.WellTo = ins.WellTo[d][i]
+			tp.Volume = wunit.CopyVolume(ins.Volume[d][i])
+			tp.TPlateType = ins.TPlateType[d][i]
+			tp.TVolume = wunit.CopyVolume(ins.TVolume[d][i])
+			tp.Channel = ins.Prms
+			tp.TipType = ins.TipType
+			blowinstruction.AddTransferParams(tp)
+
+			if !excess && d == len(ins.Volume)-1 {
+				resetinstruction.AddTransferParams(tp)
+			}
+		}
+
+		ret = append(ret, blowinstruction)
+	}
+
+	if excess {
+		blowinstruction := NewBlowInstruction()
+		blowinstruction.Multi = ins.Multi
+		blowinstruction.Prms = ins.Prms
+		blowinstruction.Head = ins.Prms.Head
+
+		for i, _ := range ins.What {
+			var tp TransferParams
+			tp.What = ins.What[i]
+			tp.PltTo = ins.PltFrom[i]
+			tp.WellTo = ins.WellFrom[i]
+			tp.Volume = wunit.CopyVolume(vol_at(ins.Excess, i))
+			tp.TPlateType = ins.FPlateType[i]
+			tp.TVolume = wunit.CopyVolume(ins.FVolume[i])
+			tp.TVolume.Subtract(totals[i])
+			tp.Channel = ins.Prms
+			tp.TipType = ins.TipType
+			blowinstruction.AddTransferParams(tp)
+			resetinstruction.AddTransferParams(tp)
+		}
+
+		ret = append(ret, blowinstruction)
+	}
+
+	ret = append(ret, resetinstruction)
+
+	return ret
+}
+
 type StateChangeInstruction struct {
-	Type     int
-	OldState *wtype.LHChannelParameter
-	NewState *wtype.LHChannelParameter
+	Type       int
+	OldState   *wtype.LHChannelParameter
+	NewState   *wtype.LHChannelParameter
+	Head       int
+	OldAdaptor string
+	NewAdaptor string
 }
 
 func NewStateChangeInstruction(oldstate, newstate *wtype.LHChannelParameter) *StateChangeInstruction {
@@ -999,14 +1483,26 @@ func (ins *StateChangeInstruction) GetParameter(name string) interface{} {
 		return ins.OldState
 	case "NEWSTATE":
 		return ins.NewState
+	case "HEAD":
+		return ins.Head
+	case "OLDADAPTOR":
+		return ins.OldAdaptor
+	case "NEWADAPTOR":
+		return ins.NewAdaptor
 	case "INSTRUCTIONTYPE":
 		return ins.InstructionType()
 	}
 	return nil
 }
 
+// the only state change the robot has to do anything about is a
+// different adaptor
 func (ins *StateChangeInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties) []RobotInstruction {
-	return nil
+	if ins.OldAdaptor == ins.NewAdaptor {
+		return nil
+	}
+
+	return []RobotInstruction{NewChangeAdaptorInstruction(ins.Head, "", "", ins.OldAdaptor, ins.NewAdaptor)}
 }
 
 type ChangeAdaptorInstruction struct {
@@ -1051,13 +1547,21 @@ func (ins *ChangeAdaptorInstruction) GetParameter(name string) interface{} {
 }
 
 func (ins *ChangeAdaptorInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties) []RobotInstruction {
-	ret := make([]RobotInstruction, 4)
-	/*
-		ret[0]=NewMoveInstruction(ins.DropPosition,...)
-		ret[1]=NewUnloadAdaptorInstruction(ins.DropPosition,...)
-		ret[2]=NewMoveInstruction(ins.GetPosition, ...)
-		ret[3]=NewLoadAdaptorInstruction(ins.GetPosition,...)
-	*/
+	ret := make([]RobotInstruction, 0, 2)
+
+	if ins.OldAdaptorType != "" {
+		uad := NewUnloadAdaptorInstruction()
+		uad.Head = ins.Head
+		uad.Pos = ins.DropPosition
+		uad.Adaptor = ins.OldAdaptorType
+		ret = append(ret, uad)
+	}
+
+	lad := NewLoadAdaptorInstruction()
+	lad.Head = ins.Head
+	lad.Pos = ins.GetPosition
+	lad.Adaptor = ins.NewAdaptorType
+	ret = append(ret, lad)
 
 	return ret
 }
@@ -1135,6 +1639,7 @@ type UnloadTipsMoveInstruction struct {
 	WellTo     []string
 	TPlateType []string
 	Multi      int
+	EmptyWaste bool // the waste is full and has to be emptied first
 }
 
 func NewUnloadTipsMoveInstruction() *UnloadTipsMoveInstruction {
@@ -1168,7 +1673,7 @@ func (ins *UnloadTipsMoveInstruction) GetParameter(name string) interface{} {
 }
 
 func (ins *UnloadTipsMoveInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties) []RobotInstruction {
-	ret := make([]RobotInstruction, 2)
+	ret := make([]RobotInstruction, 2, 3)
 
 	// move
 
@@ -1191,9 +1696,58 @@ func (ins *UnloadTipsMoveInstruction) Generate(policy *LHPolicyRuleSet, prms *LH
 	uld.Well = ins.WellTo
 	ret[1] = uld
 
+	if ins.EmptyWaste {
+		etw := NewEmptyTipwasteInstruction()
+		etw.Pos = ins.PltTo[0]
+		etw.Plt = ins.TPlateType[0]
+		ret = append([]RobotInstruction{etw}, ret...)
+	}
+
 	return ret
 }
 
+type EmptyTipwasteInstruction struct {
+	Type int
+	Pos  string
+	Plt  string
+}
+
+func NewEmptyTipwasteInstruction() *EmptyTipwasteInstruction {
+	var v EmptyTipwasteInstruction
+	v.Type = ETW
+	return &v
+}
+
+func (ins *EmptyTipwasteInstruction) InstructionType() int {
+	return ins.Type
+}
+
+func (ins *EmptyTipwasteInstruction) GetParameter(name string) interface{} {
+	switch name {
+	case "POS":
+		return ins.Pos
+	case "PLATE":
+		return ins.Plt
+	case "INSTRUCTIONTYPE":
+		return ins.InstructionType()
+	}
+	return nil
+}
+
+func (ins *EmptyTipwasteInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties) []RobotInstruction {
+	return nil
+}
+
+// drivers which can talk to whoever is running them ask for the waste
+// to be emptied, otherwise it's down to the position state
+func (ins *EmptyTipwasteInstruction) OutputTo(driver LiquidhandlingDriver) driver.CommandStatus {
+	if ext, ok := driver.(ExtendedLiquidhandlingDriver); ok {
+		return ext.Message(0, "Empty tip waste", fmt.Sprintf("The tip waste at %s is full, please empty it", ins.Pos), false)
+	}
+
+	return driver.SetPositionState(ins.Pos, map[string]interface{}{"EMPTY": true})
+}
+
 type AspirateInstruction struct {
 	Type       int
 	Head       int
@@ -1244,13 +1798,13 @@ func (ins *AspirateInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProper
 	return nil
 }
 
-func (ins *AspirateInstruction) OutputTo(driver LiquidhandlingDriver) {
+func (ins *AspirateInstruction) OutputTo(driver LiquidhandlingDriver) driver.CommandStatus {
 	volumes := make([]float64, len(ins.Volume))
 	for i, vol := range ins.Volume {
 		volumes[i] = vol.ConvertTo(wunit.ParsePrefixedUnit("ul"))
 	}
 	os := []bool{ins.Overstroke}
-	driver.Aspirate(volumes, os, ins.Head, ins.Multi, ins.Plt, ins.What, ins.LLF)
+	return driver.Aspirate(volumes, os, ins.Head, ins.Multi, ins.Plt, ins.What, ins.LLF)
 }
 
 type DispenseInstruction struct {
@@ -1300,14 +1854,14 @@ func (ins *DispenseInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProper
 	return nil
 }
 
-func (ins *DispenseInstruction) OutputTo(driver LiquidhandlingDriver) {
+func (ins *DispenseInstruction) OutputTo(driver LiquidhandlingDriver) driver.CommandStatus {
 	volumes := make([]float64, len(ins.Volume))
 	for i, vol := range ins.Volume {
 		volumes[i] = vol.ConvertTo(wunit.ParsePrefixedUnit("ul"))
 	}
 
 	os := []bool{false}
-	driver.Dispense(volumes, os, ins.Head, ins.Multi, ins.Plt, ins.What, ins.LLF)
+	return driver.Dispense(volumes, os, ins.Head, ins.Multi, ins.Plt, ins.What, ins.LLF)
 }
 
 type BlowoutInstruction struct {
@@ -1354,7 +1908,7 @@ func (ins *BlowoutInstruction) Generate(policy *LHPolicyRuleSet, prms *LHPropert
 	return nil
 }
 
-func (ins *BlowoutInstruction) OutputTo(driver LiquidhandlingDriver) {
+func (ins *BlowoutInstruction) OutputTo(driver LiquidhandlingDriver) driver.CommandStatus {
 	volumes := make([]float64, len(ins.Volume))
 	for i, vol := range ins.Volume {
 		volumes[i] = vol.ConvertTo(wunit.ParsePrefixedUnit("ul"))
@@ -1363,7 +1917,7 @@ func (ins *BlowoutInstruction) OutputTo(driver LiquidhandlingDriver) {
 	for i := 0; i < ins.Multi; i++ {
 		bo[i] = true
 	}
-	driver.Dispense(volumes, bo, ins.Head, ins.Multi, ins.Plt, ins.What, ins.LLF)
+	return driver.Dispense(volumes, bo, ins.Head, ins.Multi, ins.Plt, ins.What, ins.LLF)
 }
 
 type PTZInstruction struct {
@@ -1397,8 +1951,8 @@ func (ins *PTZInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties)
 	return nil
 }
 
-func (ins *PTZInstruction) OutputTo(driver LiquidhandlingDriver) {
-	driver.ResetPistons(ins.Head, ins.Channel)
+func (ins *PTZInstruction) OutputTo(driver LiquidhandlingDriver) driver.CommandStatus {
+	return driver.ResetPistons(ins.Head, ins.Channel)
 }
 
 type MoveInstruction struct {
@@ -1412,6 +1966,7 @@ type MoveInstruction struct {
 	OffsetX   []float64
 	OffsetY   []float64
 	OffsetZ   []float64
+	TouchOff  bool `json:",omitempty"` // does its job just by getting there
 }
 
 func NewMoveInstruction() *MoveInstruction {
@@ -1461,8 +2016,8 @@ func (ins *MoveInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties
 	return nil
 }
 
-func (ins *MoveInstruction) OutputTo(driver LiquidhandlingDriver) {
-	driver.Move(ins.Pos, ins.Well, ins.Reference, ins.OffsetX, ins.OffsetY, ins.OffsetZ, ins.Plt, ins.Head)
+func (ins *MoveInstruction) OutputTo(driver LiquidhandlingDriver) driver.CommandStatus {
+	return driver.Move(ins.Pos, ins.Well, ins.Reference, ins.OffsetX, ins.OffsetY, ins.OffsetZ, ins.Plt, ins.Head)
 }
 
 type MoveRawInstruction struct {
@@ -1536,7 +2091,7 @@ func (ins *MoveRawInstruction) Generate(policy *LHPolicyRuleSet, prms *LHPropert
 	return nil
 }
 
-func (ins *MoveRawInstruction) OutputTo(driver LiquidhandlingDriver) {
+func (ins *MoveRawInstruction) OutputTo(driver LiquidhandlingDriver) driver.CommandStatus {
 	panic("Not yet implemented")
 }
 
@@ -1593,8 +2148,8 @@ func (ins *LoadTipsInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProper
 	return nil
 }
 
-func (ins *LoadTipsInstruction) OutputTo(driver LiquidhandlingDriver) {
-	driver.LoadTips(ins.Channels, ins.Head, len(ins.TipType), ins.HolderType, ins.Pos, ins.Well)
+func (ins *LoadTipsInstruction) OutputTo(driver LiquidhandlingDriver) driver.CommandStatus {
+	return driver.LoadTips(ins.Channels, ins.Head, len(ins.TipType), ins.HolderType, ins.Pos, ins.Well)
 }
 
 type UnloadTipsInstruction struct {
@@ -1648,8 +2203,8 @@ func (ins *UnloadTipsInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProp
 	return nil
 }
 
-func (ins *UnloadTipsInstruction) OutputTo(driver LiquidhandlingDriver) {
-	driver.UnloadTips(ins.Channels, ins.Head, len(ins.TipType), ins.HolderType, ins.Pos, ins.Well)
+func (ins *UnloadTipsInstruction) OutputTo(driver LiquidhandlingDriver) driver.CommandStatus {
+	return driver.UnloadTips(ins.Channels, ins.Head, len(ins.TipType), ins.HolderType, ins.Pos, ins.Well)
 }
 
 type SuckInstruction struct {
@@ -1664,6 +2219,7 @@ type SuckInstruction struct {
 	Prms       *wtype.LHChannelParameter
 	Multi      int
 	Overstroke bool
+	TipType    string
 }
 
 func NewSuckInstruction() *SuckInstruction {
@@ -1688,6 +2244,7 @@ func (ins *SuckInstruction) AddTransferParams(tp TransferParams) {
 	ins.Volume = append(ins.Volume, tp.Volume)
 	ins.FPlateType = append(ins.FPlateType, tp.FPlateType)
 	ins.FVolume = append(ins.FVolume, tp.FVolume)
+	ins.TipType = tp.TipType
 }
 
 func (ins *SuckInstruction) GetParameter(name string) interface{} {
@@ -1714,6 +2271,8 @@ func (ins *SuckInstruction) GetParameter(name string) interface{} {
 		return ins.Overstroke
 	case "PLATFORM":
 		return ins.Prms.Name
+	case "TIPTYPE":
+		return ins.TipType
 	case "INSTRUCTIONTYPE":
 		return ins.InstructionType()
 	}
@@ -1729,26 +2288,47 @@ func (ins *SuckInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties
 
 	// so a simple list of questions
 
+	// how far down do we go? this depends on how much is in the well
+
+	after := make([]*wunit.Volume, len(ins.FVolume))
+	for i, v := range ins.FVolume {
+		after[i] = wunit.CopyVolume(v)
+		if i < len(ins.Volume) {
+			after[i].Subtract(ins.Volume[i])
+		}
+	}
+
+	zoffsets, llf := liquidLevelHeights(pol, prms, ins.Multi, ins.PltFrom, ins.WellFrom, ins.FVolume, after, pol["ASPZOFFSET"].(float64))
+
+	// air gaps are taken up above the well, the leading one after any
+	// mixing so we don't blow it into the liquid
+
+	leading, trailing := airGaps(pol, ins.Prms, ins.Volume)
+
+	var leadingair []RobotInstruction
+
+	if leading > 0.0 {
+		leadingair = append(leadingair, wellTopMove(ins.Head, ins.Multi, ins.PltFrom, ins.WellFrom, ins.FPlateType, ins.FVolume))
+		leadingair = append(leadingair, airAspirate(ins.Head, ins.Multi, ins.FPlateType, leading))
+	}
+
+	premix := mixSteps("PRE", pol, ins.Head, ins.Multi, ins.Prms, ins.PltFrom, ins.WellFrom, ins.FPlateType, ins.FVolume, ins.Volume)
+
+	if len(premix) == 0 {
+		ret = append(ret, leadingair...)
+	}
+
 	// first we generate the move
 
 	// do we need to enter slowly?
 
 	entryspeed, gentlynow := pol["ASPENTRYSPEED"]
 
+	var aspmov *MoveInstruction
+
 	if gentlynow {
 		// go to the well top
-		mov := NewMoveInstruction()
-
-		mov.Head = ins.Head
-		mov.Pos = ins.PltFrom
-		mov.Plt = ins.FPlateType
-		mov.Well = ins.WellFrom
-		mov.WVolume = ins.FVolume
-		for i := 0; i < ins.Multi; i++ {
-			mov.Reference = append(mov.Reference, 1)
-			mov.OffsetZ = append(mov.OffsetZ, 5.0)
-		}
-		ret = append(ret, mov)
+		ret = append(ret, wellTopMove(ins.Head, ins.Multi, ins.PltFrom, ins.WellFrom, ins.FPlateType, ins.FVolume))
 
 		// set the speed
 		spd := NewSetDriveSpeedInstruction()
@@ -1757,7 +2337,7 @@ func (ins *SuckInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties
 		ret = append(ret, spd)
 
 		// now move into the liquid
-		mov = NewMoveInstruction()
+		mov := NewMoveInstruction()
 		mov.Head = ins.Head
 		mov.Pos = ins.PltFrom
 		mov.Plt = ins.FPlateType
@@ -1765,10 +2345,11 @@ func (ins *SuckInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties
 		mov.WVolume = ins.FVolume
 		for i := 0; i < ins.Multi; i++ {
 			mov.Reference = append(mov.Reference, 0)
-			mov.OffsetZ = append(mov.OffsetZ, pol["ASPZOFFSET"].(float64))
+			mov.OffsetZ = append(mov.OffsetZ, zoffsets[i])
 		}
 
 		ret = append(ret, mov)
+		aspmov = mov
 		// reset the drive speed
 		spd = NewSetDriveSpeedInstruction()
 		spd.Drive = "Z"
@@ -1784,45 +2365,18 @@ func (ins *SuckInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties
 		mov.WVolume = ins.FVolume
 		for i := 0; i < ins.Multi; i++ {
 			mov.Reference = append(mov.Reference, 0)
-			mov.OffsetZ = append(mov.OffsetZ, pol["ASPZOFFSET"].(float64))
+			mov.OffsetZ = append(mov.OffsetZ, zoffsets[i])
 		}
 		ret = append(ret, mov)
+		aspmov = mov
 	}
 
-	// do we pre-mix?
+	// do we pre-mix? if so come back to where we aspirate afterwards
 
-	cycles, premix := pol["PRE_MIX"]
-
-	if premix {
-		// add the premix step
-		mix := NewMoveMixInstruction()
-		mix.Plt = ins.PltFrom
-		mix.PlateType = ins.FPlateType
-		mix.Well = ins.WellFrom
-		mix.FVolume = ins.FVolume
-		mix.Multi = ins.Multi
-
-		// this is not safe
-		mixvol, ok := pol["PRE_MIX_VOL"]
-		mix.Volume = ins.Volume
-
-		if ok {
-			v := make([]*wunit.Volume, ins.Multi)
-			for i := 0; i < ins.Multi; i++ {
-				vl := wunit.NewVolume(mixvol.(float64), "ul")
-				v[i] = &vl
-			}
-			mix.Volume = v
-		}
-
-		c := make([]int, ins.Multi)
-
-		for i := 0; i < ins.Multi; i++ {
-			c[i] = cycles.(int)
-		}
-
-		mix.Cycles = c
-		ret = append(ret, mix)
+	if len(premix) != 0 {
+		ret = append(ret, premix...)
+		ret = append(ret, leadingair...)
+		ret = append(ret, aspmov)
 	}
 
 	// Set the pipette speed if needed
@@ -1846,6 +2400,7 @@ func (ins *SuckInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties
 	aspins.Overstroke = ins.Overstroke
 	aspins.What = ins.What
 	aspins.Plt = ins.FPlateType
+	aspins.LLF = llf
 	ret = append(ret, aspins)
 
 	// do we reset the pipette speed?
@@ -1868,6 +2423,13 @@ func (ins *SuckInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties
 		ret = append(ret, waitins)
 	}
 
+	// and the trailing air gap once we're out of the liquid
+
+	if trailing > 0.0 {
+		ret = append(ret, wellTopMove(ins.Head, ins.Multi, ins.PltFrom, ins.WellFrom, ins.FPlateType, after))
+		ret = append(ret, airAspirate(ins.Head, ins.Multi, ins.FPlateType, trailing))
+	}
+
 	return ret
 }
 
@@ -1882,6 +2444,8 @@ type BlowInstruction struct {
 	TVolume    []*wunit.Volume
 	Prms       *wtype.LHChannelParameter
 	Multi      int
+	TipType    string
+	Partial    bool
 }
 
 func NewBlowInstruction() *BlowInstruction {
@@ -1921,6 +2485,8 @@ func (ins *BlowInstruction) GetParameter(name string) interface{} {
 		return ins.Prms.Name
 	case "MULTI":
 		return ins.Multi
+	case "TIPTYPE":
+		return ins.TipType
 	case "INSTRUCTIONTYPE":
 		return ins.InstructionType()
 	}
@@ -1933,6 +2499,7 @@ func (ins *BlowInstruction) AddTransferParams(tp TransferParams) {
 	ins.Volume = append(ins.Volume, tp.Volume)
 	ins.TPlateType = append(ins.TPlateType, tp.TPlateType)
 	ins.TVolume = append(ins.TVolume, tp.TVolume)
+	ins.TipType = tp.TipType
 }
 
 func (ins *BlowInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties) []RobotInstruction {
@@ -1941,24 +2508,48 @@ func (ins *BlowInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties
 
 	pol := policy.GetPolicyFor(ins)
 
+	// dispensing from the bottom means going into the liquid so we
+	// need to know how much is there, from the top it doesn't matter
+
+	zoffsets := make([]float64, ins.Multi)
+	llf := make([]bool, ins.Multi)
+
+	for i := 0; i < ins.Multi; i++ {
+		zoffsets[i] = pol["DSPZOFFSET"].(float64)
+	}
+
+	// what the wells will have in them after we dispense
+
+	after := make([]*wunit.Volume, len(ins.TVolume))
+	for i, v := range ins.TVolume {
+		after[i] = wunit.CopyVolume(v)
+		if i < len(ins.Volume) {
+			after[i].Add(ins.Volume[i])
+		}
+	}
+
+	if pol["DSPREFERENCE"].(int) == 0 {
+		zoffsets, llf = liquidLevelHeights(pol, prms, ins.Multi, ins.PltTo, ins.WellTo, ins.TVolume, after, pol["DSPZOFFSET"].(float64))
+	}
+
+	// the trailing air gap goes before we get near the liquid, the
+	// leading one after it unless that would put air into the liquid
+
+	leading, trailing := airGaps(pol, ins.Prms, ins.Volume)
+	noair, _ := pol["NO_AIR_DISPENSE"].(bool)
+
+	if trailing > 0.0 {
+		ret = append(ret, wellTopMove(ins.Head, ins.Multi, ins.PltTo, ins.WellTo, ins.TPlateType, ins.TVolume))
+		ret = append(ret, airDispense(ins.Head, ins.Multi, ins.TPlateType, trailing))
+	}
+
 	// first, are we breaking up the move?
 
 	entryspeed, gentlydoesit := pol["DSPENTRYSPEED"]
 
 	if gentlydoesit {
 		// go to the well top
-		mov := NewMoveInstruction()
-
-		mov.Head = ins.Head
-		mov.Pos = ins.PltTo
-		mov.Plt = ins.TPlateType
-		mov.Well = ins.WellTo
-		mov.WVolume = ins.TVolume
-		for i := 0; i < ins.Multi; i++ {
-			mov.Reference = append(mov.Reference, 1)
-			mov.OffsetZ = append(mov.OffsetZ, 5.0)
-		}
-		ret = append(ret, mov)
+		ret = append(ret, wellTopMove(ins.Head, ins.Multi, ins.PltTo, ins.WellTo, ins.TPlateType, ins.TVolume))
 
 		// set the speed
 		spd := NewSetDriveSpeedInstruction()
@@ -1966,7 +2557,7 @@ func (ins *BlowInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties
 		spd.Speed = entryspeed.(float64)
 		ret = append(ret, spd)
 
-		mov = NewMoveInstruction()
+		mov := NewMoveInstruction()
 		mov.Head = ins.Head
 		mov.Pos = ins.PltTo
 		mov.Plt = ins.TPlateType
@@ -1974,7 +2565,7 @@ func (ins *BlowInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties
 		mov.WVolume = ins.TVolume
 		for i := 0; i < ins.Multi; i++ {
 			mov.Reference = append(mov.Reference, pol["DSPREFERENCE"].(int))
-			mov.OffsetZ = append(mov.OffsetZ, pol["DSPZOFFSET"].(float64))
+			mov.OffsetZ = append(mov.OffsetZ, zoffsets[i])
 		}
 		ret = append(ret, mov)
 		// reset the drive speed
@@ -1992,7 +2583,7 @@ func (ins *BlowInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties
 		mov.WVolume = ins.TVolume
 		for i := 0; i < ins.Multi; i++ {
 			mov.Reference = append(mov.Reference, pol["DSPREFERENCE"].(int))
-			mov.OffsetZ = append(mov.OffsetZ, pol["DSPZOFFSET"].(float64))
+			mov.OffsetZ = append(mov.OffsetZ, zoffsets[i])
 		}
 
 		ret = append(ret, mov)
@@ -2018,6 +2609,7 @@ func (ins *BlowInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties
 	dspins.Multi = ins.Multi
 	dspins.Plt = ins.TPlateType
 	dspins.What = ins.What
+	dspins.LLF = llf
 	ret = append(ret, dspins)
 
 	// do we reset the pipette speed?
@@ -2040,64 +2632,67 @@ func (ins *BlowInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties
 		ret = append(ret, waitins)
 	}
 
-	// do we mix?
-	cycles, postmix := pol["POST_MIX"]
+	if leading > 0.0 {
+		if noair && pol["DSPREFERENCE"].(int) != 1 {
+			ret = append(ret, wellTopMove(ins.Head, ins.Multi, ins.PltTo, ins.WellTo, ins.TPlateType, ins.TVolume))
+		}
+		ret = append(ret, airDispense(ins.Head, ins.Multi, ins.TPlateType, leading))
+	}
 
-	if postmix {
-		// add the postmix step
-		mix := NewMoveMixInstruction()
-		mix.Plt = ins.PltTo
-		mix.PlateType = ins.TPlateType
-		mix.Well = ins.WellTo
-		mix.FVolume = ins.TVolume
-		mix.Multi = ins.Multi
+	// do we mix? by now the well has what we dispensed in it too
 
-		// this is not safe, need to verify volume is OK
-		mixvol, ok := pol["POST_MIX_VOL"]
-		mix.Volume = ins.Volume
+	ret = append(ret, mixSteps("POST", pol, ins.Head, ins.Multi, ins.Prms, ins.PltTo, ins.WellTo, ins.TPlateType, after, ins.Volume)...)
 
-		if ok {
-			v := make([]*wunit.Volume, ins.Multi)
-			for i := 0; i < ins.Multi; i++ {
-				vl := wunit.NewVolume(mixvol.(float64), "ul")
-				v[i] = &vl
-			}
-			mix.Volume = v
-		}
+	// blow out what's left, from the top if we can't let air into the
+	// liquid, unless there's more to come from these tips
 
-		c := make([]int, ins.Multi)
+	if bov := blowoutVolume(pol, ins.Prms); bov > 0.0 && !ins.Partial {
+		ref, _ := policy_int(pol, "BLOWOUTREFERENCE")
+		off, _ := policy_float(pol, "BLOWOUTOFFSET")
 
+		if noair && ref != 1 {
+			ref, off = 1, 0.0
+		}
+
+		mov := NewMoveInstruction()
+		mov.Head = ins.Head
+		mov.Pos = ins.PltTo
+		mov.Plt = ins.TPlateType
+		mov.Well = ins.WellTo
+		mov.WVolume = ins.TVolume
 		for i := 0; i < ins.Multi; i++ {
-			c[i] = cycles.(int)
+			mov.Reference = append(mov.Reference, ref)
+			mov.OffsetZ = append(mov.OffsetZ, off)
 		}
+		ret = append(ret, mov)
 
-		mix.Cycles = c
-		ret = append(ret, mix)
+		blow := NewBlowoutInstruction()
+		blow.Head = ins.Head
+		v := wunit.NewVolume(bov, "ul")
+		blow.Volume = append(blow.Volume, &v)
+		blow.Multi = ins.Multi
+		blow.Plt = ins.TPlateType
+		blow.What = ins.What
+		ret = append(ret, blow)
 	}
 
 	// do we need to touch off?
 
-	touch_off := pol["TOUCHOFF"].(bool)
+	if touchoff, _ := pol["TOUCHOFF"].(bool); touchoff {
+		ref, _ := policy_int(pol, "TOUCHOFFREFERENCE")
+		off, _ := policy_float(pol, "TOUCHOFFSET")
 
-	if touch_off {
-		touch_offset := pol["TOUCHOFFSET"].(float64)
 		mov := NewMoveInstruction()
 		mov.Head = ins.Head
 		mov.Pos = ins.PltTo
 		mov.Plt = ins.TPlateType
 		mov.Well = ins.WellTo
 		mov.WVolume = ins.TVolume
-
-		ref := make([]int, ins.Multi)
-		off := make([]float64, ins.Multi)
+		mov.TouchOff = true
 		for i := 0; i < ins.Multi; i++ {
-			ref[i] = 0
-			off[i] = touch_offset
+			mov.Reference = append(mov.Reference, ref)
+			mov.OffsetZ = append(mov.OffsetZ, off)
 		}
-
-		mov.Reference = ref
-		mov.OffsetZ = off
-
 		ret = append(ret, mov)
 	}
 
@@ -2138,8 +2733,8 @@ func (ins *SetPipetteSpeedInstruction) Generate(policy *LHPolicyRuleSet, prms *L
 	return nil
 }
 
-func (ins *SetPipetteSpeedInstruction) OutputTo(driver LiquidhandlingDriver) {
-	driver.SetPipetteSpeed(ins.Head, ins.Channel, ins.Speed)
+func (ins *SetPipetteSpeedInstruction) OutputTo(driver LiquidhandlingDriver) driver.CommandStatus {
+	return driver.SetPipetteSpeed(ins.Head, ins.Channel, ins.Speed)
 }
 
 type SetDriveSpeedInstruction struct {
@@ -2173,8 +2768,8 @@ func (ins *SetDriveSpeedInstruction) Generate(policy *LHPolicyRuleSet, prms *LHP
 	return nil
 }
 
-func (ins *SetDriveSpeedInstruction) OutputTo(driver LiquidhandlingDriver) {
-	driver.SetDriveSpeed(ins.Drive, ins.Speed)
+func (ins *SetDriveSpeedInstruction) OutputTo(driver LiquidhandlingDriver) driver.CommandStatus {
+	return driver.SetDriveSpeed(ins.Drive, ins.Speed)
 }
 
 type InitializeInstruction struct {
@@ -2198,8 +2793,8 @@ func (ins *InitializeInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProp
 	return nil
 }
 
-func (ins *InitializeInstruction) OutputTo(driver LiquidhandlingDriver) {
-	driver.Initialize()
+func (ins *InitializeInstruction) OutputTo(driver LiquidhandlingDriver) driver.CommandStatus {
+	return driver.Initialize()
 }
 
 type FinalizeInstruction struct {
@@ -2223,8 +2818,8 @@ func (ins *FinalizeInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProper
 	return nil
 }
 
-func (ins *FinalizeInstruction) OutputTo(driver LiquidhandlingDriver) {
-	driver.Finalize()
+func (ins *FinalizeInstruction) OutputTo(driver LiquidhandlingDriver) driver.CommandStatus {
+	return driver.Finalize()
 }
 
 type WaitInstruction struct {
@@ -2255,8 +2850,8 @@ func (ins *WaitInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties
 	return nil
 }
 
-func (ins *WaitInstruction) OutputTo(driver ExtendedLiquidhandlingDriver) {
-	driver.Wait(ins.Time)
+func (ins *WaitInstruction) OutputTo(driver LiquidhandlingDriver) driver.CommandStatus {
+	return driver.Wait(ins.Time)
 }
 
 type LightsOnInstruction struct {
@@ -2297,7 +2892,7 @@ func (ins *LightsOnInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProper
 	return nil
 }
 
-func (ins *LightsOnInstruction) OutputTo(driver LiquidhandlingDriver) {
+func (ins *LightsOnInstruction) OutputTo(driver LiquidhandlingDriver) driver.CommandStatus {
 	panic("Not yet implemented")
 }
 
@@ -2339,7 +2934,7 @@ func (ins *LightsOffInstruction) Generate(policy *LHPolicyRuleSet, prms *LHPrope
 	return nil
 }
 
-func (ins *LightsOffInstruction) OutputTo(driver LiquidhandlingDriver) {
+func (ins *LightsOffInstruction) OutputTo(driver LiquidhandlingDriver) driver.CommandStatus {
 	panic("Not yet implemented")
 }
 
@@ -2381,7 +2976,7 @@ func (ins *OpenInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties
 	return nil
 }
 
-func (ins *OpenInstruction) OutputTo(driver LiquidhandlingDriver) {
+func (ins *OpenInstruction) OutputTo(driver LiquidhandlingDriver) driver.CommandStatus {
 	panic("Not yet implemented")
 }
 
@@ -2423,34 +3018,20 @@ func (ins *CloseInstruction) Generate(policy *LHPolicyRuleSet, prms *LHPropertie
 	return nil
 }
 
-func (ins *CloseInstruction) OutputTo(driver LiquidhandlingDriver) {
+func (ins *CloseInstruction) OutputTo(driver LiquidhandlingDriver) driver.CommandStatus {
 	panic("Not yet implemented")
 }
 
 type LoadAdaptorInstruction struct {
-	Type       int
-	What       []string
-	PltFrom    []string
-	PltTo      []string
-	WellFrom   []string
-	WellTo     []string
-	Volume     []*wunit.Volume
-	FPlateType []string
-	TPlateType []string
-	FVolume    *wunit.Volume
-	TVolume    *wunit.Volume
-	Prms       *wtype.LHChannelParameter
+	Type    int
+	Head    int
+	Pos     string
+	Adaptor string
 }
 
 func NewLoadAdaptorInstruction() *LoadAdaptorInstruction {
 	var v LoadAdaptorInstruction
 	v.Type = LAD
-	v.What = make([]string, 0)
-	v.PltFrom = make([]string, 0)
-	v.PltTo = make([]string, 0)
-	v.WellFrom = make([]string, 0)
-	v.WellTo = make([]string, 0)
-	v.Volume = make([]*wunit.Volume, 0)
 	return &v
 }
 func (ins *LoadAdaptorInstruction) InstructionType() int {
@@ -2458,6 +3039,16 @@ func (ins *LoadAdaptorInstruction) InstructionType() int {
 }
 
 func (ins *LoadAdaptorInstruction) GetParameter(name string) interface{} {
+	switch name {
+	case "HEAD":
+		return ins.Head
+	case "POSFROM":
+		return ins.Pos
+	case "ADAPTOR":
+		return ins.Adaptor
+	case "INSTRUCTIONTYPE":
+		return ins.InstructionType()
+	}
 	return nil
 }
 
@@ -2465,34 +3056,25 @@ func (ins *LoadAdaptorInstruction) Generate(policy *LHPolicyRuleSet, prms *LHPro
 	return nil
 }
 
-func (ins *LoadAdaptorInstruction) OutputTo(driver LiquidhandlingDriver) {
-	panic("Not yet implemented")
+// only drivers which can change adaptors can be asked to
+func (ins *LoadAdaptorInstruction) OutputTo(driver LiquidhandlingDriver) driver.CommandStatus {
+	if ext, ok := driver.(ExtendedLiquidhandlingDriver); ok {
+		return ext.LoadAdaptor(ins.Head)
+	}
+
+	return adaptorChangeUnsupported(ins.Adaptor)
 }
 
 type UnloadAdaptorInstruction struct {
-	Type       int
-	What       []string
-	PltFrom    []string
-	PltTo      []string
-	WellFrom   []string
-	WellTo     []string
-	Volume     []*wunit.Volume
-	FPlateType []string
-	TPlateType []string
-	FVolume    *wunit.Volume
-	TVolume    *wunit.Volume
-	Prms       *wtype.LHChannelParameter
+	Type    int
+	Head    int
+	Pos     string
+	Adaptor string
 }
 
 func NewUnloadAdaptorInstruction() *UnloadAdaptorInstruction {
 	var v UnloadAdaptorInstruction
 	v.Type = UAD
-	v.What = make([]string, 0)
-	v.PltFrom = make([]string, 0)
-	v.PltTo = make([]string, 0)
-	v.WellFrom = make([]string, 0)
-	v.WellTo = make([]string, 0)
-	v.Volume = make([]*wunit.Volume, 0)
 	return &v
 }
 func (ins *UnloadAdaptorInstruction) InstructionType() int {
@@ -2500,6 +3082,16 @@ func (ins *UnloadAdaptorInstruction) InstructionType() int {
 }
 
 func (ins *UnloadAdaptorInstruction) GetParameter(name string) interface{} {
+	switch name {
+	case "HEAD":
+		return ins.Head
+	case "POSTO":
+		return ins.Pos
+	case "ADAPTOR":
+		return ins.Adaptor
+	case "INSTRUCTIONTYPE":
+		return ins.InstructionType()
+	}
 	return nil
 }
 
@@ -2507,8 +3099,16 @@ func (ins *UnloadAdaptorInstruction) Generate(policy *LHPolicyRuleSet, prms *LHP
 	return nil
 }
 
-func (ins *UnloadAdaptorInstruction) OutputTo(driver LiquidhandlingDriver) {
-	panic("Not yet implemented")
+func (ins *UnloadAdaptorInstruction) OutputTo(driver LiquidhandlingDriver) driver.CommandStatus {
+	if ext, ok := driver.(ExtendedLiquidhandlingDriver); ok {
+		return ext.UnloadAdaptor(ins.Head)
+	}
+
+	return adaptorChangeUnsupported(ins.Adaptor)
+}
+
+func adaptorChangeUnsupported(adaptor string) driver.CommandStatus {
+	return driver.CommandStatus{OK: false, Errorcode: 1, Msg: fmt.Sprintf("driver cannot change adaptors, %s needs changing by hand", adaptor)}
 }
 
 type ResetInstruction struct {
@@ -2589,6 +3189,9 @@ func (ins *ResetInstruction) AddTransferParams(tp TransferParams) {
 }
 
 func (ins *ResetInstruction) Generate(policy *LHPolicyRuleSet, prms *LHProperties) []RobotInstruction {
+	// the blowout is part of dispensing, all that's left is to put the
+	// pistons back
+
 	pol := policy.GetPolicyFor(ins)
 	ret := make([]RobotInstruction, 0)
 
@@ -2598,26 +3201,10 @@ func (ins *ResetInstruction) Generate(policy *LHPolicyRuleSet, prms *LHPropertie
 	mov.Plt = ins.TPlateType
 	mov.WVolume = ins.TVolume
 	mov.Head = ins.Prms.Head
-	mov.Reference = append(mov.Reference, pol["BLOWOUTREFERENCE"].(int))
-	mov.OffsetZ = append(mov.OffsetZ, pol["BLOWOUTOFFSET"].(float64))
-
-	blow := NewBlowoutInstruction()
-
-	blow.Head = ins.Prms.Head
-	bov := wunit.NewVolume(pol["BLOWOUTVOLUME"].(float64), pol["BLOWOUTVOLUMEUNIT"].(string))
-	blow.Volume = append(blow.Volume, &bov)
-	blow.Multi = len(ins.What)
-	blow.Plt = ins.TPlateType
-	blow.What = ins.What
-
-	mov2 := NewMoveInstruction()
-	mov2.Well = ins.WellTo
-	mov2.Pos = ins.PltTo
-	mov2.Plt = ins.TPlateType
-	mov2.WVolume = ins.TVolume
-	mov2.Head = ins.Prms.Head
-	mov2.Reference = append(mov2.Reference, pol["PTZREFERENCE"].(int))
-	mov2.OffsetZ = append(mov2.OffsetZ, pol["PTZOFFSET"].(float64))
+	for i := 0; i < len(ins.What); i++ {
+		mov.Reference = append(mov.Reference, pol["PTZREFERENCE"].(int))
+		mov.OffsetZ = append(mov.OffsetZ, pol["PTZOFFSET"].(float64))
+	}
 
 	ptz := NewPTZInstruction()
 
@@ -2625,8 +3212,6 @@ func (ins *ResetInstruction) Generate(policy *LHPolicyRuleSet, prms *LHPropertie
 	ptz.Channel = -1 // all channels
 
 	ret = append(ret, mov)
-	ret = append(ret, blow)
-	ret = append(ret, mov2)
 	ret = append(ret, ptz)
 	return ret
 }
@@ -2641,6 +3226,8 @@ type MoveMixInstruction struct {
 	FVolume   []*wunit.Volume
 	Cycles    []int
 	Multi     int
+	Reference []int
+	OffsetZ   []float64
 	Prms      map[string]interface{}
 }
 
@@ -2654,6 +3241,8 @@ func NewMoveMixInstruction() *MoveMixInstruction {
 	mi.FVolume = make([]*wunit.Volume, 0)
 	mi.PlateType = make([]string, 0)
 	mi.Cycles = make([]int, 0)
+	mi.Reference = make([]int, 0)
+	mi.OffsetZ = make([]float64, 0)
 	mi.Prms = make(map[string]interface{})
 	return &mi
 }
@@ -2676,6 +3265,10 @@ func (ins *MoveMixInstruction) GetParameter(name string) interface{} {
 		return ins.Prms
 	case "CYCLES":
 		return ins.Cycles
+	case "REFERENCE":
+		return ins.Reference
+	case "OFFSETZ":
+		return ins.OffsetZ
 	case "INSTRUCTIONTYPE":
 		return ins.InstructionType()
 	}
@@ -2698,9 +3291,19 @@ func (ins *MoveMixInstruction) Generate(policy *LHPolicyRuleSet, prms *LHPropert
 	mov.Plt = ins.PlateType
 	mov.WVolume = ins.FVolume
 	mov.Head = ins.Head
-	zoff := make([]float64, ins.Multi)
-	zoff[0] = 0.5
-	mov.OffsetZ = zoff
+
+	// mix just off the bottom unless told otherwise
+	for i := 0; i < ins.Multi; i++ {
+		ref, zoff := 0, 0.5
+		if i < len(ins.Reference) {
+			ref = ins.Reference[i]
+		}
+		if i < len(ins.OffsetZ) {
+			zoff = ins.OffsetZ[i]
+		}
+		mov.Reference = append(mov.Reference, ref)
+		mov.OffsetZ = append(mov.OffsetZ, zoff)
+	}
 	ret[0] = mov
 
 	// mix
@@ -2732,7 +3335,7 @@ type MixInstruction struct {
 func NewMixInstruction() *MixInstruction {
 	var mi MixInstruction
 
-	mi.Type = MMX
+	mi.Type = MIX
 	mi.Volume = make([]*wunit.Volume, 0)
 	mi.FVolume = make([]*wunit.Volume, 0)
 	mi.PlateType = make([]string, 0)
@@ -2770,16 +3373,18 @@ func (ins *MixInstruction) GetParameter(name string) interface{} {
 
 }
 
-func (mi *MixInstruction) OutputTo(driver LiquidhandlingDriver) {
+func (mi *MixInstruction) OutputTo(driver LiquidhandlingDriver) driver.CommandStatus {
 	vols := make([]float64, len(mi.Volume))
 	fvols := make([]float64, len(mi.Volume))
 
 	for i := 0; i < len(mi.Volume); i++ {
 		vols[i] = mi.Volume[i].ConvertTo(wunit.ParsePrefixedUnit("ul"))
-		fvols[i] = mi.Volume[i].ConvertTo(wunit.ParsePrefixedUnit("ul"))
+		if i < len(mi.FVolume) {
+			fvols[i] = mi.FVolume[i].ConvertTo(wunit.ParsePrefixedUnit("ul"))
+		}
 	}
 
-	driver.Mix(mi.Head, vols, fvols, mi.PlateType, mi.Cycles, mi.Multi, mi.Prms)
+	return driver.Mix(mi.Head, vols, fvols, mi.PlateType, mi.Cycles, mi.Multi, mi.Prms)
 }
 
 // TODO -- implement MESSAGE
@@ -2801,7 +3406,7 @@ func GetTips(tiptype string, params *LHProperties, channel *wtype.LHChannelParam
 }
 
 func DropTips(tiptype string, params *LHProperties, channel *wtype.LHChannelParameter, multi int) RobotInstruction {
-	tipwells, tipwastepositions, tipwastetypes := params.DropDirtyTips(channel, multi)
+	tipwells, tipwastepositions, tipwastetypes, emptied := params.DropDirtyTips(channel, multi)
 
 	if tipwells == nil {
 		panic("NO ROOM AT THE INN FOR THESE LITTLE TIPS")
@@ -2813,5 +3418,6 @@ func DropTips(tiptype string, params *LHProperties, channel *wtype.LHChannelPara
 	ins.PltTo = tipwastepositions
 	ins.TPlateType = tipwastetypes
 	ins.Multi = multi
+	ins.EmptyWaste = emptied
 	return ins
 }
@@ -0,0 +1,136 @@
+// /anthalib/driver/liquidhandling/compositerobotinstruction_test.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling_test
+
+import (
+	"testing"
+
+	"github.com/antha-lang/antha/antha/anthalib/driver/liquidhandling"
+	"github.com/antha-lang/antha/antha/anthalib/factory"
+	"github.com/antha-lang/antha/antha/anthalib/wtype"
+)
+
+func TestMultichannelBlock(t *testing.T) {
+	params := factory.GetLiquidhandlerByType("GilsonPipetmax")
+	params.AddPlate("position_4", factory.GetPlateByType("DWST12"))
+	params.AddPlate("position_7", factory.GetPlateByType("pcrplate"))
+	policies := liquidhandling.GetLHPolicyForTest()
+
+	// one full column out of a single trough well plus a straggler
+
+	wellfrom := []string{"A1", "A1", "A1", "A1", "A1", "A1", "A1", "A1", "A1"}
+	wellto := []string{"H1", "A1", "B1", "C1", "D1", "E1", "F1", "G1", "A2"}
+	vols := []float64{20, 20, 20, 20, 20, 20, 20, 20, 20}
+
+	mcb, rest := makeTestTransfer(wellfrom, wellto, vols).MultichannelBlock(policies, params)
+
+	if mcb == nil || len(mcb.What) != 1 || len(mcb.What[0]) != 8 {
+		t.Fatal("expected one multichannel set of 8 transfers")
+	}
+
+	column := []string{"A1", "B1", "C1", "D1", "E1", "F1", "G1", "H1"}
+
+	for i, w := range mcb.WellTo[0] {
+		if w != column[i] {
+			t.Errorf("multichannel transfer %d goes to %s, expected %s", i, w, column[i])
+		}
+	}
+
+	if rest == nil || len(rest.What) != 1 || rest.WellTo[0] != "A2" {
+		t.Error("expected A2 to be left for single channel transfer")
+	}
+
+	// unequal volumes can't be done together on a head without independent channels
+
+	vols[3] = 30
+
+	mcb, rest = makeTestTransfer(wellfrom, wellto, vols).MultichannelBlock(policies, params)
+
+	if mcb != nil {
+		t.Error("unequal volumes should not be grouped")
+	}
+
+	if rest == nil || len(rest.What) != len(wellto) {
+		t.Error("all transfers should fall back to single channel")
+	}
+
+	// sources must line up with the channels when the source isn't a trough
+
+	vols[3] = 20
+	params = factory.GetLiquidhandlerByType("GilsonPipetmax")
+	params.AddPlate("position_4", factory.GetPlateByType("pcrplate"))
+	params.AddPlate("position_7", factory.GetPlateByType("pcrplate"))
+
+	wellfrom = []string{"A3", "B3", "C3", "D3", "E3", "F3", "G3", "H3", "A1"}
+
+	mcb, rest = makeTestTransfer(wellfrom, wellto, vols).MultichannelBlock(policies, params)
+
+	if mcb != nil {
+		t.Error("misaligned sources should not be grouped")
+	}
+
+	wellfrom = []string{"H3", "A3", "B3", "C3", "D3", "E3", "F3", "G3", "A1"}
+
+	mcb, rest = makeTestTransfer(wellfrom, wellto, vols).MultichannelBlock(policies, params)
+
+	if mcb == nil || len(mcb.What) != 1 || rest == nil || len(rest.What) != 1 {
+		t.Error("aligned sources should be grouped")
+	}
+
+	// channels line up with every other well on a plate with wells half
+	// as far apart, and with none if they don't divide the channel pitch
+
+	pcr := factory.GetPlateByType("pcrplate")
+
+	plate := func(pitch float64) *wtype.LHPlate {
+		return wtype.NewLHPlate("test", "Unknown", 16, 24, pcr.Height, pcr.Hunit, pcr.Welltype, pitch, pitch, pcr.WellXStart, pcr.WellYStart, pcr.WellZStart)
+	}
+
+	params = factory.GetLiquidhandlerByType("GilsonPipetmax")
+	params.AddPlate("position_4", factory.GetPlateByType("DWST12"))
+	params.AddPlate("position_7", plate(4.5))
+
+	wellfrom = []string{"A1", "A1", "A1", "A1", "A1", "A1", "A1", "A1"}
+	wellto = []string{"A1", "C1", "E1", "G1", "I1", "K1", "M1", "O1"}
+	vols = vols[:8]
+
+	if mcb, rest = makeTestTransfer(wellfrom, wellto, vols).MultichannelBlock(policies, params); mcb == nil || len(mcb.What) != 1 || rest != nil {
+		t.Error("every other well should be grouped when the wells are half the channel pitch apart")
+	}
+
+	wellto = []string{"A1", "B1", "C1", "D1", "E1", "F1", "G1", "H1"}
+
+	if mcb, _ = makeTestTransfer(wellfrom, wellto, vols).MultichannelBlock(policies, params); mcb != nil {
+		t.Error("adjacent wells should not be grouped when the wells are half the channel pitch apart")
+	}
+
+	params = factory.GetLiquidhandlerByType("GilsonPipetmax")
+	params.AddPlate("position_4", factory.GetPlateByType("DWST12"))
+	params.AddPlate("position_7", plate(6.0))
+
+	for _, wt := range [][]string{wellto, {"A1", "C1", "E1", "G1", "I1", "K1", "M1", "O1"}} {
+		if mcb, _ = makeTestTransfer(wellfrom, wt, vols).MultichannelBlock(policies, params); mcb != nil {
+			t.Errorf("wells the channels don't line up with should not be grouped, got %v", mcb.WellTo)
+		}
+	}
+}
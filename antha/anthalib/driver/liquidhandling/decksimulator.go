@@ -0,0 +1,388 @@
+// /anthalib/driver/liquidhandling/decksimulator.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+import (
+	"fmt"
+	"github.com/antha-lang/antha/antha/anthalib/driver"
+	"github.com/antha-lang/antha/antha/anthalib/wtype"
+	"sort"
+	"strings"
+)
+
+// DeckSimulator is a driver which runs nothing but writes down what an
+// observer would see the robot do: liquid going in and out of tips
+// along with where the head is, how fast it got there and the speeds
+// in force at the time, tips and adaptors going on and off. Setting a
+// speed, waiting for no time or going somewhere only to go on
+// somewhere else are not seen, nor are clean tips unloaded only to
+// load the same sort again, so two instruction streams which differ
+// only in these leave the same Effects
+type DeckSimulator struct {
+	Effects []string
+	heads   map[int]*simulatedHead
+	drives  map[string]float64
+	unloads map[int]string // clean tips unloaded, not yet seen
+}
+
+type simulatedHead struct {
+	at     string
+	how    string  // the drive speeds it got there at
+	all    float64 // pipette speed set for every channel, 0 if unset
+	speeds map[int]float64
+	clean  string // the tips loaded if they haven't been used yet
+}
+
+func NewDeckSimulator() *DeckSimulator {
+	return &DeckSimulator{
+		Effects: make([]string, 0, 100),
+		heads:   make(map[int]*simulatedHead),
+		drives:  make(map[string]float64),
+		unloads: make(map[int]string),
+	}
+}
+
+// runs the instructions one by one, stopping at the first which fails.
+// Touching off is seen here since the driver can't tell it from any
+// other move
+func SimulateInstructions(instructions []TerminalRobotInstruction) (ds *DeckSimulator, err error) {
+	ds = NewDeckSimulator()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("cannot simulate instructions: %v", r)
+		}
+		ds.Flush()
+	}()
+
+	for i, ins := range instructions {
+		if st := ins.OutputTo(ds); !st.OK {
+			return ds, fmt.Errorf("instruction %d (%s) failed: %s", i, Robotinstructionnames[ins.InstructionType()], st.Msg)
+		}
+
+		if mov, ok := ins.(*MoveInstruction); ok && mov.TouchOff {
+			ds.record("touch off head %d %s", mov.Head, ds.headState(mov.Head))
+		}
+	}
+
+	return ds, nil
+}
+
+// clean tips unloaded are only seen once it's clear the same sort
+// aren't going straight back on, i.e. when anything else is seen or
+// at the end
+func (ds *DeckSimulator) Flush() {
+	heads := make([]int, 0, len(ds.unloads))
+	for h, _ := range ds.unloads {
+		heads = append(heads, h)
+	}
+	sort.Ints(heads)
+
+	for _, h := range heads {
+		ds.Effects = append(ds.Effects, ds.unloads[h])
+	}
+
+	ds.unloads = make(map[int]string)
+}
+
+// nil if the two simulations saw the robot do the same things,
+// otherwise says where they first differ
+func (ds *DeckSimulator) EquivalentTo(other *DeckSimulator) error {
+	for i := 0; i < len(ds.Effects) && i < len(other.Effects); i++ {
+		if ds.Effects[i] != other.Effects[i] {
+			return fmt.Errorf("step %d differs: %s / %s", i, ds.Effects[i], other.Effects[i])
+		}
+	}
+
+	if len(ds.Effects) != len(other.Effects) {
+		return fmt.Errorf("%d steps / %d steps", len(ds.Effects), len(other.Effects))
+	}
+
+	return nil
+}
+
+func (ds *DeckSimulator) head(h int) *simulatedHead {
+	if ds.heads[h] == nil {
+		ds.heads[h] = &simulatedHead{speeds: make(map[int]float64)}
+	}
+	return ds.heads[h]
+}
+
+func (ds *DeckSimulator) record(format string, args ...interface{}) driver.CommandStatus {
+	e := fmt.Sprintf(format, args...)
+	ds.Flush()
+	ds.Effects = append(ds.Effects, e)
+	return driver.CommandStatus{OK: true, Errorcode: driver.OK, Msg: ""}
+}
+
+// the head is somewhere else without having been told to go there
+func (ds *DeckSimulator) moved(h int, llf []bool, how string) {
+	for _, b := range llf {
+		if b {
+			ds.head(h).at += " " + how
+			return
+		}
+	}
+}
+
+// where the head goes is seen in what it does there
+func (ds *DeckSimulator) goTo(h int, where string) driver.CommandStatus {
+	hd := ds.head(h)
+
+	if hd.at != where {
+		hd.at = where
+		hd.how = ds.driveSpeeds()
+	}
+
+	return driver.CommandStatus{OK: true, Errorcode: driver.OK, Msg: ""}
+}
+
+func (ds *DeckSimulator) driveSpeeds() string {
+	drives := make([]string, 0, len(ds.drives))
+	for d, _ := range ds.drives {
+		drives = append(drives, d)
+	}
+	sort.Strings(drives)
+
+	s := make([]string, 0, len(drives))
+	for _, d := range drives {
+		s = append(s, fmt.Sprintf("%s=%v", d, ds.drives[d]))
+	}
+	return "[" + strings.Join(s, " ") + "]"
+}
+
+// the head's position and pipette speeds
+func (ds *DeckSimulator) headState(h int) string {
+	hd := ds.head(h)
+
+	channels := make([]int, 0, len(hd.speeds))
+	for c, _ := range hd.speeds {
+		channels = append(channels, c)
+	}
+	sort.Ints(channels)
+
+	s := []string{fmt.Sprintf("all=%v", hd.all)}
+	for _, c := range channels {
+		s = append(s, fmt.Sprintf("%d=%v", c, hd.speeds[c]))
+	}
+
+	return fmt.Sprintf("at %s (drive speeds %s) speeds [%s]", hd.at, hd.how, strings.Join(s, " "))
+}
+
+func (ds *DeckSimulator) Move(deckposition []string, wellcoords []string, reference []int, offsetX, offsetY, offsetZ []float64, plate_type []string, head int) driver.CommandStatus {
+	return ds.goTo(head, fmt.Sprint(deckposition, wellcoords, reference, offsetX, offsetY, offsetZ, plate_type))
+}
+
+func (ds *DeckSimulator) MoveExplicit(deckposition []string, wellcoords []string, reference []int, offsetX, offsetY, offsetZ []float64, plate_type []*wtype.LHPlate, head int) driver.CommandStatus {
+	names := make([]string, 0, len(plate_type))
+	for _, p := range plate_type {
+		if p != nil {
+			names = append(names, p.Type)
+		} else {
+			names = append(names, "")
+		}
+	}
+	return ds.Move(deckposition, wellcoords, reference, offsetX, offsetY, offsetZ, names, head)
+}
+
+func (ds *DeckSimulator) MoveRaw(head int, x, y, z float64) driver.CommandStatus {
+	return ds.goTo(head, fmt.Sprint("raw", x, y, z))
+}
+
+func (ds *DeckSimulator) Aspirate(volume []float64, overstroke []bool, head int, multi int, platetype []string, what []string, llf []bool) driver.CommandStatus {
+	ds.head(head).clean = ""
+	st := ds.record("aspirate head %d %s %v %v %d %v %v %v", head, ds.headState(head), volume, overstroke, multi, platetype, what, llf)
+	ds.moved(head, llf, "following the liquid down")
+	return st
+}
+
+func (ds *DeckSimulator) Dispense(volume []float64, blowout []bool, head int, multi int, platetype []string, what []string, llf []bool) driver.CommandStatus {
+	ds.head(head).clean = ""
+	st := ds.record("dispense head %d %s %v %v %d %v %v %v", head, ds.headState(head), volume, blowout, multi, platetype, what, llf)
+	ds.moved(head, llf, "following the liquid up")
+	return st
+}
+
+// getting tips on and off moves the head up and down so it is taken
+// not to be quite where it was afterwards
+func (ds *DeckSimulator) LoadTips(channels []int, head, multi int, platetype, position, well []string) driver.CommandStatus {
+	hd := ds.head(head)
+	tips := fmt.Sprint(channels, multi, platetype)
+
+	if _, ok := ds.unloads[head]; ok && hd.clean == tips {
+		delete(ds.unloads, head)
+		return driver.CommandStatus{OK: true, Errorcode: driver.OK, Msg: ""}
+	}
+
+	st := ds.record("load tips head %d %s %v %d %v %v %v", head, ds.headState(head), channels, multi, platetype, position, well)
+	ds.moved(head, []bool{true}, "after loading tips")
+	hd.clean = tips
+	return st
+}
+
+func (ds *DeckSimulator) UnloadTips(channels []int, head, multi int, platetype, position, well []string) driver.CommandStatus {
+	e := fmt.Sprintf("unload tips head %d %s %v %d %v %v %v", head, ds.headState(head), channels, multi, platetype, position, well)
+	st := driver.CommandStatus{OK: true, Errorcode: driver.OK, Msg: ""}
+
+	if ds.head(head).clean != "" {
+		ds.Flush()
+		ds.unloads[head] = e
+	} else {
+		st = ds.record("%s", e)
+	}
+
+	ds.moved(head, []bool{true}, "after unloading tips")
+	return st
+}
+
+func (ds *DeckSimulator) SetPipetteSpeed(head, channel int, rate float64) driver.CommandStatus {
+	hd := ds.head(head)
+
+	if channel < 0 {
+		hd.all = rate
+		hd.speeds = make(map[int]float64)
+	} else {
+		hd.speeds[channel] = rate
+	}
+
+	return driver.CommandStatus{OK: true, Errorcode: driver.OK, Msg: ""}
+}
+
+func (ds *DeckSimulator) SetDriveSpeed(drive string, rate float64) driver.CommandStatus {
+	ds.drives[drive] = rate
+	return driver.CommandStatus{OK: true, Errorcode: driver.OK, Msg: ""}
+}
+
+func (ds *DeckSimulator) Stop() driver.CommandStatus {
+	return ds.record("stop")
+}
+
+func (ds *DeckSimulator) Go() driver.CommandStatus {
+	return ds.record("go")
+}
+
+// nothing is known about where things are or how fast they go after
+// these
+func (ds *DeckSimulator) Initialize() driver.CommandStatus {
+	ds.heads = make(map[int]*simulatedHead)
+	ds.drives = make(map[string]float64)
+	return ds.record("initialize")
+}
+
+func (ds *DeckSimulator) Finalize() driver.CommandStatus {
+	ds.heads = make(map[int]*simulatedHead)
+	ds.drives = make(map[string]float64)
+	return ds.record("finalize")
+}
+
+func (ds *DeckSimulator) SetPositionState(position string, state driver.PositionState) driver.CommandStatus {
+	return ds.record("set state of %s to %v", position, state)
+}
+
+func (ds *DeckSimulator) GetCapabilities() (LHProperties, driver.CommandStatus) {
+	return LHProperties{}, driver.CommandStatus{OK: false, Errorcode: driver.NIM, Msg: "the simulator has no capabilities"}
+}
+
+func (ds *DeckSimulator) GetCurrentPosition(head int) (string, driver.CommandStatus) {
+	return ds.head(head).at, driver.CommandStatus{OK: true, Errorcode: driver.OK, Msg: ""}
+}
+
+func (ds *DeckSimulator) GetPositionState(position string) (string, driver.CommandStatus) {
+	return "", driver.CommandStatus{OK: true, Errorcode: driver.OK, Msg: ""}
+}
+
+func (ds *DeckSimulator) GetHeadState(head int) (string, driver.CommandStatus) {
+	return ds.headState(head), driver.CommandStatus{OK: true, Errorcode: driver.OK, Msg: ""}
+}
+
+func (ds *DeckSimulator) GetStatus() (driver.Status, driver.CommandStatus) {
+	return driver.Status{}, driver.CommandStatus{OK: true, Errorcode: driver.OK, Msg: ""}
+}
+
+func (ds *DeckSimulator) ResetPistons(head, channel int) driver.CommandStatus {
+	return ds.record("reset pistons head %d channel %d %s", head, channel, ds.headState(head))
+}
+
+func (ds *DeckSimulator) Wait(time float64) driver.CommandStatus {
+	if time <= 0 {
+		return driver.CommandStatus{OK: true, Errorcode: driver.OK, Msg: ""}
+	}
+	return ds.record("wait %v", time)
+}
+
+func (ds *DeckSimulator) Mix(head int, volume []float64, fvolume []float64, platetype []string, cycles []int, multi int, prms map[string]interface{}) driver.CommandStatus {
+	ds.head(head).clean = ""
+	return ds.record("mix head %d %s %v %v %v %v %d %v", head, ds.headState(head), volume, fvolume, platetype, cycles, multi, prms)
+}
+
+func (ds *DeckSimulator) AddPlateTo(position string, plate interface{}, name string) driver.CommandStatus {
+	return ds.record("add plate %s to %s", name, position)
+}
+
+func (ds *DeckSimulator) RemoveAllPlates() driver.CommandStatus {
+	return ds.record("remove all plates")
+}
+
+func (ds *DeckSimulator) RemovePlateAt(position string) driver.CommandStatus {
+	return ds.record("remove plate at %s", position)
+}
+
+func (ds *DeckSimulator) UnloadHead(param int) driver.CommandStatus {
+	ds.heads = make(map[int]*simulatedHead)
+	return ds.record("unload head %d", param)
+}
+
+func (ds *DeckSimulator) LoadHead(param int) driver.CommandStatus {
+	ds.heads = make(map[int]*simulatedHead)
+	return ds.record("load head %d", param)
+}
+
+func (ds *DeckSimulator) LightsOn() driver.CommandStatus {
+	return ds.record("lights on")
+}
+
+func (ds *DeckSimulator) LightsOff() driver.CommandStatus {
+	return ds.record("lights off")
+}
+
+func (ds *DeckSimulator) LoadAdaptor(param int) driver.CommandStatus {
+	delete(ds.heads, param)
+	return ds.record("load adaptor on head %d", param)
+}
+
+func (ds *DeckSimulator) UnloadAdaptor(param int) driver.CommandStatus {
+	delete(ds.heads, param)
+	return ds.record("unload adaptor from head %d", param)
+}
+
+func (ds *DeckSimulator) Open() driver.CommandStatus {
+	return ds.record("open")
+}
+
+func (ds *DeckSimulator) Close() driver.CommandStatus {
+	return ds.record("close")
+}
+
+func (ds *DeckSimulator) Message(level int, title, text string, showcancel bool) driver.CommandStatus {
+	return ds.record("message %d %s: %s", level, title, text)
+}
@@ -0,0 +1,340 @@
+// /anthalib/driver/liquidhandling/instructiontrace.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/antha-lang/antha/antha/anthalib/wtype"
+	"github.com/antha-lang/antha/antha/anthalib/wunit"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// ways of looking at the tree of instructions a plan is generated
+// from: Print for people, Trace for programs
+
+type InstructionParameter struct {
+	Name  string
+	Value interface{}
+}
+
+// every field of ins which is set, in the order they are declared
+func InstructionParameters(ins RobotInstruction) []InstructionParameter {
+	ret := make([]InstructionParameter, 0, 10)
+
+	v := reflect.ValueOf(ins)
+
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return ret
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+
+		// the type is in the name already
+		if f.PkgPath != "" || f.Name == "Type" || isUnset(v.Field(i)) {
+			continue
+		}
+
+		ret = append(ret, InstructionParameter{f.Name, v.Field(i).Interface()})
+	}
+
+	return ret
+}
+
+func isUnset(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+// the name and parameters of ins on one line
+func DescribeInstruction(ins RobotInstruction) string {
+	d := &describer{s: []string{Robotinstructionnames[ins.InstructionType()]}}
+
+	VisitRobotInstruction(ins, d)
+
+	// anything without a method of its own gets every field
+
+	if !d.typed {
+		for _, p := range InstructionParameters(ins) {
+			d.add(p.Name, p.Value)
+		}
+	}
+
+	return strings.Join(d.s, " ")
+}
+
+// picks out what matters about the instructions people read most,
+// sources and destinations are shown as plate:well
+type describer struct {
+	RobotInstructionBaseVisitor
+	s     []string
+	typed bool
+}
+
+func (d *describer) add(name string, v interface{}) {
+	d.typed = true
+	if isUnset(reflect.ValueOf(v)) {
+		return
+	}
+	d.s = append(d.s, fmt.Sprintf("%s=%s", name, describeValue(v)))
+}
+
+func places(plts, wells []string) []string {
+	ret := make([]string, len(wells))
+	for i, w := range wells {
+		if i < len(plts) {
+			ret[i] = plts[i] + ":" + w
+		} else {
+			ret[i] = w
+		}
+	}
+	return ret
+}
+
+func places2(plts, wells [][]string) [][]string {
+	ret := make([][]string, len(wells))
+	for i, w := range wells {
+		if i < len(plts) {
+			ret[i] = places(plts[i], w)
+		} else {
+			ret[i] = places(nil, w)
+		}
+	}
+	return ret
+}
+
+func (d *describer) transfer(what, pltfrom, wellfrom, pltto, wellto []string, vols []*wunit.Volume) {
+	d.add("What", what)
+	d.add("Volume", vols)
+	d.add("From", places(pltfrom, wellfrom))
+	d.add("To", places(pltto, wellto))
+}
+
+func (d *describer) VisitTransfer(ins *TransferInstruction) {
+	d.transfer(ins.What, ins.PltFrom, ins.WellFrom, ins.PltTo, ins.WellTo, ins.Volume)
+}
+
+func (d *describer) VisitSingleChannelBlock(ins *SingleChannelBlockInstruction) {
+	d.transfer(ins.What, ins.PltFrom, ins.WellFrom, ins.PltTo, ins.WellTo, ins.Volume)
+	d.add("Prms", ins.Prms)
+}
+
+func (d *describer) VisitMultiChannelBlock(ins *MultiChannelBlockInstruction) {
+	d.add("What", ins.What)
+	d.add("Volume", ins.Volume)
+	d.add("From", places2(ins.PltFrom, ins.WellFrom))
+	d.add("To", places2(ins.PltTo, ins.WellTo))
+	d.add("Multi", ins.Multi)
+	d.add("Prms", ins.Prms)
+}
+
+func (d *describer) VisitSingleChannelTransfer(ins *SingleChannelTransferInstruction) {
+	d.transfer([]string{ins.What}, []string{ins.PltFrom}, []string{ins.WellFrom}, []string{ins.PltTo}, []string{ins.WellTo}, []*wunit.Volume{ins.Volume})
+	d.add("TipType", ins.TipType)
+}
+
+func (d *describer) VisitMultiChannelTransfer(ins *MultiChannelTransferInstruction) {
+	d.transfer(ins.What, ins.PltFrom, ins.WellFrom, ins.PltTo, ins.WellTo, ins.Volume)
+	d.add("Multi", ins.Multi)
+	d.add("TipType", ins.TipType)
+}
+
+func (d *describer) VisitMultiDispense(ins *MultiDispenseInstruction) {
+	d.add("What", ins.What)
+	d.add("From", places(ins.PltFrom, ins.WellFrom))
+	d.add("Volume", ins.Volume)
+	d.add("To", places2(ins.PltTo, ins.WellTo))
+	d.add("Excess", ins.Excess)
+	d.add("TipType", ins.TipType)
+}
+
+func (d *describer) VisitSuck(ins *SuckInstruction) {
+	d.add("What", ins.What)
+	d.add("Volume", ins.Volume)
+	d.add("From", places(ins.PltFrom, ins.WellFrom))
+	d.add("TipType", ins.TipType)
+}
+
+func (d *describer) VisitBlow(ins *BlowInstruction) {
+	d.add("What", ins.What)
+	d.add("Volume", ins.Volume)
+	d.add("To", places(ins.PltTo, ins.WellTo))
+	d.add("TipType", ins.TipType)
+}
+
+func (d *describer) pipette(head int, what, plt []string, vols []*wunit.Volume) {
+	d.add("Head", head)
+	d.add("What", what)
+	d.add("Volume", vols)
+	d.add("Plt", plt)
+}
+
+func (d *describer) VisitAspirate(ins *AspirateInstruction) {
+	d.pipette(ins.Head, ins.What, ins.Plt, ins.Volume)
+}
+
+func (d *describer) VisitDispense(ins *DispenseInstruction) {
+	d.pipette(ins.Head, ins.What, ins.Plt, ins.Volume)
+}
+
+func (d *describer) VisitBlowout(ins *BlowoutInstruction) {
+	d.pipette(ins.Head, ins.What, ins.Plt, ins.Volume)
+}
+
+func (d *describer) VisitMove(ins *MoveInstruction) {
+	d.add("Head", ins.Head)
+	d.add("To", places(ins.Pos, ins.Well))
+	d.add("Plt", ins.Plt)
+	d.add("Reference", ins.Reference)
+	d.add("OffsetZ", ins.OffsetZ)
+}
+
+func (d *describer) VisitLoadTips(ins *LoadTipsInstruction) {
+	d.add("Head", ins.Head)
+	d.add("Channels", ins.Channels)
+	d.add("TipType", ins.TipType)
+	d.add("From", places(ins.Pos, ins.Well))
+}
+
+func (d *describer) VisitUnloadTips(ins *UnloadTipsInstruction) {
+	d.add("Head", ins.Head)
+	d.add("Channels", ins.Channels)
+	d.add("TipType", ins.TipType)
+	d.add("To", places(ins.Pos, ins.Well))
+}
+
+func describeValue(v interface{}) string {
+	switch vv := v.(type) {
+	case *wunit.Volume:
+		if vv == nil {
+			return "nil"
+		}
+		return fmt.Sprintf("%v%s", vv.RawValue(), vv.Unit().PrefixedSymbol())
+	case []*wunit.Volume:
+		s := make([]string, 0, len(vv))
+		for _, vol := range vv {
+			s = append(s, describeValue(vol))
+		}
+		return "[" + strings.Join(s, " ") + "]"
+	case [][]*wunit.Volume:
+		s := make([]string, 0, len(vv))
+		for _, vols := range vv {
+			s = append(s, describeValue(vols))
+		}
+		return "[" + strings.Join(s, " ") + "]"
+	case *wtype.LHChannelParameter:
+		if vv == nil {
+			return "nil"
+		}
+		return vv.Name
+	}
+	return fmt.Sprint(v)
+}
+
+// writes the tree one instruction to a line, indented by how deep
+// it is and numbered by where it is, e.g.
+//	1 TFR What=[water] ...
+//	  1.1 SCB What=[water] ...
+//	    1.1.1 LDT ...
+func (ri *RobotInstructionSet) Print(w io.Writer) error {
+	var err error
+
+	ri.Walk(func(ins RobotInstruction, step []int) {
+		if err != nil {
+			return
+		}
+
+		indent := ""
+		if len(step) > 1 {
+			indent = strings.Repeat("  ", len(step)-1)
+		}
+
+		name := StepName(step)
+		if name != "" {
+			name += " "
+		}
+
+		_, err = fmt.Fprintf(w, "%s%s%s\n", indent, name, DescribeInstruction(ins))
+	})
+
+	return err
+}
+
+// one line of a trace, Parameters is the instruction as it is written
+// in saved plans
+type InstructionTraceEntry struct {
+	Step        string
+	Parent      string
+	Depth       int
+	Instruction string
+	Parameters  json.RawMessage
+}
+
+// writes the tree as JSON, one instruction to a line in the same order
+// Print uses
+func (ri *RobotInstructionSet) Trace(w io.Writer) error {
+	var err error
+
+	enc := json.NewEncoder(w)
+
+	ri.Walk(func(ins RobotInstruction, step []int) {
+		if err != nil {
+			return
+		}
+
+		var b []byte
+
+		b, err = json.Marshal(ins)
+
+		if err != nil {
+			return
+		}
+
+		parent := ""
+		if len(step) > 0 {
+			parent = StepName(step[:len(step)-1])
+		}
+
+		err = enc.Encode(InstructionTraceEntry{
+			Step:        StepName(step),
+			Parent:      parent,
+			Depth:       len(step),
+			Instruction: Robotinstructionnames[ins.InstructionType()],
+			Parameters:  b,
+		})
+	})
+
+	return err
+}
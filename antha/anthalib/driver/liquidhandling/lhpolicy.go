@@ -24,7 +24,13 @@ package liquidhandling
 
 import (
 	"encoding/json"
+	"fmt"
+	"github.com/antha-lang/antha/antha/anthalib/wunit"
+	"github.com/antha-lang/antha/antha/anthalib/wutil"
 	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
 )
 
 const (
@@ -32,44 +38,105 @@ const (
 	LHP_OR
 )
 
-func LoadLHPoliciesFrom(filename string) *LHPolicyRuleSet {
-	dat, _ := ioutil.ReadFile(filename)
+func LoadLHPoliciesFrom(filename string) (*LHPolicyRuleSet, error) {
+	dat, err := ioutil.ReadFile(filename)
 
-	var lhprs LHPolicyRuleSet
+	if err != nil {
+		return nil, err
+	}
+
+	lhprs := NewLHPolicyRuleSet()
+
+	// policies can be written by hand in YAML or saved as JSON
+
+	if ext := filepath.Ext(filename); ext == ".yaml" || ext == ".yml" {
+		lhprs, err = ParseLHPolicyYAML(dat)
+	} else {
+		err = json.Unmarshal(dat, lhprs)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot read policies from %s: %s", filename, err)
+	}
+
+	warnings, err := lhprs.Validate()
+
+	for _, w := range warnings {
+		wutil.Warn(fmt.Sprintf("%s: %s", filename, w))
+	}
 
-	json.Unmarshal(dat, &lhprs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
 
-	return &lhprs
+	return lhprs, nil
 }
 
 // this structure defines parameters
 type LHPolicy map[string]interface{}
 
-// clobber everything in here with the other policy
-// then return the merged copy
+// a copy of this policy with everything in other clobbering it,
+// neither policy is changed
 func (lhp LHPolicy) MergeWith(other LHPolicy) LHPolicy {
+	ret := make(LHPolicy, len(lhp)+len(other))
+	for k, v := range lhp {
+		ret[k] = v
+	}
 	for k, v := range other {
-		lhp[k] = v
+		ret[k] = v
+	}
+	return ret
+}
+
+// a copy which can be changed without changing this one, nil if
+// this is nil
+func (lhp LHPolicy) Dup() LHPolicy {
+	if lhp == nil {
+		return nil
 	}
-	return lhp
+	return LHPolicy{}.MergeWith(lhp)
 }
 
-// conditions are ANDed together
-// there is no chaining
+// conditions and groups are ANDed or ORed together according to Type
+// and the whole lot can be negated. Groups are rules without names
+// which allow things like A AND (B OR NOT C)
 type LHPolicyRule struct {
 	Name       string
 	Conditions []LHVariableCondition
-	Priority   int // possibly deprecated
+	Groups     []LHPolicyRule
+	Priority   int // higher wins, see GetPolicyFor
 	Type       int // AND =0 OR = 1
+	Negate     bool
 }
 
 func NewLHPolicyRule(name string) LHPolicyRule {
 	var lhpr LHPolicyRule
 	lhpr.Name = name
 	lhpr.Conditions = make([]LHVariableCondition, 0, 5)
+	lhpr.Groups = make([]LHPolicyRule, 0)
 	return lhpr
 }
 
+// a group of conditions to go in a rule, typ is LHP_AND or LHP_OR
+func NewLHPolicyGroup(typ int) LHPolicyRule {
+	lhpr := NewLHPolicyRule("")
+	lhpr.Type = typ
+	return lhpr
+}
+
+// a copy whose conditions and groups can be added to without
+// changing this rule
+func (lhpr LHPolicyRule) Dup() LHPolicyRule {
+	ret := lhpr
+	ret.Conditions = make([]LHVariableCondition, len(lhpr.Conditions))
+	copy(ret.Conditions, lhpr.Conditions)
+	ret.Groups = make([]LHPolicyRule, 0, len(lhpr.Groups))
+	for _, group := range lhpr.Groups {
+		ret.Groups = append(ret.Groups, group.Dup())
+	}
+	return ret
+}
+
 func (lhpr *LHPolicyRule) AddNumericConditionOn(variable string, up, low float64) {
 	lhvc := NewLHVariableCondition(variable)
 	lhvc.SetNumeric(up, low)
@@ -82,13 +149,58 @@ func (lhpr *LHPolicyRule) AddCategoryConditionOn(variable, category string) {
 	lhpr.Conditions = append(lhpr.Conditions, lhvc)
 }
 
+func (lhpr *LHPolicyRule) AddSetConditionOn(variable string, categories ...string) {
+	lhvc := NewLHVariableCondition(variable)
+	lhvc.SetSet(categories...)
+	lhpr.Conditions = append(lhpr.Conditions, lhvc)
+}
+
+func (lhpr *LHPolicyRule) AddRegexpConditionOn(variable, pattern string) {
+	lhvc := NewLHVariableCondition(variable)
+	lhvc.SetRegexp(pattern)
+	lhpr.Conditions = append(lhpr.Conditions, lhvc)
+}
+
+func (lhpr *LHPolicyRule) AddCondition(lhvc LHVariableCondition) {
+	lhpr.Conditions = append(lhpr.Conditions, lhvc)
+}
+
+func (lhpr *LHPolicyRule) AddGroup(group LHPolicyRule) {
+	lhpr.Groups = append(lhpr.Groups, group)
+}
+
 func (lhpr LHPolicyRule) Check(ins RobotInstruction) bool {
+	return lhpr.check(ins) != lhpr.Negate
+}
+
+func (lhpr LHPolicyRule) check(ins RobotInstruction) bool {
+	anyof := lhpr.Type == LHP_OR
+
+	// an empty AND matches everything, an empty OR nothing
+
 	for _, condition := range lhpr.Conditions {
-		if !condition.Check(ins) {
-			return false
+		if condition.Check(ins) == anyof {
+			return anyof
 		}
 	}
-	return true
+
+	for _, group := range lhpr.Groups {
+		if group.Check(ins) == anyof {
+			return anyof
+		}
+	}
+
+	return !anyof
+}
+
+// how many conditions the rule has in all, more specific rules win
+// ties in GetPolicyFor
+func (lhpr LHPolicyRule) Specificity() int {
+	n := len(lhpr.Conditions)
+	for _, group := range lhpr.Groups {
+		n += group.Specificity()
+	}
+	return n
 }
 
 // this just looks for the same conditions, doesn't matter if
@@ -103,7 +215,7 @@ func (lhpr LHPolicyRule) IsEqualTo(other LHPolicyRule) bool {
 	// three have the same consequences but we'll just have to
 	// try and enforce some consistency rules to prevent that situation
 
-	if len(lhpr.Conditions) != len(other.Conditions) {
+	if len(lhpr.Conditions) != len(other.Conditions) || len(lhpr.Groups) != len(other.Groups) || lhpr.Type != other.Type || lhpr.Negate != other.Negate {
 		return false
 	}
 
@@ -115,9 +227,24 @@ func (lhpr LHPolicyRule) IsEqualTo(other LHPolicyRule) bool {
 			return false
 		}
 	}
+
+	for _, g := range lhpr.Groups {
+		if !other.HasGroup(g) {
+			return false
+		}
+	}
 	return true
 }
 
+func (lhpr LHPolicyRule) HasGroup(group LHPolicyRule) bool {
+	for _, g := range lhpr.Groups {
+		if g.IsEqualTo(group) {
+			return true
+		}
+	}
+	return false
+}
+
 func (lhpr LHPolicyRule) HasCondition(cond LHVariableCondition) bool {
 	for _, c := range lhpr.Conditions {
 		if c.IsEqualTo(cond) {
@@ -130,6 +257,7 @@ func (lhpr LHPolicyRule) HasCondition(cond LHVariableCondition) bool {
 type LHVariableCondition struct {
 	TestVariable string
 	Condition    LHCondition
+	Negate       bool
 }
 
 func NewLHVariableCondition(testvariable string) LHVariableCondition {
@@ -139,7 +267,7 @@ func NewLHVariableCondition(testvariable string) LHVariableCondition {
 }
 
 func (lhvc *LHVariableCondition) SetNumeric(up, low float64) {
-	if up > low {
+	if up < low {
 		panic("Nonsensical numeric condition requested")
 	}
 	lhvc.Condition = LHNumericCondition{up, low}
@@ -152,8 +280,23 @@ func (lhvc *LHVariableCondition) SetCategoric(category string) {
 	lhvc.Condition = LHCategoryCondition{category}
 }
 
+func (lhvc *LHVariableCondition) SetSet(categories ...string) {
+	if len(categories) == 0 {
+		panic("No empty set conditions can be made")
+	}
+	lhvc.Condition = LHSetCondition{categories}
+}
+
+func (lhvc *LHVariableCondition) SetRegexp(pattern string) {
+	c, err := NewLHRegexpCondition(pattern)
+	if err != nil {
+		panic(err.Error())
+	}
+	lhvc.Condition = c
+}
+
 func (lhvc LHVariableCondition) IsEqualTo(other LHVariableCondition) bool {
-	if lhvc.TestVariable != other.TestVariable {
+	if lhvc.TestVariable != other.TestVariable || lhvc.Negate != other.Negate {
 		return false
 	}
 	return lhvc.Condition.IsEqualTo(other.Condition)
@@ -161,12 +304,17 @@ func (lhvc LHVariableCondition) IsEqualTo(other LHVariableCondition) bool {
 
 func (lhvc LHVariableCondition) Check(ins RobotInstruction) bool {
 	v := ins.GetParameter(lhvc.TestVariable)
-	return lhvc.Condition.Match(v)
+	return lhvc.Condition.Match(v) != lhvc.Negate
 }
 
+// Source labels where the set came from (e.g. system, user, protocol),
+// Sources records items in Policies which came from somewhere else
+// after a merge: Sources[policy][item] = source
 type LHPolicyRuleSet struct {
 	Policies map[string]LHPolicy
 	Rules    map[string]LHPolicyRule
+	Source   string                       `json:",omitempty"`
+	Sources  map[string]map[string]string `json:",omitempty"`
 }
 
 func NewLHPolicyRuleSet() *LHPolicyRuleSet {
@@ -176,20 +324,60 @@ func NewLHPolicyRuleSet() *LHPolicyRuleSet {
 	return &lhpr
 }
 
-func (lhpr *LHPolicyRuleSet) AddRule(rule LHPolicyRule, consequent LHPolicy) {
+// the rule isn't added if its policy has bad values in it
+func (lhpr *LHPolicyRuleSet) AddRule(rule LHPolicyRule, consequent LHPolicy) error {
+	if err := validatePolicy(rule.Name, consequent); err != nil {
+		return err
+	}
 	lhpr.Policies[rule.Name] = consequent
 	lhpr.Rules[rule.Name] = rule
+	delete(lhpr.Sources, rule.Name)
+	return nil
 }
 
 func CloneLHPolicyRuleSet(parent *LHPolicyRuleSet) *LHPolicyRuleSet {
 	child := NewLHPolicyRuleSet()
-	for k, _ := range parent.Rules {
-		child.Policies[k] = parent.Policies[k]
-		child.Rules[k] = parent.Rules[k]
+	// the child gets its own maps so neither can change the other,
+	// some policies (e.g. default) may have no rule
+	for k, pol := range parent.Policies {
+		child.Policies[k] = pol.Dup()
+	}
+	for k, rule := range parent.Rules {
+		child.Rules[k] = rule.Dup()
+	}
+	child.Source = parent.Source
+	for k, srcs := range parent.Sources {
+		for item, src := range srcs {
+			child.setSource(k, item, src)
+		}
 	}
 	return child
 }
 
+// where item in the named policy came from
+func (lhpr LHPolicyRuleSet) SourceOf(policy, item string) string {
+	if src, ok := lhpr.Sources[policy][item]; ok {
+		return src
+	}
+	return lhpr.Source
+}
+
+func (lhpr *LHPolicyRuleSet) setSource(policy, item, src string) {
+	if src == lhpr.Source {
+		if lhpr.Sources[policy] != nil {
+			delete(lhpr.Sources[policy], item)
+		}
+		return
+	}
+	if lhpr.Sources == nil {
+		lhpr.Sources = make(map[string]map[string]string)
+	}
+	if lhpr.Sources[policy] == nil {
+		lhpr.Sources[policy] = make(map[string]string)
+	}
+	lhpr.Sources[policy][item] = src
+}
+
 func (lhpr LHPolicyRuleSet) GetEquivalentRuleTo(rule LHPolicyRule) string {
 	for k, c := range lhpr.Rules {
 		if c.IsEqualTo(rule) {
@@ -200,53 +388,86 @@ func (lhpr LHPolicyRuleSet) GetEquivalentRuleTo(rule LHPolicyRule) string {
 	return ""
 }
 
-func (lhpr *LHPolicyRuleSet) MergeWith(other *LHPolicyRuleSet) {
+// items in other's policies override ours, rules we don't have
+// an equivalent for are added; if any of other's policies have bad
+// values in them nothing is merged
+func (lhpr *LHPolicyRuleSet) MergeWith(other *LHPolicyRuleSet) error {
+	for k, _ := range other.Rules {
+		if err := validatePolicy(k, other.Policies[k]); err != nil {
+			return err
+		}
+	}
+
 	for k, rule := range other.Rules {
+		pol := other.Policies[k]
+
 		name := lhpr.GetEquivalentRuleTo(rule)
 
-		if name != "" {
-			// merge the two policies
-			pol := other.Policies[k]
-			p2 := lhpr.Policies[k]
-			p2.MergeWith(pol)
-			lhpr.Policies[k] = p2
+		if name == "" {
+			// a new rule
+			name = k
+			lhpr.Rules[name] = rule.Dup()
+			lhpr.Policies[name] = LHPolicy{}
+			delete(lhpr.Sources, name)
+		}
+
+		// merge the two policies, this makes a new map so nothing
+		// other has is shared
+		lhpr.Policies[name] = lhpr.Policies[name].MergeWith(pol)
+
+		for item, _ := range pol {
+			lhpr.setSource(name, item, other.SourceOf(k, item))
 		}
 	}
+
+	return nil
 }
 
-func (lhpr LHPolicyRuleSet) GetPolicyFor(ins RobotInstruction) LHPolicy {
-	var match LHPolicyRule
-	matchpriority := -1
+// every rule which matches ins, best first: rules with a higher
+// Priority win, then those with more conditions, then the first by name
+func (lhpr LHPolicyRuleSet) MatchingRules(ins RobotInstruction) []LHPolicyRule {
+	ret := make([]LHPolicyRule, 0, 2)
 
 	for _, rule := range lhpr.Rules {
-		if rule.Priority >= matchpriority {
-			// TODO:
-			// parameters of instructions are typically vectors
-			// these have to be dealt with properly
-			if rule.Check(ins) {
-				if rule.Priority == matchpriority {
-					// keep the most specific rule (most conditions)
-					if len(rule.Conditions) < len(match.Conditions) {
-						match = rule
-					} else if len(rule.Conditions) == len(match.Conditions) {
-						// what now?
-						// we need to forbid this situation: two rules at the same
-						// level with the same priority can't match the same thing
-						// so we do nothing
-					}
-				} else {
-					match = rule
-				}
-			}
+		// TODO:
+		// parameters of instructions are typically vectors
+		// these have to be dealt with properly
+		if rule.Check(ins) {
+			ret = append(ret, rule)
 		}
 	}
 
-	// we might prefer to just merge this in
+	sort.Sort(ByPrecedence(ret))
+
+	return ret
+}
+
+type ByPrecedence []LHPolicyRule
+
+func (bp ByPrecedence) Len() int      { return len(bp) }
+func (bp ByPrecedence) Swap(i, j int) { bp[i], bp[j] = bp[j], bp[i] }
+func (bp ByPrecedence) Less(i, j int) bool {
+	if bp[i].Priority != bp[j].Priority {
+		return bp[i].Priority > bp[j].Priority
+	}
+
+	if si, sj := bp[i].Specificity(), bp[j].Specificity(); si != sj {
+		return si > sj
+	}
+
+	return bp[i].Name < bp[j].Name
+}
+
+func (lhpr LHPolicyRuleSet) GetPolicyFor(ins RobotInstruction) LHPolicy {
+	matches := lhpr.MatchingRules(ins)
+
+	// what comes back is always a copy so instructions can't change
+	// the policies the next one gets
 
-	if match.Name == "" {
-		return lhpr.Policies["default"]
+	if len(matches) == 0 {
+		return lhpr.Policies["default"].MergeWith(nil)
 	}
-	return lhpr.Policies["default"].MergeWith(lhpr.Policies[match.Name])
+	return lhpr.Policies["default"].MergeWith(lhpr.Policies[matches[0].Name])
 }
 
 type LHCondition interface {
@@ -260,17 +481,26 @@ type LHCategoryCondition struct {
 }
 
 func (lhcc LHCategoryCondition) Match(v interface{}) bool {
+	return matchStrings(v, func(s string) bool { return s == lhcc.Category })
+}
 
+// true iff v is a string which matches or an array of them which all do
+func matchStrings(v interface{}, match func(string) bool) bool {
 	switch v.(type) {
 	case string:
-		s := v.(string)
-		if s == lhcc.Category {
-			return true
-		}
+		return match(v.(string))
 	case []string:
 		// true iff all members of the array are the same category
 		for _, s := range v.([]string) {
-			if !lhcc.Match(s) {
+			if !match(s) {
+				return false
+			}
+		}
+		return true
+	case [][]string:
+		// multichannel blocks: every member of every set must match
+		for _, s := range v.([][]string) {
+			if !matchStrings(s, match) {
 				return false
 			}
 		}
@@ -290,6 +520,84 @@ func (lhcc LHCategoryCondition) IsEqualTo(other LHCondition) bool {
 	return other.Match(lhcc.Category)
 }
 
+// matches any one of a set of categories
+type LHSetCondition struct {
+	Categories []string
+}
+
+func (lhsc LHSetCondition) Match(v interface{}) bool {
+	return matchStrings(v, func(s string) bool {
+		for _, c := range lhsc.Categories {
+			if s == c {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (lhsc LHSetCondition) Type() string {
+	return "set"
+}
+
+func (lhsc LHSetCondition) IsEqualTo(other LHCondition) bool {
+	if other.Type() != lhsc.Type() {
+		return false
+	}
+	o := other.(LHSetCondition)
+	if len(o.Categories) != len(lhsc.Categories) {
+		return false
+	}
+	for _, c := range o.Categories {
+		if !lhsc.Match(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches names against a regular expression, e.g. ^glycerol
+type LHRegexpCondition struct {
+	Pattern string
+	re      *regexp.Regexp
+}
+
+func NewLHRegexpCondition(pattern string) (LHRegexpCondition, error) {
+	re, err := regexp.Compile(pattern)
+
+	if err != nil {
+		return LHRegexpCondition{}, fmt.Errorf("Bad regexp condition %s: %s", pattern, err)
+	}
+
+	return LHRegexpCondition{Pattern: pattern, re: re}, nil
+}
+
+// conditions not made by NewLHRegexpCondition have no compiled pattern,
+// they only match if it compiles
+func (lhrc LHRegexpCondition) Match(v interface{}) bool {
+	re := lhrc.re
+
+	if re == nil {
+		var err error
+		if re, err = regexp.Compile(lhrc.Pattern); err != nil {
+			return false
+		}
+	}
+
+	return matchStrings(v, re.MatchString)
+}
+
+func (lhrc LHRegexpCondition) Type() string {
+	return "regexp"
+}
+
+func (lhrc LHRegexpCondition) IsEqualTo(other LHCondition) bool {
+	if other.Type() != lhrc.Type() {
+		return false
+	}
+	return other.(LHRegexpCondition).Pattern == lhrc.Pattern
+}
+
 type LHNumericCondition struct {
 	Upper float64
 	Lower float64
@@ -309,6 +617,7 @@ func (lhnc LHNumericCondition) IsEqualTo(other LHCondition) bool {
 	return false
 }
 
+// volumes are compared in ul
 func (lhnc LHNumericCondition) Match(v interface{}) bool {
 	switch v.(type) {
 	case float64:
@@ -317,6 +626,13 @@ func (lhnc LHNumericCondition) Match(v interface{}) bool {
 		if f <= lhnc.Upper && f >= lhnc.Lower {
 			return true
 		}
+	case int:
+		return lhnc.Match(float64(v.(int)))
+	case *wunit.Volume:
+		if v.(*wunit.Volume) == nil {
+			return false
+		}
+		return lhnc.Match(v.(*wunit.Volume).ConvertTo(wunit.ParsePrefixedUnit("ul")))
 	case []float64:
 		//true iff all values are within range
 		// these are simple rules but could need refinement
@@ -326,6 +642,20 @@ func (lhnc LHNumericCondition) Match(v interface{}) bool {
 			}
 		}
 		return true
+	case []*wunit.Volume:
+		for _, f := range v.([]*wunit.Volume) {
+			if !lhnc.Match(f) {
+				return false
+			}
+		}
+		return true
+	case [][]*wunit.Volume:
+		for _, f := range v.([][]*wunit.Volume) {
+			if !lhnc.Match(f) {
+				return false
+			}
+		}
+		return true
 	} // switch
 	return false
 }
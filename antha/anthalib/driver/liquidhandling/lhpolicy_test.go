@@ -0,0 +1,254 @@
+// /anthalib/driver/liquidhandling/lhpolicy_test.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/antha-lang/antha/antha/anthalib/driver/liquidhandling"
+	"github.com/antha-lang/antha/antha/anthalib/factory"
+	"github.com/antha-lang/antha/antha/anthalib/wunit"
+)
+
+func TestPolicyRules(t *testing.T) {
+	sct := func(what, tip, plate string, vol float64) *liquidhandling.SingleChannelTransferInstruction {
+		ins := liquidhandling.NewSingleChannelTransferInstruction()
+		v := wunit.NewVolume(vol, "ul")
+		ins.What = what
+		ins.TipType = tip
+		ins.FPlateType = plate
+		ins.Volume = &v
+		return ins
+	}
+
+	glycerol := sct("glycerol_60", "Gilson10", "pcrplate", 5.0)
+	water := sct("water", "Gilson200", "DSW96", 100.0)
+
+	// OR, NOT, sets, regexps and volumes
+
+	rule := liquidhandling.NewLHPolicyRule("or")
+	rule.Type = liquidhandling.LHP_OR
+	rule.AddCategoryConditionOn("LIQUIDCLASS", "water")
+	rule.AddRegexpConditionOn("LIQUIDCLASS", "^glycerol_[5-9][0-9]")
+
+	if !rule.Check(glycerol) || !rule.Check(water) || rule.Check(sct("glycerol_20", "Gilson10", "pcrplate", 5.0)) {
+		t.Errorf("OR rules should match if any condition does")
+	}
+
+	rule = liquidhandling.NewLHPolicyRule("and")
+	rule.AddSetConditionOn("TIPTYPE", "Gilson10", "Gilson50")
+	rule.AddNumericConditionOn("VOLUME", 10.0, 0.0)
+	group := liquidhandling.NewLHPolicyGroup(liquidhandling.LHP_OR)
+	group.AddCategoryConditionOn("FROMPLATETYPE", "DSW96")
+	group.AddCategoryConditionOn("FROMPLATETYPE", "pcrplate")
+	group.Negate = true
+	rule.AddGroup(group)
+
+	if rule.Check(glycerol) || rule.Check(water) || !rule.Check(sct("glycerol_60", "Gilson50", "reservoir", 5.0)) {
+		t.Errorf("AND rules with negated groups should only match when all conditions do")
+	}
+
+	// tip types and channel counts come from the instructions generated
+
+	params := factory.GetLiquidhandlerByType("GilsonPipetmax")
+	params.AddTipBox(factory.GetTipboxByType("Gilson10"))
+	params.AddTipWaste("position_1", factory.GetTipwasteByType("Gilsontipwaste"))
+	params.ResetTipTracking()
+	scb := liquidhandling.NewSingleChannelBlockInstruction()
+	v := wunit.NewVolume(5.0, "ul")
+	fv := wunit.NewVolume(100.0, "ul")
+	tv := wunit.NewVolume(0.0, "ul")
+	scb.AddTransferParams(liquidhandling.TransferParams{What: "water", PltFrom: "position_4", PltTo: "position_7", WellFrom: "A1", WellTo: "A1", Volume: &v, FVolume: &fv, TVolume: &tv, FPlateType: "pcrplate", TPlateType: "pcrplate"})
+
+	for _, ins := range scb.Generate(liquidhandling.GetLHPolicyForTest(), params) {
+		if ins.InstructionType() == liquidhandling.SCT && (ins.GetParameter("TIPTYPE") != "Gilson10" || ins.GetParameter("MULTI") != 1) {
+			t.Errorf("transfers should say which tips they use and how many channels, got %v %v", ins.GetParameter("TIPTYPE"), ins.GetParameter("MULTI"))
+		}
+	}
+
+	// higher priority wins, then more conditions, then name order
+
+	lhpr := liquidhandling.GetLHPolicyForTest()
+
+	add := func(name string, priority int, conditions int) {
+		rule := liquidhandling.NewLHPolicyRule(name)
+		rule.Priority = priority
+		rule.AddRegexpConditionOn("LIQUIDCLASS", "^glycerol")
+		if conditions > 1 {
+			rule.AddSetConditionOn("TIPTYPE", "Gilson10")
+		}
+		lhpr.AddRule(rule, liquidhandling.LHPolicy{"TIP_REUSE_LIMIT": priority*10 + conditions})
+	}
+
+	best := func() string {
+		m := lhpr.MatchingRules(glycerol)
+		if len(m) == 0 {
+			return ""
+		}
+		return m[0].Name
+	}
+
+	add("b", 0, 1)
+	add("a", 0, 1)
+
+	if best() != "a" {
+		t.Errorf("ties should go to the first rule by name, got %s", best())
+	}
+
+	add("c", 0, 2)
+
+	if best() != "c" {
+		t.Errorf("more specific rules should win, got %s", best())
+	}
+
+	add("d", 1, 1)
+
+	if best() != "d" || lhpr.GetPolicyFor(glycerol)["TIP_REUSE_LIMIT"] != 11 {
+		t.Errorf("higher priority rules should win, got %s", best())
+	}
+
+	// and rules survive being saved
+
+	b, err := json.Marshal(lhpr)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lhpr2 := liquidhandling.NewLHPolicyRuleSet()
+
+	if err := json.Unmarshal(b, lhpr2); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, rule := range lhpr.Rules {
+		if !rule.IsEqualTo(lhpr2.Rules[name]) {
+			t.Errorf("rule %s should come back from JSON as it was", name)
+		}
+	}
+
+	if m := lhpr2.MatchingRules(glycerol); len(m) == 0 || m[0].Name != "d" {
+		t.Errorf("regexps should still match after coming back from JSON, got %v", m)
+	}
+
+	// patterns which don't compile can't be loaded
+
+	bad := strings.Replace(string(b), `"Pattern":"^glycerol"`, `"Pattern":"^glycerol("`, 1)
+
+	if bad == string(b) {
+		t.Fatalf("no pattern found to break in %s", b)
+	}
+
+	if err := json.Unmarshal([]byte(bad), liquidhandling.NewLHPolicyRuleSet()); err == nil || !strings.Contains(err.Error(), "^glycerol(") {
+		t.Errorf("bad regexps should not load, got %v", err)
+	}
+}
+
+func TestPolicyMergeCopies(t *testing.T) {
+	sct := func(what string) *liquidhandling.SingleChannelTransferInstruction {
+		ins := liquidhandling.NewSingleChannelTransferInstruction()
+		v := wunit.NewVolume(5.0, "ul")
+		ins.What = what
+		ins.Volume = &v
+		return ins
+	}
+
+	// dna mixes afterwards, water and anything without a rule of its
+	// own shouldn't however many dna transfers come first
+
+	lhpr := liquidhandling.GetLHPolicyForTest()
+	ndefault := len(lhpr.Policies["default"])
+
+	for _, what := range []string{"dna", "water", "dna", "tartrazine"} {
+		pol := lhpr.GetPolicyFor(sct(what))
+
+		if _, ok := pol["POST_MIX"]; ok != (what == "dna") {
+			t.Errorf("%s: POST_MIX should only be set for dna, got %v", what, pol)
+		}
+
+		// what comes back is the caller's to change
+		pol["ASPSPEED"] = 100.0
+	}
+
+	if len(lhpr.Policies["default"]) != ndefault || lhpr.Policies["default"]["ASPSPEED"] == 100.0 {
+		t.Errorf("looking policies up should not change the default")
+	}
+
+	a := liquidhandling.LHPolicy{"TOUCHOFF": true}
+	b := liquidhandling.LHPolicy{"TOUCHOFF": false, "PRE_MIX": 3}
+	c := a.MergeWith(b)
+
+	if len(a) != 1 || a["TOUCHOFF"] != true || len(b) != 2 || len(c) != 2 || c["TOUCHOFF"] != false {
+		t.Errorf("merging should make a new policy, got %v %v %v", a, b, c)
+	}
+
+	// clones and merged sets have maps of their own
+
+	parent := liquidhandling.GetLHPolicyForTest()
+	parent.Policies["nocondition"] = liquidhandling.LHPolicy{"TOUCHOFF": true}
+	child := liquidhandling.CloneLHPolicyRuleSet(parent)
+
+	if child.Policies["nocondition"] == nil {
+		t.Errorf("policies without rules should be cloned too")
+	}
+
+	child.Policies["water"]["ASPSPEED"] = 100.0
+	rule := child.Rules["water"]
+	rule.AddCategoryConditionOn("PLATETYPE", "pcrplate")
+	child.Rules["water"] = rule
+
+	if _, ok := parent.Policies["water"]["ASPSPEED"]; ok || len(parent.Rules["water"].Conditions) != 1 {
+		t.Errorf("changing a clone should not change what it was cloned from")
+	}
+
+	other := liquidhandling.NewLHPolicyRuleSet()
+	rule = liquidhandling.NewLHPolicyRule("mywater")
+	rule.AddCategoryConditionOn("LIQUIDCLASS", "water")
+	other.AddRule(rule, liquidhandling.LHPolicy{"DSPSPEED": 2.0})
+	rule = liquidhandling.NewLHPolicyRule("new")
+	rule.AddCategoryConditionOn("LIQUIDCLASS", "new")
+	other.AddRule(rule, liquidhandling.LHPolicy{"DSPSPEED": 3.0})
+
+	system := liquidhandling.GetLHPolicyForTest()
+	merged := liquidhandling.CloneLHPolicyRuleSet(system)
+
+	if err := merged.MergeWith(other); err != nil {
+		t.Fatal(err)
+	}
+
+	if merged.Policies["water"]["DSPSPEED"] != 2.0 || merged.Policies["new"]["DSPSPEED"] != 3.0 {
+		t.Errorf("protocol policies should be merged in, got %v %v", merged.Policies["water"], merged.Policies["new"])
+	}
+
+	if _, ok := system.Policies["water"]["DSPSPEED"]; ok {
+		t.Errorf("merging should not change the system policies")
+	}
+
+	merged.Policies["new"]["DSPSPEED"] = 4.0
+
+	if other.Policies["new"]["DSPSPEED"] != 3.0 {
+		t.Errorf("merging should not share policies with the set merged in")
+	}
+}
@@ -0,0 +1,83 @@
+// /anthalib/driver/liquidhandling/liquidlevel.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+import (
+	"github.com/antha-lang/antha/antha/anthalib/wunit"
+)
+
+// works out how far above the bottom of each well the channels should
+// go to aspirate or dispense and whether they should follow the liquid
+// as its level changes. before and after are the volumes in the wells
+// either side of the operation, zoffset is the policy's height above
+// the bottom which tips never go below
+//
+// tips stay submerged for the whole operation unless the well is deep
+// enough to follow the liquid, in which case they start just under the
+// surface and the driver takes them down (or up) with it
+func liquidLevelHeights(pol LHPolicy, prms *LHProperties, multi int, positions, wells []string, before, after []*wunit.Volume, zoffset float64) ([]float64, []bool) {
+	offsets := make([]float64, multi)
+	llf := make([]bool, multi)
+
+	for i := 0; i < multi; i++ {
+		offsets[i] = zoffset
+	}
+
+	use, _ := pol["USE_LLF"].(bool)
+
+	if !use || prms == nil {
+		return offsets, llf
+	}
+
+	below, _ := pol["LLF_BELOW_SURFACE"].(float64)
+	mindepth, _ := pol["LLF_MIN_WELL_DEPTH"].(float64)
+
+	for i := 0; i < multi && i < len(positions) && i < len(wells) && i < len(before) && i < len(after); i++ {
+		plate, ok := prms.Plates[positions[i]]
+
+		if !ok || plate == nil || plate.Welltype == nil {
+			continue
+		}
+
+		well := plate.Welltype
+
+		hb := well.LiquidHeight(before[i])
+		ha := well.LiquidHeight(after[i])
+
+		llf[i] = well.Zdim >= mindepth
+
+		h := hb
+
+		if !llf[i] && ha < hb {
+			h = ha
+		}
+
+		h -= below
+
+		if h > offsets[i] {
+			offsets[i] = h
+		}
+	}
+
+	return offsets, llf
+}
@@ -0,0 +1,124 @@
+// /anthalib/driver/liquidhandling/liquidlevel_test.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/antha-lang/antha/antha/anthalib/driver/liquidhandling"
+	"github.com/antha-lang/antha/antha/anthalib/factory"
+	"github.com/antha-lang/antha/antha/anthalib/wunit"
+)
+
+func TestLiquidLevel(t *testing.T) {
+	params := factory.GetLiquidhandlerByType("GilsonPipetmax")
+	params.AddPlate("position_4", factory.GetPlateByType("DSW96"))
+	params.AddPlate("position_7", factory.GetPlateByType("pcrplate"))
+	policies := liquidhandling.GetLHPolicyForTest()
+
+	suck := func(pos, what string, vol, wvol float64) (*liquidhandling.MoveInstruction, *liquidhandling.AspirateInstruction) {
+		ins := liquidhandling.NewSuckInstruction()
+		ins.Multi = 1
+		v := wunit.NewVolume(vol, "ul")
+		fv := wunit.NewVolume(wvol, "ul")
+		ins.AddTransferParams(liquidhandling.TransferParams{What: what, PltFrom: pos, WellFrom: "A1", Volume: &v, FVolume: &fv})
+		var mov *liquidhandling.MoveInstruction
+		for _, r := range ins.Generate(policies, params) {
+			switch r.(type) {
+			case *liquidhandling.MoveInstruction:
+				mov = r.(*liquidhandling.MoveInstruction)
+			case *liquidhandling.AspirateInstruction:
+				return mov, r.(*liquidhandling.AspirateInstruction)
+			}
+		}
+		return nil, nil
+	}
+
+	blow := func(pos, what string, vol, wvol float64) (*liquidhandling.MoveInstruction, *liquidhandling.DispenseInstruction) {
+		ins := liquidhandling.NewBlowInstruction()
+		ins.Multi = 1
+		v := wunit.NewVolume(vol, "ul")
+		tv := wunit.NewVolume(wvol, "ul")
+		ins.AddTransferParams(liquidhandling.TransferParams{What: what, PltTo: pos, WellTo: "A1", Volume: &v, TVolume: &tv})
+		var mov *liquidhandling.MoveInstruction
+		for _, r := range ins.Generate(policies, params) {
+			switch r.(type) {
+			case *liquidhandling.MoveInstruction:
+				mov = r.(*liquidhandling.MoveInstruction)
+			case *liquidhandling.DispenseInstruction:
+				return mov, r.(*liquidhandling.DispenseInstruction)
+			}
+		}
+		return nil, nil
+	}
+
+	// 1000 ul in a deep well is a pyramid 4.7 mm high holding 105.35 ul
+	// plus 13.31 mm of straight sides, we go 1 mm under and follow it down
+
+	mov, asp := suck("position_4", "water", 100.0, 1000.0)
+
+	if math.Abs(mov.OffsetZ[0]-17.006) > 0.01 || !asp.LLF[0] {
+		t.Errorf("deep well aspirate should start under the surface and follow it, got %v at %v", asp.LLF, mov.OffsetZ)
+	}
+
+	// shallow wells are entered below where the surface ends up but
+	// never below the policy offset
+
+	mov, asp = suck("position_7", "water", 100.0, 300.0)
+
+	if math.Abs(mov.OffsetZ[0]-2.787) > 0.01 || asp.LLF[0] {
+		t.Errorf("shallow well aspirate should stay under the final surface without following, got %v at %v", asp.LLF, mov.OffsetZ)
+	}
+
+	mov, asp = suck("position_7", "water", 50.0, 60.0)
+
+	if mov.OffsetZ[0] != 0.5 {
+		t.Errorf("aspirate should not go below the policy offset, got %v", mov.OffsetZ)
+	}
+
+	// dispensing from the top ignores the liquid, from the bottom it
+	// follows the level up
+
+	mov, dsp := blow("position_4", "water", 100.0, 1000.0)
+
+	if mov.Reference[0] != 1 || mov.OffsetZ[0] != -0.5 || dsp.LLF[0] {
+		t.Errorf("dispense from the top should not depend on the liquid, got %v at %v", dsp.LLF, mov.OffsetZ)
+	}
+
+	mov, dsp = blow("position_4", "culture", 100.0, 1000.0)
+
+	if mov.Reference[0] != 0 || math.Abs(mov.OffsetZ[0]-17.006) > 0.01 || !dsp.LLF[0] {
+		t.Errorf("deep well dispense from the bottom should follow the liquid, got %v at %v", dsp.LLF, mov.OffsetZ)
+	}
+
+	// and it can be switched off
+
+	policies.Policies["default"]["USE_LLF"] = false
+
+	mov, asp = suck("position_4", "water", 100.0, 1000.0)
+
+	if mov.OffsetZ[0] != 0.5 || asp.LLF[0] {
+		t.Errorf("without liquid level following we should go to the policy offset, got %v at %v", asp.LLF, mov.OffsetZ)
+	}
+}
@@ -72,10 +72,10 @@ func MakeCulturePolicy() LHPolicy {
 
 func MakeGlycerolPolicy() LHPolicy {
 	glycerolpolicy := make(LHPolicy, 5)
-	glycerolpolicy["ASP_SPEED"] = 1.0
-	glycerolpolicy["DSP_SPEED"] = 1.0
-	glycerolpolicy["ASP_WAIT"] = 5
-	glycerolpolicy["DSP_WAIT"] = 5
+	glycerolpolicy["ASPSPEED"] = 1.0
+	glycerolpolicy["DSPSPEED"] = 1.0
+	glycerolpolicy["ASP_WAIT"] = 5.0
+	glycerolpolicy["DSP_WAIT"] = 5.0
 	glycerolpolicy["TOUCHOFF"] = true
 	glycerolpolicy["TIP_REUSE_LIMIT"] = 0
 	return glycerolpolicy
@@ -93,7 +93,7 @@ func MakeSolventPolicy() LHPolicy {
 func MakeDNAPolicy() LHPolicy {
 	dnapolicy := make(LHPolicy, 10)
 	dnapolicy["POST_MIX"] = 3
-	dnapolicy["POST_MIX_VOLUME"] = 50
+	dnapolicy["POST_MIX_VOLUME"] = 50.0
 	dnapolicy["ASPSPEED"] = 0.06
 	dnapolicy["DSPSPEED"] = 0.06
 	dnapolicy["CAN_MULTI"] = false
@@ -108,8 +108,6 @@ func MakeDNAPolicy() LHPolicy {
 
 func MakeDefaultPolicy() LHPolicy {
 	defaultpolicy := make(LHPolicy, 10)
-	defaultpolicy["ASP_SPEED"] = 3.0
-	defaultpolicy["DSP_SPEED"] = 3.0
 	defaultpolicy["TOUCHOFF"] = false
 	defaultpolicy["TOUCHOFFSET"] = 0.5
 	defaultpolicy["ASPREFERENCE"] = 0
@@ -120,6 +118,7 @@ func MakeDefaultPolicy() LHPolicy {
 	defaultpolicy["CAN_MSA"] = false
 	defaultpolicy["CAN_SDD"] = true
 	defaultpolicy["TIP_REUSE_LIMIT"] = 100
+	defaultpolicy["TIP_REUSE_SAME_SOURCE"] = true
 	defaultpolicy["BLOWOUTREFERENCE"] = 1
 	defaultpolicy["BLOWOUTOFFSET"] = -0.5
 	defaultpolicy["BLOWOUTVOLUME"] = 200.0
@@ -128,6 +127,10 @@ func MakeDefaultPolicy() LHPolicy {
 	defaultpolicy["PTZOFFSET"] = -0.5
 	defaultpolicy["NO_AIR_DISPENSE"] = false
 	defaultpolicy["DEFAULTPIPETTESPEED"] = 1.0
+	defaultpolicy["USE_LLF"] = true
+	defaultpolicy["LLF_BELOW_SURFACE"] = 1.0
+	defaultpolicy["LLF_MIN_WELL_DEPTH"] = 20.0
+	defaultpolicy["ADAPTOR_CHANGE_MOVES"] = 4
 	return defaultpolicy
 }
 
@@ -143,7 +146,10 @@ func GetLHPolicyForTest() *LHPolicyRuleSet {
 	for name, policy := range policies {
 		rule := NewLHPolicyRule(name)
 		rule.AddCategoryConditionOn("LIQUIDCLASS", name)
-		lhpr.AddRule(rule, policy)
+		// these are ours so anything wrong with them is a bug
+		if err := lhpr.AddRule(rule, policy); err != nil {
+			panic(err)
+		}
 	}
 
 	return lhpr
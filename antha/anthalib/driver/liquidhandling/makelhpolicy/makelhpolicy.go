@@ -46,6 +46,7 @@ import (
 // DSP_WAIT		float64		s	time to wait after dispensing
 // DSPREFERENCE		int		code	well top, well bottom etc. as ints 0: well bottom 1: well top 2: liquid level
 // TIP_REUSE_LIMIT	int			how many times can we re-use a tip?
+// TIP_REUSE_SAME_SOURCE	bool			can a clean tip go back to the same source for more?
 // CAN_MULTI		bool			can we use multichannel operations
 // CAN_MSA		bool			can we do multi-source aspiration?
 // CAN SDD		bool			can we do single-destination dispensing?
@@ -136,6 +137,7 @@ func MakeDefaultPolicy() liquidhandling.LHPolicy {
 	defaultpolicy["CAN_MSA"] = false
 	defaultpolicy["CAN_SDD"] = true
 	defaultpolicy["NO_AIR_DISPENSE"] = false
+	defaultpolicy["TIP_REUSE_SAME_SOURCE"] = true
 	return defaultpolicy
 }
 
@@ -151,7 +153,9 @@ func main() {
 	for name, policy := range policies {
 		rule := liquidhandling.NewLHPolicyRule(name)
 		rule.AddCategoryConditionOn("LIQUIDCLASS", name)
-		lhpr.AddRule(rule, policy)
+		if err := lhpr.AddRule(rule, policy); err != nil {
+			panic(err)
+		}
 	}
 
 	//fmt.Println(lhpr)
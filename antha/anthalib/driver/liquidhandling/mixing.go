@@ -0,0 +1,143 @@
+// /anthalib/driver/liquidhandling/mixing.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+import (
+	"fmt"
+	"github.com/antha-lang/antha/antha/anthalib/wtype"
+	"github.com/antha-lang/antha/antha/anthalib/wunit"
+	"github.com/antha-lang/antha/antha/anthalib/wutil"
+)
+
+// the mixing a policy asks for, when is PRE for before aspirating and
+// POST for after dispensing:
+//	<when>_MIX		how many cycles, no mixing if not set or 0
+//	<when>_MIX_VOLUME	how much to mix with
+//	<when>_MIX_FRACTION	or how much as a fraction of what is in the well
+//				(the transfer volume if neither is set)
+//	<when>_MIX_RATE		pipette speed to mix at, reset to
+//				DEFAULTPIPETTESPEED afterwards
+//	<when>_MIX_Z		height above the well bottom to mix at
+// wellvols is what is in each well while mixing. Volumes are cut down
+// to what the tips and wells hold; a channel which can't mix at all
+// is given no cycles
+func mixSteps(when string, pol LHPolicy, head, multi int, prms *wtype.LHChannelParameter, plt, wells, platetypes []string, wellvols, vols []*wunit.Volume) []RobotInstruction {
+	cycles, _ := policy_int(pol, when+"_MIX")
+
+	if cycles <= 0 {
+		return nil
+	}
+
+	mix := NewMoveMixInstruction()
+	mix.Head = head
+	mix.Multi = multi
+	mix.Plt = plt
+	mix.Well = wells
+	mix.PlateType = platetypes
+	mix.FVolume = wellvols
+
+	z, ok := policy_float(pol, when+"_MIX_Z")
+	if !ok {
+		z = 0.5
+	}
+
+	mixing := false
+
+	for i := 0; i < multi; i++ {
+		v := mixVolume(when, pol, vol_at(wellvols, i), vol_at(vols, i))
+		c := cycles
+
+		if max := vol_at(wellvols, i); v.GreaterThan(max) {
+			wutil.Warn(fmt.Sprintf("%s_MIX volume %s is more than the %s in %s, mixing with that", when, v.ToString(), max.ToString(), wells[i]))
+			v = wunit.CopyVolume(max)
+		}
+
+		if prms != nil && v.GreaterThan(prms.Maxvol) {
+			wutil.Warn(fmt.Sprintf("%s_MIX volume %s is more than %s tips take, mixing with %s", when, v.ToString(), prms.Name, prms.Maxvol.ToString()))
+			v = wunit.CopyVolume(prms.Maxvol)
+		}
+
+		if v.RawValue() <= 0.0 || (prms != nil && v.LessThan(prms.Minvol)) {
+			wutil.Warn(fmt.Sprintf("cannot %s_MIX in %s: %s is too little", when, wells[i], v.ToString()))
+			c = 0
+		} else {
+			mixing = true
+		}
+
+		mix.Volume = append(mix.Volume, v)
+		mix.Cycles = append(mix.Cycles, c)
+		mix.Reference = append(mix.Reference, 0)
+		mix.OffsetZ = append(mix.OffsetZ, z)
+	}
+
+	if !mixing {
+		return nil
+	}
+
+	ret := make([]RobotInstruction, 0, 3)
+
+	rate, setrate := policy_float(pol, when+"_MIX_RATE")
+
+	if setrate {
+		sps := NewSetPipetteSpeedInstruction()
+		sps.Head = head
+		sps.Channel = -1 // all channels
+		sps.Speed = rate
+		ret = append(ret, sps)
+	}
+
+	ret = append(ret, mix)
+
+	if setrate {
+		sps := NewSetPipetteSpeedInstruction()
+		sps.Head = head
+		sps.Channel = -1
+		sps.Speed, _ = policy_float(pol, "DEFAULTPIPETTESPEED")
+		ret = append(ret, sps)
+	}
+
+	return ret
+}
+
+func mixVolume(when string, pol LHPolicy, wellvol, vol *wunit.Volume) *wunit.Volume {
+	if v, ok := policy_float(pol, when+"_MIX_VOLUME"); ok {
+		mv := wunit.NewVolume(v, "ul")
+		return &mv
+	}
+
+	if f, ok := policy_float(pol, when+"_MIX_FRACTION"); ok {
+		mv := wunit.NewVolume(wellvol.ConvertTo(wunit.ParsePrefixedUnit("ul"))*f, "ul")
+		return &mv
+	}
+
+	return wunit.CopyVolume(vol)
+}
+
+// nothing if there isn't an ith volume
+func vol_at(vols []*wunit.Volume, i int) *wunit.Volume {
+	if i < len(vols) && vols[i] != nil {
+		return vols[i]
+	}
+	v := wunit.NewVolume(0.0, "ul")
+	return &v
+}
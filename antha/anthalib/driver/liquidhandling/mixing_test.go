@@ -0,0 +1,172 @@
+// /anthalib/driver/liquidhandling/mixing_test.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/antha-lang/antha/antha/anthalib/driver/liquidhandling"
+	"github.com/antha-lang/antha/antha/anthalib/factory"
+	"github.com/antha-lang/antha/antha/anthalib/wtype"
+	"github.com/antha-lang/antha/antha/anthalib/wunit"
+)
+
+func TestPolicyMixing(t *testing.T) {
+	params := factory.GetLiquidhandlerByType("GilsonPipetmax")
+	params.AddPlate("position_7", factory.GetPlateByType("pcrplate"))
+
+	channel := func(min, max float64) *wtype.LHChannelParameter {
+		minvol, maxvol := wunit.NewVolume(min, "ul"), wunit.NewVolume(max, "ul")
+		minspd, maxspd := wunit.NewFlowRate(0.1, "ml/min"), wunit.NewFlowRate(10.0, "ml/min")
+		return wtype.NewLHChannelParameter("test", &minvol, &maxvol, &minspd, &maxspd, 1, false, wtype.LHVChannel, 1)
+	}
+
+	generate := func(policies *liquidhandling.LHPolicyRuleSet, ins liquidhandling.RobotInstruction) []liquidhandling.TerminalRobotInstruction {
+		ret := make([]liquidhandling.TerminalRobotInstruction, 0)
+		for _, r := range liquidhandling.NewRobotInstructionSet(ins).Generate(policies, params) {
+			ret = append(ret, r.(liquidhandling.TerminalRobotInstruction))
+		}
+		return ret
+	}
+
+	suck := func(policies *liquidhandling.LHPolicyRuleSet, prms *wtype.LHChannelParameter, vol, wvol float64) []liquidhandling.TerminalRobotInstruction {
+		ins := liquidhandling.NewSuckInstruction()
+		ins.Multi = 1
+		ins.Head = 1
+		ins.Prms = prms
+		v := wunit.NewVolume(vol, "ul")
+		fv := wunit.NewVolume(wvol, "ul")
+		ins.AddTransferParams(liquidhandling.TransferParams{What: "tartrazine", PltFrom: "position_7", FPlateType: "pcrplate", WellFrom: "A1", Volume: &v, FVolume: &fv})
+		return generate(policies, ins)
+	}
+
+	blow := func(policies *liquidhandling.LHPolicyRuleSet, prms *wtype.LHChannelParameter, vol, wvol float64) []liquidhandling.TerminalRobotInstruction {
+		ins := liquidhandling.NewBlowInstruction()
+		ins.Multi = 1
+		ins.Head = 1
+		ins.Prms = prms
+		v := wunit.NewVolume(vol, "ul")
+		tv := wunit.NewVolume(wvol, "ul")
+		ins.AddTransferParams(liquidhandling.TransferParams{What: "tartrazine", PltTo: "position_7", TPlateType: "pcrplate", WellTo: "A1", Volume: &v, TVolume: &tv})
+		return generate(policies, ins)
+	}
+
+	mixes := func(instrx []liquidhandling.TerminalRobotInstruction) []*liquidhandling.MixInstruction {
+		ret := make([]*liquidhandling.MixInstruction, 0)
+		for _, ins := range instrx {
+			if mix, ok := ins.(*liquidhandling.MixInstruction); ok {
+				ret = append(ret, mix)
+			}
+		}
+		return ret
+	}
+
+	// half of 40 ul is more than 10 ul tips take, policies written by
+	// hand may use ints for any of these
+
+	policies := liquidhandling.GetLHPolicyForTest()
+	policies.Policies["default"]["PRE_MIX"] = 2.0
+	policies.Policies["default"]["PRE_MIX_FRACTION"] = 0.5
+	policies.Policies["default"]["PRE_MIX_RATE"] = 3
+	policies.Policies["default"]["PRE_MIX_Z"] = 1.5
+
+	instrx := suck(policies, channel(0.5, 10.0), 5.0, 40.0)
+
+	types := make([]string, 0, len(instrx))
+	for _, ins := range instrx {
+		types = append(types, liquidhandling.Robotinstructionnames[ins.InstructionType()])
+	}
+
+	if s, e := strings.Join(types, " "), "MOV SPS MOV MIX SPS MOV ASP"; s != e {
+		t.Errorf("expected %s got %s", e, s)
+	}
+
+	mov, mixmov, mix := instrx[0].(*liquidhandling.MoveInstruction), instrx[2].(*liquidhandling.MoveInstruction), instrx[3].(*liquidhandling.MixInstruction)
+
+	if mixmov.Reference[0] != 0 || mixmov.OffsetZ[0] != 1.5 {
+		t.Errorf("expected to mix 1.5 mm off the bottom, got %v %v", mixmov.Reference, mixmov.OffsetZ)
+	}
+
+	if mix.Cycles[0] != 2 || mix.Volume[0].RawValue() != 10.0 || mix.FVolume[0].RawValue() != 40.0 {
+		t.Errorf("expected 2 cycles of 10 ul in 40 ul, got %v of %s in %s", mix.Cycles, mix.Volume[0].ToString(), mix.FVolume[0].ToString())
+	}
+
+	if sps := instrx[1].(*liquidhandling.SetPipetteSpeedInstruction); sps.Speed != 3.0 {
+		t.Errorf("expected to mix at 3 ml/min, got %v", sps.Speed)
+	}
+
+	if back := instrx[5].(*liquidhandling.MoveInstruction); !reflect.DeepEqual(back, mov) {
+		t.Errorf("expected to go back to %v to aspirate, got %v", mov, back)
+	}
+
+	// the simulator sees the mix at the mixing speed and the aspirate
+	// back where it started
+
+	ds, err := liquidhandling.SimulateInstructions(instrx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, e := range ds.Effects {
+		if strings.HasPrefix(e, "mix head 1") {
+			found = strings.Contains(e, "all=3") && strings.Contains(e, "[10] [40]")
+		}
+	}
+
+	if !found {
+		t.Errorf("expected a 10 ul mix at 3 ml/min in %v", ds.Effects)
+	}
+
+	// after dispensing the well holds what was there plus what we put
+	// in, mixes are cut down to that
+
+	policies = liquidhandling.GetLHPolicyForTest()
+	policies.Policies["default"]["POST_MIX"] = 3
+	policies.Policies["default"]["POST_MIX_VOLUME"] = 100
+
+	mm := mixes(blow(policies, channel(1.0, 200.0), 5.0, 20.0))
+
+	if len(mm) != 1 || mm[0].Cycles[0] != 3 || mm[0].Volume[0].RawValue() != 25.0 || mm[0].FVolume[0].RawValue() != 25.0 {
+		t.Errorf("expected one 3 cycle mix of 25 ul in 25 ul, got %v", mm)
+	}
+
+	// and not done at all if the tip can't take that little
+
+	policies.Policies["default"]["POST_MIX_VOLUME"] = 0.5
+
+	if mm := mixes(blow(policies, channel(1.0, 200.0), 5.0, 20.0)); len(mm) != 0 {
+		t.Errorf("expected no mix below the tip minimum, got %v", mm)
+	}
+
+	// no cycles means no mixing
+
+	policies.Policies["default"]["POST_MIX"] = 0
+
+	if mm := mixes(blow(policies, nil, 5.0, 20.0)); len(mm) != 0 {
+		t.Errorf("expected no mix without cycles, got %v", mm)
+	}
+}
@@ -0,0 +1,165 @@
+// /anthalib/driver/liquidhandling/multidispense.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+import (
+	"github.com/antha-lang/antha/antha/anthalib/wtype"
+	"github.com/antha-lang/antha/antha/anthalib/wunit"
+	"github.com/antha-lang/antha/antha/anthalib/wutil"
+)
+
+// multi-dispensing takes up enough for several destinations from the
+// same source in one go. Policies have to ask for it with
+// MULTI_DISPENSE and can't have anything which lets air out or puts
+// other liquid into the tip between dispenses. Any excess goes back
+// into the source after the last destination so the tips mustn't have
+// touched what is in the destinations: no dispensing into the liquid
+// or touching off
+func canMultiDispense(pol LHPolicy) bool {
+	if md, _ := pol["MULTI_DISPENSE"].(bool); !md {
+		return false
+	}
+
+	ul, _ := policy_float(pol, "MULTI_DISPENSE_EXCESS")
+	frac, _ := policy_float(pol, "MULTI_DISPENSE_EXCESS_FRACTION")
+
+	if ul+frac > 0.0 && dispense_touches(pol) {
+		wutil.Warn("can't multi-dispense with an excess if the tips touch what is in the destinations")
+		return false
+	}
+
+	if cycles, _ := policy_int(pol, "POST_MIX"); cycles > 0 {
+		wutil.Warn("can't multi-dispense with POST_MIX")
+		return false
+	}
+
+	if leading, trailing := airGaps(pol, nil, nil); leading+trailing > 0.0 {
+		wutil.Warn("can't multi-dispense with air gaps")
+		return false
+	}
+
+	return true
+}
+
+// extra to take up on top of vol:
+//	MULTI_DISPENSE_EXCESS		a fixed volume
+//	MULTI_DISPENSE_EXCESS_FRACTION	plus a fraction of vol
+func multiDispenseExcess(pol LHPolicy, vol *wunit.Volume) *wunit.Volume {
+	ul, _ := policy_float(pol, "MULTI_DISPENSE_EXCESS")
+	frac, _ := policy_float(pol, "MULTI_DISPENSE_EXCESS_FRACTION")
+	v := wunit.NewVolume(ul+frac*vol.ConvertTo(wunit.ParsePrefixedUnit("ul")), "ul")
+	return &v
+}
+
+// how many of vols (one set per dispense, one volume per channel) the
+// tips can do from one aspirate, each dispense has to be big enough for
+// the tip on its own
+func packDispenses(vols [][]*wunit.Volume, channel *wtype.LHChannelParameter, pol LHPolicy) int {
+	sums := make([]*wunit.Volume, 0)
+
+	for n, dsp := range vols {
+		for i, v := range dsp {
+			if i == len(sums) {
+				z := wunit.NewVolume(0.0, "ul")
+				sums = append(sums, &z)
+			}
+
+			if v.LessThan(channel.Minvol) {
+				return n
+			}
+
+			sum := wunit.CopyVolume(sums[i])
+			sum.Add(v)
+			total := wunit.CopyVolume(sum)
+			total.Add(multiDispenseExcess(pol, sum))
+
+			if total.GreaterThan(channel.Maxvol) {
+				return n
+			}
+		}
+
+		for i, v := range dsp {
+			sums[i].Add(v)
+		}
+	}
+
+	return len(vols)
+}
+
+// the transfers from t on which could come from one aspirate with the
+// channel to use for them, too few to be worth it if n < 2
+func (ins *SingleChannelBlockInstruction) multiDispenseGroup(t int, prms *LHProperties, pol LHPolicy, current *LHChannelChoice) (n int, channel LHChannelChoice) {
+	vols := make([][]*wunit.Volume, 0)
+	total := wunit.NewVolume(0.0, "ul")
+
+	for u := t; u < len(ins.Volume); u++ {
+		if ins.What[u] != ins.What[t] || ins.PltFrom[u] != ins.PltFrom[t] || ins.WellFrom[u] != ins.WellFrom[t] {
+			break
+		}
+		vols = append(vols, []*wunit.Volume{ins.Volume[u]})
+		total.Add(ins.Volume[u])
+	}
+
+	if len(vols) < 2 {
+		return 0, channel
+	}
+
+	total.Add(multiDispenseExcess(pol, &total))
+	channel = ChooseChannel(&total, prms, pol, 1, current)
+
+	return packDispenses(vols, channel.Channel, pol), channel
+}
+
+func (ins *MultiChannelBlockInstruction) multiDispenseGroup(t int, prms *LHProperties, pol LHPolicy, current *LHChannelChoice) (n int, channel LHChannelChoice) {
+	vols := make([][]*wunit.Volume, 0)
+	total := wunit.NewVolume(0.0, "ul")
+
+	for u := t; u < len(ins.Volume); u++ {
+		if !sameStrings(ins.What[u], ins.What[t]) || !sameStrings(ins.PltFrom[u], ins.PltFrom[t]) || !sameStrings(ins.WellFrom[u], ins.WellFrom[t]) {
+			break
+		}
+		vols = append(vols, ins.Volume[u])
+		total.Add(ins.Volume[u][0])
+	}
+
+	if len(vols) < 2 {
+		return 0, channel
+	}
+
+	total.Add(multiDispenseExcess(pol, &total))
+	channel = ChooseChannel(&total, prms, pol, ins.Multi, current)
+
+	return packDispenses(vols, channel.Channel, pol), channel
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, _ := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
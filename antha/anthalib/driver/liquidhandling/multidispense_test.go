@@ -0,0 +1,226 @@
+// /anthalib/driver/liquidhandling/multidispense_test.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling_test
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/antha-lang/antha/antha/anthalib/driver/liquidhandling"
+	"github.com/antha-lang/antha/antha/anthalib/factory"
+	"github.com/antha-lang/antha/antha/anthalib/wtype"
+	"github.com/antha-lang/antha/antha/anthalib/wunit"
+)
+
+func makeTestTransfer(wellfrom, wellto []string, vols []float64) *liquidhandling.TransferInstruction {
+	n := len(wellto)
+	whats := make([]string, n)
+	pltfrom := make([]string, n)
+	pltto := make([]string, n)
+	fptype := make([]string, n)
+	tptype := make([]string, n)
+	v := make([]*wunit.Volume, n)
+	fv := make([]*wunit.Volume, n)
+	tv := make([]*wunit.Volume, n)
+
+	for i := 0; i < n; i++ {
+		whats[i] = "water"
+		pltfrom[i] = "position_4"
+		pltto[i] = "position_7"
+		fptype[i] = "DWST12"
+		tptype[i] = "pcrplate"
+		vl := wunit.NewVolume(vols[i], "ul")
+		fvl := wunit.NewVolume(0.0, "ul")
+		tvl := wunit.NewVolume(0.0, "ul")
+		v[i] = &vl
+		fv[i] = &fvl
+		tv[i] = &tvl
+	}
+
+	return liquidhandling.NewTransferInstruction(whats, pltfrom, pltto, wellfrom, wellto, fptype, tptype, v, fv, tv)
+}
+
+func TestMultiDispense(t *testing.T) {
+	// a plate's worth of buffer out of one trough well
+
+	wellfrom := make([]string, 0, 96)
+	wellto := make([]string, 0, 96)
+	vols := make([]float64, 0, 96)
+	for c := 1; c <= 12; c++ {
+		for _, r := range "ABCDEFGH" {
+			wellfrom = append(wellfrom, "A1")
+			wellto = append(wellto, fmt.Sprintf("%c%d", r, c))
+			vols = append(vols, 20.0)
+		}
+	}
+
+	type result struct {
+		aspirates int
+		channels  int
+		taken     float64
+		returned  float64
+		blowouts  int
+		dispensed map[string]float64
+	}
+
+	transfer := func(policies *liquidhandling.LHPolicyRuleSet) result {
+		params := factory.GetLiquidhandlerByType("GilsonPipetmax")
+		params.AddPlate("position_4", factory.GetPlateByType("DWST12"))
+		params.AddPlate("position_7", factory.GetPlateByType("pcrplate"))
+		tb := factory.GetTipboxByType("Gilson200")
+		params.AddTipBox(tb)
+		params.AddTipWaste("position_1", factory.GetTipwasteByType("Gilsontipwaste"))
+		params.Tips = []*wtype.LHTip{tb.Tiptype}
+		params.ResetTipTracking()
+
+		tfr := makeTestTransfer(wellfrom, wellto, vols)
+		for _, v := range tfr.FVolume {
+			*v = wunit.NewVolume(5000.0, "ul")
+		}
+
+		instrx := liquidhandling.NewRobotInstructionSet(tfr).Generate(policies, params)
+
+		terminal := make([]liquidhandling.TerminalRobotInstruction, 0, len(instrx))
+		for _, ins := range instrx {
+			terminal = append(terminal, ins.(liquidhandling.TerminalRobotInstruction))
+		}
+
+		if _, err := liquidhandling.SimulateInstructions(terminal); err != nil {
+			t.Fatal(err)
+		}
+
+		// follow the head about to see where everything went
+
+		r := result{dispensed: make(map[string]float64)}
+		var at *liquidhandling.MoveInstruction
+		for _, ins := range instrx {
+			switch ins := ins.(type) {
+			case *liquidhandling.MoveInstruction:
+				at = ins
+			case *liquidhandling.AspirateInstruction:
+				r.aspirates += 1
+				r.channels += len(ins.Volume)
+				for _, v := range ins.Volume {
+					if v.RawValue() > 200.0 {
+						t.Errorf("aspirated %s, more than any tip holds", v.ToString())
+					}
+					r.taken += v.RawValue()
+				}
+			case *liquidhandling.DispenseInstruction:
+				for i, v := range ins.Volume {
+					if at.Pos[i] == "position_4" {
+						r.returned += v.RawValue()
+					} else {
+						r.dispensed[at.Well[i]] += v.RawValue()
+					}
+				}
+			case *liquidhandling.BlowoutInstruction:
+				r.blowouts += 1
+			}
+		}
+
+		return r
+	}
+
+	check := func(name string, r result) {
+		if len(r.dispensed) != 96 {
+			t.Errorf("%s: expected 96 wells filled, got %d", name, len(r.dispensed))
+		}
+
+		for well, v := range r.dispensed {
+			if math.Abs(v-20.0) > 0.0001 {
+				t.Errorf("%s: %s got %v ul, expected 20", name, well, v)
+			}
+		}
+
+		if math.Abs(r.taken-r.returned-1920.0) > 0.0001 {
+			t.Errorf("%s: took %v ul and put back %v, expected 1920 ul to go", name, r.taken, r.returned)
+		}
+
+		if r.blowouts != r.aspirates {
+			t.Errorf("%s: expected a blowout for each of %d aspirates, got %d", name, r.aspirates, r.blowouts)
+		}
+	}
+
+	policies := liquidhandling.GetLHPolicyForTest()
+
+	for _, multi := range []bool{true, false} {
+		name := fmt.Sprintf("CAN_MULTI=%v", multi)
+		policies.Policies["water"]["CAN_MULTI"] = multi
+		delete(policies.Policies["water"], "MULTI_DISPENSE")
+
+		each := transfer(policies)
+		check(name, each)
+
+		// one aspirate with 5 ul extra which goes back into the trough
+
+		policies.Policies["water"]["MULTI_DISPENSE"] = true
+		policies.Policies["water"]["MULTI_DISPENSE_EXCESS"] = 5
+
+		md := transfer(policies)
+		check(name+" MULTI_DISPENSE=true", md)
+
+		if md.aspirates*4 > each.aspirates {
+			t.Errorf("%s: expected multi-dispensing to need at most a quarter of %d aspirates, got %d", name, each.aspirates, md.aspirates)
+		}
+
+		if math.Abs(md.returned-5.0*float64(md.channels)) > 0.0001 {
+			t.Errorf("%s: expected 5 ul back from each of %d channels aspirating, got %v", name, md.channels, md.returned)
+		}
+
+		// mixing after dispensing rules it out
+
+		policies.Policies["water"]["POST_MIX"] = 2
+
+		if r := transfer(policies); r.aspirates != each.aspirates {
+			t.Errorf("%s: expected no multi-dispensing with POST_MIX, got %d aspirates not %d", name, r.aspirates, each.aspirates)
+		}
+
+		delete(policies.Policies["water"], "POST_MIX")
+
+		// the excess can't go back into the trough if the tips have
+		// been in the destinations, without one it doesn't matter
+
+		policies.Policies["water"]["TOUCHOFF"] = true
+
+		if r := transfer(policies); r.aspirates != each.aspirates {
+			t.Errorf("%s: expected no multi-dispensing with touching off and an excess, got %d aspirates not %d", name, r.aspirates, each.aspirates)
+		}
+
+		delete(policies.Policies["water"], "TOUCHOFF")
+		policies.Policies["water"]["DSPREFERENCE"] = 0
+
+		if r := transfer(policies); r.aspirates != each.aspirates {
+			t.Errorf("%s: expected no multi-dispensing into the liquid with an excess, got %d aspirates not %d", name, r.aspirates, each.aspirates)
+		}
+
+		delete(policies.Policies["water"], "MULTI_DISPENSE_EXCESS")
+
+		if r := transfer(policies); r.aspirates > md.aspirates || r.returned != 0.0 {
+			t.Errorf("%s: expected at most %d aspirates and nothing back without an excess, got %d and %v ul", name, md.aspirates, r.aspirates, r.returned)
+		}
+
+		policies.Policies["water"]["DSPREFERENCE"] = 1
+	}
+}
@@ -0,0 +1,302 @@
+// /anthalib/driver/liquidhandling/optimise.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// peephole optimisation of generated instructions: Generate expands
+// each composite instruction without looking at its neighbours so the
+// stream it makes has moves to where the head already is, pipette and
+// drive speeds set to what they already are and waits for no time.
+// None of these change what the robot does so they are dropped.
+// Clean tips unloaded only to load the same sort again are kept on
+// instead, and a move straight followed by another is dropped unless
+// it is a touch off, which does its job just by getting there.
+// Returns the optimised instructions and the index in instructions of
+// each one
+func OptimiseInstructions(instructions []TerminalRobotInstruction) ([]TerminalRobotInstruction, []int) {
+	drop := make([]bool, len(instructions))
+
+	keepTips(instructions, drop)
+	mergeMoves(instructions, drop)
+
+	var st optimiserState
+	st.reset()
+
+	ret := make([]TerminalRobotInstruction, 0, len(instructions))
+	kept := make([]int, 0, len(instructions))
+
+	for i, ins := range instructions {
+		if drop[i] || st.redundant(ins) {
+			continue
+		}
+		ret = append(ret, ins)
+		kept = append(kept, i)
+	}
+
+	return ret, kept
+}
+
+// whether the head can be somewhere else while ins runs without it
+// making any difference
+func goesAnywhere(ins TerminalRobotInstruction) bool {
+	switch i := ins.(type) {
+	case *SetPipetteSpeedInstruction, *SetDriveSpeedInstruction:
+		return true
+	case *WaitInstruction:
+		return i.Time <= 0
+	}
+	return false
+}
+
+// the next instruction after i which is not dropped and needs the head
+// to be where it is, -1 if there isn't one
+func nextPlaced(instructions []TerminalRobotInstruction, drop []bool, i int) int {
+	for j := i + 1; j < len(instructions); j++ {
+		if !drop[j] && !goesAnywhere(instructions[j]) {
+			return j
+		}
+	}
+	return -1
+}
+
+// drops tips being unloaded without having been used along with the
+// load of the same sort of tips which comes after them, moves aside
+func keepTips(instructions []TerminalRobotInstruction, drop []bool) {
+	clean := make(map[int]*LoadTipsInstruction)
+
+	for i, ins := range instructions {
+		if drop[i] {
+			continue
+		}
+
+		switch t := ins.(type) {
+		case *LoadTipsInstruction:
+			clean[t.Head] = t
+		case *UnloadTipsInstruction:
+			lod := clean[t.Head]
+			delete(clean, t.Head)
+			if lod == nil {
+				continue
+			}
+
+			j := i
+			for {
+				j = nextPlaced(instructions, drop, j)
+				if j < 0 {
+					break
+				}
+				if _, ok := instructions[j].(*MoveInstruction); !ok {
+					break
+				}
+			}
+
+			if j < 0 {
+				continue
+			}
+
+			if next, ok := instructions[j].(*LoadTipsInstruction); ok && sameTips(lod, next) {
+				drop[i] = true
+				drop[j] = true
+				clean[t.Head] = lod
+			}
+		case *AspirateInstruction:
+			delete(clean, t.Head)
+		case *DispenseInstruction:
+			delete(clean, t.Head)
+		case *BlowoutInstruction:
+			delete(clean, t.Head)
+		case *MixInstruction:
+			delete(clean, t.Head)
+		case *MoveInstruction, *PTZInstruction:
+		default:
+			if !goesAnywhere(ins) {
+				// could be anything
+				clean = make(map[int]*LoadTipsInstruction)
+			}
+		}
+	}
+}
+
+// drops moves which are straight followed by another move of the same
+// head, unless they are a touch off
+func mergeMoves(instructions []TerminalRobotInstruction, drop []bool) {
+	for i, ins := range instructions {
+		mov, ok := ins.(*MoveInstruction)
+
+		if !ok || drop[i] || mov.TouchOff {
+			continue
+		}
+
+		if j := nextPlaced(instructions, drop, i); j >= 0 {
+			if next, ok := instructions[j].(*MoveInstruction); ok && next.Head == mov.Head {
+				drop[i] = true
+			}
+		}
+	}
+}
+
+// optimises the instructions then makes sure the deck simulator sees
+// the robot do the same thing both ways; if it doesn't the original
+// instructions are returned with the reason.
+// This is not much of a proof: the simulator is blind to the very
+// things dropped above, speeds only show up in what is done after they
+// are set, a move only in what is done where it ends up and clean tips
+// swapped for the same sort not at all. So the check catches the
+// optimiser dropping something which matters, e.g. a speed change, a
+// touch off or a move before an aspirate, or getting its idea of where
+// the head is wrong, but the assumption that the dropped instructions
+// don't matter is built into both
+func OptimiseInstructionsChecked(instructions []TerminalRobotInstruction) ([]TerminalRobotInstruction, []int, error) {
+	opt, kept := OptimiseInstructions(instructions)
+
+	all := make([]int, len(instructions))
+	for i, _ := range all {
+		all[i] = i
+	}
+
+	before, err := SimulateInstructions(instructions)
+
+	if err != nil {
+		return instructions, all, err
+	}
+
+	after, err := SimulateInstructions(opt)
+
+	if err == nil {
+		err = before.EquivalentTo(after)
+	}
+
+	if err != nil {
+		return instructions, all, fmt.Errorf("optimised instructions are not the same as the originals: %s", err)
+	}
+
+	return opt, kept, nil
+}
+
+// what we know about the robot at a point in the stream, nothing is
+// known until it has been set
+type optimiserState struct {
+	at     map[int]*MoveInstruction
+	all    map[int]float64
+	speeds map[int]map[int]float64
+	drives map[string]float64
+}
+
+func (st *optimiserState) reset() {
+	st.at = make(map[int]*MoveInstruction)
+	st.all = make(map[int]float64)
+	st.speeds = make(map[int]map[int]float64)
+	st.drives = make(map[string]float64)
+}
+
+// whether ins can be dropped, st is updated as if it runs
+func (st *optimiserState) redundant(ins TerminalRobotInstruction) bool {
+	switch i := ins.(type) {
+	case *MoveInstruction:
+		if sameMove(st.at[i.Head], i) {
+			return true
+		}
+		st.at[i.Head] = i
+	case *SetPipetteSpeedInstruction:
+		return st.setPipetteSpeed(i.Head, i.Channel, i.Speed)
+	case *SetDriveSpeedInstruction:
+		if v, ok := st.drives[i.Drive]; ok && v == i.Speed {
+			return true
+		}
+		st.drives[i.Drive] = i.Speed
+	case *WaitInstruction:
+		return i.Time <= 0
+	case *AspirateInstruction:
+		st.followed(i.Head, i.LLF)
+	case *DispenseInstruction:
+		st.followed(i.Head, i.LLF)
+	case *BlowoutInstruction, *PTZInstruction, *MixInstruction:
+		// these stay where they are
+	case *LoadTipsInstruction:
+		delete(st.at, i.Head)
+	case *UnloadTipsInstruction:
+		delete(st.at, i.Head)
+	default:
+		// could be anything
+		st.reset()
+	}
+
+	return false
+}
+
+// following the liquid moves the head
+func (st *optimiserState) followed(head int, llf []bool) {
+	for _, b := range llf {
+		if b {
+			delete(st.at, head)
+			return
+		}
+	}
+}
+
+// channel -1 sets every channel at once
+func (st *optimiserState) setPipetteSpeed(head, channel int, speed float64) bool {
+	all, allset := st.all[head]
+	speeds := st.speeds[head]
+
+	if channel < 0 {
+		if allset && all == speed && len(speeds) == 0 {
+			return true
+		}
+		st.all[head] = speed
+		st.speeds[head] = make(map[int]float64)
+		return false
+	}
+
+	if v, ok := speeds[channel]; ok && v == speed || !ok && allset && all == speed {
+		return true
+	}
+
+	if speeds == nil {
+		speeds = make(map[int]float64)
+		st.speeds[head] = speeds
+	}
+
+	speeds[channel] = speed
+
+	return false
+}
+
+// whether two moves go to exactly the same place; measuring from the
+// liquid level means a different volume in the well is somewhere else
+func sameMove(a, b *MoveInstruction) bool {
+	if a == nil || b == nil {
+		return false
+	}
+
+	return a.Head == b.Head && reflect.DeepEqual(a.Pos, b.Pos) && reflect.DeepEqual(a.Well, b.Well) && reflect.DeepEqual(a.Reference, b.Reference) && reflect.DeepEqual(a.OffsetX, b.OffsetX) && reflect.DeepEqual(a.OffsetY, b.OffsetY) && reflect.DeepEqual(a.OffsetZ, b.OffsetZ) && reflect.DeepEqual(a.Plt, b.Plt) && reflect.DeepEqual(a.WVolume, b.WVolume)
+}
+
+// whether two loads put the same sort of tips on the same channels
+func sameTips(a, b *LoadTipsInstruction) bool {
+	return a.Head == b.Head && a.Multi == b.Multi && reflect.DeepEqual(a.Channels, b.Channels) && reflect.DeepEqual(a.TipType, b.TipType) && reflect.DeepEqual(a.HolderType, b.HolderType)
+}
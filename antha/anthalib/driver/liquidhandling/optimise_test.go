@@ -0,0 +1,195 @@
+// /anthalib/driver/liquidhandling/optimise_test.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/antha-lang/antha/antha/anthalib/driver/liquidhandling"
+	"github.com/antha-lang/antha/antha/anthalib/wunit"
+)
+
+func TestInstructionOptimiser(t *testing.T) {
+	mov := func(well string, z float64) liquidhandling.TerminalRobotInstruction {
+		ins := liquidhandling.NewMoveInstruction()
+		ins.Head = 1
+		ins.Pos = []string{"position_4"}
+		ins.Plt = []string{"pcrplate"}
+		ins.Well = []string{well}
+		ins.Reference = []int{0}
+		ins.OffsetZ = []float64{z}
+		return ins
+	}
+
+	sps := func(channel int, speed float64) liquidhandling.TerminalRobotInstruction {
+		ins := liquidhandling.NewSetPipetteSpeedInstruction()
+		ins.Head = 1
+		ins.Channel = channel
+		ins.Speed = speed
+		return ins
+	}
+
+	sds := func(speed float64) liquidhandling.TerminalRobotInstruction {
+		ins := liquidhandling.NewSetDriveSpeedInstruction()
+		ins.Drive = "Z"
+		ins.Speed = speed
+		return ins
+	}
+
+	wait := func(time float64) liquidhandling.TerminalRobotInstruction {
+		ins := liquidhandling.NewWaitInstruction()
+		ins.Time = time
+		return ins
+	}
+
+	asp := func(llf bool) liquidhandling.TerminalRobotInstruction {
+		ins := liquidhandling.NewAspirateInstruction()
+		v := wunit.NewVolume(10.0, "ul")
+		ins.Head = 1
+		ins.Multi = 1
+		ins.Volume = []*wunit.Volume{&v}
+		ins.What = []string{"water"}
+		ins.Plt = []string{"pcrplate"}
+		ins.LLF = []bool{llf}
+		return ins
+	}
+
+	lod := func(well string) *liquidhandling.LoadTipsInstruction {
+		ins := liquidhandling.NewLoadTipsInstruction()
+		ins.Head = 1
+		ins.Multi = 1
+		ins.Channels = []int{0}
+		ins.TipType = []string{"Gilson20"}
+		ins.HolderType = []string{"Gilson20"}
+		ins.Pos = []string{"position_2"}
+		ins.Well = []string{well}
+		return ins
+	}
+
+	uld := func() *liquidhandling.UnloadTipsInstruction {
+		ins := liquidhandling.NewUnloadTipsInstruction()
+		ins.Head = 1
+		ins.Multi = 1
+		ins.Channels = []int{0}
+		ins.TipType = []string{"Gilson20"}
+		ins.HolderType = []string{"Gilsontipwaste"}
+		ins.Pos = []string{"position_1"}
+		ins.Well = []string{"A1"}
+		return ins
+	}
+
+	touch := mov("A1", -0.5).(*liquidhandling.MoveInstruction)
+	touch.TouchOff = true
+
+	instrx := []liquidhandling.TerminalRobotInstruction{
+		liquidhandling.NewInitializeInstruction(),
+		mov("A1", 0.5),
+		sps(-1, 1.0),
+		asp(false),
+		sps(-1, 1.0), // same speed
+		sds(5.0),
+		mov("A1", 0.5), // already there
+		sds(5.0),       // same speed
+		wait(0.0),      // no time
+		wait(2.0),
+		mov("A1", 1.0),
+		asp(true),
+		mov("A1", 1.0), // the head followed the liquid down
+		lod("A1"),
+		mov("A1", 1.0), // loading tips moves the head
+		sps(0, 1.0),    // already set for all channels
+		sps(0, 2.0),
+		asp(false),
+		touch,          // does its job just by getting there
+		mov("B1", 0.5), // only to go on somewhere else
+		sds(6.0),
+		mov("C1", 0.5),
+		asp(false),
+		mov("D1", 0.5),
+		uld(), // used tips
+		mov("E1", 0.5),
+		lod("B1"),
+		mov("D1", 0.5), // on to where the next tips go...
+		uld(),          // ...which are clean...
+		mov("E1", 0.5),
+		lod("C1"), // ...so are kept on instead of these
+		mov("C1", 0.5),
+		asp(false),
+		liquidhandling.NewFinalizeInstruction(),
+		mov("A1", 1.0), // nothing is known after finalizing
+	}
+
+	opt, kept, err := liquidhandling.OptimiseInstructionsChecked(instrx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []int{0, 1, 2, 3, 5, 9, 10, 11, 12, 13, 14, 16, 17, 18, 20, 21, 22, 23, 24, 25, 26, 31, 32, 33, 34}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("expected to keep %v got %v", want, kept)
+	}
+
+	for i, ins := range opt {
+		if ins != instrx[kept[i]] {
+			t.Errorf("instruction %d is not the one kept", i)
+		}
+	}
+
+	// measured from the liquid level the same well with more in it is
+	// somewhere else
+
+	level := func(vol float64) liquidhandling.TerminalRobotInstruction {
+		ins := mov("A1", 0.5).(*liquidhandling.MoveInstruction)
+		v := wunit.NewVolume(vol, "ul")
+		ins.Reference = []int{2}
+		ins.WVolume = []*wunit.Volume{&v}
+		return ins
+	}
+
+	if _, kept := liquidhandling.OptimiseInstructions([]liquidhandling.TerminalRobotInstruction{level(10.0), asp(false), level(20.0), asp(false), level(20.0), asp(false)}); !reflect.DeepEqual(kept, []int{0, 1, 2, 3, 5}) {
+		t.Errorf("expected only the move to the same level to go, kept %v", kept)
+	}
+
+	// the simulator sees real changes
+
+	simulate := func(instrx []liquidhandling.TerminalRobotInstruction) *liquidhandling.DeckSimulator {
+		ds, err := liquidhandling.SimulateInstructions(instrx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ds
+	}
+
+	before := simulate(instrx)
+
+	for _, drop := range []int{1, 2, 10, 12, 16, 18, 20, 24, 26, 31} {
+		changed := make([]liquidhandling.TerminalRobotInstruction, 0, len(instrx))
+		changed = append(changed, instrx[:drop]...)
+		changed = append(changed, instrx[drop+1:]...)
+
+		if before.EquivalentTo(simulate(changed)) == nil {
+			t.Errorf("dropping instruction %d should change what the robot does", drop)
+		}
+	}
+}
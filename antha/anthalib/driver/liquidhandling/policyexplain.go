@@ -0,0 +1,233 @@
+// /anthalib/driver/liquidhandling/policyexplain.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// why an instruction got the policy it did: every rule in the set,
+// whether each of its conditions matched, the rule which won and
+// where each item in the final policy came from
+
+type LHConditionExplanation struct {
+	Variable  string
+	Condition string
+	Value     string // what the instruction had for Variable
+	Negate    bool
+	Matched   bool
+}
+
+type LHRuleExplanation struct {
+	Name       string
+	Priority   int
+	Type       int
+	Negate     bool
+	Matched    bool
+	Conditions []LHConditionExplanation
+	Groups     []LHRuleExplanation
+}
+
+type LHPolicyItemExplanation struct {
+	Name   string
+	Value  interface{}
+	Rule   string // the rule whose policy supplied the value
+	Source string // system, user or protocol
+}
+
+type LHPolicyExplanation struct {
+	Step        string `json:",omitempty"` // where in the plan, if known
+	Instruction string
+	Rules       []LHRuleExplanation
+	Winner      string
+	Items       []LHPolicyItemExplanation
+}
+
+// instructions which look their policy up when generated
+var policyInstructions = map[int]bool{
+	TFR: true,
+	SCB: true,
+	MCB: true,
+	SUK: true,
+	BLW: true,
+	RST: true,
+}
+
+func UsesPolicy(ins RobotInstruction) bool {
+	return policyInstructions[ins.InstructionType()]
+}
+
+// this works out the same answer as GetPolicyFor but shows its working
+func (lhpr LHPolicyRuleSet) Explain(ins RobotInstruction) LHPolicyExplanation {
+	var lhpe LHPolicyExplanation
+	lhpe.Instruction = Robotinstructionnames[ins.InstructionType()]
+
+	names := make([]string, 0, len(lhpr.Rules))
+	for name, _ := range lhpr.Rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lhpe.Rules = make([]LHRuleExplanation, 0, len(names))
+	for _, name := range names {
+		lhpe.Rules = append(lhpe.Rules, explainRule(lhpr.Rules[name], ins))
+	}
+
+	matches := lhpr.MatchingRules(ins)
+
+	if len(matches) != 0 {
+		lhpe.Winner = matches[0].Name
+	}
+
+	// the default policy overlaid with the winner's
+
+	items := make(map[string]LHPolicyItemExplanation)
+
+	for _, rule := range []string{"default", lhpe.Winner} {
+		for item, v := range lhpr.Policies[rule] {
+			items[item] = LHPolicyItemExplanation{item, v, rule, lhpr.SourceOf(rule, item)}
+		}
+	}
+
+	itemnames := make([]string, 0, len(items))
+	for item, _ := range items {
+		itemnames = append(itemnames, item)
+	}
+	sort.Strings(itemnames)
+
+	lhpe.Items = make([]LHPolicyItemExplanation, 0, len(itemnames))
+	for _, item := range itemnames {
+		lhpe.Items = append(lhpe.Items, items[item])
+	}
+
+	return lhpe
+}
+
+func explainRule(rule LHPolicyRule, ins RobotInstruction) LHRuleExplanation {
+	lhre := LHRuleExplanation{
+		Name:       rule.Name,
+		Priority:   rule.Priority,
+		Type:       rule.Type,
+		Negate:     rule.Negate,
+		Matched:    rule.Check(ins),
+		Conditions: make([]LHConditionExplanation, 0, len(rule.Conditions)),
+		Groups:     make([]LHRuleExplanation, 0, len(rule.Groups)),
+	}
+
+	for _, cond := range rule.Conditions {
+		lhre.Conditions = append(lhre.Conditions, LHConditionExplanation{
+			Variable:  cond.TestVariable,
+			Condition: describeCondition(cond.Condition),
+			Value:     describeValue(ins.GetParameter(cond.TestVariable)),
+			Negate:    cond.Negate,
+			Matched:   cond.Check(ins),
+		})
+	}
+
+	for _, group := range rule.Groups {
+		lhre.Groups = append(lhre.Groups, explainRule(group, ins))
+	}
+
+	return lhre
+}
+
+func describeCondition(cond LHCondition) string {
+	switch c := cond.(type) {
+	case LHCategoryCondition:
+		return fmt.Sprintf("is %s", c.Category)
+	case LHSetCondition:
+		return fmt.Sprintf("in %s", strings.Join(c.Categories, ","))
+	case LHRegexpCondition:
+		return fmt.Sprintf("matches /%s/", c.Pattern)
+	case LHNumericCondition:
+		return fmt.Sprintf("between %v and %v", c.Lower, c.Upper)
+	}
+	return fmt.Sprint(cond)
+}
+
+func (lhpe LHPolicyExplanation) String() string {
+	s := ""
+	if lhpe.Step != "" {
+		s += fmt.Sprintf("%s ", lhpe.Step)
+	}
+	winner := lhpe.Winner
+	if winner == "" {
+		winner = "none, default only"
+	}
+	s += fmt.Sprintf("%s: winning rule %s\n", lhpe.Instruction, winner)
+
+	for _, rule := range lhpe.Rules {
+		s += rule.toString(1)
+	}
+
+	for _, item := range lhpe.Items {
+		s += fmt.Sprintf("\t%s = %v from %s", item.Name, item.Value, item.Rule)
+		if item.Source != "" {
+			s += fmt.Sprintf(" (%s)", item.Source)
+		}
+		s += "\n"
+	}
+
+	return s
+}
+
+func (lhre LHRuleExplanation) toString(level int) string {
+	indent := strings.Repeat("\t", level)
+
+	join := "all of"
+	if lhre.Type == LHP_OR {
+		join = "any of"
+	}
+	if lhre.Negate {
+		join = "not " + join
+	}
+
+	s := indent
+	if lhre.Name != "" {
+		s += fmt.Sprintf("rule %s priority %d ", lhre.Name, lhre.Priority)
+	}
+	s += fmt.Sprintf("%s: %s\n", join, yesno(lhre.Matched))
+
+	for _, cond := range lhre.Conditions {
+		not := ""
+		if cond.Negate {
+			not = "not "
+		}
+		s += fmt.Sprintf("%s\t%s %s%s (%s): %s\n", indent, cond.Variable, not, cond.Condition, cond.Value, yesno(cond.Matched))
+	}
+
+	for _, group := range lhre.Groups {
+		s += group.toString(level + 1)
+	}
+
+	return s
+}
+
+func yesno(b bool) string {
+	if b {
+		return "matched"
+	}
+	return "no match"
+}
@@ -0,0 +1,128 @@
+// /anthalib/driver/liquidhandling/policyexplain_test.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/antha-lang/antha/antha/anthalib/driver/liquidhandling"
+	"github.com/antha-lang/antha/antha/anthalib/wunit"
+)
+
+func TestPolicyExplain(t *testing.T) {
+	sct := func(what string) *liquidhandling.SingleChannelTransferInstruction {
+		ins := liquidhandling.NewSingleChannelTransferInstruction()
+		v := wunit.NewVolume(5.0, "ul")
+		ins.What = what
+		ins.Volume = &v
+		return ins
+	}
+
+	// the user changes the water policy, the protocol adds a rule
+
+	user := liquidhandling.NewLHPolicyRuleSet()
+	rule := liquidhandling.NewLHPolicyRule("mywater")
+	rule.AddCategoryConditionOn("LIQUIDCLASS", "water")
+	if err := user.AddRule(rule, liquidhandling.LHPolicy{"ASPSPEED": 2.0}); err != nil {
+		t.Fatal(err)
+	}
+
+	protocol := liquidhandling.NewLHPolicyRuleSet()
+	rule = liquidhandling.NewLHPolicyRule("slowglycerol")
+	rule.Priority = 1
+	rule.AddRegexpConditionOn("LIQUIDCLASS", "^glycerol")
+	if err := protocol.AddRule(rule, liquidhandling.LHPolicy{"ASP_WAIT": 2.0}); err != nil {
+		t.Fatal(err)
+	}
+
+	// merged the way the planner does it, each set says where it came from
+
+	user.Source, protocol.Source = "user", "protocol"
+	lhpr := liquidhandling.CloneLHPolicyRuleSet(liquidhandling.GetLHPolicyForTest())
+	lhpr.Source = "system"
+
+	for _, other := range []*liquidhandling.LHPolicyRuleSet{user, protocol} {
+		if err := lhpr.MergeWith(other); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	item := func(lhpe liquidhandling.LHPolicyExplanation, name string) liquidhandling.LHPolicyItemExplanation {
+		for _, it := range lhpe.Items {
+			if it.Name == name {
+				return it
+			}
+		}
+		t.Fatalf("%s: no item %s", lhpe.Instruction, name)
+		return liquidhandling.LHPolicyItemExplanation{}
+	}
+
+	for what, want := range map[string]map[string]liquidhandling.LHPolicyItemExplanation{
+		"water": {
+			"ASPSPEED":     {Name: "ASPSPEED", Value: 2.0, Rule: "water", Source: "user"},
+			"DSPREFERENCE": {Name: "DSPREFERENCE", Value: 1, Rule: "water", Source: "system"},
+			"TOUCHOFF":     {Name: "TOUCHOFF", Value: false, Rule: "default", Source: "system"},
+		},
+		"glycerol_60": {
+			"ASP_WAIT": {Name: "ASP_WAIT", Value: 2.0, Rule: "slowglycerol", Source: "protocol"},
+			"TOUCHOFF": {Name: "TOUCHOFF", Value: false, Rule: "default", Source: "system"},
+		},
+	} {
+		ins := sct(what)
+		lhpe := lhpr.Explain(ins)
+
+		if lhpe.Instruction != "SCT" || len(lhpe.Rules) != len(lhpr.Rules) {
+			t.Errorf("%s: every rule should be explained, got %d of %d", what, len(lhpe.Rules), len(lhpr.Rules))
+		}
+
+		for name, it := range want {
+			if got := item(lhpe, name); !reflect.DeepEqual(got, it) {
+				t.Errorf("%s: expected %v got %v", what, it, got)
+			}
+		}
+
+		// the explanation has to agree with what instructions get
+
+		pol := lhpr.GetPolicyFor(ins)
+
+		if len(pol) != len(lhpe.Items) {
+			t.Errorf("%s: expected %d items got %d", what, len(pol), len(lhpe.Items))
+		}
+
+		for _, it := range lhpe.Items {
+			if !reflect.DeepEqual(pol[it.Name], it.Value) {
+				t.Errorf("%s: %s should be %v not %v", what, it.Name, pol[it.Name], it.Value)
+			}
+		}
+
+		for _, re := range lhpe.Rules {
+			if re.Matched != (re.Name == "water" && what == "water" || re.Name == "slowglycerol" && what == "glycerol_60") {
+				t.Errorf("%s: rule %s should not have matched", what, re.Name)
+			}
+			if len(re.Conditions) != 1 || re.Conditions[0].Value != what || re.Conditions[0].Matched != re.Matched {
+				t.Errorf("%s: conditions of rule %s explained wrongly: %v", what, re.Name, re.Conditions)
+			}
+		}
+	}
+}
@@ -0,0 +1,303 @@
+// /anthalib/driver/liquidhandling/policyschema.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+import (
+	"fmt"
+	"github.com/antha-lang/antha/antha/anthalib/wutil"
+	"math"
+	"sort"
+	"strings"
+)
+
+// describes one item a policy can set: what type it has to be, what
+// units it's in and what values make sense
+type LHPolicyParameter struct {
+	Name    string
+	Type    string // bool, int, float64 or string
+	Unit    string
+	Min     float64
+	Max     float64
+	Desc    string
+	Aliases []string // spellings which have been used for this by mistake
+}
+
+func (p LHPolicyParameter) String() string {
+	s := fmt.Sprintf("%s\t%s", p.Name, p.Type)
+
+	if p.Unit != "" {
+		s += "\t" + p.Unit
+	}
+
+	if p.numeric() {
+		s += fmt.Sprintf("\t[%s, %s]", rangeEnd(p.Min), rangeEnd(p.Max))
+	}
+
+	return s + "\t" + p.Desc
+}
+
+func rangeEnd(f float64) string {
+	if math.IsInf(f, 0) {
+		return "-"
+	}
+	return fmt.Sprint(f)
+}
+
+func (p LHPolicyParameter) numeric() bool {
+	return p.Type == "int" || p.Type == "float64"
+}
+
+// checks v is the right type and in range; ints and float64s are
+// converted to whichever the parameter needs if that can be done
+// without losing anything, since JSON can't tell them apart
+func (p LHPolicyParameter) Check(v interface{}) (interface{}, error) {
+	switch p.Type {
+	case "bool":
+		if _, ok := v.(bool); !ok {
+			return v, fmt.Errorf("policy item %s must be a bool, got %v", p.Name, v)
+		}
+		return v, nil
+	case "string":
+		if _, ok := v.(string); !ok {
+			return v, fmt.Errorf("policy item %s must be a string, got %v", p.Name, v)
+		}
+		return v, nil
+	}
+
+	var f float64
+
+	switch n := v.(type) {
+	case int:
+		f = float64(n)
+	case float64:
+		f = n
+	default:
+		return v, fmt.Errorf("policy item %s must be a number, got %v", p.Name, v)
+	}
+
+	if f < p.Min || f > p.Max {
+		return v, fmt.Errorf("policy item %s must be between %s and %s, got %v", p.Name, rangeEnd(p.Min), rangeEnd(p.Max), v)
+	}
+
+	if p.Type == "int" {
+		if f != math.Trunc(f) {
+			return v, fmt.Errorf("policy item %s must be a whole number, got %v", p.Name, v)
+		}
+		return int(f), nil
+	}
+
+	return f, nil
+}
+
+var policyParameters = makePolicyParameters()
+
+func makePolicyParameters() map[string]LHPolicyParameter {
+	inf := math.Inf(1)
+	params := make(map[string]LHPolicyParameter)
+
+	add := func(name, typ, unit string, min, max float64, desc string, aliases ...string) {
+		params[name] = LHPolicyParameter{Name: name, Type: typ, Unit: unit, Min: min, Max: max, Desc: desc, Aliases: aliases}
+	}
+
+	// what we can do
+
+	add("CAN_MULTI", "bool", "", 0, 0, "can we use multichannel operations")
+	add("CAN_MSA", "bool", "", 0, 0, "can we do multi-source aspiration")
+	add("CAN_SDD", "bool", "", 0, 0, "can we do single-destination dispensing")
+	add("MULTI_DISPENSE", "bool", "", 0, 0, "can we aspirate once and dispense to several destinations from the same source")
+	add("MULTI_DISPENSE_EXCESS", "float64", "ul", 0, inf, "extra to aspirate when multi-dispensing, put back in the source afterwards")
+	add("MULTI_DISPENSE_EXCESS_FRACTION", "float64", "fraction", 0, 1, "extra to aspirate when multi-dispensing as a fraction of what is dispensed, on top of MULTI_DISPENSE_EXCESS")
+	add("NO_AIR_DISPENSE", "bool", "", 0, 0, "never let air out of tips into the liquid, air gaps and blowouts go above it")
+
+	// tips and adaptors
+
+	add("TIP_REUSE_LIMIT", "int", "", 0, inf, "how many times can we re-use a tip")
+	add("TIP_REUSE_SAME_SOURCE", "bool", "", 0, 0, "can a clean tip go back to the same source for more")
+	add("ADAPTOR_CHANGE_MOVES", "int", "", 0, inf, "how many moves an adaptor change has to save to be worth doing")
+
+	// aspirating
+
+	add("ASPREFERENCE", "int", "code", 0, 2, "where aspirate heights are measured from: 0 well bottom, 1 well top, 2 liquid level")
+	add("ASPZOFFSET", "float64", "mm", -inf, inf, "Z offset when aspirating")
+	add("ASPENTRYSPEED", "float64", "mm/s", 0, inf, "how fast to move vertically into the liquid when aspirating")
+	add("ASPSPEED", "float64", "ml/min", 0, inf, "pipette speed for aspiration", "ASP_SPEED")
+	add("ASP_WAIT", "float64", "s", 0, inf, "time to wait after aspirating")
+
+	// dispensing
+
+	add("DSPREFERENCE", "int", "code", 0, 2, "where dispense heights are measured from: 0 well bottom, 1 well top, 2 liquid level")
+	add("DSPZOFFSET", "float64", "mm", -inf, inf, "Z offset when dispensing")
+	add("DSPENTRYSPEED", "float64", "mm/s", 0, inf, "how fast to move vertically into the liquid when dispensing")
+	add("DSPSPEED", "float64", "ml/min", 0, inf, "pipette speed for dispensing", "DSP_SPEED")
+	add("DSP_WAIT", "float64", "s", 0, inf, "time to wait after dispensing")
+
+	// speeds to go back to
+
+	add("DEFAULTPIPETTESPEED", "float64", "ml/min", 0, inf, "pipette speed to reset to after ASPSPEED or DSPSPEED")
+	add("DEFAULTZSPEED", "float64", "mm/s", 0, inf, "vertical speed to reset to after ASPENTRYSPEED or DSPENTRYSPEED")
+
+	// mixing
+
+	add("PRE_MIX", "int", "cycles", 0, inf, "how many times to mix before aspirating", "PREMIX")
+	add("PRE_MIX_VOLUME", "float64", "ul", 0, inf, "volume to mix with before aspirating, the transfer volume if not set", "PRE_MIX_VOL")
+	add("PRE_MIX_FRACTION", "float64", "fraction", 0, 1, "volume to mix with before aspirating as a fraction of what is in the well, if PRE_MIX_VOLUME is not set")
+	add("PRE_MIX_RATE", "float64", "ml/min", 0, inf, "pipette speed to mix at before aspirating")
+	add("PRE_MIX_Z", "float64", "mm", -inf, inf, "height above the well bottom to mix at before aspirating, 0.5 if not set")
+	add("POST_MIX", "int", "cycles", 0, inf, "how many times to mix after dispensing", "POSTMIX")
+	add("POST_MIX_VOLUME", "float64", "ul", 0, inf, "volume to mix with after dispensing, the transfer volume if not set", "POST_MIX_VOL")
+	add("POST_MIX_FRACTION", "float64", "fraction", 0, 1, "volume to mix with after dispensing as a fraction of what is in the well, if POST_MIX_VOLUME is not set")
+	add("POST_MIX_RATE", "float64", "ml/min", 0, inf, "pipette speed to mix at after dispensing")
+	add("POST_MIX_Z", "float64", "mm", -inf, inf, "height above the well bottom to mix at after dispensing, 0.5 if not set")
+
+	// air gaps
+
+	add("ASP_LEADING_AIR", "float64", "ul", 0, inf, "air to take up before aspirating, let out after dispensing")
+	add("ASP_TRAILING_AIR", "float64", "ul", 0, inf, "air to take up after aspirating, let out before dispensing")
+
+	// touching off, blowing out and putting tips back to zero
+
+	add("TOUCHOFF", "bool", "", 0, 0, "touch off after dispensing")
+	add("TOUCHOFFREFERENCE", "int", "code", 0, 2, "where the touch off height is measured from, the well bottom if not set")
+	add("TOUCHOFFSET", "float64", "mm", -inf, inf, "Z offset to touch off at")
+	add("BLOWOUTREFERENCE", "int", "code", 0, 2, "where the blowout height is measured from")
+	add("BLOWOUTOFFSET", "float64", "mm", -inf, inf, "Z offset to blow out at")
+	add("BLOWOUTVOLUME", "float64", "BLOWOUTVOLUMEUNIT", 0, inf, "volume of air to blow out, no blowout if 0")
+	add("BLOWOUTVOLUMEUNIT", "string", "", 0, 0, "unit of BLOWOUTVOLUME")
+	add("PTZREFERENCE", "int", "code", 0, 2, "where the pistons are reset from")
+	add("PTZOFFSET", "float64", "mm", -inf, inf, "Z offset to reset the pistons at")
+
+	// liquid level following
+
+	add("USE_LLF", "bool", "", 0, 0, "follow the liquid level in deep wells")
+	add("LLF_BELOW_SURFACE", "float64", "mm", 0, inf, "how far under the liquid to go")
+	add("LLF_MIN_WELL_DEPTH", "float64", "mm", 0, inf, "wells shallower than this aren't followed")
+
+	return params
+}
+
+// every item a policy can set, by name
+func GetPolicyParameters() map[string]LHPolicyParameter {
+	ret := make(map[string]LHPolicyParameter, len(policyParameters))
+	for k, v := range policyParameters {
+		ret[k] = v
+	}
+	return ret
+}
+
+func squashName(s string) string {
+	return strings.Replace(strings.ToUpper(s), "_", "", -1)
+}
+
+// the item name looks like it was meant to be, if there is one
+func SuggestPolicyParameter(name string) string {
+	for _, p := range policyParameters {
+		if squashName(p.Name) == squashName(name) {
+			return p.Name
+		}
+		for _, a := range p.Aliases {
+			if a == name {
+				return p.Name
+			}
+		}
+	}
+	return ""
+}
+
+// checks every item in the policy against the schema, fixing up
+// numbers which came in as the wrong sort. Items the schema doesn't
+// know about are left alone but come back as warnings, the first item
+// which is the wrong type or out of range is an error
+func (lhp LHPolicy) Validate() ([]string, error) {
+	names := make([]string, 0, len(lhp))
+	for k, _ := range lhp {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	warnings := make([]string, 0)
+
+	for _, k := range names {
+		p, ok := policyParameters[k]
+
+		if !ok {
+			w := fmt.Sprintf("unknown policy item %s", k)
+			if s := SuggestPolicyParameter(k); s != "" {
+				w += fmt.Sprintf(", did you mean %s?", s)
+			}
+			warnings = append(warnings, w)
+			continue
+		}
+
+		v, err := p.Check(lhp[k])
+
+		if err != nil {
+			return warnings, err
+		}
+
+		lhp[k] = v
+	}
+
+	return warnings, nil
+}
+
+// validates every policy in the set, warnings and errors say which
+// policy they came from
+func (lhpr *LHPolicyRuleSet) Validate() ([]string, error) {
+	names := make([]string, 0, len(lhpr.Policies))
+	for k, _ := range lhpr.Policies {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	warnings := make([]string, 0)
+
+	for _, name := range names {
+		ws, err := lhpr.Policies[name].Validate()
+
+		for _, w := range ws {
+			warnings = append(warnings, fmt.Sprintf("policy %s: %s", name, w))
+		}
+
+		if err != nil {
+			return warnings, fmt.Errorf("policy %s: %s", name, err)
+		}
+	}
+
+	return warnings, nil
+}
+
+// validates a policy which is being put to use: bad values are an
+// error but unknown items only get a warning
+func validatePolicy(name string, lhp LHPolicy) error {
+	warnings, err := lhp.Validate()
+
+	for _, w := range warnings {
+		wutil.Warn(fmt.Sprintf("policy %s: %s", name, w))
+	}
+
+	if err != nil {
+		return fmt.Errorf("policy %s: %s", name, err)
+	}
+
+	return nil
+}
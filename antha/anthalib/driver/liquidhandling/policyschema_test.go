@@ -0,0 +1,119 @@
+// /anthalib/driver/liquidhandling/policyschema_test.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/antha-lang/antha/antha/anthalib/driver/liquidhandling"
+)
+
+func TestPolicySchema(t *testing.T) {
+	// the built in policies should all be spelt right
+
+	for name, pol := range liquidhandling.MakePolicies() {
+		if warnings, err := pol.Validate(); len(warnings) != 0 || err != nil {
+			t.Errorf("policy %s should validate cleanly, got %v %v", name, warnings, err)
+		}
+	}
+
+	pol := liquidhandling.LHPolicy{"ASP_SPEED": 1.0, "DSPREFERENCE": 1.0, "POST_MIX_VOLUME": 50}
+
+	warnings, err := pol.Validate()
+
+	if err != nil || len(warnings) != 1 || !strings.Contains(warnings[0], "did you mean ASPSPEED") {
+		t.Errorf("misspelt items should be warned about with the right spelling, got %v %v", warnings, err)
+	}
+
+	if _, ok := pol["DSPREFERENCE"].(int); !ok {
+		t.Errorf("whole numbers should be made ints where the schema says so, got %T", pol["DSPREFERENCE"])
+	}
+
+	if _, ok := pol["POST_MIX_VOLUME"].(float64); !ok {
+		t.Errorf("ints should be made float64s where the schema says so, got %T", pol["POST_MIX_VOLUME"])
+	}
+
+	for _, bad := range []liquidhandling.LHPolicy{
+		{"DSPREFERENCE": "top"},
+		{"DSPREFERENCE": 1.5},
+		{"TIP_REUSE_LIMIT": -1},
+		{"TOUCHOFF": 1},
+	} {
+		if _, err := bad.Validate(); err == nil {
+			t.Errorf("policy %v should not validate", bad)
+		}
+	}
+
+	rule := liquidhandling.NewLHPolicyRule("bad")
+	rule.AddCategoryConditionOn("LIQUIDCLASS", "bad")
+
+	if err := liquidhandling.NewLHPolicyRuleSet().AddRule(rule, liquidhandling.LHPolicy{"ASPZOFFSET": "low"}); err == nil {
+		t.Errorf("adding a rule with a bad policy should fail")
+	}
+
+	// and when loaded
+
+	dir, err := ioutil.TempDir("", "policies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	save := func(name string, lhpr *liquidhandling.LHPolicyRuleSet) string {
+		b, err := json.Marshal(lhpr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fn := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(fn, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return fn
+	}
+
+	lhpr, err := liquidhandling.LoadLHPoliciesFrom(save("good.json", liquidhandling.GetLHPolicyForTest()))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := lhpr.Policies["default"]["DSPREFERENCE"].(int); !ok || len(lhpr.Rules) != len(liquidhandling.MakePolicies()) {
+		t.Errorf("loaded policies should come back as they were saved")
+	}
+
+	bad := liquidhandling.GetLHPolicyForTest()
+	bad.Policies["water"]["TIP_REUSE_LIMIT"] = -3
+
+	if _, err := liquidhandling.LoadLHPoliciesFrom(save("bad.json", bad)); err == nil || !strings.Contains(err.Error(), "water") {
+		t.Errorf("loading a bad policy should say which one is wrong, got %v", err)
+	}
+
+	if _, err := liquidhandling.LoadLHPoliciesFrom(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("loading a missing file should fail")
+	}
+}
@@ -0,0 +1,301 @@
+// /anthalib/driver/liquidhandling/policyyaml.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+import (
+	"fmt"
+	"github.com/antha-lang/antha/internal/github.com/ghodss/yaml"
+	"math"
+	"sort"
+)
+
+// policy rules can be written as YAML, e.g.
+//
+//	default:
+//	  TIP_REUSE_LIMIT: 100
+//	rules:
+//	  - name: thickglycerol
+//	    priority: 1
+//	    conditions:
+//	      - variable: LIQUIDCLASS
+//	        regexp: ^glycerol_[5-9][0-9]
+//	      - variable: TIPTYPE
+//	        in: [Gilson10, Gilson50]
+//	      - not:
+//	          variable: VOLUME
+//	          between: [0, 1]
+//	      - any:
+//	          - variable: FROMPLATETYPE
+//	            is: pcrplate
+//	          - variable: MULTI
+//	            between: [8, 8]
+//	    policy:
+//	      ASPSPEED: 0.5
+//	      ASP_WAIT: 5.0
+//
+// conditions are ANDed unless the rule says match: any; all, any and
+// not can be nested as deep as needed. Variables are whatever the
+// instructions give back from GetParameter. Policies aren't validated
+// here, LoadLHPoliciesFrom does that
+
+func ParseLHPolicyYAML(dat []byte) (*LHPolicyRuleSet, error) {
+	var v interface{}
+
+	if err := yaml.Unmarshal(dat, &v); err != nil {
+		return nil, err
+	}
+
+	top, ok := v.(map[string]interface{})
+
+	if !ok && v != nil {
+		return nil, fmt.Errorf("policy file must be a map of default and rules")
+	}
+
+	lhpr := NewLHPolicyRuleSet()
+
+	for _, k := range yamlKeys(top) {
+		if k != "default" && k != "rules" {
+			return nil, fmt.Errorf("unknown section %s in policy file", k)
+		}
+	}
+
+	if def, ok := top["default"]; ok {
+		pol, err := yamlPolicy(def)
+		if err != nil {
+			return nil, fmt.Errorf("default: %s", err)
+		}
+		lhpr.Policies["default"] = pol
+	}
+
+	rules, ok := top["rules"].([]interface{})
+
+	if !ok && top["rules"] != nil {
+		return nil, fmt.Errorf("rules must be a list")
+	}
+
+	for i, r := range rules {
+		rule, pol, err := yamlRule(r)
+
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %s", i+1, err)
+		}
+
+		if _, ok := lhpr.Rules[rule.Name]; ok || rule.Name == "default" {
+			return nil, fmt.Errorf("rule %d: name %s is already used", i+1, rule.Name)
+		}
+
+		lhpr.Policies[rule.Name] = pol
+		lhpr.Rules[rule.Name] = rule
+	}
+
+	return lhpr, nil
+}
+
+func yamlPolicy(v interface{}) (LHPolicy, error) {
+	m, ok := v.(map[string]interface{})
+
+	if !ok {
+		return nil, fmt.Errorf("policy must be a map")
+	}
+
+	return LHPolicy(m), nil
+}
+
+func yamlRule(v interface{}) (LHPolicyRule, LHPolicy, error) {
+	m, ok := v.(map[string]interface{})
+
+	if !ok {
+		return LHPolicyRule{}, nil, fmt.Errorf("must be a map")
+	}
+
+	name, ok := m["name"].(string)
+
+	if !ok || name == "" {
+		return LHPolicyRule{}, nil, fmt.Errorf("has no name")
+	}
+
+	rule := NewLHPolicyRule(name)
+
+	for _, k := range yamlKeys(m) {
+		v := m[k]
+		var err error
+
+		switch k {
+		case "name":
+		case "priority":
+			p, ok := v.(float64)
+			if !ok || p != math.Trunc(p) {
+				err = fmt.Errorf("priority must be a whole number")
+			}
+			rule.Priority = int(p)
+		case "match":
+			rule.Type, err = yamlMatchType(v)
+		case "conditions":
+			err = yamlConditions(&rule, v)
+		case "policy":
+		default:
+			err = fmt.Errorf("unknown item %s", k)
+		}
+
+		if err != nil {
+			return rule, nil, fmt.Errorf("%s: %s", name, err)
+		}
+	}
+
+	pol, err := yamlPolicy(m["policy"])
+
+	if err != nil {
+		return rule, nil, fmt.Errorf("%s: %s", name, err)
+	}
+
+	return rule, pol, nil
+}
+
+func yamlMatchType(v interface{}) (int, error) {
+	switch v {
+	case "all":
+		return LHP_AND, nil
+	case "any":
+		return LHP_OR, nil
+	}
+	return LHP_AND, fmt.Errorf("match must be all or any, got %v", v)
+}
+
+func yamlConditions(group *LHPolicyRule, v interface{}) error {
+	items, ok := v.([]interface{})
+
+	if !ok {
+		return fmt.Errorf("conditions must be a list")
+	}
+
+	for _, item := range items {
+		cond, g, err := yamlCondition(item)
+
+		if err != nil {
+			return err
+		}
+
+		if cond != nil {
+			group.AddCondition(*cond)
+		} else {
+			group.AddGroup(*g)
+		}
+	}
+
+	return nil
+}
+
+// a condition on a variable or a group of them
+func yamlCondition(v interface{}) (*LHVariableCondition, *LHPolicyRule, error) {
+	m, ok := v.(map[string]interface{})
+
+	if !ok {
+		return nil, nil, fmt.Errorf("condition must be a map, got %v", v)
+	}
+
+	if len(m) == 1 {
+		for k, sub := range m {
+			switch k {
+			case "all", "any":
+				typ, _ := yamlMatchType(k)
+				g := NewLHPolicyGroup(typ)
+				return nil, &g, yamlConditions(&g, sub)
+			case "not":
+				cond, g, err := yamlCondition(sub)
+				if cond != nil {
+					cond.Negate = !cond.Negate
+				} else if g != nil {
+					g.Negate = !g.Negate
+				}
+				return cond, g, err
+			}
+		}
+	}
+
+	variable, ok := m["variable"].(string)
+
+	if !ok || len(m) != 2 {
+		return nil, nil, fmt.Errorf("condition must have a variable and one of is, in, regexp or between")
+	}
+
+	cond := NewLHVariableCondition(variable)
+
+	for _, k := range yamlKeys(m) {
+		v := m[k]
+		switch k {
+		case "variable":
+		case "is":
+			s := fmt.Sprint(v)
+			if v == nil || s == "" {
+				return nil, nil, fmt.Errorf("%s: is needs a value", variable)
+			}
+			cond.SetCategoric(s)
+		case "in":
+			l, ok := v.([]interface{})
+			if !ok || len(l) == 0 {
+				return nil, nil, fmt.Errorf("%s: in needs a list", variable)
+			}
+			set := make([]string, len(l))
+			for i, s := range l {
+				set[i] = fmt.Sprint(s)
+			}
+			cond.SetSet(set...)
+		case "regexp":
+			s, ok := v.(string)
+			if !ok {
+				return nil, nil, fmt.Errorf("%s: regexp must be a string", variable)
+			}
+			c, err := NewLHRegexpCondition(s)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %s", variable, err)
+			}
+			cond.Condition = c
+		case "between":
+			l, ok := v.([]interface{})
+			if !ok || len(l) != 2 {
+				return nil, nil, fmt.Errorf("%s: between needs [low, high]", variable)
+			}
+			// numbers all come back as float64, as they would from JSON
+			low, ok1 := l[0].(float64)
+			up, ok2 := l[1].(float64)
+			if !ok1 || !ok2 || up < low {
+				return nil, nil, fmt.Errorf("%s: between needs [low, high], got %v", variable, l)
+			}
+			cond.SetNumeric(up, low)
+		default:
+			return nil, nil, fmt.Errorf("%s: unknown condition %s", variable, k)
+		}
+	}
+
+	return &cond, nil, nil
+}
+
+// the names of everything in a map, sorted
+func yamlKeys(m map[string]interface{}) []string {
+	ret := make([]string, 0, len(m))
+	for k, _ := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
@@ -0,0 +1,125 @@
+// /anthalib/driver/liquidhandling/policyyaml_test.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/antha-lang/antha/antha/anthalib/driver/liquidhandling"
+	"github.com/antha-lang/antha/antha/anthalib/wunit"
+)
+
+func TestPolicyYAML(t *testing.T) {
+	yaml := `
+# low volume glycerol is slow
+default:
+  TIP_REUSE_LIMIT: 100
+rules:
+  - name: thickglycerol
+    priority: 1
+    conditions:
+      - variable: LIQUIDCLASS
+        regexp: ^glycerol_[5-9][0-9]
+      - variable: TIPTYPE
+        in: [Gilson10, Gilson50]
+      - not:
+          variable: VOLUME
+          between: [0, 1]
+      - any:
+        - variable: FROMPLATETYPE
+          is: pcrplate
+        - variable: FROMPLATETYPE
+          is: "DSW96"   # deep wells too
+    policy:
+      ASPSPEED: 0.5
+      ASP_WAIT: 5
+  - name: water
+    conditions: [{variable: LIQUIDCLASS, is: water}]
+    policy: {DSPREFERENCE: 1, TOUCHOFF: false}
+`
+	dir, err := ioutil.TempDir("", "policies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fn := filepath.Join(dir, "policies.yaml")
+
+	if err := ioutil.WriteFile(fn, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lhpr, err := liquidhandling.LoadLHPoliciesFrom(fn)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sct := func(what, tip, plate string, vol float64) *liquidhandling.SingleChannelTransferInstruction {
+		ins := liquidhandling.NewSingleChannelTransferInstruction()
+		v := wunit.NewVolume(vol, "ul")
+		ins.What = what
+		ins.TipType = tip
+		ins.FPlateType = plate
+		ins.Volume = &v
+		return ins
+	}
+
+	pol := lhpr.GetPolicyFor(sct("glycerol_60", "Gilson10", "DSW96", 5.0))
+
+	if pol["ASPSPEED"] != 0.5 || pol["ASP_WAIT"] != 5.0 || pol["TIP_REUSE_LIMIT"] != 100 {
+		t.Errorf("glycerol rule should apply on top of the default, got %v", pol)
+	}
+
+	for _, ins := range []*liquidhandling.SingleChannelTransferInstruction{
+		sct("glycerol_60", "Gilson200", "DSW96", 5.0),
+		sct("glycerol_60", "Gilson10", "DSW96", 0.5),
+		sct("glycerol_60", "Gilson10", "reservoir", 5.0),
+		sct("glycerol_20", "Gilson10", "DSW96", 5.0),
+	} {
+		if m := lhpr.MatchingRules(ins); len(m) != 0 {
+			t.Errorf("%s in %s from %s should not match, got %s", ins.What, ins.TipType, ins.FPlateType, m[0].Name)
+		}
+	}
+
+	if m := lhpr.MatchingRules(sct("water", "Gilson200", "DSW96", 5.0)); len(m) != 1 || m[0].Name != "water" {
+		t.Errorf("flow style rules should work too")
+	}
+
+	for _, bad := range []string{
+		"rules:\n  - name: x\n   conditions: []\n",
+		"rules:\n  - name: x\n    conditions:\n      - variable: VOLUME\n        between: [10, 1]\n",
+		"rules:\n  - name: x\n    conditions:\n      - variable: LIQUIDCLASS\n        like: water\n",
+		"rules:\n  - name: x\n    conditions:\n      - variable: LIQUIDCLASS\n        regexp: ^glycerol(\n",
+		"rules:\n  - conditions: []\n",
+		"rules: [a, b\n",
+		"colours: {}\n",
+	} {
+		if _, err := liquidhandling.ParseLHPolicyYAML([]byte(bad)); err == nil {
+			t.Errorf("%q should not parse", bad)
+		}
+	}
+}
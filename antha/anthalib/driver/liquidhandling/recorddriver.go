@@ -0,0 +1,630 @@
+// /anthalib/driver/liquidhandling/recorddriver.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/antha-lang/antha/antha/anthalib/driver"
+	"github.com/antha-lang/antha/antha/anthalib/wtype"
+)
+
+// drivers which write down everything sent to another driver and play
+// it back again, so what a plan does on a device can be checked
+// against what it did last time
+
+// one line of a recording, Args and Result are the call's arguments
+// and anything other than the status it returned, as JSON
+type DriverCall struct {
+	Time     time.Time
+	Duration time.Duration
+	Method   string
+	Args     json.RawMessage
+	Result   json.RawMessage `json:",omitempty"`
+	Status   driver.CommandStatus
+}
+
+// reads a recording back, one call to a line
+func ReadDriverCalls(r io.Reader) ([]DriverCall, error) {
+	calls := make([]DriverCall, 0, 100)
+
+	dec := json.NewDecoder(r)
+
+	for {
+		var call DriverCall
+
+		if err := dec.Decode(&call); err == io.EOF {
+			return calls, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("reading call %d: %s", len(calls)+1, err)
+		}
+
+		calls = append(calls, call)
+	}
+}
+
+// plates get new IDs every time they're made so only their types go in
+func plateTypes(plates []*wtype.LHPlate) []string {
+	s := make([]string, len(plates))
+	for i, p := range plates {
+		if p != nil {
+			s[i] = p.Type
+		}
+	}
+	return s
+}
+
+func deckItemType(item interface{}) string {
+	switch it := item.(type) {
+	case *wtype.LHPlate:
+		return it.Type
+	case *wtype.LHTipbox:
+		return it.Type
+	case *wtype.LHTipwaste:
+		return it.Type
+	}
+	return fmt.Sprintf("%T", item)
+}
+
+// IDs are made afresh every time a plan is made, if Replacer is set it
+// is used on the arguments to swap them for something which isn't
+func replaceArgs(r *strings.Replacer, args json.RawMessage) json.RawMessage {
+	if r == nil {
+		return args
+	}
+	return json.RawMessage(r.Replace(string(args)))
+}
+
+// passes everything on to Inner and writes each call to w as it
+// returns; the first error writing is kept in Err. If Untimed is set
+// Time and Duration are left as zero, so recording the same thing
+// twice gives the same recording
+type RecordingDriver struct {
+	Inner    LiquidhandlingDriver
+	Replacer *strings.Replacer
+	Untimed  bool
+	enc      *json.Encoder
+	err      error
+}
+
+func NewRecordingDriver(inner LiquidhandlingDriver, w io.Writer) *RecordingDriver {
+	return &RecordingDriver{Inner: inner, enc: json.NewEncoder(w)}
+}
+
+func (d *RecordingDriver) Err() error {
+	return d.err
+}
+
+func (d *RecordingDriver) record(start time.Time, method string, args []interface{}, result interface{}, status driver.CommandStatus) {
+	if d.err != nil {
+		return
+	}
+
+	call := DriverCall{Method: method, Status: status}
+
+	if !d.Untimed {
+		call.Time, call.Duration = start, time.Since(start)
+	}
+
+	call.Args, d.err = json.Marshal(args)
+	call.Args = replaceArgs(d.Replacer, call.Args)
+
+	if d.err == nil && result != nil {
+		call.Result, d.err = json.Marshal(result)
+	}
+
+	if d.err == nil {
+		d.err = d.enc.Encode(call)
+	}
+
+	if d.err != nil {
+		d.err = fmt.Errorf("recording %s: %s", method, d.err)
+	}
+}
+
+func (d *RecordingDriver) Move(deckposition []string, wellcoords []string, reference []int, offsetX, offsetY, offsetZ []float64, plate_type []string, head int) driver.CommandStatus {
+	start := time.Now()
+	st := d.Inner.Move(deckposition, wellcoords, reference, offsetX, offsetY, offsetZ, plate_type, head)
+	d.record(start, "Move", []interface{}{deckposition, wellcoords, reference, offsetX, offsetY, offsetZ, plate_type, head}, nil, st)
+	return st
+}
+
+func (d *RecordingDriver) MoveExplicit(deckposition []string, wellcoords []string, reference []int, offsetX, offsetY, offsetZ []float64, plate_type []*wtype.LHPlate, head int) driver.CommandStatus {
+	start := time.Now()
+	st := d.Inner.MoveExplicit(deckposition, wellcoords, reference, offsetX, offsetY, offsetZ, plate_type, head)
+	d.record(start, "MoveExplicit", []interface{}{deckposition, wellcoords, reference, offsetX, offsetY, offsetZ, plateTypes(plate_type), head}, nil, st)
+	return st
+}
+
+func (d *RecordingDriver) MoveRaw(head int, x, y, z float64) driver.CommandStatus {
+	start := time.Now()
+	st := d.Inner.MoveRaw(head, x, y, z)
+	d.record(start, "MoveRaw", []interface{}{head, x, y, z}, nil, st)
+	return st
+}
+
+func (d *RecordingDriver) Aspirate(volume []float64, overstroke []bool, head int, multi int, platetype []string, what []string, llf []bool) driver.CommandStatus {
+	start := time.Now()
+	st := d.Inner.Aspirate(volume, overstroke, head, multi, platetype, what, llf)
+	d.record(start, "Aspirate", []interface{}{volume, overstroke, head, multi, platetype, what, llf}, nil, st)
+	return st
+}
+
+func (d *RecordingDriver) Dispense(volume []float64, blowout []bool, head int, multi int, platetype []string, what []string, llf []bool) driver.CommandStatus {
+	start := time.Now()
+	st := d.Inner.Dispense(volume, blowout, head, multi, platetype, what, llf)
+	d.record(start, "Dispense", []interface{}{volume, blowout, head, multi, platetype, what, llf}, nil, st)
+	return st
+}
+
+func (d *RecordingDriver) LoadTips(channels []int, head, multi int, platetype, position, well []string) driver.CommandStatus {
+	start := time.Now()
+	st := d.Inner.LoadTips(channels, head, multi, platetype, position, well)
+	d.record(start, "LoadTips", []interface{}{channels, head, multi, platetype, position, well}, nil, st)
+	return st
+}
+
+func (d *RecordingDriver) UnloadTips(channels []int, head, multi int, platetype, position, well []string) driver.CommandStatus {
+	start := time.Now()
+	st := d.Inner.UnloadTips(channels, head, multi, platetype, position, well)
+	d.record(start, "UnloadTips", []interface{}{channels, head, multi, platetype, position, well}, nil, st)
+	return st
+}
+
+func (d *RecordingDriver) SetPipetteSpeed(head, channel int, rate float64) driver.CommandStatus {
+	start := time.Now()
+	st := d.Inner.SetPipetteSpeed(head, channel, rate)
+	d.record(start, "SetPipetteSpeed", []interface{}{head, channel, rate}, nil, st)
+	return st
+}
+
+func (d *RecordingDriver) SetDriveSpeed(drive string, rate float64) driver.CommandStatus {
+	start := time.Now()
+	st := d.Inner.SetDriveSpeed(drive, rate)
+	d.record(start, "SetDriveSpeed", []interface{}{drive, rate}, nil, st)
+	return st
+}
+
+func (d *RecordingDriver) Stop() driver.CommandStatus {
+	start := time.Now()
+	st := d.Inner.Stop()
+	d.record(start, "Stop", nil, nil, st)
+	return st
+}
+
+func (d *RecordingDriver) Go() driver.CommandStatus {
+	start := time.Now()
+	st := d.Inner.Go()
+	d.record(start, "Go", nil, nil, st)
+	return st
+}
+
+func (d *RecordingDriver) Initialize() driver.CommandStatus {
+	start := time.Now()
+	st := d.Inner.Initialize()
+	d.record(start, "Initialize", nil, nil, st)
+	return st
+}
+
+func (d *RecordingDriver) Finalize() driver.CommandStatus {
+	start := time.Now()
+	st := d.Inner.Finalize()
+	d.record(start, "Finalize", nil, nil, st)
+	return st
+}
+
+func (d *RecordingDriver) SetPositionState(position string, state driver.PositionState) driver.CommandStatus {
+	start := time.Now()
+	st := d.Inner.SetPositionState(position, state)
+	d.record(start, "SetPositionState", []interface{}{position, state}, nil, st)
+	return st
+}
+
+func (d *RecordingDriver) GetCapabilities() (LHProperties, driver.CommandStatus) {
+	start := time.Now()
+	p, st := d.Inner.GetCapabilities()
+	d.record(start, "GetCapabilities", nil, &p, st)
+	return p, st
+}
+
+func (d *RecordingDriver) GetCurrentPosition(head int) (string, driver.CommandStatus) {
+	start := time.Now()
+	s, st := d.Inner.GetCurrentPosition(head)
+	d.record(start, "GetCurrentPosition", []interface{}{head}, s, st)
+	return s, st
+}
+
+func (d *RecordingDriver) GetPositionState(position string) (string, driver.CommandStatus) {
+	start := time.Now()
+	s, st := d.Inner.GetPositionState(position)
+	d.record(start, "GetPositionState", []interface{}{position}, s, st)
+	return s, st
+}
+
+func (d *RecordingDriver) GetHeadState(head int) (string, driver.CommandStatus) {
+	start := time.Now()
+	s, st := d.Inner.GetHeadState(head)
+	d.record(start, "GetHeadState", []interface{}{head}, s, st)
+	return s, st
+}
+
+func (d *RecordingDriver) GetStatus() (driver.Status, driver.CommandStatus) {
+	start := time.Now()
+	s, st := d.Inner.GetStatus()
+	d.record(start, "GetStatus", nil, s, st)
+	return s, st
+}
+
+func (d *RecordingDriver) ResetPistons(head, channel int) driver.CommandStatus {
+	start := time.Now()
+	st := d.Inner.ResetPistons(head, channel)
+	d.record(start, "ResetPistons", []interface{}{head, channel}, nil, st)
+	return st
+}
+
+func (d *RecordingDriver) Wait(secs float64) driver.CommandStatus {
+	start := time.Now()
+	st := d.Inner.Wait(secs)
+	d.record(start, "Wait", []interface{}{secs}, nil, st)
+	return st
+}
+
+func (d *RecordingDriver) Mix(head int, volume []float64, fvolume []float64, platetype []string, cycles []int, multi int, prms map[string]interface{}) driver.CommandStatus {
+	start := time.Now()
+	st := d.Inner.Mix(head, volume, fvolume, platetype, cycles, multi, prms)
+	d.record(start, "Mix", []interface{}{head, volume, fvolume, platetype, cycles, multi, prms}, nil, st)
+	return st
+}
+
+func (d *RecordingDriver) AddPlateTo(position string, plate interface{}, name string) driver.CommandStatus {
+	start := time.Now()
+	st := d.Inner.AddPlateTo(position, plate, name)
+	d.record(start, "AddPlateTo", []interface{}{position, deckItemType(plate), name}, nil, st)
+	return st
+}
+
+func (d *RecordingDriver) RemoveAllPlates() driver.CommandStatus {
+	start := time.Now()
+	st := d.Inner.RemoveAllPlates()
+	d.record(start, "RemoveAllPlates", nil, nil, st)
+	return st
+}
+
+func (d *RecordingDriver) RemovePlateAt(position string) driver.CommandStatus {
+	start := time.Now()
+	st := d.Inner.RemovePlateAt(position)
+	d.record(start, "RemovePlateAt", []interface{}{position}, nil, st)
+	return st
+}
+
+// the same for drivers which can do more, a RecordingDriver around one
+// of those would hide it
+type ExtendedRecordingDriver struct {
+	*RecordingDriver
+	ext ExtendedLiquidhandlingDriver
+}
+
+func NewExtendedRecordingDriver(inner ExtendedLiquidhandlingDriver, w io.Writer) *ExtendedRecordingDriver {
+	return &ExtendedRecordingDriver{NewRecordingDriver(inner, w), inner}
+}
+
+func (d *ExtendedRecordingDriver) UnloadHead(param int) driver.CommandStatus {
+	start := time.Now()
+	st := d.ext.UnloadHead(param)
+	d.record(start, "UnloadHead", []interface{}{param}, nil, st)
+	return st
+}
+
+func (d *ExtendedRecordingDriver) LoadHead(param int) driver.CommandStatus {
+	start := time.Now()
+	st := d.ext.LoadHead(param)
+	d.record(start, "LoadHead", []interface{}{param}, nil, st)
+	return st
+}
+
+func (d *ExtendedRecordingDriver) LightsOn() driver.CommandStatus {
+	start := time.Now()
+	st := d.ext.LightsOn()
+	d.record(start, "LightsOn", nil, nil, st)
+	return st
+}
+
+func (d *ExtendedRecordingDriver) LightsOff() driver.CommandStatus {
+	start := time.Now()
+	st := d.ext.LightsOff()
+	d.record(start, "LightsOff", nil, nil, st)
+	return st
+}
+
+func (d *ExtendedRecordingDriver) LoadAdaptor(param int) driver.CommandStatus {
+	start := time.Now()
+	st := d.ext.LoadAdaptor(param)
+	d.record(start, "LoadAdaptor", []interface{}{param}, nil, st)
+	return st
+}
+
+func (d *ExtendedRecordingDriver) UnloadAdaptor(param int) driver.CommandStatus {
+	start := time.Now()
+	st := d.ext.UnloadAdaptor(param)
+	d.record(start, "UnloadAdaptor", []interface{}{param}, nil, st)
+	return st
+}
+
+func (d *ExtendedRecordingDriver) Open() driver.CommandStatus {
+	start := time.Now()
+	st := d.ext.Open()
+	d.record(start, "Open", nil, nil, st)
+	return st
+}
+
+func (d *ExtendedRecordingDriver) Close() driver.CommandStatus {
+	start := time.Now()
+	st := d.ext.Close()
+	d.record(start, "Close", nil, nil, st)
+	return st
+}
+
+func (d *ExtendedRecordingDriver) Message(level int, title, text string, showcancel bool) driver.CommandStatus {
+	start := time.Now()
+	st := d.ext.Message(level, title, text, showcancel)
+	d.record(start, "Message", []interface{}{level, title, text, showcancel}, nil, st)
+	return st
+}
+
+// answers each call with what was recorded for it, as long as it's the
+// same call with the same arguments; anything else fails and the first
+// difference is kept for Done
+type ReplayDriver struct {
+	Calls    []DriverCall
+	Replacer *strings.Replacer
+	next     int
+	err      error
+}
+
+func NewReplayDriver(r io.Reader) (*ReplayDriver, error) {
+	calls, err := ReadDriverCalls(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReplayDriver{Calls: calls}, nil
+}
+
+// the first call which didn't match, or an error if the recording
+// wasn't played to the end
+func (d *ReplayDriver) Done() error {
+	if d.err != nil {
+		return d.err
+	}
+
+	if d.next < len(d.Calls) {
+		return fmt.Errorf("%d of %d recorded calls were never made, the first is %s(%s)", len(d.Calls)-d.next, len(d.Calls), d.Calls[d.next].Method, d.Calls[d.next].Args)
+	}
+
+	return nil
+}
+
+func (d *ReplayDriver) replay(method string, args []interface{}, result interface{}) driver.CommandStatus {
+	n := d.next
+	d.next += 1
+
+	fail := func(err error) driver.CommandStatus {
+		if d.err == nil {
+			d.err = err
+		}
+		return driver.CommandStatus{OK: false, Errorcode: driver.ERR, Msg: err.Error()}
+	}
+
+	a, err := json.Marshal(args)
+
+	if err != nil {
+		return fail(fmt.Errorf("call %d: %s: %s", n+1, method, err))
+	}
+
+	a = replaceArgs(d.Replacer, a)
+
+	if n >= len(d.Calls) {
+		return fail(fmt.Errorf("call %d: %s(%s) is past the end of the recording", n+1, method, a))
+	}
+
+	call := d.Calls[n]
+
+	if call.Method != method || !bytes.Equal(call.Args, a) {
+		return fail(fmt.Errorf("call %d: expected %s(%s), got %s(%s)", n+1, call.Method, call.Args, method, a))
+	}
+
+	if result != nil && len(call.Result) != 0 {
+		if err := json.Unmarshal(call.Result, result); err != nil {
+			return fail(fmt.Errorf("call %d: %s: %s", n+1, method, err))
+		}
+	}
+
+	return call.Status
+}
+
+func (d *ReplayDriver) Move(deckposition []string, wellcoords []string, reference []int, offsetX, offsetY, offsetZ []float64, plate_type []string, head int) driver.CommandStatus {
+	return d.replay("Move", []interface{}{deckposition, wellcoords, reference, offsetX, offsetY, offsetZ, plate_type, head}, nil)
+}
+
+func (d *ReplayDriver) MoveExplicit(deckposition []string, wellcoords []string, reference []int, offsetX, offsetY, offsetZ []float64, plate_type []*wtype.LHPlate, head int) driver.CommandStatus {
+	return d.replay("MoveExplicit", []interface{}{deckposition, wellcoords, reference, offsetX, offsetY, offsetZ, plateTypes(plate_type), head}, nil)
+}
+
+func (d *ReplayDriver) MoveRaw(head int, x, y, z float64) driver.CommandStatus {
+	return d.replay("MoveRaw", []interface{}{head, x, y, z}, nil)
+}
+
+func (d *ReplayDriver) Aspirate(volume []float64, overstroke []bool, head int, multi int, platetype []string, what []string, llf []bool) driver.CommandStatus {
+	return d.replay("Aspirate", []interface{}{volume, overstroke, head, multi, platetype, what, llf}, nil)
+}
+
+func (d *ReplayDriver) Dispense(volume []float64, blowout []bool, head int, multi int, platetype []string, what []string, llf []bool) driver.CommandStatus {
+	return d.replay("Dispense", []interface{}{volume, blowout, head, multi, platetype, what, llf}, nil)
+}
+
+func (d *ReplayDriver) LoadTips(channels []int, head, multi int, platetype, position, well []string) driver.CommandStatus {
+	return d.replay("LoadTips", []interface{}{channels, head, multi, platetype, position, well}, nil)
+}
+
+func (d *ReplayDriver) UnloadTips(channels []int, head, multi int, platetype, position, well []string) driver.CommandStatus {
+	return d.replay("UnloadTips", []interface{}{channels, head, multi, platetype, position, well}, nil)
+}
+
+func (d *ReplayDriver) SetPipetteSpeed(head, channel int, rate float64) driver.CommandStatus {
+	return d.replay("SetPipetteSpeed", []interface{}{head, channel, rate}, nil)
+}
+
+func (d *ReplayDriver) SetDriveSpeed(drive string, rate float64) driver.CommandStatus {
+	return d.replay("SetDriveSpeed", []interface{}{drive, rate}, nil)
+}
+
+func (d *ReplayDriver) Stop() driver.CommandStatus {
+	return d.replay("Stop", nil, nil)
+}
+
+func (d *ReplayDriver) Go() driver.CommandStatus {
+	return d.replay("Go", nil, nil)
+}
+
+func (d *ReplayDriver) Initialize() driver.CommandStatus {
+	return d.replay("Initialize", nil, nil)
+}
+
+func (d *ReplayDriver) Finalize() driver.CommandStatus {
+	return d.replay("Finalize", nil, nil)
+}
+
+func (d *ReplayDriver) SetPositionState(position string, state driver.PositionState) driver.CommandStatus {
+	return d.replay("SetPositionState", []interface{}{position, state}, nil)
+}
+
+func (d *ReplayDriver) GetCapabilities() (LHProperties, driver.CommandStatus) {
+	var p LHProperties
+	st := d.replay("GetCapabilities", nil, &p)
+	return p, st
+}
+
+func (d *ReplayDriver) GetCurrentPosition(head int) (string, driver.CommandStatus) {
+	var s string
+	st := d.replay("GetCurrentPosition", []interface{}{head}, &s)
+	return s, st
+}
+
+func (d *ReplayDriver) GetPositionState(position string) (string, driver.CommandStatus) {
+	var s string
+	st := d.replay("GetPositionState", []interface{}{position}, &s)
+	return s, st
+}
+
+func (d *ReplayDriver) GetHeadState(head int) (string, driver.CommandStatus) {
+	var s string
+	st := d.replay("GetHeadState", []interface{}{head}, &s)
+	return s, st
+}
+
+func (d *ReplayDriver) GetStatus() (driver.Status, driver.CommandStatus) {
+	var s driver.Status
+	st := d.replay("GetStatus", nil, &s)
+	return s, st
+}
+
+func (d *ReplayDriver) ResetPistons(head, channel int) driver.CommandStatus {
+	return d.replay("ResetPistons", []interface{}{head, channel}, nil)
+}
+
+func (d *ReplayDriver) Wait(secs float64) driver.CommandStatus {
+	return d.replay("Wait", []interface{}{secs}, nil)
+}
+
+func (d *ReplayDriver) Mix(head int, volume []float64, fvolume []float64, platetype []string, cycles []int, multi int, prms map[string]interface{}) driver.CommandStatus {
+	return d.replay("Mix", []interface{}{head, volume, fvolume, platetype, cycles, multi, prms}, nil)
+}
+
+func (d *ReplayDriver) AddPlateTo(position string, plate interface{}, name string) driver.CommandStatus {
+	return d.replay("AddPlateTo", []interface{}{position, deckItemType(plate), name}, nil)
+}
+
+func (d *ReplayDriver) RemoveAllPlates() driver.CommandStatus {
+	return d.replay("RemoveAllPlates", nil, nil)
+}
+
+func (d *ReplayDriver) RemovePlateAt(position string) driver.CommandStatus {
+	return d.replay("RemovePlateAt", []interface{}{position}, nil)
+}
+
+// plays back a recording of an ExtendedRecordingDriver
+type ExtendedReplayDriver struct {
+	*ReplayDriver
+}
+
+func NewExtendedReplayDriver(r io.Reader) (*ExtendedReplayDriver, error) {
+	d, err := NewReplayDriver(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &ExtendedReplayDriver{d}, nil
+}
+
+func (d *ExtendedReplayDriver) UnloadHead(param int) driver.CommandStatus {
+	return d.replay("UnloadHead", []interface{}{param}, nil)
+}
+
+func (d *ExtendedReplayDriver) LoadHead(param int) driver.CommandStatus {
+	return d.replay("LoadHead", []interface{}{param}, nil)
+}
+
+func (d *ExtendedReplayDriver) LightsOn() driver.CommandStatus {
+	return d.replay("LightsOn", nil, nil)
+}
+
+func (d *ExtendedReplayDriver) LightsOff() driver.CommandStatus {
+	return d.replay("LightsOff", nil, nil)
+}
+
+func (d *ExtendedReplayDriver) LoadAdaptor(param int) driver.CommandStatus {
+	return d.replay("LoadAdaptor", []interface{}{param}, nil)
+}
+
+func (d *ExtendedReplayDriver) UnloadAdaptor(param int) driver.CommandStatus {
+	return d.replay("UnloadAdaptor", []interface{}{param}, nil)
+}
+
+func (d *ExtendedReplayDriver) Open() driver.CommandStatus {
+	return d.replay("Open", nil, nil)
+}
+
+func (d *ExtendedReplayDriver) Close() driver.CommandStatus {
+	return d.replay("Close", nil, nil)
+}
+
+func (d *ExtendedReplayDriver) Message(level int, title, text string, showcancel bool) driver.CommandStatus {
+	return d.replay("Message", []interface{}{level, title, text, showcancel}, nil)
+}
@@ -0,0 +1,177 @@
+// /anthalib/driver/liquidhandling/recorddriver_test.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/antha-lang/antha/antha/anthalib/driver/liquidhandling"
+	"github.com/antha-lang/antha/antha/anthalib/factory"
+	"github.com/antha-lang/antha/antha/anthalib/wtype"
+	"github.com/antha-lang/antha/antha/anthalib/wunit"
+)
+
+func TestRecordAndReplayDriver(t *testing.T) {
+	// a column of water out of a trough
+
+	params := factory.GetLiquidhandlerByType("GilsonPipetmax")
+	params.AddPlate("position_4", factory.GetPlateByType("DWST12"))
+	params.AddPlate("position_7", factory.GetPlateByType("pcrplate"))
+	tb := factory.GetTipboxByType("Gilson200")
+	params.AddTipBox(tb)
+	params.AddTipWaste("position_1", factory.GetTipwasteByType("Gilsontipwaste"))
+	params.Tips = []*wtype.LHTip{tb.Tiptype}
+	params.ResetTipTracking()
+
+	wellfrom := make([]string, 0, 8)
+	wellto := make([]string, 0, 8)
+	vols := make([]float64, 0, 8)
+	for _, r := range "ABCDEFGH" {
+		wellfrom = append(wellfrom, "A1")
+		wellto = append(wellto, fmt.Sprintf("%c1", r))
+		vols = append(vols, 20.0)
+	}
+
+	tfr := makeTestTransfer(wellfrom, wellto, vols)
+	for _, v := range tfr.FVolume {
+		*v = wunit.NewVolume(5000.0, "ul")
+	}
+
+	instrx := make([]liquidhandling.TerminalRobotInstruction, 0)
+	for _, ins := range liquidhandling.NewRobotInstructionSet(tfr).Generate(liquidhandling.GetLHPolicyForTest(), params) {
+		instrx = append(instrx, ins.(liquidhandling.TerminalRobotInstruction))
+	}
+
+	// names which change from run to run are swapped for ones which don't
+
+	replacer := strings.NewReplacer("position_7", "destination")
+
+	run := func(d liquidhandling.LiquidhandlingDriver) error {
+		if st := d.RemoveAllPlates(); !st.OK {
+			return fmt.Errorf("removing plates: %s", st.Msg)
+		}
+		for i, ins := range instrx {
+			if st := ins.OutputTo(d); !st.OK {
+				return fmt.Errorf("instruction %d: %s", i, st.Msg)
+			}
+		}
+		return nil
+	}
+
+	var buf bytes.Buffer
+
+	ds := liquidhandling.NewDeckSimulator()
+	rec := liquidhandling.NewRecordingDriver(ds, &buf)
+	rec.Replacer = replacer
+
+	if err := run(rec); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.Err() != nil {
+		t.Fatal(rec.Err())
+	}
+
+	calls, err := liquidhandling.ReadDriverCalls(bytes.NewReader(buf.Bytes()))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aspirates, seen := 0, 0
+	for _, call := range calls {
+		if call.Method == "Aspirate" {
+			aspirates += 1
+		}
+		if call.Time.IsZero() || !call.Status.OK {
+			t.Errorf("%s should be recorded with when it was sent and what it returned, got %v and %v", call.Method, call.Time, call.Status)
+		}
+	}
+
+	for _, e := range ds.Effects {
+		if strings.HasPrefix(e, "aspirate") {
+			seen += 1
+		}
+	}
+
+	if len(calls) != strings.Count(buf.String(), "\n") || aspirates == 0 || aspirates != seen || calls[0].Method != "RemoveAllPlates" {
+		t.Errorf("every call should be recorded on its own line in order: %d calls in %d lines, %d of %d aspirates", len(calls), strings.Count(buf.String(), "\n"), aspirates, seen)
+	}
+
+	if strings.Contains(buf.String(), "position_7") || !strings.Contains(buf.String(), "destination") {
+		t.Errorf("the recording should have the names replaced")
+	}
+
+	// the same instructions run again make the same calls
+
+	rp, err := liquidhandling.NewReplayDriver(bytes.NewReader(buf.Bytes()))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rp.Replacer = replacer
+
+	if err := run(rp); err != nil || rp.Done() != nil {
+		t.Errorf("replaying should match the recording: %v, %v", err, rp.Done())
+	}
+
+	// and ones which differ stop where they do
+
+	for i, call := range calls {
+		if call.Method == "Aspirate" {
+			calls[i].Args = json.RawMessage(strings.Replace(string(call.Args), "[", "[[1],", 1))
+			break
+		}
+	}
+
+	rp = &liquidhandling.ReplayDriver{Calls: calls, Replacer: replacer}
+
+	if err := run(rp); err == nil || rp.Done() == nil || !strings.Contains(rp.Done().Error(), "expected Aspirate") {
+		t.Errorf("replaying should fail at the first aspirate which differs: %v, %v", err, rp.Done())
+	}
+
+	// or run out
+
+	rp = &liquidhandling.ReplayDriver{Calls: calls[:1], Replacer: replacer}
+
+	if err := run(rp); err == nil || rp.Done() == nil || !strings.Contains(rp.Done().Error(), "past the end") {
+		t.Errorf("replaying should fail past the end of the recording: %v, %v", err, rp.Done())
+	}
+
+	// without a replacer the names are left alone
+
+	buf.Reset()
+
+	if err := run(liquidhandling.NewRecordingDriver(liquidhandling.NewDeckSimulator(), &buf)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), "position_7") {
+		t.Errorf("the recording should have the names as they were sent")
+	}
+}
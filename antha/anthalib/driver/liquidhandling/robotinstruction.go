@@ -22,6 +22,10 @@
 
 package liquidhandling
 
+import (
+	"github.com/antha-lang/antha/antha/anthalib/driver"
+)
+
 type RobotInstruction interface {
 	InstructionType() int
 	GetParameter(name string) interface{}
@@ -30,7 +34,7 @@ type RobotInstruction interface {
 
 type TerminalRobotInstruction interface {
 	RobotInstruction
-	OutputTo(driver LiquidhandlingDriver)
+	OutputTo(driver LiquidhandlingDriver) driver.CommandStatus
 }
 
 const (
@@ -68,6 +72,8 @@ const (
 	UAD            // Unload Adaptor
 	MMX            // Move and Mix
 	MIX            // Mix
+	ETW            // Empty tip waste
+	MDT            // Multi-dispense transfer
 )
 
-var Robotinstructionnames = []string{"TFR", "CTF", "SCB", "MCB", "SCT", "MCT", "CCC", "LDT", "UDT", "RST", "CHA", "ASP", "DSP", "BLO", "PTZ", "MOV", "MRW", "LOD", "ULD", "SUK", "BLW", "SPS", "SDS", "INI", "FIN", "WAI", "LON", "LOF", "OPN", "CLS", "LAD", "UAD", "MIX"}
+var Robotinstructionnames = []string{"TFR", "CTF", "SCB", "MCB", "SCT", "MCT", "CCC", "LDT", "UDT", "RST", "CHA", "ASP", "DSP", "BLO", "PTZ", "MOV", "MRW", "LOD", "ULD", "SUK", "BLW", "SPS", "SDS", "INI", "FIN", "WAI", "LON", "LOF", "OPN", "CLS", "LAD", "UAD", "MMX", "MIX", "ETW", "MDT"}
@@ -24,6 +24,7 @@ package liquidhandling
 
 import (
 	"fmt"
+	"strings"
 )
 
 type RobotInstructionSet struct {
@@ -43,6 +44,70 @@ func (ri *RobotInstructionSet) Add(ins RobotInstruction) {
 	ri.instructions = append(ri.instructions, ris)
 }
 
+// add a set which may already have been generated
+func (ri *RobotInstructionSet) AddSet(ris *RobotInstructionSet) {
+	ri.instructions = append(ri.instructions, ris)
+}
+
+func (ri *RobotInstructionSet) Parent() RobotInstruction {
+	return ri.parent
+}
+
+func (ri *RobotInstructionSet) Children() []*RobotInstructionSet {
+	return ri.instructions
+}
+
+// calls fn for every instruction in the tree, parents before their
+// children; step is where the instruction is in the tree so 2.1 is the
+// first thing the second instruction made
+func (ri *RobotInstructionSet) Walk(fn func(ins RobotInstruction, step []int)) {
+	ri.walk(fn, []int{})
+}
+
+func (ri *RobotInstructionSet) walk(fn func(ins RobotInstruction, step []int), step []int) {
+	if ri.parent != nil {
+		fn(ri.parent, step)
+	}
+
+	for i, ris := range ri.instructions {
+		s := make([]int, len(step)+1)
+		copy(s, step)
+		s[len(step)] = i + 1
+		ris.walk(fn, s)
+	}
+}
+
+// takes out of the tree every instruction drop says to, e.g. after
+// optimising, along with anything left with nothing under it
+func (ri *RobotInstructionSet) Prune(drop func(ins RobotInstruction) bool) {
+	kept := make([]*RobotInstructionSet, 0, len(ri.instructions))
+
+	for _, ris := range ri.instructions {
+		if ris.parent != nil && drop(ris.parent) {
+			continue
+		}
+
+		had := len(ris.instructions) != 0
+		ris.Prune(drop)
+
+		if had && len(ris.instructions) == 0 {
+			continue
+		}
+
+		kept = append(kept, ris)
+	}
+
+	ri.instructions = kept
+}
+
+func StepName(step []int) string {
+	s := make([]string, 0, len(step))
+	for _, i := range step {
+		s = append(s, fmt.Sprintf("%d", i))
+	}
+	return strings.Join(s, ".")
+}
+
 func (ri *RobotInstructionSet) Generate(lhpr *LHPolicyRuleSet, lhpm *LHProperties) []RobotInstruction {
 	ret := make([]RobotInstruction, 0, 1)
 
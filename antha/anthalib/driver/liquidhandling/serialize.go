@@ -0,0 +1,422 @@
+// /anthalib/driver/liquidhandling/serialize.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/antha-lang/antha/antha/anthalib/wtype"
+)
+
+// instructions, conditions and properties all hold interfaces which
+// JSON can write but not read back; these put in enough to make the
+// right things again
+
+// makes an empty instruction of each type
+var robotinstructionmakers = map[int]func() RobotInstruction{
+	TFR: func() RobotInstruction { return &TransferInstruction{} },
+	SCB: func() RobotInstruction { return NewSingleChannelBlockInstruction() },
+	MCB: func() RobotInstruction { return NewMultiChannelBlockInstruction() },
+	SCT: func() RobotInstruction { return NewSingleChannelTransferInstruction() },
+	MCT: func() RobotInstruction { return NewMultiChannelTransferInstruction() },
+	CCC: func() RobotInstruction { return &StateChangeInstruction{} },
+	LDT: func() RobotInstruction { return NewLoadTipsMoveInstruction() },
+	UDT: func() RobotInstruction { return NewUnloadTipsMoveInstruction() },
+	RST: func() RobotInstruction { return NewResetInstruction() },
+	CHA: func() RobotInstruction { return &ChangeAdaptorInstruction{} },
+	ASP: func() RobotInstruction { return NewAspirateInstruction() },
+	DSP: func() RobotInstruction { return NewDispenseInstruction() },
+	BLO: func() RobotInstruction { return NewBlowoutInstruction() },
+	PTZ: func() RobotInstruction { return NewPTZInstruction() },
+	MOV: func() RobotInstruction { return NewMoveInstruction() },
+	MRW: func() RobotInstruction { return NewMoveRawInstruction() },
+	LOD: func() RobotInstruction { return NewLoadTipsInstruction() },
+	ULD: func() RobotInstruction { return NewUnloadTipsInstruction() },
+	SUK: func() RobotInstruction { return NewSuckInstruction() },
+	BLW: func() RobotInstruction { return NewBlowInstruction() },
+	SPS: func() RobotInstruction { return NewSetPipetteSpeedInstruction() },
+	SDS: func() RobotInstruction { return NewSetDriveSpeedInstruction() },
+	INI: func() RobotInstruction { return NewInitializeInstruction() },
+	FIN: func() RobotInstruction { return NewFinalizeInstruction() },
+	WAI: func() RobotInstruction { return NewWaitInstruction() },
+	LON: func() RobotInstruction { return NewLightsOnInstruction() },
+	LOF: func() RobotInstruction { return NewLightsOffInstruction() },
+	OPN: func() RobotInstruction { return NewOpenInstruction() },
+	CLS: func() RobotInstruction { return NewCloseInstruction() },
+	LAD: func() RobotInstruction { return NewLoadAdaptorInstruction() },
+	UAD: func() RobotInstruction { return NewUnloadAdaptorInstruction() },
+	MMX: func() RobotInstruction { return NewMoveMixInstruction() },
+	MIX: func() RobotInstruction { return NewMixInstruction() },
+	ETW: func() RobotInstruction { return NewEmptyTipwasteInstruction() },
+	MDT: func() RobotInstruction { return NewMultiDispenseInstruction() },
+}
+
+func robotinstruction_type(name string) (int, bool) {
+	for t, n := range Robotinstructionnames {
+		if n == name {
+			return t, true
+		}
+	}
+	return -1, false
+}
+
+// an instruction tagged with the name of its type
+type SRobotInstruction struct {
+	Type        string
+	Instruction json.RawMessage
+}
+
+func MarshalRobotInstruction(ins RobotInstruction) ([]byte, error) {
+	t := ins.InstructionType()
+
+	if t < 0 || t >= len(Robotinstructionnames) {
+		return nil, fmt.Errorf("cannot marshal instruction of unknown type %d", t)
+	}
+
+	b, err := json.Marshal(ins)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(SRobotInstruction{Robotinstructionnames[t], b})
+}
+
+func UnmarshalRobotInstruction(b []byte) (RobotInstruction, error) {
+	var sri SRobotInstruction
+
+	if err := json.Unmarshal(b, &sri); err != nil {
+		return nil, err
+	}
+
+	t, ok := robotinstruction_type(sri.Type)
+
+	if !ok {
+		return nil, fmt.Errorf("cannot unmarshal instruction of unknown type %s", sri.Type)
+	}
+
+	mk, ok := robotinstructionmakers[t]
+
+	if !ok {
+		return nil, fmt.Errorf("cannot unmarshal instruction of type %s", sri.Type)
+	}
+
+	ins := mk()
+
+	if err := json.Unmarshal(sri.Instruction, ins); err != nil {
+		return nil, err
+	}
+
+	return ins, nil
+}
+
+// instruction sets are written as a tree of tagged instructions
+type SRobotInstructionSet struct {
+	Parent       json.RawMessage
+	Instructions []*RobotInstructionSet
+}
+
+func (ri *RobotInstructionSet) MarshalJSON() ([]byte, error) {
+	var sri SRobotInstructionSet
+
+	if ri.parent != nil {
+		b, err := MarshalRobotInstruction(ri.parent)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sri.Parent = b
+	}
+
+	sri.Instructions = ri.instructions
+
+	return json.Marshal(sri)
+}
+
+func (ri *RobotInstructionSet) UnmarshalJSON(b []byte) error {
+	var sri SRobotInstructionSet
+
+	if err := json.Unmarshal(b, &sri); err != nil {
+		return err
+	}
+
+	ri.parent = nil
+
+	if len(sri.Parent) != 0 && string(sri.Parent) != "null" {
+		ins, err := UnmarshalRobotInstruction(sri.Parent)
+
+		if err != nil {
+			return err
+		}
+
+		ri.parent = ins
+	}
+
+	ri.instructions = sri.Instructions
+
+	if ri.instructions == nil {
+		ri.instructions = make([]*RobotInstructionSet, 0)
+	}
+
+	return nil
+}
+
+// conditions carry their type so we know which to make
+
+type SLHVariableCondition struct {
+	TestVariable  string
+	ConditionType string
+	Condition     json.RawMessage
+	Negate        bool
+}
+
+func (lhvc LHVariableCondition) MarshalJSON() ([]byte, error) {
+	slhvc := SLHVariableCondition{TestVariable: lhvc.TestVariable, Negate: lhvc.Negate}
+
+	if lhvc.Condition != nil {
+		b, err := json.Marshal(lhvc.Condition)
+
+		if err != nil {
+			return nil, err
+		}
+
+		slhvc.ConditionType = lhvc.Condition.Type()
+		slhvc.Condition = b
+	}
+
+	return json.Marshal(slhvc)
+}
+
+func (lhvc *LHVariableCondition) UnmarshalJSON(b []byte) error {
+	var slhvc SLHVariableCondition
+
+	if err := json.Unmarshal(b, &slhvc); err != nil {
+		return err
+	}
+
+	lhvc.TestVariable = slhvc.TestVariable
+	lhvc.Negate = slhvc.Negate
+	lhvc.Condition = nil
+
+	switch slhvc.ConditionType {
+	case "":
+	case LHCategoryCondition{}.Type():
+		var c LHCategoryCondition
+		if err := json.Unmarshal(slhvc.Condition, &c); err != nil {
+			return err
+		}
+		lhvc.Condition = c
+	case LHNumericCondition{}.Type():
+		var c LHNumericCondition
+		if err := json.Unmarshal(slhvc.Condition, &c); err != nil {
+			return err
+		}
+		lhvc.Condition = c
+	case LHSetCondition{}.Type():
+		var c LHSetCondition
+		if err := json.Unmarshal(slhvc.Condition, &c); err != nil {
+			return err
+		}
+		lhvc.Condition = c
+	case LHRegexpCondition{}.Type():
+		var c LHRegexpCondition
+		if err := json.Unmarshal(slhvc.Condition, &c); err != nil {
+			return err
+		}
+		c, err := NewLHRegexpCondition(c.Pattern)
+		if err != nil {
+			return err
+		}
+		lhvc.Condition = c
+	default:
+		return fmt.Errorf("cannot unmarshal condition of unknown type %s", slhvc.ConditionType)
+	}
+
+	return nil
+}
+
+// JSON numbers all come back as float64 but some policy items have to
+// be ints: anything the schema says is an int is made one again, as is
+// any whole number the schema doesn't know about
+func (lhp *LHPolicy) UnmarshalJSON(b []byte) error {
+	var m map[string]interface{}
+
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+
+	for k, v := range m {
+		f, ok := v.(float64)
+
+		if !ok {
+			continue
+		}
+
+		p, known := policyParameters[k]
+
+		if (!known || p.Type == "int") && f == math.Trunc(f) {
+			m[k] = int(f)
+		}
+	}
+
+	*lhp = LHPolicy(m)
+
+	return nil
+}
+
+// serializable version of LHProperties: the driver is left out and the
+// plate lookup is made again from what is on the deck
+type SLHProperties struct {
+	ID                 string
+	Nposns             int
+	Positions          map[string]*wtype.LHPosition
+	PosLookup          map[string]string
+	PlateIDLookup      map[string]string
+	Plates             map[string]*wtype.LHPlate
+	Tipboxes           map[string]*wtype.LHTipbox
+	Tipwastes          map[string]*wtype.LHTipwaste
+	Wastes             map[string]*wtype.LHPlate
+	Washes             map[string]*wtype.LHPlate
+	Devices            map[string]string
+	Model              string
+	Mnfr               string
+	LHType             string
+	TipType            string
+	Heads              []*wtype.LHHead
+	HeadsLoaded        []*wtype.LHHead
+	Adaptors           []*wtype.LHAdaptor
+	Tips               []*wtype.LHTip
+	Tip_preferences    []int
+	Input_preferences  []int
+	Output_preferences []int
+	CurrConf           *wtype.LHChannelParameter
+	Cnfvol             []*wtype.LHChannelParameter
+	Layout             map[string]wtype.Coordinates
+	TipboxLibrary      map[string]*wtype.LHTipbox
+}
+
+func (lhp *LHProperties) MarshalJSON() ([]byte, error) {
+	slhp := SLHProperties{
+		ID:                 lhp.ID,
+		Nposns:             lhp.Nposns,
+		Positions:          lhp.Positions,
+		PosLookup:          lhp.PosLookup,
+		PlateIDLookup:      lhp.PlateIDLookup,
+		Plates:             lhp.Plates,
+		Tipboxes:           lhp.Tipboxes,
+		Tipwastes:          lhp.Tipwastes,
+		Wastes:             lhp.Wastes,
+		Washes:             lhp.Washes,
+		Devices:            lhp.Devices,
+		Model:              lhp.Model,
+		Mnfr:               lhp.Mnfr,
+		LHType:             lhp.LHType,
+		TipType:            lhp.TipType,
+		Heads:              lhp.Heads,
+		HeadsLoaded:        lhp.HeadsLoaded,
+		Adaptors:           lhp.Adaptors,
+		Tips:               lhp.Tips,
+		Tip_preferences:    lhp.Tip_preferences,
+		Input_preferences:  lhp.Input_preferences,
+		Output_preferences: lhp.Output_preferences,
+		CurrConf:           lhp.CurrConf,
+		Cnfvol:             lhp.Cnfvol,
+		Layout:             lhp.Layout,
+		TipboxLibrary:      lhp.TipboxLibrary,
+	}
+
+	return json.Marshal(slhp)
+}
+
+func (lhp *LHProperties) UnmarshalJSON(b []byte) error {
+	var slhp SLHProperties
+
+	if err := json.Unmarshal(b, &slhp); err != nil {
+		return err
+	}
+
+	r := NewLHProperties(slhp.Nposns, slhp.Model, slhp.Mnfr, slhp.LHType, slhp.TipType, slhp.Layout)
+
+	r.ID = slhp.ID
+	r.Devices = slhp.Devices
+	r.Heads = slhp.Heads
+	r.HeadsLoaded = slhp.HeadsLoaded
+	r.Adaptors = slhp.Adaptors
+	r.Tips = slhp.Tips
+	r.Tip_preferences = slhp.Tip_preferences
+	r.Input_preferences = slhp.Input_preferences
+	r.Output_preferences = slhp.Output_preferences
+	r.CurrConf = slhp.CurrConf
+	r.Cnfvol = slhp.Cnfvol
+
+	for tiptype, tb := range slhp.TipboxLibrary {
+		r.TipboxLibrary[tiptype] = tb
+	}
+
+	if slhp.Positions != nil {
+		r.Positions = slhp.Positions
+	}
+
+	// everything goes back where it was
+
+	for pos, p := range slhp.Plates {
+		r.AddPlate(pos, p)
+	}
+
+	for pos, tb := range slhp.Tipboxes {
+		r.AddTipBoxTo(pos, tb)
+	}
+
+	for pos, tw := range slhp.Tipwastes {
+		r.AddTipWaste(pos, tw)
+	}
+
+	for pos, p := range slhp.Wastes {
+		r.AddWaste(pos, p)
+	}
+
+	for pos, p := range slhp.Washes {
+		r.AddWash(pos, p)
+	}
+
+	// as are lookups for things which have been taken off
+
+	for pos, id := range slhp.PosLookup {
+		if _, ok := r.PosLookup[pos]; !ok {
+			r.PosLookup[pos] = id
+		}
+	}
+
+	for id, pos := range slhp.PlateIDLookup {
+		if _, ok := r.PlateIDLookup[id]; !ok {
+			r.PlateIDLookup[id] = pos
+		}
+	}
+
+	*lhp = *r
+
+	return nil
+}
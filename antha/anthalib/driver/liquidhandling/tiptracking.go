@@ -0,0 +1,166 @@
+// /anthalib/driver/liquidhandling/tiptracking.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+// what a loaded tip has been in contact with; tips are changed
+// rather than carry anything from one well to another
+type LHTipHistory struct {
+	Touched map[string]bool // components the tip has been in
+	Source  string          // the last place aspirated from
+	Uses    int
+}
+
+func NewLHTipHistory() *LHTipHistory {
+	var th LHTipHistory
+	th.Touched = make(map[string]bool)
+	return &th
+}
+
+// whether this tip can go into the source for more of what without
+// carrying anything else into it
+func (th *LHTipHistory) CanAspirate(tt *LHTipTracker, what, plt, well string, pol LHPolicy) bool {
+	if th == nil {
+		return false
+	}
+
+	limit, ok := policy_int(pol, "TIP_REUSE_LIMIT")
+
+	if ok && th.Uses > limit {
+		return false
+	}
+
+	reuse, ok := pol["TIP_REUSE_SAME_SOURCE"].(bool)
+
+	if !ok || !reuse {
+		return false
+	}
+
+	if th.Source != wellKey(plt, well) {
+		return false
+	}
+
+	in := tt.Contents(plt, well)
+
+	for c, _ := range th.Touched {
+		if c != what && !in[c] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (th *LHTipHistory) Aspirate(tt *LHTipTracker, what, plt, well string) {
+	th.Touched[what] = true
+	for c, _ := range tt.Contents(plt, well) {
+		th.Touched[c] = true
+	}
+	th.Source = wellKey(plt, well)
+	th.Uses += 1
+}
+
+// the tip only picks up what is in the destination if it goes into it:
+// dispensing from the top of the well with no touchoff or mixing after
+// keeps it clean
+func (th *LHTipHistory) Dispense(tt *LHTipTracker, what, plt, well string, pol LHPolicy) {
+	if dispense_touches(pol) {
+		for c, _ := range tt.Contents(plt, well) {
+			th.Touched[c] = true
+		}
+	}
+	tt.AddTo(plt, well, what)
+}
+
+func dispense_touches(pol LHPolicy) bool {
+	if ref, ok := policy_int(pol, "DSPREFERENCE"); !ok || ref != 1 {
+		return true
+	}
+
+	if touchoff, ok := pol["TOUCHOFF"].(bool); ok && touchoff {
+		return true
+	}
+
+	if cycles, _ := policy_int(pol, "POST_MIX"); cycles > 0 {
+		return true
+	}
+
+	return false
+}
+
+// policies read from JSON have float64 where we'd expect ints
+func policy_int(pol LHPolicy, name string) (int, bool) {
+	switch v := pol[name].(type) {
+	case int:
+		return v, true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
+
+// and the other way round when policies are written by hand
+func policy_float(pol LHPolicy, name string) (float64, bool) {
+	switch v := pol[name].(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0.0, false
+}
+
+// keeps track of what has gone into each well while instructions are
+// generated and how many tips of each type have been taken
+type LHTipTracker struct {
+	Wells    map[string]map[string]bool
+	TipsUsed map[string]int
+}
+
+func NewLHTipTracker() *LHTipTracker {
+	var tt LHTipTracker
+	tt.Wells = make(map[string]map[string]bool)
+	tt.TipsUsed = make(map[string]int)
+	return &tt
+}
+
+func wellKey(plt, well string) string {
+	return plt + ":" + well
+}
+
+func (tt *LHTipTracker) Contents(plt, well string) map[string]bool {
+	return tt.Wells[wellKey(plt, well)]
+}
+
+func (tt *LHTipTracker) AddTo(plt, well, what string) {
+	k := wellKey(plt, well)
+	c, ok := tt.Wells[k]
+	if !ok {
+		c = make(map[string]bool)
+		tt.Wells[k] = c
+	}
+	c[what] = true
+}
+
+func (tt *LHTipTracker) UseTips(tiptype string, n int) {
+	tt.TipsUsed[tiptype] += n
+}
@@ -25,6 +25,7 @@ package liquidhandling
 import (
 	"fmt"
 	"github.com/antha-lang/antha/antha/anthalib/wtype"
+	"sort"
 	"strconv"
 )
 
@@ -59,6 +60,9 @@ type LHProperties struct {
 	CurrConf           *wtype.LHChannelParameter   // TODO: initialise
 	Cnfvol             []*wtype.LHChannelParameter // TODO: initialise
 	Layout             map[string]wtype.Coordinates
+	TipboxLibrary      map[string]*wtype.LHTipbox // boxes which can be put out when their tips are wanted
+	tiptracker         *LHTipTracker
+	expected           []float64 // volumes still to move in ul, see ExpectTransfers
 }
 
 // copy constructor
@@ -152,6 +156,10 @@ func (lhp *LHProperties) Dup() *LHProperties {
 		r.Layout[i] = v
 	}
 
+	for tiptype, tb := range lhp.TipboxLibrary {
+		r.TipboxLibrary[tiptype] = tb.Dup()
+	}
+
 	return r
 }
 
@@ -191,6 +199,7 @@ func NewLHProperties(num_positions int, model, manufacturer, lhtype, tiptype str
 	lhp.Devices = make(map[string]string, lhp.Nposns)
 	lhp.Heads = make([]*wtype.LHHead, 0, 2)
 	lhp.Tips = make([]*wtype.LHTip, 0, 3)
+	lhp.TipboxLibrary = make(map[string]*wtype.LHTipbox)
 
 	lhp.Layout = layout
 
@@ -212,6 +221,15 @@ func (lhp *LHProperties) AddTipBox(tipbox *wtype.LHTipbox) {
 
 	panic("NO TIP SPACES LEFT")
 }
+func (lhp *LHProperties) tipboxSpaceLeft() bool {
+	for _, pref := range lhp.Tip_preferences {
+		if lhp.PosLookup[fmt.Sprintf("position_%d", pref)] == "" {
+			return true
+		}
+	}
+	return false
+}
+
 func (lhp *LHProperties) AddTipBoxTo(pos string, tipbox *wtype.LHTipbox) {
 	if lhp.PosLookup[pos] != "" {
 		panic("CAN'T ADD TIPBOX TO FULL POSITION")
@@ -272,58 +290,141 @@ func (lhp *LHProperties) AddWash(pos string, wash *wtype.LHPlate) {
 	lhp.PlateIDLookup[wash.ID] = pos
 }
 
+// what has been done with tips since the last reset
+func (lhp *LHProperties) TipTracking() *LHTipTracker {
+	if lhp.tiptracker == nil {
+		lhp.tiptracker = NewLHTipTracker()
+	}
+	return lhp.tiptracker
+}
+
+func (lhp *LHProperties) ResetTipTracking() {
+	lhp.tiptracker = NewLHTipTracker()
+}
+
 func (lhp *LHProperties) GetCleanTips(tiptype string, channel *wtype.LHChannelParameter, mirror bool, multi int) (wells, positions, boxtypes []string) {
 	positions = make([]string, multi)
 	boxtypes = make([]string, multi)
 
-	foundit := false
+	var found, template *wtype.LHTipbox
+	foundpos := ""
 
-	for pos, bx := range lhp.Tipboxes {
+	for _, pos := range lhp.tipboxPositions() {
+		bx := lhp.Tipboxes[pos]
 		if bx.Tiptype.Type != tiptype {
 			continue
 		}
+		template = bx
 		wells = bx.GetTips(mirror, multi, channel.Orientation)
 		if wells != nil {
-			foundit = true
-			for i := 0; i < multi; i++ {
-				positions[i] = pos
-				boxtypes[i] = bx.Type
-			}
+			found = bx
+			foundpos = pos
 			break
 		}
 	}
 
-	if !foundit {
+	// when the boxes on deck can't supply what we need another one goes
+	// out, as long as there's room for it
+
+	if template == nil {
+		template = lhp.TipboxLibrary[tiptype]
+	}
+
+	if found == nil && template != nil && lhp.tipboxSpaceLeft() {
+		bx := template.Dup()
+		lhp.AddTipBox(bx)
+		wells = bx.GetTips(mirror, multi, channel.Orientation)
+		if wells != nil {
+			found = bx
+			foundpos = lhp.PlateIDLookup[bx.ID]
+		}
+	}
+
+	if found == nil {
 		return nil, nil, nil
 	}
 
+	for i := 0; i < multi; i++ {
+		positions[i] = foundpos
+		boxtypes[i] = found.Type
+	}
+
 	return
 }
 
-func (lhp *LHProperties) DropDirtyTips(channel *wtype.LHChannelParameter, multi int) (wells, positions, boxtypes []string) {
+// emptied is true if a tip waste was full and had to be emptied
+// before the tips could go in
+func (lhp *LHProperties) DropDirtyTips(channel *wtype.LHChannelParameter, multi int) (wells, positions, boxtypes []string, emptied bool) {
 	wells = make([]string, multi)
 	positions = make([]string, multi)
 	boxtypes = make([]string, multi)
 
-	foundit := false
+	wastepositions := lhp.tipwastePositions()
 
-	for pos, bx := range lhp.Tipwastes {
-		yes := bx.Dispose(multi)
-		if yes {
-			foundit = true
-			for i := 0; i < multi; i++ {
-				wells[i] = "A1"
-				positions[i] = pos
-				boxtypes[i] = bx.Type
-			}
+	if len(wastepositions) == 0 {
+		return nil, nil, nil, false
+	}
+
+	foundpos := ""
 
+	for _, pos := range wastepositions {
+		if lhp.Tipwastes[pos].Dispose(multi) {
+			foundpos = pos
 			break
 		}
 	}
 
-	if !foundit {
-		return nil, nil, nil
+	// everything is full: the first one gets emptied
+
+	if foundpos == "" {
+		pos := wastepositions[0]
+		lhp.Tipwastes[pos].Empty()
+		if !lhp.Tipwastes[pos].Dispose(multi) {
+			return nil, nil, nil, false
+		}
+		foundpos = pos
+		emptied = true
+	}
+
+	for i := 0; i < multi; i++ {
+		wells[i] = "A1"
+		positions[i] = foundpos
+		boxtypes[i] = lhp.Tipwastes[foundpos].Type
 	}
 
 	return
 }
+
+// tips are taken from boxes in the order they were set out in the
+// preferences, anything else comes after in position order
+func (lhp *LHProperties) tipboxPositions() []string {
+	positions := make([]string, 0, len(lhp.Tipboxes))
+	seen := make(map[string]bool, len(lhp.Tipboxes))
+
+	for _, pref := range lhp.Tip_preferences {
+		pos := fmt.Sprintf("position_%d", pref)
+		if _, ok := lhp.Tipboxes[pos]; ok && !seen[pos] {
+			positions = append(positions, pos)
+			seen[pos] = true
+		}
+	}
+
+	rest := make([]string, 0, len(lhp.Tipboxes))
+	for pos, _ := range lhp.Tipboxes {
+		if !seen[pos] {
+			rest = append(rest, pos)
+		}
+	}
+	sort.Strings(rest)
+
+	return append(positions, rest...)
+}
+
+func (lhp *LHProperties) tipwastePositions() []string {
+	positions := make([]string, 0, len(lhp.Tipwastes))
+	for pos, _ := range lhp.Tipwastes {
+		positions = append(positions, pos)
+	}
+	sort.Strings(positions)
+	return positions
+}
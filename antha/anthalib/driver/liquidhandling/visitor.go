@@ -0,0 +1,184 @@
+// /anthalib/driver/liquidhandling/visitor.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+// a visitor has a method for every kind of instruction so code which
+// has to deal with them all can do so with the types checked rather
+// than by asking GetParameter; VisitOther gets anything else
+type RobotInstructionVisitor interface {
+	VisitTransfer(*TransferInstruction)
+	VisitSingleChannelBlock(*SingleChannelBlockInstruction)
+	VisitMultiChannelBlock(*MultiChannelBlockInstruction)
+	VisitSingleChannelTransfer(*SingleChannelTransferInstruction)
+	VisitMultiChannelTransfer(*MultiChannelTransferInstruction)
+	VisitStateChange(*StateChangeInstruction)
+	VisitLoadTipsMove(*LoadTipsMoveInstruction)
+	VisitUnloadTipsMove(*UnloadTipsMoveInstruction)
+	VisitReset(*ResetInstruction)
+	VisitChangeAdaptor(*ChangeAdaptorInstruction)
+	VisitAspirate(*AspirateInstruction)
+	VisitDispense(*DispenseInstruction)
+	VisitBlowout(*BlowoutInstruction)
+	VisitPTZ(*PTZInstruction)
+	VisitMove(*MoveInstruction)
+	VisitMoveRaw(*MoveRawInstruction)
+	VisitLoadTips(*LoadTipsInstruction)
+	VisitUnloadTips(*UnloadTipsInstruction)
+	VisitSuck(*SuckInstruction)
+	VisitBlow(*BlowInstruction)
+	VisitSetPipetteSpeed(*SetPipetteSpeedInstruction)
+	VisitSetDriveSpeed(*SetDriveSpeedInstruction)
+	VisitInitialize(*InitializeInstruction)
+	VisitFinalize(*FinalizeInstruction)
+	VisitWait(*WaitInstruction)
+	VisitLightsOn(*LightsOnInstruction)
+	VisitLightsOff(*LightsOffInstruction)
+	VisitOpen(*OpenInstruction)
+	VisitClose(*CloseInstruction)
+	VisitLoadAdaptor(*LoadAdaptorInstruction)
+	VisitUnloadAdaptor(*UnloadAdaptorInstruction)
+	VisitMoveMix(*MoveMixInstruction)
+	VisitMix(*MixInstruction)
+	VisitEmptyTipwaste(*EmptyTipwasteInstruction)
+	VisitMultiDispense(*MultiDispenseInstruction)
+	VisitOther(RobotInstruction)
+}
+
+// embed this to only have to write the methods for the instructions
+// you are interested in
+type RobotInstructionBaseVisitor struct{}
+
+func (RobotInstructionBaseVisitor) VisitTransfer(*TransferInstruction)                           {}
+func (RobotInstructionBaseVisitor) VisitSingleChannelBlock(*SingleChannelBlockInstruction)       {}
+func (RobotInstructionBaseVisitor) VisitMultiChannelBlock(*MultiChannelBlockInstruction)         {}
+func (RobotInstructionBaseVisitor) VisitSingleChannelTransfer(*SingleChannelTransferInstruction) {}
+func (RobotInstructionBaseVisitor) VisitMultiChannelTransfer(*MultiChannelTransferInstruction)   {}
+func (RobotInstructionBaseVisitor) VisitStateChange(*StateChangeInstruction)                     {}
+func (RobotInstructionBaseVisitor) VisitLoadTipsMove(*LoadTipsMoveInstruction)                   {}
+func (RobotInstructionBaseVisitor) VisitUnloadTipsMove(*UnloadTipsMoveInstruction)               {}
+func (RobotInstructionBaseVisitor) VisitReset(*ResetInstruction)                                 {}
+func (RobotInstructionBaseVisitor) VisitChangeAdaptor(*ChangeAdaptorInstruction)                 {}
+func (RobotInstructionBaseVisitor) VisitAspirate(*AspirateInstruction)                           {}
+func (RobotInstructionBaseVisitor) VisitDispense(*DispenseInstruction)                           {}
+func (RobotInstructionBaseVisitor) VisitBlowout(*BlowoutInstruction)                             {}
+func (RobotInstructionBaseVisitor) VisitPTZ(*PTZInstruction)                                     {}
+func (RobotInstructionBaseVisitor) VisitMove(*MoveInstruction)                                   {}
+func (RobotInstructionBaseVisitor) VisitMoveRaw(*MoveRawInstruction)                             {}
+func (RobotInstructionBaseVisitor) VisitLoadTips(*LoadTipsInstruction)                           {}
+func (RobotInstructionBaseVisitor) VisitUnloadTips(*UnloadTipsInstruction)                       {}
+func (RobotInstructionBaseVisitor) VisitSuck(*SuckInstruction)                                   {}
+func (RobotInstructionBaseVisitor) VisitBlow(*BlowInstruction)                                   {}
+func (RobotInstructionBaseVisitor) VisitSetPipetteSpeed(*SetPipetteSpeedInstruction)             {}
+func (RobotInstructionBaseVisitor) VisitSetDriveSpeed(*SetDriveSpeedInstruction)                 {}
+func (RobotInstructionBaseVisitor) VisitInitialize(*InitializeInstruction)                       {}
+func (RobotInstructionBaseVisitor) VisitFinalize(*FinalizeInstruction)                           {}
+func (RobotInstructionBaseVisitor) VisitWait(*WaitInstruction)                                   {}
+func (RobotInstructionBaseVisitor) VisitLightsOn(*LightsOnInstruction)                           {}
+func (RobotInstructionBaseVisitor) VisitLightsOff(*LightsOffInstruction)                         {}
+func (RobotInstructionBaseVisitor) VisitOpen(*OpenInstruction)                                   {}
+func (RobotInstructionBaseVisitor) VisitClose(*CloseInstruction)                                 {}
+func (RobotInstructionBaseVisitor) VisitLoadAdaptor(*LoadAdaptorInstruction)                     {}
+func (RobotInstructionBaseVisitor) VisitUnloadAdaptor(*UnloadAdaptorInstruction)                 {}
+func (RobotInstructionBaseVisitor) VisitMoveMix(*MoveMixInstruction)                             {}
+func (RobotInstructionBaseVisitor) VisitMix(*MixInstruction)                                     {}
+func (RobotInstructionBaseVisitor) VisitEmptyTipwaste(*EmptyTipwasteInstruction)                 {}
+func (RobotInstructionBaseVisitor) VisitMultiDispense(*MultiDispenseInstruction)                 {}
+func (RobotInstructionBaseVisitor) VisitOther(RobotInstruction)                                  {}
+
+// calls whichever method of v matches ins
+func VisitRobotInstruction(ins RobotInstruction, v RobotInstructionVisitor) {
+	switch i := ins.(type) {
+	case *TransferInstruction:
+		v.VisitTransfer(i)
+	case *SingleChannelBlockInstruction:
+		v.VisitSingleChannelBlock(i)
+	case *MultiChannelBlockInstruction:
+		v.VisitMultiChannelBlock(i)
+	case *SingleChannelTransferInstruction:
+		v.VisitSingleChannelTransfer(i)
+	case *MultiChannelTransferInstruction:
+		v.VisitMultiChannelTransfer(i)
+	case *StateChangeInstruction:
+		v.VisitStateChange(i)
+	case *LoadTipsMoveInstruction:
+		v.VisitLoadTipsMove(i)
+	case *UnloadTipsMoveInstruction:
+		v.VisitUnloadTipsMove(i)
+	case *ResetInstruction:
+		v.VisitReset(i)
+	case *ChangeAdaptorInstruction:
+		v.VisitChangeAdaptor(i)
+	case *AspirateInstruction:
+		v.VisitAspirate(i)
+	case *DispenseInstruction:
+		v.VisitDispense(i)
+	case *BlowoutInstruction:
+		v.VisitBlowout(i)
+	case *PTZInstruction:
+		v.VisitPTZ(i)
+	case *MoveInstruction:
+		v.VisitMove(i)
+	case *MoveRawInstruction:
+		v.VisitMoveRaw(i)
+	case *LoadTipsInstruction:
+		v.VisitLoadTips(i)
+	case *UnloadTipsInstruction:
+		v.VisitUnloadTips(i)
+	case *SuckInstruction:
+		v.VisitSuck(i)
+	case *BlowInstruction:
+		v.VisitBlow(i)
+	case *SetPipetteSpeedInstruction:
+		v.VisitSetPipetteSpeed(i)
+	case *SetDriveSpeedInstruction:
+		v.VisitSetDriveSpeed(i)
+	case *InitializeInstruction:
+		v.VisitInitialize(i)
+	case *FinalizeInstruction:
+		v.VisitFinalize(i)
+	case *WaitInstruction:
+		v.VisitWait(i)
+	case *LightsOnInstruction:
+		v.VisitLightsOn(i)
+	case *LightsOffInstruction:
+		v.VisitLightsOff(i)
+	case *OpenInstruction:
+		v.VisitOpen(i)
+	case *CloseInstruction:
+		v.VisitClose(i)
+	case *LoadAdaptorInstruction:
+		v.VisitLoadAdaptor(i)
+	case *UnloadAdaptorInstruction:
+		v.VisitUnloadAdaptor(i)
+	case *MoveMixInstruction:
+		v.VisitMoveMix(i)
+	case *MixInstruction:
+		v.VisitMix(i)
+	case *EmptyTipwasteInstruction:
+		v.VisitEmptyTipwaste(i)
+	case *MultiDispenseInstruction:
+		v.VisitMultiDispense(i)
+	default:
+		v.VisitOther(ins)
+	}
+}
@@ -0,0 +1,64 @@
+// /anthalib/driver/liquidhandling/visitor_test.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/antha-lang/antha/antha/anthalib/driver/liquidhandling"
+)
+
+// remembers anything it has no method for
+type otherVisitor struct {
+	liquidhandling.RobotInstructionBaseVisitor
+	other []liquidhandling.RobotInstruction
+}
+
+func (ov *otherVisitor) VisitOther(ins liquidhandling.RobotInstruction) {
+	ov.other = append(ov.other, ins)
+}
+
+func TestInstructionVisitor(t *testing.T) {
+	// every kind of instruction there is has its own method
+
+	for _, name := range liquidhandling.Robotinstructionnames {
+		if name == "CTF" {
+			// there is no such instruction yet
+			continue
+		}
+
+		ins, err := liquidhandling.UnmarshalRobotInstruction([]byte(fmt.Sprintf(`{"Type":%q,"Instruction":{}}`, name)))
+
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		ov := &otherVisitor{}
+		liquidhandling.VisitRobotInstruction(ins, ov)
+
+		if len(ov.other) != 0 {
+			t.Errorf("%s should have a method of its own", name)
+		}
+	}
+}
@@ -60,3 +60,25 @@ func ExampleThree() {
 	// Output
 	// there
 }
+
+func TestWrapperError(t *testing.T) {
+	w := NewWrapper("test")
+	failed := false
+
+	w.SetError(&failed)
+
+	if failed || w.Err() != nil {
+		t.Errorf("a wrapper which has done nothing has not failed")
+	}
+
+	w.fail(fmt.Errorf("no liquid handler"))
+
+	// nothing more gets sent once something has gone wrong, so this
+	// doesn't need a liquid handler
+	w.Mix(wtype.NewLHComponent())
+	w.SetError(&failed)
+
+	if !failed || w.Err().Error() != "no liquid handler" {
+		t.Errorf("a failed mix should fail the results, got %v", w.Err())
+	}
+}
@@ -23,6 +23,7 @@
 package execution
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	lhdriver "github.com/antha-lang/antha/antha/anthalib/driver/liquidhandling"
@@ -30,6 +31,10 @@ import (
 	"github.com/antha-lang/antha/antha/anthalib/liquidhandling"
 	"github.com/antha-lang/antha/antha/anthalib/wtype"
 	"github.com/antha-lang/antha/antha/execute"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 )
 
@@ -58,6 +63,8 @@ func initRequest(rq *liquidhandling.LHRequest, id execute.ThreadID) *liquidhandl
 	rq.BlockID = string(id)
 	rq.Input_Setup_Weights = plateInitWeights(id)
 	rq.Policies = initLHPolicies()
+	rq.Loss_model = initLossModel(id)
+	rq.Optimise_instructions = optimiseInstructions(id)
 	initDriverConfig(rq, id)
 
 	return rq
@@ -68,13 +75,144 @@ func initDriverConfig(rq *liquidhandling.LHRequest, id execute.ThreadID) {
 	cfg := ctx.ConfigService.GetConfig(id)
 	rq.Robotfn = cfg["SQLITE_FILE_IN"].(string)
 	rq.Outputfn = cfg["SQLITE_FILE_OUT"].(string)
+	// optional: where to save progress if the robot stops part way, if
+	// there is one there already the next run carries on from it; blocks
+	// share it so it is only any use with one liquid handling block
+	rq.Checkpointfn, _ = cfg["CHECKPOINT_FILE"].(string)
+}
+
+// losses are optional: LOSS_MODEL in the config has the same layout
+// as liquidhandling.LHLossModel
+func initLossModel(id execute.ThreadID) *liquidhandling.LHLossModel {
+	lm := liquidhandling.NewLHLossModel()
+	ctx := GetContext()
+	cfg := ctx.ConfigService.GetConfig(id)
+
+	lmcfg, ok := cfg["LOSS_MODEL"]
+
+	if !ok {
+		return lm
+	}
+
+	b, err := json.Marshal(lmcfg)
+
+	if err == nil {
+		err = json.Unmarshal(b, lm)
+	}
+
+	if err != nil {
+		panic(fmt.Sprintf("LiquidHandlingService: cannot read LOSS_MODEL: %s", err.Error()))
+	}
+
+	return lm
 }
 
 func initLHPolicies() *lhdriver.LHPolicyRuleSet {
 	pol := lhdriver.GetLHPolicyForTest()
+	pol.Source = "system"
 	return pol
 }
 
+// DECK_STATE_FILE_IN names a deck state saved by a previous run to
+// start from, DECK_STATE_FILE_OUT where to save the state we leave
+func deckStateFiles(id execute.ThreadID) (in, out string) {
+	ctx := GetContext()
+	cfg := ctx.ConfigService.GetConfig(id)
+	in, _ = cfg["DECK_STATE_FILE_IN"].(string)
+	out, _ = cfg["DECK_STATE_FILE_OUT"].(string)
+	return
+}
+
+// EXPORT_FORMATS lists the formats each block's instructions are
+// exported in, either as a list or separated by commas; files are
+// named by block and go in EXPORT_DIR
+func exportFormats(id execute.ThreadID) (formats []string, dir string) {
+	ctx := GetContext()
+	cfg := ctx.ConfigService.GetConfig(id)
+
+	switch f := cfg["EXPORT_FORMATS"].(type) {
+	case string:
+		for _, s := range strings.Split(f, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				formats = append(formats, s)
+			}
+		}
+	case []string:
+		formats = f
+	case []interface{}:
+		for _, s := range f {
+			formats = append(formats, fmt.Sprint(s))
+		}
+	}
+
+	dir, _ = cfg["EXPORT_DIR"].(string)
+
+	return
+}
+
+func exportRequest(liquidhandler *liquidhandling.Liquidhandler, rq *liquidhandling.LHRequest, id execute.ThreadID) error {
+	formats, dir := exportFormats(id)
+
+	for _, format := range formats {
+		exp, ok := liquidhandling.GetLHExporter(format)
+
+		if !ok {
+			return errors.New(fmt.Sprintf("no exporter for format %s, have %s", format, strings.Join(liquidhandling.LHExporterNames(), ", ")))
+		}
+
+		fn := filepath.Join(dir, string(id)+"."+exp.Extension)
+
+		if err := liquidhandler.ExportFile(rq, format, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// OPTIMISE_INSTRUCTIONS false in the config leaves the instructions
+// exactly as they are generated
+func optimiseInstructions(id execute.ThreadID) bool {
+	ctx := GetContext()
+	cfg := ctx.ConfigService.GetConfig(id)
+	opt, ok := cfg["OPTIMISE_INSTRUCTIONS"].(bool)
+	return !ok || opt
+}
+
+// ESTIMATE_ONLY in the config asks for plans to be estimated rather than run
+func estimateOnly(id execute.ThreadID) bool {
+	ctx := GetContext()
+	cfg := ctx.ConfigService.GetConfig(id)
+	est, ok := cfg["ESTIMATE_ONLY"].(bool)
+	return ok && est
+}
+
+// ON_FAILURE says what to do when the robot reports an instruction
+// failed: "abort", the default, stops leaving a checkpoint for the next
+// run to carry on from, "skip" gives up on the transfer and "ask" asks
+// on the terminal whether to retry, skip or abort
+func recoveryAgent(id execute.ThreadID) func(*liquidhandling.LHRequest, *liquidhandling.LHCheckpoint) liquidhandling.LHRecoveryAction {
+	ctx := GetContext()
+	cfg := ctx.ConfigService.GetConfig(id)
+	onfailure, _ := cfg["ON_FAILURE"].(string)
+
+	action := liquidhandling.LHAbort
+
+	switch onfailure {
+	case "ask":
+		return liquidhandling.PromptRecoveryAgent(os.Stdin, os.Stdout)
+	case "skip":
+		action = liquidhandling.LHSkip
+	case "", "abort":
+	default:
+		panic(fmt.Sprintf("LiquidHandlingService: ON_FAILURE must be abort, skip or ask, not %s", onfailure))
+	}
+
+	return func(*liquidhandling.LHRequest, *liquidhandling.LHCheckpoint) liquidhandling.LHRecoveryAction {
+		return action
+	}
+}
+
 func plateInitWeights(id execute.ThreadID) map[string]float64 {
 	ret := make(map[string]float64, 3)
 	ctx := GetContext()
@@ -175,14 +313,80 @@ func (lhs *LiquidHandlingService) Run() error {
 	lhs.lock.Lock()
 	defer lhs.lock.Unlock()
 
-	for _, rq := range lhs.RequestQueue {
+	// the queue is cleared however the run ends
+
+	defer func() {
+		lhs.RequestQueue = make(map[execute.ThreadID]*liquidhandling.LHRequest)
+	}()
+
+	// blocks are run in ID order so the same workflow always comes out the same
+
+	ids := make([]string, 0, len(lhs.RequestQueue))
+	for id, _ := range lhs.RequestQueue {
+		ids = append(ids, string(id))
+	}
+	sort.Strings(ids)
+
+	// each block starts with the tips the one before left
+
+	var deckstate *liquidhandling.LHDeckState
+	deckstateout := ""
+
+	for i, id := range ids {
+		rq := lhs.RequestQueue[execute.ThreadID(id)]
+
+		in, out := deckStateFiles(execute.ThreadID(id))
+
+		if i == 0 && in != "" {
+			ds, err := liquidhandling.LoadDeckState(in)
+			if err != nil {
+				return fmt.Errorf("LiquidHandlingService: cannot read deck state %s: %s", in, err)
+			}
+			deckstate = ds
+		}
+
+		if out != "" {
+			deckstateout = out
+		}
+
+		rq.Deck_state = deckstate
+
 		// each block gets executed separately
 		liquidhandler := liquidhandling.Init(lhs.Properties)
-		liquidhandler.MakeSolutions(rq)
+		liquidhandler.RecoveryAgent = recoveryAgent(execute.ThreadID(id))
+
+		// if all we want is an estimate we just plan and report
+
+		if estimateOnly(execute.ThreadID(id)) {
+			if err := liquidhandler.Plan(rq); err != nil {
+				return fmt.Errorf("LiquidHandlingService: cannot plan %s: %s", id, err)
+			}
+			fmt.Printf("Estimate for %s\n%s", id, liquidhandler.Estimate(rq).String())
+			if err := exportRequest(liquidhandler, rq, execute.ThreadID(id)); err != nil {
+				return fmt.Errorf("LiquidHandlingService: cannot export %s: %s", id, err)
+			}
+			deckstate = rq.Final_deck_state
+			continue
+		}
+
+		_, err := liquidhandler.MakeSolutions(rq)
+
+		if err != nil {
+			return fmt.Errorf("LiquidHandlingService: cannot make solutions for %s: %s", id, err)
+		}
+
+		if err := exportRequest(liquidhandler, rq, execute.ThreadID(id)); err != nil {
+			return fmt.Errorf("LiquidHandlingService: cannot export %s: %s", id, err)
+		}
+
+		deckstate = rq.Final_deck_state
 	}
 
-	// clear the queue
+	if deckstateout != "" && deckstate != nil {
+		if err := deckstate.Save(deckstateout); err != nil {
+			return fmt.Errorf("LiquidHandlingService: cannot save deck state %s: %s", deckstateout, err)
+		}
+	}
 
-	lhs.RequestQueue = make(map[execute.ThreadID]*liquidhandling.LHRequest)
 	return nil
 }
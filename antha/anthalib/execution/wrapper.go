@@ -24,6 +24,7 @@
 package execution
 
 import (
+	"errors"
 	"fmt"
 	"github.com/antha-lang/antha/antha/anthalib/factory"
 	"github.com/antha-lang/antha/antha/anthalib/mixer"
@@ -34,6 +35,7 @@ import (
 )
 
 type Wrapper struct {
+	err           error
 	usedMix       bool
 	usedIncubate  bool
 	liquidHandler *LiquidHandlingService
@@ -53,6 +55,25 @@ func (w *Wrapper) Incubate(what *wtype.LHSolution, temp wunit.Temperature, time
 }
 
 func (w *Wrapper) MixInto(outplate *wtype.LHPlate, components ...*wtype.LHComponent) *wtype.LHSolution {
+	reaction := mixer.MixInto(outplate, components...)
+	w.run(reaction)
+	return reaction
+}
+
+func (w *Wrapper) Mix(components ...*wtype.LHComponent) *wtype.LHSolution {
+	reaction := mixer.Mix(components...)
+	w.run(reaction)
+	return reaction
+}
+
+// sends a reaction to the liquid handler; once anything has gone wrong
+// nothing more is sent and the element's results are errors, see
+// SetError
+func (w *Wrapper) run(reaction *wtype.LHSolution) {
+	if w.err != nil {
+		return
+	}
+
 	if !w.usedMix {
 		ctx := GetContext()
 		em := ctx.EquipmentManager
@@ -68,48 +89,41 @@ func (w *Wrapper) MixInto(outplate *wtype.LHPlate, components ...*wtype.LHCompon
 		rqOut := em.MakeDeviceRequest("liquidhandler", "Manual")
 		response := <-rqOut
 		if response["status"] == "FAIL" {
-			log.Fatal("Error requesting liquid handler service")
+			w.fail(errors.New("Error requesting liquid handler service"))
+			return
 		}
 		w.liquidHandler = response["devicequeue"].(*LiquidHandlingService)
 		w.usedMix = true
 	}
 
-	reaction := mixer.MixInto(outplate, components...)
 	reaction.BlockID = string(w.threadID)
 
 	req := w.liquidHandler.MakeMixRequest(reaction)
 	if req == nil {
-		log.Fatal("Error running liquid handling request")
+		w.fail(errors.New("Error running liquid handling request"))
+		return
 	}
 	req.Tip_Type = w.tipType
-	w.liquidHandler.Run()
-
-	return reaction
+	if err := w.liquidHandler.Run(); err != nil {
+		w.fail(err)
+	}
 }
 
-func (w *Wrapper) Mix(components ...*wtype.LHComponent) *wtype.LHSolution {
-
-	if !w.usedMix {
-		ctx := GetContext()
-		em := ctx.EquipmentManager
-		rqOut := em.MakeDeviceRequest("liquidhandler", "Manual")
-		response := <-rqOut
-		if response["status"] == "FAIL" {
-			log.Fatal("Error requesting liquid handler service")
-		}
-		w.liquidHandler = response["devicequeue"].(*LiquidHandlingService)
-		w.usedMix = true
-	}
+func (w *Wrapper) fail(err error) {
+	log.Println(err)
+	w.err = err
+}
 
-	reaction := mixer.Mix( components...)
-	reaction.BlockID = string(w.threadID)
+// whatever went wrong with the first mix which failed, if any did
+func (w *Wrapper) Err() error {
+	return w.err
+}
 
-	req := w.liquidHandler.MakeMixRequest(reaction)
-	if req == nil {
-		log.Fatal("Error running liquid handling request")
+// marks a result block as failed if anything the wrapper was asked to
+// do went wrong; compiled elements defer this in their steps, analysis
+// and validation
+func (w *Wrapper) SetError(failed *bool) {
+	if w.err != nil {
+		*failed = true
 	}
-	req.Tip_Type = w.tipType
-	w.liquidHandler.Run()
-
-	return reaction
 }
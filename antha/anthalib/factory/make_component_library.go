@@ -24,6 +24,7 @@ package factory
 
 import (
 	"github.com/antha-lang/antha/antha/anthalib/wtype"
+	"sort"
 )
 
 func makeComponentLibrary() map[string]*wtype.LHComponent {
@@ -118,6 +119,9 @@ func GetComponentList() []string {
 		kz[x] = name
 		x += 1
 	}
+
+	sort.Strings(kz)
+
 	return kz
 
 }
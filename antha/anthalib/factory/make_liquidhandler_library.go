@@ -66,49 +66,49 @@ func makeManual() *liquidhandling.LHProperties {
 	lhp.Input_preferences = []int{7, 8, 9, 10, 11, 12, 13, 14, 15}
 	lhp.Output_preferences = []int{16, 17, 18, 19, 20, 21, 22, 23, 24, 25}
 
-	minvol := wunit.NewVolume(200, "ul")
-	maxvol := wunit.NewVolume(1000, "ul")
-	minspd := wunit.NewFlowRate(0.5, "ml/min")
-	maxspd := wunit.NewFlowRate(2, "ml/min")
+	hvminvol := wunit.NewVolume(200, "ul")
+	hvmaxvol := wunit.NewVolume(1000, "ul")
+	hvminspd := wunit.NewFlowRate(0.5, "ml/min")
+	hvmaxspd := wunit.NewFlowRate(2, "ml/min")
 
-	hvconfig := wtype.NewLHChannelParameter("P1000Config", &minvol, &maxvol, &minspd, &maxspd, 1, false, wtype.LHVChannel, 0)
+	hvconfig := wtype.NewLHChannelParameter("P1000Config", &hvminvol, &hvmaxvol, &hvminspd, &hvmaxspd, 1, false, wtype.LHVChannel, 0)
 	hvadaptor := wtype.NewLHAdaptor("P1000", "Gilson", hvconfig)
 
-	minvol = wunit.NewVolume(50, "ul")
-	maxvol = wunit.NewVolume(200, "ul")
-	minspd = wunit.NewFlowRate(0.1, "ml/min")
-	maxspd = wunit.NewFlowRate(0.5, "ml/min")
+	mvminvol := wunit.NewVolume(50, "ul")
+	mvmaxvol := wunit.NewVolume(200, "ul")
+	mvminspd := wunit.NewFlowRate(0.1, "ml/min")
+	mvmaxspd := wunit.NewFlowRate(0.5, "ml/min")
 
-	mvconfig := wtype.NewLHChannelParameter("P200Config", &minvol, &maxvol, &minspd, &maxspd, 1, false, wtype.LHVChannel, 0)
+	mvconfig := wtype.NewLHChannelParameter("P200Config", &mvminvol, &mvmaxvol, &mvminspd, &mvmaxspd, 1, false, wtype.LHVChannel, 0)
 	mvadaptor := wtype.NewLHAdaptor("P200", "Gilson", mvconfig)
 
-	minvol = wunit.NewVolume(2, "ul")
-	maxvol = wunit.NewVolume(20, "ul")
-	minspd = wunit.NewFlowRate(0.1, "ml/min")
-	maxspd = wunit.NewFlowRate(0.5, "ml/min")
+	lmvminvol := wunit.NewVolume(2, "ul")
+	lmvmaxvol := wunit.NewVolume(20, "ul")
+	lmvminspd := wunit.NewFlowRate(0.1, "ml/min")
+	lmvmaxspd := wunit.NewFlowRate(0.5, "ml/min")
 
-	lmvconfig := wtype.NewLHChannelParameter("P20Config", &minvol, &maxvol, &minspd, &maxspd, 1, false, wtype.LHVChannel, 0)
+	lmvconfig := wtype.NewLHChannelParameter("P20Config", &lmvminvol, &lmvmaxvol, &lmvminspd, &lmvmaxspd, 1, false, wtype.LHVChannel, 0)
 	lmvadaptor := wtype.NewLHAdaptor("P20", "Gilson", lmvconfig)
 
-	minvol = wunit.NewVolume(1, "ul")
-	maxvol = wunit.NewVolume(10, "ul")
-	minspd = wunit.NewFlowRate(0.1, "ml/min")
-	maxspd = wunit.NewFlowRate(0.5, "ml/min")
+	lvminvol := wunit.NewVolume(1, "ul")
+	lvmaxvol := wunit.NewVolume(10, "ul")
+	lvminspd := wunit.NewFlowRate(0.1, "ml/min")
+	lvmaxspd := wunit.NewFlowRate(0.5, "ml/min")
 
-	lvconfig := wtype.NewLHChannelParameter("P10Config", &minvol, &maxvol, &minspd, &maxspd, 1, false, wtype.LHVChannel, 0)
+	lvconfig := wtype.NewLHChannelParameter("P10Config", &lvminvol, &lvmaxvol, &lvminspd, &lvmaxspd, 1, false, wtype.LHVChannel, 0)
 	lvadaptor := wtype.NewLHAdaptor("P10", "Gilson", lvconfig)
 
-	minvol = wunit.NewVolume(0.2, "ul")
-	maxvol = wunit.NewVolume(2, "ul")
-	minspd = wunit.NewFlowRate(0.1, "ml/min")
-	maxspd = wunit.NewFlowRate(0.5, "ml/min")
+	vlvminvol := wunit.NewVolume(0.2, "ul")
+	vlvmaxvol := wunit.NewVolume(2, "ul")
+	vlvminspd := wunit.NewFlowRate(0.1, "ml/min")
+	vlvmaxspd := wunit.NewFlowRate(0.5, "ml/min")
 
-	vlvconfig := wtype.NewLHChannelParameter("P2Config", &minvol, &maxvol, &minspd, &maxspd, 1, false, wtype.LHVChannel, 0)
+	vlvconfig := wtype.NewLHChannelParameter("P2Config", &vlvminvol, &vlvmaxvol, &vlvminspd, &vlvmaxspd, 1, false, wtype.LHVChannel, 0)
 	vlvadaptor := wtype.NewLHAdaptor("P2", "Gilson", vlvconfig)
 
-	minvol = wunit.NewVolume(0.2, "ul")
-	maxvol = wunit.NewVolume(5000, "ul")
-	headparams := wtype.NewLHChannelParameter("LabHand", &minvol, &maxvol, &minspd, &maxspd, 8, false, wtype.LHVChannel, 0)
+	headminvol := wunit.NewVolume(0.2, "ul")
+	headmaxvol := wunit.NewVolume(5000, "ul")
+	headparams := wtype.NewLHChannelParameter("LabHand", &headminvol, &headmaxvol, &vlvminspd, &vlvmaxspd, 8, false, wtype.LHVChannel, 0)
 	head := wtype.NewLHHead("LabHand", "MotherNature", headparams)
 	head.Adaptor = hvadaptor
 
@@ -145,26 +145,26 @@ func makeCyBio() *liquidhandling.LHProperties {
 	lhp.Input_preferences = []int{10, 11, 12}
 	lhp.Output_preferences = []int{7, 8, 9, 2, 4}
 
-	minvol := wunit.NewVolume(10, "ul")
-	maxvol := wunit.NewVolume(1000, "ul")
-	minspd := wunit.NewFlowRate(0.5, "ml/min")
-	maxspd := wunit.NewFlowRate(2, "ml/min")
+	hvminvol := wunit.NewVolume(10, "ul")
+	hvmaxvol := wunit.NewVolume(1000, "ul")
+	hvminspd := wunit.NewFlowRate(0.5, "ml/min")
+	hvmaxspd := wunit.NewFlowRate(2, "ml/min")
 
-	hvconfig := wtype.NewLHChannelParameter("HVconfig", &minvol, &maxvol, &minspd, &maxspd, 8, false, wtype.LHVChannel, 0)
+	hvconfig := wtype.NewLHChannelParameter("HVconfig", &hvminvol, &hvmaxvol, &hvminspd, &hvmaxspd, 8, false, wtype.LHVChannel, 0)
 
 	hvadaptor := wtype.NewLHAdaptor("HVAdaptor", "CyBio", hvconfig)
 
-	minvol = wunit.NewVolume(0.5, "ul")
-	maxvol = wunit.NewVolume(50, "ul")
-	minspd = wunit.NewFlowRate(0.1, "ml/min")
-	maxspd = wunit.NewFlowRate(0.5, "ml/min")
+	lvminvol := wunit.NewVolume(0.5, "ul")
+	lvmaxvol := wunit.NewVolume(50, "ul")
+	lvminspd := wunit.NewFlowRate(0.1, "ml/min")
+	lvmaxspd := wunit.NewFlowRate(0.5, "ml/min")
 
-	lvconfig := wtype.NewLHChannelParameter("LVconfig", &minvol, &maxvol, &minspd, &maxspd, 8, false, wtype.LHVChannel, 0)
+	lvconfig := wtype.NewLHChannelParameter("LVconfig", &lvminvol, &lvmaxvol, &lvminspd, &lvmaxspd, 8, false, wtype.LHVChannel, 0)
 	lvadaptor := wtype.NewLHAdaptor("LVAdaptor", "CyBio", lvconfig)
 
-	minvol = wunit.NewVolume(0.5, "ul")
-	maxvol = wunit.NewVolume(1000, "ul")
-	headparams := wtype.NewLHChannelParameter("ChoiceHead", &minvol, &maxvol, &minspd, &maxspd, 8, false, wtype.LHVChannel, 0)
+	headminvol := wunit.NewVolume(0.5, "ul")
+	headmaxvol := wunit.NewVolume(1000, "ul")
+	headparams := wtype.NewLHChannelParameter("ChoiceHead", &headminvol, &headmaxvol, &lvminspd, &lvmaxspd, 8, false, wtype.LHVChannel, 0)
 	head := wtype.NewLHHead("ChoiceHead", "CyBio", headparams)
 	head.Adaptor = hvadaptor
 
@@ -212,22 +212,22 @@ func makeGilson() *liquidhandling.LHProperties {
 	lhp.Tip_preferences = []int{2, 3, 4}
 	lhp.Input_preferences = []int{4, 5, 6}
 	lhp.Output_preferences = []int{7, 8, 9}
-	minvol := wunit.NewVolume(10, "ul")
-	maxvol := wunit.NewVolume(250, "ul")
-	minspd := wunit.NewFlowRate(0.5, "ml/min")
-	maxspd := wunit.NewFlowRate(2, "ml/min")
+	hvminvol := wunit.NewVolume(10, "ul")
+	hvmaxvol := wunit.NewVolume(250, "ul")
+	hvminspd := wunit.NewFlowRate(0.5, "ml/min")
+	hvmaxspd := wunit.NewFlowRate(2, "ml/min")
 
-	hvconfig := wtype.NewLHChannelParameter("HVconfig", &minvol, &maxvol, &minspd, &maxspd, 8, false, wtype.LHVChannel, 0)
+	hvconfig := wtype.NewLHChannelParameter("HVconfig", &hvminvol, &hvmaxvol, &hvminspd, &hvmaxspd, 8, false, wtype.LHVChannel, 0)
 	hvadaptor := wtype.NewLHAdaptor("DummyAdaptor", "Gilson", hvconfig)
 	hvhead := wtype.NewLHHead("HVHead", "Gilson", hvconfig)
 	hvhead.Adaptor = hvadaptor
 
-	minvol = wunit.NewVolume(0.5, "ul")
-	maxvol = wunit.NewVolume(50, "ul")
-	minspd = wunit.NewFlowRate(0.1, "ml/min")
-	maxspd = wunit.NewFlowRate(0.5, "ml/min")
+	lvminvol := wunit.NewVolume(0.5, "ul")
+	lvmaxvol := wunit.NewVolume(50, "ul")
+	lvminspd := wunit.NewFlowRate(0.1, "ml/min")
+	lvmaxspd := wunit.NewFlowRate(0.5, "ml/min")
 
-	lvconfig := wtype.NewLHChannelParameter("LVconfig", &minvol, &maxvol, &minspd, &maxspd, 8, false, wtype.LHVChannel, 1)
+	lvconfig := wtype.NewLHChannelParameter("LVconfig", &lvminvol, &lvmaxvol, &lvminspd, &lvmaxspd, 8, false, wtype.LHVChannel, 1)
 	lvadaptor := wtype.NewLHAdaptor("DummyAdaptor", "Gilson", lvconfig)
 	lvhead := wtype.NewLHHead("LVHead", "Gilson", lvconfig)
 	lvhead.Adaptor = lvadaptor
@@ -22,7 +22,10 @@
 
 package factory
 
-import "github.com/antha-lang/antha/antha/anthalib/wtype"
+import (
+	"github.com/antha-lang/antha/antha/anthalib/wtype"
+	"sort"
+)
 
 // TODO plate dimensions are not correct
 func makePlateLibrary() map[string]*wtype.LHPlate {
@@ -69,5 +72,8 @@ func GetPlateList() []string {
 		kz[x] = name
 		x += 1
 	}
+
+	sort.Strings(kz)
+
 	return kz
 }
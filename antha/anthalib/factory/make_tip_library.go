@@ -25,6 +25,7 @@ package factory
 import (
 	"fmt"
 	"github.com/antha-lang/antha/antha/anthalib/wtype"
+	"sort"
 )
 
 func makeTipLibrary() map[string]*wtype.LHTipbox {
@@ -76,6 +77,16 @@ func makeTipLibrary() map[string]*wtype.LHTipbox {
 	tips[tip.Type] = tb
 	tips[tb.Type] = tb
 
+	// these details are incorrect and need fixing
+	w = wtype.NewLHWell("Gilson10Tipbox", "", "A1", "ul", 10.0, 0.5, 1, 0, 7.3, 7.3, 46.0, 0.0, "mm")
+	w.Extra["InnerL"] = 5.5
+	w.Extra["InnerW"] = 5.5
+	w.Extra["Tipeffectiveheight"] = 34.6
+	tip = wtype.NewLHTip("gilson", "Gilson10", 0.5, 10.0, "ul")
+	tb = wtype.NewLHTipbox(8, 12, 60.13, "Gilson", "DL10 Tip Rack (PIPETMAX 8x10)", tip, w, 9.0, 9.0, 0.0, 0.0, 28.93)
+	tips[tip.Type] = tb
+	tips[tb.Type] = tb
+
 	return tips
 }
 
@@ -103,5 +114,8 @@ func GetTipList() []string {
 		kz[x] = name
 		x += 1
 	}
+
+	sort.Strings(kz)
+
 	return kz
 }
@@ -55,8 +55,8 @@ func MakeConfigFile(fn string, request LHRequest) {
 
 	inputs := request.Input_solutions
 
-	for _, inputarr := range inputs {
-		for _, input := range inputarr {
+	for _, name := range sorted_input_names(inputs) {
+		for _, input := range inputs[name] {
 			// if both concentration and volume are set for this then
 			// volume has priority
 
@@ -97,19 +97,18 @@ func MakePlanFile(fn string, request LHRequest) {
 
 	inputs := request.Input_solutions
 	inputnames := make([]string, 0, len(inputs))
-	c := 0
-	for _, inputarr := range inputs {
-		for _, input := range inputarr {
-			inputnames[c] = input.CName
-			fmt.Fprintf(f, "%s\t", inputnames[c])
-			c += 1
+	for _, name := range sorted_input_names(inputs) {
+		for _, input := range inputs[name] {
+			inputnames = append(inputnames, input.CName)
+			fmt.Fprintf(f, "%s\t", input.CName)
 		}
 	}
 	fmt.Fprintf(f, "Y\n")
 
 	solns := request.Output_solutions
 
-	for _, solution := range solns {
+	for _, id := range sorted_solution_ids(solns) {
+		solution := solns[id]
 		for _, n := range inputnames {
 			val := solution.GetComponentVolume(n)
 			fmt.Fprintf(f, "%f\t", val)
@@ -0,0 +1,287 @@
+// /anthalib/liquidhandling/checkpoint.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/antha-lang/antha/antha/anthalib/driver"
+	"github.com/antha-lang/antha/antha/anthalib/driver/liquidhandling"
+)
+
+// what to do when the driver says an instruction failed
+type LHRecoveryAction int
+
+const (
+	LHAbort LHRecoveryAction = iota // stop; the request can be resumed from its checkpoint
+	LHRetry                         // send the failed instruction again
+	LHSkip                          // give up on what was being done with the tips on and unload them
+)
+
+// asks on w what to do about each failure and reads the answer from r;
+// anything other than retry or skip, including nothing, stops
+func PromptRecoveryAgent(r io.Reader, w io.Writer) func(*LHRequest, *LHCheckpoint) LHRecoveryAction {
+	in := bufio.NewReader(r)
+
+	return func(request *LHRequest, cp *LHCheckpoint) LHRecoveryAction {
+		fmt.Fprintf(w, "Instruction %d (%s) failed: %s\nRetry, skip what these tips are for or abort? [r/s/a] ", cp.Instruction, cp.Name, cp.Status.Msg)
+
+		answer, _ := in.ReadString('\n')
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "r", "retry":
+			return LHRetry
+		case "s", "skip":
+			return LHSkip
+		}
+
+		return LHAbort
+	}
+}
+
+// where execution of a request stopped: the instruction which failed
+// and the tips and waste as they were just before it, which ResumeFrom
+// uses to check a new run has planned the same instructions
+type LHCheckpoint struct {
+	RequestID   string
+	Instruction int
+	Name        string
+	Status      driver.CommandStatus
+	Deck_state  *LHDeckState
+}
+
+func LoadCheckpoint(filename string) (*LHCheckpoint, error) {
+	dat, err := ioutil.ReadFile(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var cp LHCheckpoint
+
+	if err := json.Unmarshal(dat, &cp); err != nil {
+		return nil, err
+	}
+
+	return &cp, nil
+}
+
+// a checkpoint saved by another run can only be carried on from if the
+// request has been planned the same way: requests get new IDs every time
+// so we check the instruction it stopped at is the same and the tips and
+// waste were left the same way getting there
+func (this *Liquidhandler) ResumeFrom(request *LHRequest, cp *LHCheckpoint) error {
+	n := cp.Instruction
+
+	if n < 0 || n >= len(request.Instructions) {
+		return fmt.Errorf("cannot resume from instruction %d, request %s has %d", n, request.ID, len(request.Instructions))
+	}
+
+	if name := liquidhandling.Robotinstructionnames[request.Instructions[n].InstructionType()]; name != cp.Name {
+		return fmt.Errorf("cannot resume from instruction %d: it was %s, request %s has %s there", n, cp.Name, request.ID, name)
+	}
+
+	if cp.Deck_state != nil && !same_deck_state(cp.Deck_state, deck_state_at(request.Instructions, this.Properties, n)) {
+		return fmt.Errorf("cannot resume from instruction %d: request %s does not use the same tips up to there", n, request.ID)
+	}
+
+	cp.RequestID = request.ID
+	request.Checkpoint = cp
+
+	return nil
+}
+
+// picks up the checkpoint a previous run left in Checkpointfn, if any
+func (this *Liquidhandler) resume_from_file(request *LHRequest) error {
+	if request.Checkpoint != nil || request.Checkpointfn == "" {
+		return nil
+	}
+
+	cp, err := LoadCheckpoint(request.Checkpointfn)
+
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := this.ResumeFrom(request, cp); err != nil {
+		return fmt.Errorf("%s: %s; remove it to start again from the beginning", request.Checkpointfn, err.Error())
+	}
+
+	return nil
+}
+
+func same_deck_state(a, b *LHDeckState) bool {
+	if len(a.Tipboxes) != len(b.Tipboxes) || len(a.Tipwastes) != len(b.Tipwastes) {
+		return false
+	}
+
+	for pos, st := range a.Tipboxes {
+		st2, ok := b.Tipboxes[pos]
+		if !ok || st.Type != st2.Type || len(st.Used) != len(st2.Used) {
+			return false
+		}
+		for i, well := range st.Used {
+			if st2.Used[i] != well {
+				return false
+			}
+		}
+	}
+
+	for pos, n := range a.Tipwastes {
+		if n2, ok := b.Tipwastes[pos]; !ok || n2 != n {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (cp *LHCheckpoint) Save(filename string) error {
+	dat, err := json.Marshal(cp)
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, dat, 0644)
+}
+
+func make_checkpoint(request *LHRequest, properties *liquidhandling.LHProperties, n int, status driver.CommandStatus) *LHCheckpoint {
+	cp := LHCheckpoint{RequestID: request.ID, Instruction: n, Status: status}
+
+	if t := request.Instructions[n].InstructionType(); t >= 0 && t < len(liquidhandling.Robotinstructionnames) {
+		cp.Name = liquidhandling.Robotinstructionnames[t]
+	}
+
+	cp.Deck_state = deck_state_at(request.Instructions, properties, n)
+
+	return &cp
+}
+
+// the tips and waste after the first n instructions have been run,
+// starting from the deck as set up in the properties
+func deck_state_at(instructions []liquidhandling.TerminalRobotInstruction, properties *liquidhandling.LHProperties, n int) *LHDeckState {
+	ds := GetDeckState(properties)
+
+	for i := 0; i < n && i < len(instructions); i++ {
+		switch ins := instructions[i].(type) {
+		case *liquidhandling.LoadTipsInstruction:
+			for j, pos := range ins.Pos {
+				st, ok := ds.Tipboxes[pos]
+				if !ok || j >= len(ins.Well) {
+					continue
+				}
+				st.Used = append(st.Used, ins.Well[j])
+				ds.Tipboxes[pos] = st
+			}
+		case *liquidhandling.UnloadTipsInstruction:
+			if len(ins.Pos) > 0 {
+				if _, ok := ds.Tipwastes[ins.Pos[0]]; ok {
+					ds.Tipwastes[ins.Pos[0]] += ins.Multi
+				}
+			}
+		case *liquidhandling.EmptyTipwasteInstruction:
+			if _, ok := ds.Tipwastes[ins.Pos]; ok {
+				ds.Tipwastes[ins.Pos] = 0
+			}
+		}
+	}
+
+	return ds
+}
+
+// skipping a failed instruction gives up on everything done with the
+// tips on at the time: we go on from where they are unloaded or, if
+// there are none on, from after the next lot have been loaded, used and
+// unloaded. Tips going on or off can't be skipped since there is no
+// telling what is left on the head, nor can the moves taking them to
+// be unloaded
+func skip_to(instructions []liquidhandling.TerminalRobotInstruction, n int) (int, error) {
+	switch instructions[n].InstructionType() {
+	case liquidhandling.LOD, liquidhandling.ULD:
+		return n, fmt.Errorf("tips may have been left on the head")
+	}
+
+	tips := false
+
+	for i := n - 1; i >= 0; i-- {
+		if t := instructions[i].InstructionType(); t == liquidhandling.LOD {
+			tips = true
+			break
+		} else if t == liquidhandling.ULD {
+			break
+		}
+	}
+
+	for i := n + 1; i < len(instructions); i++ {
+		switch instructions[i].InstructionType() {
+		case liquidhandling.ULD:
+			if !tips {
+				return i + 1, nil
+			}
+
+			// the moves to the tip waste go with it
+
+			j := i
+			for j-1 > n && is_transfer_preamble(instructions[j-1]) {
+				j -= 1
+			}
+
+			if j == n+1 && is_transfer_preamble(instructions[n]) {
+				return n, fmt.Errorf("the tips have to get to the waste")
+			}
+
+			return j, nil
+		case liquidhandling.FIN:
+			return i, nil
+		}
+	}
+
+	return len(instructions), nil
+}
+
+// carrying on from a checkpoint starts with the moves and speed
+// settings leading up to the instruction which failed, since whatever
+// the robot has done since may have undone them
+func resume_point(instructions []liquidhandling.TerminalRobotInstruction, n int) int {
+	for n > 0 && is_transfer_preamble(instructions[n-1]) {
+		n -= 1
+	}
+	return n
+}
+
+func is_transfer_preamble(ins liquidhandling.TerminalRobotInstruction) bool {
+	switch ins.InstructionType() {
+	case liquidhandling.MOV, liquidhandling.MIX, liquidhandling.SPS, liquidhandling.SDS:
+		return true
+	}
+	return false
+}
@@ -24,11 +24,10 @@ package liquidhandling
 
 import (
 	"fmt"
-	"github.com/antha-lang/antha/antha/anthalib/wutil"
-	"github.com/antha-lang/antha/internal/github.com/lukpank/go-glpk/glpk"
+	"sort"
 )
 
-func choose_stock_concentrations(minrequired map[string]float64, maxrequired map[string]float64, Smax map[string]float64, vmin float64, T map[string]float64) map[string]float64 {
+func choose_stock_concentrations(minrequired map[string]float64, maxrequired map[string]float64, Smax map[string]float64, vmin float64, T map[string]float64) (map[string]float64, LHSolverResult) {
 	// we want to find the minimum concentrations
 	// which fulfill the constraints
 
@@ -56,7 +55,7 @@ func choose_stock_concentrations(minrequired map[string]float64, maxrequired map
 	// no concentrations -> end here
 
 	if nc == 0 {
-		return (make(map[string]float64, 1))
+		return (make(map[string]float64, 1)), LHSolverResult{Problem: "stock concentrations", Status: LP_OPT}
 	}
 
 	// need to do these things in a consistent order
@@ -68,88 +67,97 @@ func choose_stock_concentrations(minrequired map[string]float64, maxrequired map
 		cur += 1
 	}
 
-	lp := glpk.New()
-	defer lp.Delete()
+	sort.Strings(names)
 
-	lp.SetProbName("Concentrations")
-	lp.SetObjName("Z")
-	lp.SetObjDir(glpk.MAX)
+	// one column per component, then
+	// rows: min volume constraints, max conc constraints, sum constraint
 
-	// sets up number of constraints and B vector
-	lp.AddRows(2*nc + 1)
+	lp := NewLPProblem("Concentrations", LP_MAX, 2*nc+1, nc)
 
-	cur = 1
+	for i, name := range names {
+		lp.RowBounds[i] = LPBounds{Type: LP_UP, Lower: -999999.0, Upper: (-1.0 * vmin * maxrequired[name]) / (T[name] * minrequired[name])}
+		lp.RowBounds[nc+i] = LPBounds{Type: LP_UP, Lower: -999999.0, Upper: (-1.0 * maxrequired[name] / Smax[name])}
 
-	for _, name := range names {
-		lp.SetRowBnds(cur, glpk.UP, -999999.0, (-1.0*vmin*maxrequired[name])/(T[name]*minrequired[name]))
-		cur += 1
-	}
+		lp.Objective[i] = -1.0
+		lp.ColBounds[i] = LPBounds{Type: LP_LO}
 
-	for _, name := range names {
-		lp.SetRowBnds(cur, glpk.UP, -999999.0, (-1.0 * maxrequired[name] / Smax[name]))
-		cur += 1
-	}
+		// constraint coeffs
 
-	lp.SetRowBnds(cur, glpk.UP, 0.0, 1.0)
+		lp.Matrix[i][i] = -1.0
+		lp.Matrix[nc+i][i] = -1.0
 
-	// sets up objective and constraint coefficients
+		// now the sum constraint
+		lp.Matrix[2*nc][i] = 1.0
+	}
 
-	lp.AddCols(nc)
+	lp.RowBounds[2*nc] = LPBounds{Type: LP_UP, Lower: 0.0, Upper: 1.0}
 
-	cur = 1
-	for _, name := range names {
-		name = name
-		lp.SetObjCoef(cur, -1.0)
-		lp.SetColName(cur, fmt.Sprintf("X%d", cur))
-		lp.SetColBnds(cur, glpk.LO, 0.0, 0.0)
-		cur += 1
-	}
+	// solve it
 
-	cur = 1
+	sol := DefaultLPSolver.Solve(lp)
 
-	// constraint coeffs
+	// now look at the solution
 
-	ind := wutil.Series(0, nc)
+	concentrations := make(map[string]float64, nc)
+	result := solver_result("stock concentrations", sol)
 
-	for j := 0; j < 2; j++ {
-		for i := 0; i < nc; i++ {
-			row := make([]float64, nc+1)
-			row[i+1] = -1.0
-			lp.SetMatRow(cur, ind, row)
-			cur += 1
-		}
+	if sol.Status != LP_OPT {
+		// some problem
+		result.Reasons = explain_stock_concentrations(lp, names, minrequired, maxrequired, Smax, vmin, T)
+		return concentrations, result
 	}
-	// now the sum constraint
-	row := make([]float64, nc+1)
-	for i := 0; i < nc; i++ {
-		row[i+1] = 1.0
+
+	for i, name := range names {
+		concentrations[name] = maxrequired[name] / sol.Cols[i]
 	}
-	lp.SetMatRow(cur, ind, row)
 
-	// solve it
+	//fmt.Println()
 
-	prm := glpk.NewSmcp()
-	prm.SetMsgLev(0)
-	lp.Simplex(prm)
+	return concentrations, result
+}
 
-	// now look at the solution
+// the components can't all fit: say how much of the volume each needs
+// and why
+func explain_stock_concentrations(lp *LPProblem, names []string, minrequired, maxrequired, Smax map[string]float64, vmin float64, T map[string]float64) []string {
+	nc := len(names)
 
-	concentrations := make(map[string]float64, nc)
+	// we would rather blame the total than any one component
+	weights := make([]float64, 2*nc+1)
+	for i := 0; i < 2*nc; i++ {
+		weights[i] = 1000.0
+	}
+	weights[2*nc] = 1.0
 
-	stat := lp.Status()
+	broken, x, ok := lp_explain(lp, weights)
 
-	if stat != glpk.OPT {
-		// some problem
-		return concentrations
+	if !ok {
+		return []string{"cannot find out why"}
 	}
 
-	cur = 1
-	for _, name := range names {
-		concentrations[name] = maxrequired[name] / lp.ColPrim(cur)
-		cur += 1
+	reasons := make([]string, 0, nc+1)
+
+	if broken[2*nc] > 0.0 {
+		reasons = append(reasons, fmt.Sprintf("the components together need %.1f%% of the total volume", 100.0*(1.0+broken[2*nc])))
 	}
 
-	//fmt.Println()
+	for i, name := range names {
+		if broken[i] > 0.0 || broken[nc+i] > 0.0 {
+			reasons = append(reasons, fmt.Sprintf("component %s cannot be made up to between %g and %g", name, minrequired[name], maxrequired[name]))
+			continue
+		}
+
+		if broken[2*nc] == 0.0 || x[i] == 0.0 {
+			continue
+		}
+
+		// which lower limit holds this one up
+
+		if -lp.RowBounds[i].Upper >= -lp.RowBounds[nc+i].Upper {
+			reasons = append(reasons, fmt.Sprintf("component %s needs %.1f%% so that at least %g of its stock goes into a total of %g at concentration %g", name, 100.0*x[i], vmin, T[name], minrequired[name]))
+		} else {
+			reasons = append(reasons, fmt.Sprintf("component %s needs %.1f%% to reach concentration %g from a stock no stronger than its maximum solubility %g", name, 100.0*x[i], maxrequired[name], Smax[name]))
+		}
+	}
 
-	return concentrations
+	return reasons
 }
@@ -0,0 +1,120 @@
+// /anthalib/liquidhandling/deckstate.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+import (
+	"encoding/json"
+	"github.com/antha-lang/antha/antha/anthalib/driver/liquidhandling"
+	"github.com/antha-lang/antha/antha/anthalib/factory"
+	"io/ioutil"
+)
+
+// what is left on the deck after a run: which tips have gone from each
+// box and how many tips are in each waste. A request can start from
+// this rather than from fresh boxes and an empty waste
+type LHDeckState struct {
+	Tipboxes  map[string]LHTipboxState // by position
+	Tipwastes map[string]int           // by position
+}
+
+type LHTipboxState struct {
+	Type string
+	Used []string
+}
+
+func NewLHDeckState() *LHDeckState {
+	var ds LHDeckState
+	ds.Tipboxes = make(map[string]LHTipboxState)
+	ds.Tipwastes = make(map[string]int)
+	return &ds
+}
+
+// the state of the tip boxes and wastes currently on the deck
+func GetDeckState(properties *liquidhandling.LHProperties) *LHDeckState {
+	ds := NewLHDeckState()
+
+	for pos, tb := range properties.Tipboxes {
+		ds.Tipboxes[pos] = LHTipboxState{Type: tb.Type, Used: tb.UsedTips()}
+	}
+
+	for pos, tw := range properties.Tipwastes {
+		ds.Tipwastes[pos] = tw.Contents
+	}
+
+	return ds
+}
+
+func LoadDeckState(filename string) (*LHDeckState, error) {
+	dat, err := ioutil.ReadFile(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ds := NewLHDeckState()
+
+	if err := json.Unmarshal(dat, ds); err != nil {
+		return nil, err
+	}
+
+	return ds, nil
+}
+
+func (ds *LHDeckState) Save(filename string) error {
+	dat, err := json.Marshal(ds)
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, dat, 0644)
+}
+
+// puts the tip boxes back on the deck as they were left, replacing any
+// already there; wastes have to be on the deck already
+func (ds *LHDeckState) setup(properties *liquidhandling.LHProperties) {
+	properties.RemoveTipBoxes()
+
+	for _, pos := range sorted_tipbox_positions(ds.Tipboxes) {
+		st := ds.Tipboxes[pos]
+		tb := factory.GetTipboxByType(st.Type)
+
+		if tb == nil {
+			RaiseError("deck state: no tip box of type " + st.Type)
+		}
+
+		tb.RemoveTipsAt(st.Used)
+
+		if tb.NTips == 0 {
+			continue
+		}
+
+		properties.AddTipBoxTo(pos, tb)
+	}
+
+	for pos, n := range ds.Tipwastes {
+		if tw, ok := properties.Tipwastes[pos]; ok {
+			tw.Contents = n
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// /anthalib/liquidhandling/dilution_series.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+import (
+	"fmt"
+	"github.com/antha-lang/antha/antha/anthalib/driver/liquidhandling"
+	"github.com/antha-lang/antha/antha/anthalib/mixer"
+	"github.com/antha-lang/antha/antha/anthalib/wtype"
+	"github.com/antha-lang/antha/antha/anthalib/wunit"
+	"math"
+)
+
+// describes a serial dilution: each step is made by taking some of the
+// previous step and diluting it Fold times, the first step takes from
+// the neat sample. Every step ends up with volume Vol
+type DilutionSeries struct {
+	Sample    *wtype.LHComponent
+	Diluent   *wtype.LHComponent
+	Fold      float64
+	Steps     int
+	Vol       wunit.Volume
+	MixCycles int    // mix each step before sampling from it, 0 for no mixing
+	Platetype string // output plate type, may be empty
+}
+
+func NewDilutionSeries(sample, diluent *wtype.LHComponent, fold float64, steps int, vol wunit.Volume) *DilutionSeries {
+	var ds DilutionSeries
+	ds.Sample = sample
+	ds.Diluent = diluent
+	ds.Fold = fold
+	ds.Steps = steps
+	ds.Vol = vol
+	return &ds
+}
+
+// makes the solutions for each step in the series, in order
+// since each step except the last has some taken out to make the next
+// these are made up with extra to leave Vol behind
+func (ds *DilutionSeries) Solutions() ([]*wtype.LHSolution, error) {
+	if ds.Fold <= 1.0 {
+		return nil, fmt.Errorf("Dilution series of %s: fold must be greater than 1, not %g", ds.Sample.CName, ds.Fold)
+	}
+
+	if ds.Steps < 1 {
+		return nil, fmt.Errorf("Dilution series of %s: need at least one step", ds.Sample.CName)
+	}
+
+	// work out the totals from the last step backwards
+
+	totals := make([]float64, ds.Steps)
+	v := ds.Vol.RawValue()
+
+	for i := ds.Steps - 1; i >= 0; i-- {
+		totals[i] = v
+		if i < ds.Steps-1 {
+			totals[i] += totals[i+1] / ds.Fold
+		}
+	}
+
+	unit := ds.Vol.Unit().PrefixedSymbol()
+	solutions := make([]*wtype.LHSolution, ds.Steps)
+
+	for i := 0; i < ds.Steps; i++ {
+		diluent := ds.Diluent.Dup()
+		diluent.Vol = totals[i] - totals[i]/ds.Fold
+		diluent.Vunit = unit
+		diluent.Tvol = 0.0
+		diluent.Conc = 0.0
+
+		var sample *wtype.LHComponent
+
+		if i == 0 {
+			sample = ds.Sample.Dup()
+			sample.Vol = totals[i] / ds.Fold
+			sample.Vunit = unit
+			sample.Tvol = 0.0
+			sample.Conc = 0.0
+		} else {
+			sample = mixer.SampleSolution(solutions[i-1], wunit.NewVolume(totals[i]/ds.Fold, unit))
+		}
+
+		// add the sample to the diluent
+
+		sol := mixer.Mix(diluent, sample)
+
+		if ds.Platetype != "" {
+			sol.ContainerType = ds.Platetype
+			sol.Platetype = ds.Platetype
+		}
+
+		sol.SName = fmt.Sprintf("%s_1in%g", ds.Sample.CName, math.Pow(ds.Fold, float64(i+1)))
+		sol.Vol = totals[i]
+		solutions[i] = sol
+	}
+
+	return solutions, nil
+}
+
+// policy rules which mix each step of the series before the next step
+// takes from it, and mix the last step once it is made
+func (ds *DilutionSeries) mixingRules(solutions []*wtype.LHSolution) ([]liquidhandling.LHPolicyRule, []liquidhandling.LHPolicy) {
+	rules := make([]liquidhandling.LHPolicyRule, 0, len(solutions))
+	policies := make([]liquidhandling.LHPolicy, 0, len(solutions))
+
+	if ds.MixCycles <= 0 {
+		return rules, policies
+	}
+
+	for i := 1; i < len(solutions); i++ {
+		// the liquid class of the transfer into step i is the ID of step i-1
+		name := solutions[i-1].ID
+		rule := liquidhandling.NewLHPolicyRule(name)
+		rule.AddCategoryConditionOn("LIQUIDCLASS", name)
+
+		pol := make(liquidhandling.LHPolicy, 2)
+		pol["PRE_MIX"] = ds.MixCycles
+
+		if i == len(solutions)-1 {
+			pol["POST_MIX"] = ds.MixCycles
+		}
+
+		rules = append(rules, rule)
+		policies = append(policies, pol)
+	}
+
+	return rules, policies
+}
+
+// adds each step of the series to the request as an output solution
+// along with any policies needed for mixing
+func (rq *LHRequest) AddDilutionSeries(ds *DilutionSeries) ([]*wtype.LHSolution, error) {
+	solutions, err := ds.Solutions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sol := range solutions {
+		sol.BlockID = rq.BlockID
+		rq.Output_solutions[sol.ID] = sol
+	}
+
+	rules, policies := ds.mixingRules(solutions)
+
+	if len(rules) != 0 && rq.Policies == nil {
+		rq.Policies = liquidhandling.NewLHPolicyRuleSet()
+	}
+
+	for i, rule := range rules {
+		if err := rq.Policies.AddRule(rule, policies[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return solutions, nil
+}
@@ -0,0 +1,436 @@
+// /anthalib/liquidhandling/estimate.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/antha-lang/antha/antha/anthalib/driver/liquidhandling"
+	"github.com/antha-lang/antha/antha/anthalib/wtype"
+	"github.com/antha-lang/antha/antha/anthalib/wunit"
+	"math"
+	"time"
+)
+
+// rough timings for a liquid handler, used to estimate how long a plan
+// will take before it is run
+// all times are in seconds, distances in mm, speeds in mm/s except for
+// PipetteSpeed which is in ml/min as set by SetPipetteSpeed
+type LHTimingParameters struct {
+	MoveOverhead      float64 // fixed cost of any move
+	XYSpeed           float64 // until a SetDriveSpeed for X or Y says otherwise
+	ZSpeed            float64 // until a SetDriveSpeed for Z says otherwise
+	ZTravel           float64 // up and down again for each move
+	WellPitch         float64 // distance between neighbouring wells
+	PipetteSpeed      float64 // until a SetPipetteSpeed says otherwise
+	PipetteTime       float64 // fixed cost of aspirating or dispensing
+	BlowoutTime       float64
+	PTZTime           float64
+	TipLoadTime       float64
+	TipUnloadTime     float64
+	AdaptorLoadTime   float64
+	AdaptorUnloadTime float64
+	MixCycleTime      float64 // fixed cost of each mix cycle
+	InitTime          float64
+	FinaliseTime      float64
+}
+
+// timings for each liquid handler keyed by manufacturer then model,
+// anything not listed gets DefaultLHTimingParameters
+var lhtimings = map[string]LHTimingParameters{
+	"GilsonPipetmax": LHTimingParameters{
+		MoveOverhead:      0.5,
+		XYSpeed:           150.0,
+		ZSpeed:            50.0,
+		ZTravel:           40.0,
+		WellPitch:         9.0,
+		PipetteSpeed:      3.0,
+		PipetteTime:       1.0,
+		BlowoutTime:       1.5,
+		PTZTime:           1.0,
+		TipLoadTime:       4.0,
+		TipUnloadTime:     3.0,
+		AdaptorLoadTime:   10.0,
+		AdaptorUnloadTime: 10.0,
+		MixCycleTime:      0.5,
+		InitTime:          30.0,
+		FinaliseTime:      10.0,
+	},
+}
+
+var DefaultLHTimingParameters = LHTimingParameters{
+	MoveOverhead:      1.0,
+	XYSpeed:           100.0,
+	ZSpeed:            25.0,
+	ZTravel:           40.0,
+	WellPitch:         9.0,
+	PipetteSpeed:      1.0,
+	PipetteTime:       1.0,
+	BlowoutTime:       2.0,
+	PTZTime:           1.0,
+	TipLoadTime:       5.0,
+	TipUnloadTime:     5.0,
+	AdaptorLoadTime:   15.0,
+	AdaptorUnloadTime: 15.0,
+	MixCycleTime:      1.0,
+	InitTime:          30.0,
+	FinaliseTime:      10.0,
+}
+
+func GetLHTimingParameters(properties *liquidhandling.LHProperties) LHTimingParameters {
+	tp, ok := lhtimings[properties.Mnfr+properties.Model]
+
+	if !ok {
+		return DefaultLHTimingParameters
+	}
+
+	return tp
+}
+
+func SetLHTimingParameters(mnfr, model string, tp LHTimingParameters) {
+	lhtimings[mnfr+model] = tp
+}
+
+// what one stage of a plan costs; times in seconds, volumes in ul
+type LHStageEstimate struct {
+	Time     float64
+	Tips     map[string]int
+	Reagents map[string]float64
+}
+
+func newLHStageEstimate() LHStageEstimate {
+	return LHStageEstimate{Tips: make(map[string]int), Reagents: make(map[string]float64)}
+}
+
+// what a whole plan costs: total time, tips per type, plates and tip
+// boxes per type, how much of each input has to be loaded, how much of
+// each reagent is taken and from where, then the same broken down by stage
+type LHEstimate struct {
+	Time     float64
+	Tips     map[string]int
+	Plates   map[string]int
+	Load     map[string]float64 // losses included, so more than Reagents
+	Reagents map[string]float64
+	Sources  map[string]float64
+	Stages   []LHStageEstimate
+}
+
+func NewLHEstimate() LHEstimate {
+	var e LHEstimate
+	e.Tips = make(map[string]int)
+	e.Plates = make(map[string]int)
+	e.Load = make(map[string]float64)
+	e.Reagents = make(map[string]float64)
+	e.Sources = make(map[string]float64)
+	e.Stages = make([]LHStageEstimate, 0, 1)
+	return e
+}
+
+func (e LHEstimate) Duration() time.Duration {
+	return seconds(e.Time)
+}
+
+// to the nearest second, nobody needs more than that
+func seconds(t float64) time.Duration {
+	return time.Duration(math.Floor(t+0.5)) * time.Second
+}
+
+func (e LHEstimate) String() string {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "Estimated time: %s\n", e.Duration().String())
+
+	fmt.Fprintf(&buf, "Tips:\n")
+	for _, k := range sorted_int_keys(e.Tips) {
+		fmt.Fprintf(&buf, "\t%-40s %d\n", k, e.Tips[k])
+	}
+
+	fmt.Fprintf(&buf, "Plates:\n")
+	for _, k := range sorted_int_keys(e.Plates) {
+		fmt.Fprintf(&buf, "\t%-40s %d\n", k, e.Plates[k])
+	}
+
+	fmt.Fprintf(&buf, "Load:\n")
+	for _, k := range sorted_float_keys(e.Load) {
+		fmt.Fprintf(&buf, "\t%-40s %8.2f ul\n", k, e.Load[k])
+	}
+
+	fmt.Fprintf(&buf, "Reagents:\n")
+	for _, k := range sorted_float_keys(e.Reagents) {
+		fmt.Fprintf(&buf, "\t%-40s %8.2f ul\n", k, e.Reagents[k])
+	}
+
+	fmt.Fprintf(&buf, "Sources:\n")
+	for _, k := range sorted_float_keys(e.Sources) {
+		fmt.Fprintf(&buf, "\t%-40s %8.2f ul\n", k, e.Sources[k])
+	}
+
+	for i, st := range e.Stages {
+		fmt.Fprintf(&buf, "Stage %d: %s\n", i+1, seconds(st.Time).String())
+		for _, k := range sorted_int_keys(st.Tips) {
+			fmt.Fprintf(&buf, "\ttips %-35s %d\n", k, st.Tips[k])
+		}
+		for _, k := range sorted_float_keys(st.Reagents) {
+			fmt.Fprintf(&buf, "\t%-40s %8.2f ul\n", k, st.Reagents[k])
+		}
+	}
+
+	return buf.String()
+}
+
+// Estimate walks the instructions of a planned request and works out how long
+// they will take on this liquid handler and what they use up
+func (this *Liquidhandler) Estimate(request *LHRequest) LHEstimate {
+	return estimate_instructions(request, this.Properties, GetLHTimingParameters(this.Properties))
+}
+
+func estimate_instructions(request *LHRequest, properties *liquidhandling.LHProperties, tp LHTimingParameters) LHEstimate {
+	est := NewLHEstimate()
+
+	for _, plate := range request.Input_plates {
+		est.Plates[plate.Type] += 1
+	}
+
+	for _, plate := range request.Output_plates {
+		est.Plates[plate.Type] += 1
+	}
+
+	for _, tb := range request.Tips {
+		est.Plates[tb.Type] += 1
+	}
+
+	for name, v := range request.Input_volumes {
+		est.Load[name] = v.ConvertTo(wunit.ParsePrefixedUnit("ul"))
+	}
+
+	xyspeed := tp.XYSpeed
+	zspeed := tp.ZSpeed
+	pipspeed := tp.PipetteSpeed
+
+	// where each channel was last moved to
+	curpos := make([]string, 0, 1)
+	curwell := make([]string, 0, 1)
+
+	for i, ins := range request.Instructions {
+		stage := -1
+
+		if len(request.Instruction_stages) == len(request.Instructions) {
+			stage = request.Instruction_stages[i]
+		}
+
+		var st *LHStageEstimate
+
+		if stage >= 0 {
+			for len(est.Stages) <= stage {
+				est.Stages = append(est.Stages, newLHStageEstimate())
+			}
+			st = &est.Stages[stage]
+		}
+
+		t := 0.0
+
+		switch ins.InstructionType() {
+		case liquidhandling.INI:
+			t = tp.InitTime
+		case liquidhandling.FIN:
+			t = tp.FinaliseTime
+		case liquidhandling.SDS:
+			speed := ins.GetParameter("SPEED").(float64)
+			if speed > 0.0 {
+				switch ins.GetParameter("DRIVE").(string) {
+				case "Z":
+					zspeed = speed
+				default:
+					xyspeed = speed
+				}
+			}
+		case liquidhandling.SPS:
+			speed := ins.GetParameter("SPEED").(float64)
+			if speed > 0.0 {
+				pipspeed = speed
+			}
+		case liquidhandling.MOV:
+			pos := ins.GetParameter("POSTO").([]string)
+			wells := ins.GetParameter("WELLTO").([]string)
+
+			t = tp.MoveOverhead + tp.ZTravel/zspeed
+
+			if len(pos) > 0 && len(wells) > 0 {
+				oldpos, oldwell := "", ""
+				if len(curpos) > 0 {
+					oldpos, oldwell = curpos[0], curwell[0]
+				}
+				t += move_distance(properties, tp, oldpos, oldwell, pos[0], wells[0]) / xyspeed
+			}
+
+			curpos = pos
+			curwell = wells
+		case liquidhandling.ASP:
+			vols := ins.GetParameter("VOLUME").([]*wunit.Volume)
+			whats := ins.GetParameter("WHAT").([]string)
+
+			t = tp.PipetteTime + max_volume_ul(vols)/pipette_rate(pipspeed)
+
+			for j, v := range vols {
+				ul := v.ConvertTo(wunit.ParsePrefixedUnit("ul"))
+				what := ""
+				if j < len(whats) {
+					what = whats[j]
+				}
+				// air gaps aren't reagents
+				if what == liquidhandling.AirGap {
+					continue
+				}
+				// things made earlier in the plan go by their own names
+				if sol, ok := request.Output_solutions[what]; ok && sol.SName != "" {
+					what = sol.SName
+				}
+				est.Reagents[what] += ul
+				if st != nil {
+					st.Reagents[what] += ul
+				}
+				if j < len(curpos) && j < len(curwell) {
+					est.Sources[source_name(properties, curpos[j], curwell[j])] += ul
+				}
+			}
+		case liquidhandling.DSP:
+			vols := ins.GetParameter("VOLUME").([]*wunit.Volume)
+			t = tp.PipetteTime + max_volume_ul(vols)/pipette_rate(pipspeed)
+		case liquidhandling.BLO:
+			t = tp.BlowoutTime
+		case liquidhandling.PTZ:
+			t = tp.PTZTime
+		case liquidhandling.LOD:
+			t = tp.TipLoadTime
+			tiptype := ins.GetParameter("TIPTYPE").([]string)
+			if len(tiptype) > 0 {
+				n := ins.GetParameter("MULTI").(int)
+				est.Tips[tiptype[0]] += n
+				if st != nil {
+					st.Tips[tiptype[0]] += n
+				}
+			}
+		case liquidhandling.ULD:
+			t = tp.TipUnloadTime
+		case liquidhandling.LAD:
+			t = tp.AdaptorLoadTime
+		case liquidhandling.UAD:
+			t = tp.AdaptorUnloadTime
+		case liquidhandling.WAI:
+			t = ins.GetParameter("TIME").(float64)
+		case liquidhandling.MIX:
+			vols := ins.GetParameter("VOLUME").([]*wunit.Volume)
+			cycles := ins.GetParameter("CYCLES").([]int)
+			n := 0
+			for _, c := range cycles {
+				if c > n {
+					n = c
+				}
+			}
+			// up and down again each cycle
+			t = float64(n) * (tp.MixCycleTime + 2.0*max_volume_ul(vols)/pipette_rate(pipspeed))
+		}
+
+		est.Time += t
+
+		if st != nil {
+			st.Time += t
+		}
+	}
+
+	return est
+}
+
+// ml/min to ul/s
+func pipette_rate(speed float64) float64 {
+	return speed * 1000.0 / 60.0
+}
+
+func max_volume_ul(vols []*wunit.Volume) float64 {
+	max := 0.0
+	for _, v := range vols {
+		if v == nil {
+			continue
+		}
+		ul := v.ConvertTo(wunit.ParsePrefixedUnit("ul"))
+		if ul > max {
+			max = ul
+		}
+	}
+	return max
+}
+
+// how far the head goes between two wells; positions missing from the
+// layout are taken to be next to each other
+func move_distance(properties *liquidhandling.LHProperties, tp LHTimingParameters, pos1, well1, pos2, well2 string) float64 {
+	if pos1 == pos2 && well1 == well2 {
+		return 0.0
+	}
+
+	x1, y1 := well_offset(tp, well1)
+	x2, y2 := well_offset(tp, well2)
+
+	if pos1 != pos2 {
+		c1, ok1 := properties.Layout[pos1]
+		c2, ok2 := properties.Layout[pos2]
+
+		if ok1 && ok2 {
+			x1 += c1.X
+			y1 += c1.Y
+			x2 += c2.X
+			y2 += c2.Y
+		} else {
+			x2 += 12 * tp.WellPitch
+		}
+	}
+
+	return math.Hypot(x2-x1, y2-y1)
+}
+
+func well_offset(tp LHTimingParameters, well string) (float64, float64) {
+	if len(well) < 2 {
+		return 0.0, 0.0
+	}
+	wc := wtype.MakeWellCoordsA1(well)
+	return float64(wc.X) * tp.WellPitch, float64(wc.Y) * tp.WellPitch
+}
+
+// sources are named by the plate at a position where we know it
+func source_name(properties *liquidhandling.LHProperties, pos, well string) string {
+	return plate_name(properties, pos) + " " + well
+}
+
+// the name of whatever is at pos, or pos if it has none
+func plate_name(properties *liquidhandling.LHProperties, pos string) string {
+	name := pos
+
+	if id, ok := properties.PosLookup[pos]; ok && id != "" {
+		if plate, ok := properties.PlateLookup[id]; ok {
+			if named, ok := plate.(wtype.Named); ok {
+				name = named.GetName()
+			}
+		}
+	}
+
+	return name
+}
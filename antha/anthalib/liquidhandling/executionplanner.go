@@ -46,122 +46,322 @@ func AdvancedExecutionPlanner(request *LHRequest, parameters *liquidhandling.LHP
 	inass := request.Input_assignments
 	output_solutions := request.Output_solutions
 	input_plates := request.Input_plates
+	output_plates := request.Output_plates
 	output_plate_layout := request.Output_plate_layout
 
 	plate_lookup := request.Plate_lookup
 
-	// highly inelegant... we should swap Tip Box Setup
-	// around to take place after, then this whole thing
-	// is a non-issue
-	tt := make([]*wtype.LHTip, 1)
+	// the tips asked for come first so they're used whenever they
+	// can do the job, any others the machine can put out are there
+	// for volumes they can't
+	tt := make([]*wtype.LHTip, 1, len(parameters.Tips)+1)
 	tt[0] = request.Tip_Type.Tiptype
+	for _, tip := range parameters.Tips {
+		if tip.Type != tt[0].Type && parameters.TipboxLibrary[tip.Type] != nil {
+			tt = append(tt, tip)
+		}
+	}
 	parameters.Tips = tt
 
-	instructions := liquidhandling.NewRobotInstructionSet(nil)
+	// where each solution is going; solutions made here may also be
+	// taken from to make others
+
+	outplates, outwells := get_output_locations(minorlayoutgroups, ass, output_plate_layout)
+
+	// what's in each well as the transfers are made, so the heights
+	// of the liquid can be worked out when instructions are generated
+
+	wellvols := well_volumes(request)
+
+	// top level instructions and the stage each one belongs to
+	instructions := make([]liquidhandling.RobotInstruction, 0, 10)
+	instructionstages := make([]int, 0, 10)
 	// need to deal with solutions
 
-	order := request.Input_order
+	// solutions made from other solutions are done in stages, each
+	// stage has its own component order
 
-	for _, name := range order {
-		//fmt.Println(name)
+	stageorders := request.Input_stages
+	stages := request.Solution_stages
 
-		// cmpa holds a list of inputs required per destination
-		// i.e. if destination X requires 15 ul of h2o this will be listed separately
-		// at this point all of the requests must be for volumes,
+	if len(stageorders) == 0 {
+		// everything in one stage
+		stageorders = [][]string{request.Input_order}
+		stages = make(map[string]int)
+	}
 
-		// we need a mapping from these to where they belong
-		// do we?
-		/*
-			cmpa:=value.([]map[string]interface{})
-			cmp_map:=make(map[string]interface{}, len(cmpa))
-			for _,cmp:=range cmpa{
-				srcid:=cmp.Srcid
-				cmp_map[srcid]=cmp
-			}
-		*/
-
-		for n, g := range minorlayoutgroups {
-			grp := []string(g)
-			// get the group assignment string
-
-			assignment := ass[n]
-
-			// the assignment has the format plateID:row:column:incrow:inccol
-			// where inc defines how the next one is to be calculated
-			// e.g. {GUID}:A:1:1:0
-			// 	{GUID}:A:1:0:1
-
-			asstx := strings.Split(assignment, ":")
-
-			plate := asstx[0]
-			toplatenum := wutil.ParseInt(plate)
-			row := wutil.AlphaToNum(asstx[1])
-			col := wutil.ParseInt(asstx[2])
-			incrow := wutil.ParseInt(asstx[3])
-			inccol := wutil.ParseInt(asstx[4])
-
-			whats := make([]string, len(grp))
-			pltfrom := make([]string, len(grp))
-			pltto := make([]string, len(grp))
-			plttypefrom := make([]string, len(grp))
-			plttypeto := make([]string, len(grp))
-			wellfrom := make([]string, len(grp))
-			wellto := make([]string, len(grp))
-			vols := make([]*wunit.Volume, len(grp))
-			fvols := make([]*wunit.Volume, len(grp))
-			tvols := make([]*wunit.Volume, len(grp))
-			for i, solID := range grp {
-				sol := output_solutions[solID]
-
-				// we need to get the relevant component out
-				smpl := get_aggregate_component(sol, name)
-
-				// we need to know where this component was assigned to
-				inassignmentar := []string(inass[name])
-				inassignment, ok := get_assignment(inassignmentar, &input_plates, smpl.Vol)
-
-				if !ok {
-					wutil.Error(errors.New(fmt.Sprintf("No input assignment for %s with vol %-4.1f", name, smpl.Vol)))
+	for stage, order := range stageorders {
+		for _, name := range order {
+			//fmt.Println(name)
+
+			// cmpa holds a list of inputs required per destination
+			// i.e. if destination X requires 15 ul of h2o this will be listed separately
+			// at this point all of the requests must be for volumes,
+
+			// we need a mapping from these to where they belong
+			// do we?
+			/*
+				cmpa:=value.([]map[string]interface{})
+				cmp_map:=make(map[string]interface{}, len(cmpa))
+				for _,cmp:=range cmpa{
+					srcid:=cmp.Srcid
+					cmp_map[srcid]=cmp
 				}
+			*/
+
+			// all transfers of this component are gathered up so we can
+			// find those which line up with a multichannel head
+
+			whats := make([]string, 0, len(output_solutions))
+			pltfrom := make([]string, 0, len(output_solutions))
+			pltto := make([]string, 0, len(output_solutions))
+			plttypefrom := make([]string, 0, len(output_solutions))
+			plttypeto := make([]string, 0, len(output_solutions))
+			wellfrom := make([]string, 0, len(output_solutions))
+			wellto := make([]string, 0, len(output_solutions))
+			vols := make([]*wunit.Volume, 0, len(output_solutions))
+			fvols := make([]*wunit.Volume, 0, len(output_solutions))
+			tvols := make([]*wunit.Volume, 0, len(output_solutions))
+
+			for _, g := range minorlayoutgroups {
+				grp := []string(g)
+
+				for _, solID := range grp {
+					if stages[solID] != stage {
+						continue
+					}
+
+					sol := output_solutions[solID]
+
+					// we need to get the relevant component out
+					smpl := get_aggregate_component(sol, name)
+
+					// nothing to do if this solution doesn't need any
+
+					if smpl.Vol <= 0.0 {
+						continue
+					}
+
+					// where is it coming from?
+
+					var fromplate, fromplatetype, fromwell string
+
+					if _, ok := output_solutions[name]; ok {
+						// this is made earlier on in this request
+
+						fromplate = plate_lookup[outplates[name]]
+						fromplatetype = output_plates[outplates[name]].Type
+						fromwell = outwells[name]
+					} else {
+						// we need to know where this component was assigned to
+						inassignmentar := []string(inass[name])
+						inassignment, ok := get_assignment(inassignmentar, &input_plates, smpl.Vol, request.Loss_model, name)
+
+						if !ok {
+							wutil.Error(errors.New(fmt.Sprintf("No input assignment for %s with vol %-4.1f", name, smpl.Vol)))
+						}
+
+						inasstx := strings.Split(inassignment, ":")
+
+						inplt := inasstx[0]
+						inrow := string(inasstx[1])
+						incol := wutil.ParseInt(inasstx[2])
+
+						fromplate = plate_lookup[string(inplt)]
+						fromplatetype = input_plates[inplt].Type
+						fromwell = inrow + strconv.Itoa(incol)
+					}
+
+					// we can fill the structure now
+
+					whats = append(whats, name)
+					pltfrom = append(pltfrom, fromplate)
+					pltto = append(pltto, plate_lookup[outplates[solID]])
+					plttypefrom = append(plttypefrom, fromplatetype)
+					plttypeto = append(plttypeto, output_plates[outplates[solID]].Type)
+					wellfrom = append(wellfrom, fromwell)
+					wellto = append(wellto, outwells[solID])
+					v := wunit.NewVolume(smpl.Vol, smpl.Vunit)
+					v2, v3 := move_volume(wellvols, fromplate+":"+fromwell, plate_lookup[outplates[solID]]+":"+outwells[solID], &v)
+					vols = append(vols, &v)
+					fvols = append(fvols, v2)
+					tvols = append(tvols, v3)
+				}
+			}
 
-				inasstx := strings.Split(inassignment, ":")
-
-				inplt := inasstx[0]
-				inrow := string(inasstx[1])
-				incol := wutil.ParseInt(inasstx[2])
-
-				// we can fill the structure now
-
-				whats[i] = name
-				pltfrom[i] = plate_lookup[string(inplt)]
-				pltto[i] = plate_lookup[output_plate_layout[toplatenum]]
-				wellfrom[i] = inrow + strconv.Itoa(incol)
-				wellto[i] = wutil.NumToAlpha(row) + strconv.Itoa(col)
-				v := wunit.NewVolume(smpl.Vol, smpl.Vunit)
-				v2 := wunit.NewVolume(0.0, "ul")
-				vols[i] = &v
-				// TODO Get the proper volumes here
-				fvols[i] = &v2
-				tvols[i] = &v2
-				row += incrow
-				col += inccol
+			if len(whats) == 0 {
+				continue
 			}
 
 			ins := liquidhandling.NewTransferInstruction(whats, pltfrom, pltto, wellfrom, wellto, plttypefrom, plttypeto, vols, fvols, tvols /*, parameters.Cnfvol*/)
-			instructions.Add(ins)
+
+			// multichannel transfers go first, the rest are left to
+			// the transfer instruction to do one at a time
+
+			mcb, rest := ins.MultichannelBlock(request.Policies, parameters)
+
+			if mcb != nil {
+				instructions = append(instructions, mcb)
+				instructionstages = append(instructionstages, stage)
+			}
+
+			if rest != nil {
+				instructions = append(instructions, rest)
+				instructionstages = append(instructionstages, stage)
+			}
 		}
 	}
 
-	inx := instructions.Generate(request.Policies, parameters)
-	instrx := make([]liquidhandling.TerminalRobotInstruction, len(inx))
-	for i := 0; i < len(inx); i++ {
-		instrx[i] = inx[i].(liquidhandling.TerminalRobotInstruction)
+	// the channel chooser needs to know everything it's going to be
+	// asked to move so it can keep adaptor changes down
+
+	parameters.ExpectTransfers(transfer_volumes(instructions))
+
+	// each top level instruction is generated on its own so we know which
+	// stage everything comes from - this gives the same as generating
+	// them all from one instruction set, initialize and finalize are
+	// outside any stage
+
+	instrx := make([]liquidhandling.TerminalRobotInstruction, 0, len(instructions))
+	instrxstages := make([]int, 0, len(instructions))
+
+	instrx = append(instrx, liquidhandling.NewInitializeInstruction())
+	instrxstages = append(instrxstages, -1)
+
+	// keep the generation tree so we can see later where things came from
+	request.InstructionSet = liquidhandling.NewRobotInstructionSet(nil)
+
+	for i, ins := range instructions {
+		ris := liquidhandling.NewRobotInstructionSet(ins)
+		inx := ris.Generate(request.Policies, parameters)
+		request.InstructionSet.AddSet(ris)
+		for _, in := range inx {
+			instrx = append(instrx, in.(liquidhandling.TerminalRobotInstruction))
+			instrxstages = append(instrxstages, instructionstages[i])
+		}
 	}
+
+	instrx = append(instrx, liquidhandling.NewFinalizeInstruction())
+	instrxstages = append(instrxstages, -1)
+
+	if request.Optimise_instructions {
+		opt, kept, err := liquidhandling.OptimiseInstructionsChecked(instrx)
+
+		if err != nil {
+			wutil.Warn(fmt.Sprintf("instructions for request %s not optimised: %s", request.ID, err))
+		}
+
+		optstages := make([]int, 0, len(kept))
+		dropped := make(map[liquidhandling.RobotInstruction]bool, len(instrx))
+		for _, ins := range instrx {
+			dropped[ins] = true
+		}
+		for _, i := range kept {
+			optstages = append(optstages, instrxstages[i])
+			delete(dropped, instrx[i])
+		}
+
+		// the tree has to say the same as what is going to be run
+
+		request.InstructionSet.Prune(func(ins liquidhandling.RobotInstruction) bool {
+			return dropped[ins]
+		})
+
+		instrx = opt
+		instrxstages = optstages
+	}
+
 	request.Instructions = instrx
+	request.Instruction_stages = instrxstages
 
 	return request
 }
 
+// every volume the instructions have to move
+func transfer_volumes(instructions []liquidhandling.RobotInstruction) []*wunit.Volume {
+	vols := make([]*wunit.Volume, 0, len(instructions))
+
+	for _, ins := range instructions {
+		switch v := ins.GetParameter("VOLUME").(type) {
+		case []*wunit.Volume:
+			vols = append(vols, v...)
+		case [][]*wunit.Volume:
+			for _, vv := range v {
+				vols = append(vols, vv...)
+			}
+		}
+	}
+
+	return vols
+}
+
+// the volume in each well of the input and output plates before any
+// transfers are made, keyed by position and well e.g. position_4:A1
+func well_volumes(request *LHRequest) map[string]*wunit.Volume {
+	vols := make(map[string]*wunit.Volume)
+
+	for _, plates := range []map[string]*wtype.LHPlate{request.Input_plates, request.Output_plates} {
+		for _, plate := range plates {
+			for _, well := range plate.Wellcoords {
+				v := wunit.NewVolume(well.Currvol, well.Vunit)
+				vols[request.Plate_lookup[plate.ID]+":"+strings.Replace(well.Crds, ":", "", -1)] = &v
+			}
+		}
+	}
+
+	return vols
+}
+
+// moves v from one well to another, returns what was in each of them
+// beforehand
+func move_volume(vols map[string]*wunit.Volume, from, to string, v *wunit.Volume) (*wunit.Volume, *wunit.Volume) {
+	for _, w := range []string{from, to} {
+		if _, ok := vols[w]; !ok {
+			z := wunit.NewVolume(0.0, "ul")
+			vols[w] = &z
+		}
+	}
+
+	fv := wunit.CopyVolume(vols[from])
+	tv := wunit.CopyVolume(vols[to])
+
+	vols[from].Subtract(v)
+	vols[to].Add(v)
+
+	return fv, tv
+}
+
+// works out the output plate ID and well for each solution from the
+// minor layout groups and their assignments
+func get_output_locations(minorlayoutgroups [][]string, ass []string, output_plate_layout map[int]string) (map[string]string, map[string]string) {
+	plates := make(map[string]string)
+	wells := make(map[string]string)
+
+	for n, grp := range minorlayoutgroups {
+		// the assignment has the format plateID:row:column:incrow:inccol
+		// where inc defines how the next one is to be calculated
+		// e.g. {GUID}:A:1:1:0
+		// 	{GUID}:A:1:0:1
+
+		asstx := strings.Split(ass[n], ":")
+
+		toplatenum := wutil.ParseInt(asstx[0])
+		row := wutil.AlphaToNum(asstx[1])
+		col := wutil.ParseInt(asstx[2])
+		incrow := wutil.ParseInt(asstx[3])
+		inccol := wutil.ParseInt(asstx[4])
+
+		for _, solID := range grp {
+			plates[solID] = output_plate_layout[toplatenum]
+			wells[solID] = wutil.NumToAlpha(row) + strconv.Itoa(col)
+			row += incrow
+			col += inccol
+		}
+	}
+
+	return plates, wells
+}
+
 func get_aggregate_component(sol *wtype.LHSolution, name string) *wtype.LHComponent {
 	components := sol.Components
 
@@ -188,7 +388,9 @@ func get_aggregate_component(sol *wtype.LHSolution, name string) *wtype.LHCompon
 	return ret
 }
 
-func get_assignment(assignments []string, plates *map[string]*wtype.LHPlate, vol float64) (string, bool) {
+// finds a well with enough left in it to take vol from and takes it,
+// along with whatever the loss model says is lost on the way
+func get_assignment(assignments []string, plates *map[string]*wtype.LHPlate, vol float64, loss_model *LHLossModel, liquidclass string) (string, bool) {
 	assignment := ""
 	ok := false
 
@@ -200,10 +402,11 @@ func get_assignment(assignments []string, plates *map[string]*wtype.LHPlate, vol
 		wellidlkp := plate.Wellcoords
 		well := wellidlkp[crds]
 
+		taken := vol + loss_model.TransferLoss(liquidclass, plate.Type)
+
 		currvol := well.Currvol - well.Rvol
-		if currvol >= vol {
-			vol += well.Currvol
-			well.Currvol -= vol
+		if currvol >= taken {
+			well.Currvol -= taken
 			plate.HWells[well.ID] = well
 			(*plates)[asstx[0]] = plate
 			ok = true
@@ -0,0 +1,55 @@
+// /anthalib/liquidhandling/explain.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+import (
+	"fmt"
+
+	"github.com/antha-lang/antha/antha/anthalib/driver/liquidhandling"
+)
+
+// explains the policy of every instruction in a planned request which
+// looked one up; steps are numbered by where they sit in the tree the
+// instructions were generated from, so 2.1 is the first thing the
+// second top level instruction made
+func ExplainPolicies(request *LHRequest) ([]liquidhandling.LHPolicyExplanation, error) {
+	if request.InstructionSet == nil {
+		return nil, fmt.Errorf("cannot explain request %s: it has no instruction tree, has it been planned?", request.ID)
+	}
+
+	if request.Policies == nil {
+		return nil, fmt.Errorf("cannot explain request %s: it has no policies", request.ID)
+	}
+
+	ret := make([]liquidhandling.LHPolicyExplanation, 0, 10)
+
+	request.InstructionSet.Walk(func(ins liquidhandling.RobotInstruction, step []int) {
+		if liquidhandling.UsesPolicy(ins) {
+			lhpe := request.Policies.Explain(ins)
+			lhpe.Step = liquidhandling.StepName(step)
+			ret = append(ret, lhpe)
+		}
+	})
+
+	return ret, nil
+}
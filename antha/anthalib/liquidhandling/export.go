@@ -0,0 +1,264 @@
+// /anthalib/liquidhandling/export.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/antha-lang/antha/antha/anthalib/driver/liquidhandling"
+	"github.com/antha-lang/antha/antha/anthalib/wunit"
+)
+
+// exporters write the instructions of a planned request in a form
+// robot software can read; more formats are added with
+// RegisterLHExporter
+type LHExportFunc func(w io.Writer, request *LHRequest, properties *liquidhandling.LHProperties) error
+
+type LHExporter struct {
+	Name      string
+	Extension string // for files written in this format
+	Export    LHExportFunc
+}
+
+var lhexporters = map[string]LHExporter{
+	"csv": LHExporter{
+		Name:      "csv",
+		Extension: "csv",
+		Export:    ExportCSVWorklist,
+	},
+	"json": LHExporter{
+		Name:      "json",
+		Extension: "json",
+		Export:    ExportJSONInstructions,
+	},
+	"plan": LHExporter{
+		Name:      "plan",
+		Extension: "plan.json",
+		Export:    ExportPlan,
+	},
+	"tree": LHExporter{
+		Name:      "tree",
+		Extension: "tree.txt",
+		Export:    ExportInstructionTree,
+	},
+	"trace": LHExporter{
+		Name:      "trace",
+		Extension: "trace.jsonl",
+		Export:    ExportInstructionTrace,
+	},
+}
+
+func RegisterLHExporter(exp LHExporter) {
+	lhexporters[exp.Name] = exp
+}
+
+func GetLHExporter(name string) (LHExporter, bool) {
+	exp, ok := lhexporters[name]
+	return exp, ok
+}
+
+func LHExporterNames() []string {
+	names := make([]string, 0, len(lhexporters))
+	for name, _ := range lhexporters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writes the instructions of a planned request in the given format
+func (this *Liquidhandler) Export(request *LHRequest, format string, w io.Writer) error {
+	exp, ok := GetLHExporter(format)
+
+	if !ok {
+		return fmt.Errorf("no exporter for format %s", format)
+	}
+
+	if request.Instructions == nil {
+		return fmt.Errorf("cannot export request %s: no instructions have been planned", request.ID)
+	}
+
+	return exp.Export(w, request, this.Properties)
+}
+
+func (this *Liquidhandler) ExportFile(request *LHRequest, format, filename string) error {
+	f, err := os.Create(filename)
+
+	if err != nil {
+		return err
+	}
+
+	err = this.Export(request, format, f)
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// one line per channel per dispense: where the liquid came from, where
+// it went, how much and the liquid class its policy is looked up by.
+// Air gaps aren't liquid and what is left over from multi-dispensing
+// goes back where it came from, so neither gets a line
+func ExportCSVWorklist(w io.Writer, request *LHRequest, properties *liquidhandling.LHProperties) error {
+	cw := csv.NewWriter(w)
+
+	cw.Write([]string{"SourcePlate", "SourceWell", "DestinationPlate", "DestinationWell", "Volume", "LiquidClass"})
+
+	// where each channel was last moved to and where what it holds
+	// was taken from
+	curpos := make([]string, 0, 1)
+	curwell := make([]string, 0, 1)
+	srcpos := make(map[int]string)
+	srcwell := make(map[int]string)
+
+	for _, ins := range request.Instructions {
+		switch ins.InstructionType() {
+		case liquidhandling.MOV:
+			curpos = ins.GetParameter("POSTO").([]string)
+			curwell = ins.GetParameter("WELLTO").([]string)
+		case liquidhandling.ASP:
+			vols := ins.GetParameter("VOLUME").([]*wunit.Volume)
+			whats := ins.GetParameter("WHAT").([]string)
+			for j, _ := range vols {
+				if j < len(whats) && whats[j] == liquidhandling.AirGap {
+					continue
+				}
+				if j < len(curpos) && j < len(curwell) {
+					srcpos[j] = curpos[j]
+					srcwell[j] = curwell[j]
+				}
+			}
+		case liquidhandling.DSP:
+			vols := ins.GetParameter("VOLUME").([]*wunit.Volume)
+			whats := ins.GetParameter("WHAT").([]string)
+
+			for j, v := range vols {
+				if v == nil || j >= len(curpos) || j >= len(curwell) {
+					continue
+				}
+
+				what := ""
+				if j < len(whats) {
+					what = whats[j]
+				}
+
+				if what == liquidhandling.AirGap {
+					continue
+				}
+
+				// things made earlier in the plan go by their own names
+				if sol, ok := request.Output_solutions[what]; ok && sol.SName != "" {
+					what = sol.SName
+				}
+
+				if curpos[j] == srcpos[j] && curwell[j] == srcwell[j] {
+					continue
+				}
+
+				ul := v.ConvertTo(wunit.ParsePrefixedUnit("ul"))
+
+				cw.Write([]string{plate_name(properties, srcpos[j]), srcwell[j], plate_name(properties, curpos[j]), curwell[j], fmt.Sprintf("%.2f", ul), what})
+			}
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+type lhexportedinstruction struct {
+	Name        string
+	Stage       int
+	Instruction liquidhandling.TerminalRobotInstruction
+}
+
+// every instruction in order with its name and the stage it belongs to
+func ExportJSONInstructions(w io.Writer, request *LHRequest, properties *liquidhandling.LHProperties) error {
+	out := make([]lhexportedinstruction, 0, len(request.Instructions))
+
+	for i, ins := range request.Instructions {
+		stage := -1
+
+		if len(request.Instruction_stages) == len(request.Instructions) {
+			stage = request.Instruction_stages[i]
+		}
+
+		name := ""
+		if t := ins.InstructionType(); t >= 0 && t < len(liquidhandling.Robotinstructionnames) {
+			name = liquidhandling.Robotinstructionnames[t]
+		}
+
+		out = append(out, lhexportedinstruction{Name: name, Stage: stage, Instruction: ins})
+	}
+
+	dat, err := json.MarshalIndent(out, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(dat)
+
+	return err
+}
+
+// the whole request, which can be read back with LoadLHRequest
+func ExportPlan(w io.Writer, request *LHRequest, properties *liquidhandling.LHProperties) error {
+	dat, err := json.Marshal(request)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(dat)
+
+	return err
+}
+
+// the tree the instructions were generated from, see
+// RobotInstructionSet.Print
+func ExportInstructionTree(w io.Writer, request *LHRequest, properties *liquidhandling.LHProperties) error {
+	if request.InstructionSet == nil {
+		return fmt.Errorf("request %s has no instruction tree", request.ID)
+	}
+
+	return request.InstructionSet.Print(w)
+}
+
+// the same as a line of JSON per instruction, see
+// RobotInstructionSet.Trace
+func ExportInstructionTrace(w io.Writer, request *LHRequest, properties *liquidhandling.LHProperties) error {
+	if request.InstructionSet == nil {
+		return fmt.Errorf("request %s has no instruction tree", request.ID)
+	}
+
+	return request.InstructionSet.Trace(w)
+}
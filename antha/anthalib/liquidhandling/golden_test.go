@@ -0,0 +1,304 @@
+// anthalib/liquidhandling/golden_test.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+//
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/antha-lang/antha/antha/anthalib/driver/liquidhandling"
+	"github.com/antha-lang/antha/antha/anthalib/factory"
+	"github.com/antha-lang/antha/antha/anthalib/mixer"
+	"github.com/antha-lang/antha/antha/anthalib/wtype"
+	"github.com/antha-lang/antha/antha/anthalib/wunit"
+)
+
+// the golden files hold the instruction stream planned for each of the
+// requests below; any change to planning shows up as a diff against them
+// use go test -update to rewrite them once a change has been checked
+// nothing in planning is random so there is no seed to fix, the same
+// request planned twice must give the same instructions
+
+var update = flag.Bool("update", false, "update golden files")
+
+const dataDir = "testdata"
+
+var goldenRequests = []struct {
+	name string
+	make func() *LHRequest
+}{
+	{"constructassembly", func() *LHRequest { return constructAssemblyRequest(1) }},
+	{"constructassembly_x8", func() *LHRequest { return constructAssemblyRequest(8) }},
+	{"dilutionseries", dilutionSeriesRequest},
+}
+
+// as in examples/workflows/constructassembly
+func constructAssemblyRequest(n int) *LHRequest {
+	rq := NewLHRequest()
+	rq.Policies = liquidhandling.GetLHPolicyForTest()
+	rq.Input_Setup_Weights["MAX_N_PLATES"] = 1.5
+	rq.Input_Setup_Weights["MAX_N_WELLS"] = 12.0
+	rq.Input_Setup_Weights["RESIDUAL_VOLUME_WEIGHT"] = 1.0
+	rq.Tip_Type = factory.GetTipboxByType("Gilson50")
+
+	outplate := factory.GetPlateByType("pcrplate")
+	rq.Output_platetype = outplate
+
+	for i := 0; i < n; i++ {
+		samples := make([]*wtype.LHComponent, 0, 9)
+		samples = append(samples, mixer.SampleForTotalVolume(factory.GetComponentByType("CutsmartBuffer"), wunit.NewVolume(20.0, "ul")))
+		samples = append(samples, mixer.Sample(factory.GetComponentByType("ATP"), wunit.NewVolume(1.0, "ul")))
+		samples = append(samples, mixer.SampleForConcentration(factory.GetComponentByType("standard_cloning_vector_mark_1"), wunit.NewConcentration(0.001, "g/l")))
+
+		for j := 0; j < 4; j++ {
+			samples = append(samples, mixer.SampleForConcentration(factory.GetComponentByType("dna_part"), wunit.NewConcentration(0.0001, "g/l")))
+		}
+
+		samples = append(samples, mixer.Sample(factory.GetComponentByType("SapI"), wunit.NewVolume(1.0, "ul")))
+		samples = append(samples, mixer.Sample(factory.GetComponentByType("T4Ligase"), wunit.NewVolume(1.0, "ul")))
+
+		reaction := mixer.MixInto(outplate, samples...)
+		reaction.SName = fmt.Sprintf("reaction_%d", i+1)
+		rq.Output_solutions[reaction.ID] = reaction
+	}
+
+	return rq
+}
+
+func dilutionSeriesRequest() *LHRequest {
+	rq := NewLHRequest()
+	rq.Policies = liquidhandling.GetLHPolicyForTest()
+	rq.Input_Setup_Weights["MAX_N_PLATES"] = 1.5
+	rq.Input_Setup_Weights["MAX_N_WELLS"] = 12.0
+	rq.Input_Setup_Weights["RESIDUAL_VOLUME_WEIGHT"] = 1.0
+	rq.Tip_Type = factory.GetTipboxByType("Gilson50")
+	rq.Output_platetype = factory.GetPlateByType("pcrplate")
+
+	ds := NewDilutionSeries(factory.GetComponentByType("tartrazine"), factory.GetComponentByType("water"), 10.0, 4, wunit.NewVolume(50.0, "ul"))
+	ds.MixCycles = 3
+	ds.Platetype = "pcrplate"
+	if _, err := rq.AddDilutionSeries(ds); err != nil {
+		panic(err)
+	}
+
+	return rq
+}
+
+// IDs are made afresh on every run so they are swapped for names
+func planNames(rq *LHRequest) *strings.Replacer {
+	names := make([]string, 0, 2*(len(rq.Output_solutions)+len(rq.Input_plates)+len(rq.Output_plates)))
+
+	for i, id := range sorted_solution_ids(rq.Output_solutions) {
+		name := rq.Output_solutions[id].SName
+		if name == "" {
+			name = fmt.Sprintf("solution_%d", i+1)
+		}
+		names = append(names, id, name)
+	}
+
+	for _, p := range sorted_plates(rq.Input_plates) {
+		names = append(names, p.ID, p.PlateName)
+	}
+
+	for _, p := range sorted_plates(rq.Output_plates) {
+		names = append(names, p.ID, p.PlateName)
+	}
+
+	return strings.NewReplacer(names...)
+}
+
+// plans the request from scratch and writes out the instructions one
+// per line
+func planToString(mk func() *LHRequest) []byte {
+	rq := mk()
+	lh := Init(factory.GetLiquidhandlerByType("GilsonPipetmax"))
+	if err := lh.Plan(rq); err != nil {
+		panic(err)
+	}
+
+	replacer := planNames(rq)
+
+	var buf bytes.Buffer
+
+	for _, ins := range rq.Instructions {
+		b, err := json.Marshal(ins)
+
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Fprintf(&buf, "%s %s\n", instructionName(ins), replacer.Replace(string(b)))
+	}
+
+	return buf.Bytes()
+}
+
+func instructionName(ins liquidhandling.RobotInstruction) string {
+	t := ins.InstructionType()
+	if t >= 0 && t < len(liquidhandling.Robotinstructionnames) {
+		return liquidhandling.Robotinstructionnames[t]
+	}
+	return fmt.Sprintf("INS%d", t)
+}
+
+// swaps the planning solver for s until the returned func is called
+func withLPSolver(s LPSolver) func() {
+	old := DefaultLPSolver
+	DefaultLPSolver = s
+	return func() { DefaultLPSolver = old }
+}
+
+// the golden files are made with the pure go solver whichever is the
+// default, with GLPK built in TestGoldenPlansGLPK checks it makes the same
+func TestGoldenPlans(t *testing.T) {
+	defer withLPSolver(NewSimplexSolver())()
+	checkGoldenPlans(t, *update)
+}
+
+func checkGoldenPlans(t *testing.T, update bool) {
+	for _, e := range goldenRequests {
+		golden := filepath.Join(dataDir, e.name+".golden")
+
+		if _, err := os.Stat(golden); err != nil && !update {
+			t.Errorf("%s: no golden file, run go test -update to make one", e.name)
+			continue
+		}
+
+		res := planToString(e.make)
+
+		// the same request must always give the same plan
+
+		if again := planToString(e.make); !bytes.Equal(res, again) {
+			t.Errorf("%s: planning the same request twice gave different instructions", e.name)
+			continue
+		}
+
+		if update {
+			os.MkdirAll(dataDir, 0755)
+			if err := ioutil.WriteFile(golden, res, 0644); err != nil {
+				t.Error(err)
+			}
+			continue
+		}
+
+		gld, err := ioutil.ReadFile(golden)
+
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		if err := diffLines(golden, gld, res); err != nil {
+			t.Errorf("%s: plan differs from golden file: %s", e.name, err)
+		}
+	}
+}
+
+// the calls executing each plan makes are kept alongside it and played
+// back to check they're still the same
+func TestGoldenExecution(t *testing.T) {
+	defer withLPSolver(NewSimplexSolver())()
+
+	for _, e := range goldenRequests {
+		golden := filepath.Join(dataDir, e.name+".calls")
+
+		if _, err := os.Stat(golden); err != nil && !*update {
+			t.Errorf("%s: no recorded calls, run go test -update to make them", e.name)
+			continue
+		}
+
+		rq := e.make()
+		lh := Init(factory.GetLiquidhandlerByType("GilsonPipetmax"))
+		if err := lh.Plan(rq); err != nil {
+			t.Fatal(err)
+		}
+
+		if *update {
+			var buf bytes.Buffer
+			rec := liquidhandling.NewRecordingDriver(&testDriver{}, &buf)
+			rec.Replacer = planNames(rq)
+			rec.Untimed = true
+			lh.Properties.Driver = rec
+
+			if err := lh.Execute(rq); err != nil {
+				t.Errorf("%s: %s", e.name, err)
+			} else if rec.Err() != nil {
+				t.Errorf("%s: %s", e.name, rec.Err())
+			} else if err := ioutil.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+				t.Error(err)
+			}
+			continue
+		}
+
+		f, err := os.Open(golden)
+
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		rp, err := liquidhandling.NewReplayDriver(f)
+		f.Close()
+
+		if err != nil {
+			t.Errorf("%s: %s", golden, err)
+			continue
+		}
+
+		rp.Replacer = planNames(rq)
+		lh.Properties.Driver = rp
+
+		if err := lh.Execute(rq); err != nil {
+			t.Errorf("%s: %s", e.name, err)
+		}
+
+		if err := rp.Done(); err != nil {
+			t.Errorf("%s: execution differs from recorded calls: %s", e.name, err)
+		}
+	}
+}
+
+// reports the first line at which the two differ
+func diffLines(golden string, want, got []byte) error {
+	a := strings.Split(string(want), "\n")
+	b := strings.Split(string(got), "\n")
+
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return fmt.Errorf("\n%s:%d: %s\nplanned:%d: %s", golden, i+1, a[i], i+1, b[i])
+		}
+	}
+
+	if len(a) != len(b) {
+		return fmt.Errorf("%s has %d lines, planned %d", golden, len(a), len(b))
+	}
+
+	return nil
+}
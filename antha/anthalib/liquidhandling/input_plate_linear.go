@@ -23,13 +23,14 @@
 package liquidhandling
 
 import (
+	"fmt"
 	"github.com/antha-lang/antha/antha/anthalib/wtype"
 	"github.com/antha-lang/antha/antha/anthalib/wunit"
-	"github.com/antha-lang/antha/antha/anthalib/wutil"
-	"github.com/antha-lang/antha/internal/github.com/lukpank/go-glpk/glpk"
+	"sort"
+	"strings"
 )
 
-func choose_plate_assignments(component_volumes map[string]wunit.Volume, plate_types []*wtype.LHPlate, weight_constraint map[string]float64) map[string]map[*wtype.LHPlate]int {
+func choose_plate_assignments(component_volumes map[string]wunit.Volume, plate_types []*wtype.LHPlate, weight_constraint map[string]float64) (map[string]map[*wtype.LHPlate]int, LHSolverResult) {
 
 	//
 	//	optimization is set up as follows:
@@ -54,146 +55,94 @@ func choose_plate_assignments(component_volumes map[string]wunit.Volume, plate_t
 
 	// setup
 
-	lp := glpk.New()
-	defer lp.Delete()
+	component_order := make([]string, 0, len(component_volumes))
 
-	lp.SetProbName("Assignments")
-	lp.SetObjName("Z")
+	for cmp, _ := range component_volumes {
+		component_order = append(component_order, cmp)
+	}
 
-	// CHECK THIS
+	sort.Strings(component_order)
 
-	lp.SetObjDir(glpk.MAX)
+	// from now on we always have to use component_order
 
 	// constraints:
 	// 		total component volume
 	//		number of plates
 	//		number of wells
 	n_rows := len(component_volumes) + 2
+	num_cols := len(component_order) * len(plate_types)
 
-	lp.AddRows(n_rows)
-
-	cur := 1
+	// CHECK THIS
 
-	component_order := make([]string, len(component_volumes))
+	lp := NewLPProblem("Assignments", LP_MAX, n_rows, num_cols)
 
 	// volume constraints
-	for cmp, vol := range component_volumes {
-		component_order[cur-1] = cmp
-		lp.SetRowBnds(cur, glpk.LO, vol.ConvertTo(wunit.ParsePrefixedUnit("ul")), 99999.0)
-		cur += 1
+	for i, cmp := range component_order {
+		vol := component_volumes[cmp]
+		lp.RowBounds[i] = LPBounds{Type: LP_LO, Lower: vol.ConvertTo(wunit.ParsePrefixedUnit("ul")), Upper: 99999.0}
 	}
 
-	// from now on we always have to use component_order
-
 	// plate number constraints
 
 	max_n_plates := weight_constraint["MAX_N_PLATES"] - 1.0
-	lp.SetRowBnds(cur, glpk.UP, -99999.0, max_n_plates)
-	cur += 1
+	lp.RowBounds[n_rows-2] = LPBounds{Type: LP_UP, Lower: -99999.0, Upper: max_n_plates}
 
 	// well number constraints
 	max_n_wells := weight_constraint["MAX_N_WELLS"]
-	lp.SetRowBnds(cur, glpk.UP, -99999.0, max_n_wells)
-	cur += 1
+	lp.RowBounds[n_rows-1] = LPBounds{Type: LP_UP, Lower: -99999.0, Upper: max_n_wells}
 
 	// set up the matrix columns
 
-	num_cols := len(component_order) * len(plate_types)
-	lp.AddCols(num_cols)
-	cur = 1
+	cur := 0
 
-	for _, component := range component_order {
+	for i, _ := range component_order {
 		for _, plate := range plate_types {
-			// set up objective coefficient, column name and lower bound
+			// set up objective coefficient and lower bound
 			rv := plate.Welltype.ResidualVolume()
 			coef := rv.ConvertTo(wunit.ParsePrefixedUnit("ul")) * weight_constraint["RESIDUAL_VOLUME_WEIGHT"]
-			lp.SetObjCoef(cur, coef)
-			lp.SetColName(cur, component+"_"+plate.PlateName)
-			lp.SetColBnds(cur, glpk.LO, 0.0, 0.0)
-			lp.SetColKind(cur, glpk.IV)
-			cur += 1
-		}
-	}
-
-	// now set up the constraint coefficients
-	cur = 1
-
-	ind := wutil.Series(0, num_cols)
-
-	for c, _ := range component_order {
-		row := make([]float64, num_cols+1)
-		col := 0
-		for i := 0; i < len(component_order); i++ {
-			for j := 0; j < len(plate_types); j++ {
-				vc := 0.0
-				// pick out a set of columns according to which row we're on
-				// volume constraints are the working volumes of the wells
-				if c == i {
-					vol := wunit.NewVolume(plate_types[j].Welltype.Vol, plate_types[j].Welltype.Vunit)
-					rvol := wunit.NewVolume(plate_types[j].Welltype.Rvol, plate_types[j].Welltype.Vunit)
-					vol.Subtract(&rvol)
-					vc = vol.ConvertTo(wunit.ParsePrefixedUnit("ul"))
-				}
-				row[col+1] = vc
-				col += 1
-			}
-		}
-		lp.SetMatRow(cur, ind, row)
-		cur += 1
-	}
+			lp.Objective[cur] = coef
+			lp.ColBounds[cur] = LPBounds{Type: LP_LO}
+			lp.Integer[cur] = true
 
-	// now the plate constraint
+			// volume constraints are the working volumes of the wells
+			vol := wunit.NewVolume(plate.Welltype.Vol, plate.Welltype.Vunit)
+			rvol := wunit.NewVolume(plate.Welltype.Rvol, plate.Welltype.Vunit)
+			vol.Subtract(&rvol)
+			lp.Matrix[i][cur] = vol.ConvertTo(wunit.ParsePrefixedUnit("ul"))
 
-	row := make([]float64, num_cols+1)
-	col := 1
-	for i := 0; i < len(component_order); i++ {
-		for j := 0; j < len(plate_types); j++ {
-			// the coefficient here is 1/the number of this well type per plate
-			r := 1.0 / float64(plate_types[j].Nwells)
-			row[col] = r
-			col += 1
-		}
-	}
+			// the plate coefficient is 1/the number of this well type per plate
+			lp.Matrix[n_rows-2][cur] = 1.0 / float64(plate.Nwells)
 
-	lp.SetMatRow(cur, ind, row)
-	cur += 1
-
-	// finally the well constraint
-
-	row = make([]float64, num_cols+1)
-	col = 1
-	for i := 0; i < len(component_order); i++ {
-		for j := 0; j < len(plate_types); j++ {
 			// the number of wells is constrained so we just count
-			row[col] = 1.0
-			col += 1
+			lp.Matrix[n_rows-1][cur] = 1.0
+
+			cur += 1
 		}
 	}
 
-	lp.SetMatRow(cur, ind, row)
-
-	iocp := glpk.NewIocp()
-	iocp.SetPresolve(true)
-	iocp.SetMsgLev(0)
-	lp.Intopt(iocp)
+	sol := DefaultLPSolver.Solve(lp)
 
 	// check constraints
 
 	/*
-		for i := 1; i <= n_rows; i++ {
-			fmt.Println("ROW : ", i, " VAL : ", lp.MipRowVal(i))
+		for i := 0; i < n_rows; i++ {
+			fmt.Println("ROW : ", i, " VAL : ", sol.Rows[i])
 		}
 	*/
 	// fill assignments - this is the number of wells in the plate of each type needed
 
 	assignments := make(map[string]map[*wtype.LHPlate]int, len(component_volumes))
 
-	cur = 1
+	cur = 0
 
 	for i := 0; i < len(component_order); i++ {
 		cmap := make(map[*wtype.LHPlate]int)
 		for j := 0; j < len(plate_types); j++ {
-			nwells := lp.MipColVal(cur)
+			nwells := 0.0
+
+			if sol.Status == LP_OPT || sol.Status == LP_FEAS {
+				nwells = sol.Cols[cur]
+			}
 
 			if nwells > 0 {
 				//fmt.Println(component_order[i], " : ", plate_types[j].Type, " N WELLS: ", nwells)
@@ -204,5 +153,85 @@ func choose_plate_assignments(component_volumes map[string]wunit.Volume, plate_t
 		assignments[component_order[i]] = cmap
 	}
 
-	return assignments
+	result := solver_result("input plate assignments", sol)
+
+	if !result.OK() {
+		result.Reasons = explain_plate_assignments(lp, component_order, component_volumes, plate_types, weight_constraint)
+	}
+
+	return assignments, result
+}
+
+// say which of the limits on wells and plates the inputs don't fit into
+func explain_plate_assignments(lp *LPProblem, component_order []string, component_volumes map[string]wunit.Volume, plate_types []*wtype.LHPlate, weight_constraint map[string]float64) []string {
+	nc := len(component_order)
+	np := len(plate_types)
+
+	// the limits are to blame before the volumes asked for
+	weights := make([]float64, nc+2)
+	for i := 0; i < nc; i++ {
+		weights[i] = 1000.0
+	}
+	weights[nc] = 1.0
+	weights[nc+1] = 1.0
+
+	broken, x, ok := lp_explain(lp, weights)
+
+	if !ok {
+		return []string{"cannot find out why"}
+	}
+
+	reasons := make([]string, 0, nc+2)
+
+	// the most any one well can take
+
+	maxvol := 0.0
+	for _, plate := range plate_types {
+		vol := wunit.NewVolume(plate.Welltype.Vol, plate.Welltype.Vunit)
+		rvol := wunit.NewVolume(plate.Welltype.Rvol, plate.Welltype.Vunit)
+		vol.Subtract(&rvol)
+		if v := vol.ConvertTo(wunit.ParsePrefixedUnit("ul")); v > maxvol {
+			maxvol = v
+		}
+	}
+
+	max_n_wells := weight_constraint["MAX_N_WELLS"]
+
+	if broken[nc] > 0.0 {
+		reasons = append(reasons, fmt.Sprintf("inputs need %.2f plates but MAX_N_PLATES %g leaves room for %g", lp.RowBounds[nc].Upper+broken[nc], weight_constraint["MAX_N_PLATES"], lp.RowBounds[nc].Upper))
+	}
+
+	if broken[nc+1] > 0.0 {
+		reasons = append(reasons, fmt.Sprintf("inputs need %d wells but MAX_N_WELLS allows %g", int(max_n_wells+broken[nc+1]+0.5), max_n_wells))
+	}
+
+	for i, cmp := range component_order {
+		vol := component_volumes[cmp]
+		v := vol.ConvertTo(wunit.ParsePrefixedUnit("ul"))
+
+		if broken[i] > 0.0 {
+			reasons = append(reasons, fmt.Sprintf("component %s needs %.1f ul but only %.1f ul can be assigned", cmp, v, v-broken[i]))
+			continue
+		}
+
+		if v > maxvol*max_n_wells {
+			reasons = append(reasons, fmt.Sprintf("component %s needs %.1f ul but MAX_N_WELLS allows %.1f ul", cmp, v, maxvol*max_n_wells))
+			continue
+		}
+
+		if broken[nc] == 0.0 && broken[nc+1] == 0.0 {
+			continue
+		}
+
+		wells := make([]string, 0, np)
+		for j, plate := range plate_types {
+			if n := int(x[i*np+j] + 0.5); n > 0 {
+				wells = append(wells, fmt.Sprintf("%d wells of %s", n, plate.Type))
+			}
+		}
+
+		reasons = append(reasons, fmt.Sprintf("component %s needs %.1f ul in %s", cmp, v, strings.Join(wells, " and ")))
+	}
+
+	return reasons
 }
@@ -35,7 +35,7 @@ import (
 // INPUT: 	"input_platetype", "inputs"
 //OUTPUT: 	"input_plates"      -- these each have components in wells
 //		"input_assignments" -- map with arrays of assignment strings, i.e. {tea: [plate1:A:1, plate1:A:2...] }etc.
-func input_plate_setup(request *LHRequest) *LHRequest {
+func input_plate_setup(request *LHRequest) (*LHRequest, error) {
 	input_platetypes := (*request).Input_platetypes
 	if input_platetypes == nil || len(input_platetypes) == 0 {
 		// this configuration needs to happen outside but for now...
@@ -61,7 +61,10 @@ func input_plate_setup(request *LHRequest) *LHRequest {
 	input_volumes := make(map[string]wunit.Volume, len(inputs))
 
 	// aggregate the volumes for the inputs
-	for k, v := range inputs {
+	input_names := sorted_input_names(inputs)
+
+	for _, k := range input_names {
+		v := inputs[k]
 		v2 := v[0].Volume()
 		vol := &v2
 		for i := 1; i < len(v); i++ {
@@ -75,15 +78,63 @@ func input_plate_setup(request *LHRequest) *LHRequest {
 	weights_constraints := request.Input_Setup_Weights
 
 	// get the assignments
+	// we need to load more than we move to cover losses along the way
+	// but how much depends on what the inputs are put in and how many
+	// wells they need, so we go round until the assignments settle down
+
+	loss_model := request.Loss_model
+	required_volumes := make(map[string]wunit.Volume, len(input_volumes))
+	loss_labware := make(map[string]string, len(input_volumes))
+	loss_nwells := make(map[string]int, len(input_volumes))
+
+	for k, _ := range input_volumes {
+		loss_nwells[k] = 1
+	}
+
+	var well_count_assignments map[string]map[*wtype.LHPlate]int
+
+	for iter := 0; iter < 5; iter++ {
+		for _, k := range input_names {
+			v := input_volumes[k]
+			r := loss_model.RequiredVolume(k, loss_labware[k], v.ConvertToString("ul"), len(inputs[k]), loss_nwells[k])
+			required_volumes[k] = wunit.NewVolume(r, "ul")
+		}
+
+		var result LHSolverResult
+		well_count_assignments, result = choose_plate_assignments(required_volumes, input_platetypes, weights_constraints)
+
+		if err := result.Err(); err != nil {
+			return request, err
+		}
+
+		settled := true
+
+		for _, k := range input_names {
+			nwells, labware := worst_case_labware(loss_model, k, len(inputs[k]), well_count_assignments[k])
+
+			if nwells != loss_nwells[k] || labware != loss_labware[k] {
+				settled = false
+			}
+
+			loss_nwells[k] = nwells
+			loss_labware[k] = labware
+		}
+
+		if settled {
+			break
+		}
+	}
+
+	// this is how much of each needs to be loaded
 
-	well_count_assignments := choose_plate_assignments(input_volumes, input_platetypes, weights_constraints)
+	(*request).Input_volumes = required_volumes
 
 	input_assignments := make(map[string][]string, len(well_count_assignments))
 
 	plates_in_play := make(map[string]*wtype.LHPlate)
 
 	curplaten := 1
-	for cname, volume := range input_volumes {
+	for _, cname := range input_names {
 		component := inputs[cname][0]
 		//fmt.Println("Plate_setup - component", cname, ":")
 
@@ -95,7 +146,8 @@ func input_plate_setup(request *LHRequest) *LHRequest {
 		var ok bool
 		ass := make([]string, 0, 3)
 
-		for platetype, nwells := range well_assignments {
+		for _, platetype := range sorted_plate_assignments(well_assignments) {
+			nwells := well_assignments[platetype]
 			for i := 0; i < nwells; i++ {
 				curr_plate = plates_in_play[platetype.Type]
 
@@ -112,6 +164,10 @@ func input_plate_setup(request *LHRequest) *LHRequest {
 
 				if !ok {
 					plates_in_play[platetype.Type] = factory.GetPlateByType(platetype.Type)
+					curr_plate = plates_in_play[platetype.Type]
+					platename := fmt.Sprintf("Input_plate_%d", curplaten)
+					curr_plate.PlateName = platename
+					curplaten += 1
 					curr_well, ok = wtype.Get_Next_Well(curr_plate, component, nil)
 				}
 
@@ -130,7 +186,6 @@ func input_plate_setup(request *LHRequest) *LHRequest {
 				newcomponent := component.Dup()
 				newcomponent.Vol = curr_well.Vol
 				newcomponent.Loc = location
-				volume.Subtract(curr_well.WorkingVolume())
 
 				contents = append(contents, newcomponent)
 
@@ -146,5 +201,42 @@ func input_plate_setup(request *LHRequest) *LHRequest {
 	(*request).Input_plates = input_plates
 	(*request).Input_assignments = input_assignments
 	//return input_plates, input_assignments
-	return request
+	return request, nil
+}
+
+// the total number of wells assigned to a component and the labware
+// among them which loses the most
+func worst_case_labware(loss_model *LHLossModel, cname string, ntransfers int, assignments map[*wtype.LHPlate]int) (int, string) {
+	nwells := 0
+	labware := ""
+	worst := -1.0
+
+	for _, plate := range sorted_plate_assignments(assignments) {
+		n := assignments[plate]
+		nwells += n
+
+		l := loss_model.TransferLoss(cname, plate.Type)*float64(ntransfers) + loss_model.EvaporationLoss(cname, plate.Type)
+
+		if l > worst || (l == worst && plate.Type < labware) {
+			worst = l
+			labware = plate.Type
+		}
+	}
+
+	if nwells == 0 {
+		nwells = 1
+	}
+
+	return nwells, labware
+}
+
+// plate types in a stable order
+func sorted_plate_assignments(assignments map[*wtype.LHPlate]int) []*wtype.LHPlate {
+	plates := make(map[string]*wtype.LHPlate, len(assignments))
+
+	for plate, _ := range assignments {
+		plates[plate.ID] = plate
+	}
+
+	return sorted_plates(plates)
 }
@@ -68,8 +68,8 @@ func BasicLayoutAgent(request *LHRequest, params *liquidhandling.LHProperties) *
 
 	max_major_group_size := plate.Nwells
 
-	for _, soln := range solutions {
-		id := soln.ID
+	for _, id := range sorted_solution_ids(solutions) {
+		soln := solutions[id]
 
 		lg := soln.Majorlayoutgroup
 
@@ -97,14 +97,18 @@ func BasicLayoutAgent(request *LHRequest, params *liquidhandling.LHProperties) *
 	minor_group_layouts := make([][]string, 0, len(solutions))
 	assignments := make([]string, len(solutions))
 
-	for i, grp := range MajorLayoutGroups {
+	for _, i := range sorted_group_keys(MajorLayoutGroups) {
+		grp := MajorLayoutGroups[i]
 		dplate := plateLayouts[i]
 
 		plate_minor_groups, plate_assignments := assign_minor_layouts(grp, plate, dplate)
 
+		// minor group indices run across all plates
+		offset := len(minor_group_layouts)
+
 		minor_group_layouts = append(minor_group_layouts, plate_minor_groups...)
 		for j, as := range plate_assignments {
-			assignments[j] = as
+			assignments[offset+j] = as
 		}
 	}
 	request.Output_minor_group_layouts = minor_group_layouts
@@ -118,6 +122,8 @@ func assign_minor_layouts(group []string, plate *wtype.LHPlate, plateID string)
 	masss = make(map[int]string, 10)
 
 	// in this version we just use the number of wells in a column
+	// each group fills one column from the top down, which keeps
+	// the group in line with a vertical multichannel head
 
 	colsize := plate.WlsY
 
@@ -127,10 +133,9 @@ func assign_minor_layouts(group []string, plate *wtype.LHPlate, plateID string)
 	for i := 0; i < len(group); i += colsize {
 		// make a layout group
 
-		grp := make([]string, 0, 8)
-		grpsize := len(group) - i
+		grp := make([]string, 0, colsize)
 
-		for j := 0; j < grpsize; j++ {
+		for j := 0; j < colsize; j++ {
 			if i+j >= len(group) {
 				break
 			}
@@ -139,7 +144,7 @@ func assign_minor_layouts(group []string, plate *wtype.LHPlate, plateID string)
 
 		// get its assignment
 
-		ass := plateID + ":" + wutil.NumToAlpha(row) + ":" + strconv.Itoa(col) + ":" + strconv.Itoa(0) + ":" + strconv.Itoa(1)
+		ass := plateID + ":" + wutil.NumToAlpha(row) + ":" + strconv.Itoa(col) + ":" + strconv.Itoa(1) + ":" + strconv.Itoa(0)
 
 		mgrps = append(mgrps, grp)
 		masss[col-1] = ass
@@ -150,7 +155,8 @@ func assign_minor_layouts(group []string, plate *wtype.LHPlate, plateID string)
 
 func choose_major_layout_group(groups map[int][]string, mx int) int {
 	g := 0
-	for x, ar := range groups {
+	for _, x := range sorted_group_keys(groups) {
+		ar := groups[x]
 		if len(ar) < mx {
 			g = x
 			break
@@ -169,7 +175,7 @@ func do_major_layouts(request *LHRequest, majorlayoutgroups map[int][]string) ma
 		plateLayouts = make(map[int]string, 10)
 
 		platenum := 0
-		for k, _ := range majorlayoutgroups {
+		for _, k := range sorted_group_keys(majorlayoutgroups) {
 			plateLayouts[k] = strconv.Itoa(platenum)
 			platenum += 1
 		}
@@ -184,7 +190,8 @@ func getLayoutGroups(solutions map[string]*wtype.LHSolution) ([]int, []int) {
 	MajorLayoutGroupIDs := make([]int, 0, 4)
 	MinorLayoutGroupIDs := make([]int, 0, 4)
 
-	for _, s := range solutions {
+	for _, id := range sorted_solution_ids(solutions) {
+		s := solutions[id]
 		Mlg := 0
 		Mlg = s.Majorlayoutgroup
 		MajorLayoutGroupIDs = append(MajorLayoutGroupIDs, Mlg)
@@ -24,8 +24,10 @@
 package liquidhandling
 
 import (
+	"fmt"
 	"github.com/antha-lang/antha/antha/anthalib/driver/liquidhandling"
 	"github.com/antha-lang/antha/antha/anthalib/wtype"
+	"github.com/antha-lang/antha/antha/anthalib/wunit"
 )
 
 // structure for defining a request to the liquid handler
@@ -42,8 +44,12 @@ type LHRequest struct {
 	Setup                      wtype.LHSetup
 	InstructionSet             *liquidhandling.RobotInstructionSet
 	Instructions               []liquidhandling.TerminalRobotInstruction
+	Instruction_stages         []int // stage each instruction belongs to, -1 for none
+	Optimise_instructions      bool  // drop instructions which don't change what the robot does
 	Robotfn                    string
 	Outputfn                   string
+	Checkpointfn               string        // where to save the checkpoint if execution stops
+	Checkpoint                 *LHCheckpoint // where to resume execution from, nil to start at the beginning
 	Input_assignments          map[string][]string
 	Output_assignments         []string
 	Input_plates               map[string]*wtype.LHPlate
@@ -61,6 +67,13 @@ type LHRequest struct {
 	Stockconcs                 map[string]float64
 	Policies                   *liquidhandling.LHPolicyRuleSet
 	Input_order                []string
+	Input_stages               [][]string              // component order for each stage of a multi-step request
+	Solution_stages            map[string]int          // stage each output solution is made in
+	Input_volumes              map[string]wunit.Volume // how much of each input must be loaded
+	Loss_model                 *LHLossModel
+	Deck_state                 *LHDeckState                 // tips and waste left from a previous run, nil to start afresh
+	Final_deck_state           *LHDeckState                 // how this request leaves them
+	Properties                 *liquidhandling.LHProperties // the liquid handler as it was planned for
 }
 
 func NewLHRequest() *LHRequest {
@@ -83,6 +96,11 @@ func NewLHRequest() *LHRequest {
 	lhr.Plate_lookup = make(map[string]string)
 	lhr.Stockconcs = make(map[string]float64)
 	lhr.Input_order = make([]string, 0)
+	lhr.Input_stages = make([][]string, 0)
+	lhr.Instruction_stages = make([]int, 0)
+	lhr.Optimise_instructions = true
+	lhr.Input_volumes = make(map[string]wunit.Volume)
+	lhr.Loss_model = NewLHLossModel()
 	return &lhr
 }
 
@@ -91,12 +109,31 @@ type LHPolicyManager struct {
 	UserPolicies   *liquidhandling.LHPolicyRuleSet
 }
 
-func (mgr *LHPolicyManager) MergePolicies(protocolpolicies *liquidhandling.LHPolicyRuleSet) *liquidhandling.LHPolicyRuleSet {
+// items in the result remember whether they came from the system, user
+// or protocol policies, see LHPolicyRuleSet.SourceOf. User or protocol
+// policies with bad values in them are an error
+func (mgr *LHPolicyManager) MergePolicies(protocolpolicies *liquidhandling.LHPolicyRuleSet) (*liquidhandling.LHPolicyRuleSet, error) {
 	ret := liquidhandling.CloneLHPolicyRuleSet(mgr.SystemPolicies)
+	ret.Source = "system"
 
 	// things coming in take precedence over things already there
-	ret.MergeWith(mgr.UserPolicies)
-	ret.MergeWith(protocolpolicies)
+	if mgr.UserPolicies != nil {
+		if err := ret.MergeWith(labelPolicies(mgr.UserPolicies, "user")); err != nil {
+			return nil, fmt.Errorf("user policies: %s", err)
+		}
+	}
+	if protocolpolicies != nil {
+		if err := ret.MergeWith(labelPolicies(protocolpolicies, "protocol")); err != nil {
+			return nil, fmt.Errorf("protocol policies: %s", err)
+		}
+	}
 
-	return ret
+	return ret, nil
+}
+
+// a copy of lhpr which says it came from source, lhpr itself is left alone
+func labelPolicies(lhpr *liquidhandling.LHPolicyRuleSet, source string) *liquidhandling.LHPolicyRuleSet {
+	ret := *lhpr
+	ret.Source = source
+	return &ret
 }
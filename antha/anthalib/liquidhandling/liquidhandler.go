@@ -29,7 +29,11 @@ import (
 	"github.com/antha-lang/antha/antha/anthalib/driver/liquidhandling/manual"
 	"github.com/antha-lang/antha/antha/anthalib/factory"
 	"github.com/antha-lang/antha/antha/anthalib/wtype"
+	"github.com/antha-lang/antha/antha/anthalib/wutil"
 	"log"
+	"os"
+	"sort"
+	"strings"
 )
 
 // the liquid handler structure defines the interface to a particular liquid handling
@@ -46,6 +50,9 @@ import (
 // - layout (LayoutAgent): how experiments are assigned to outputs
 // - execution (ExecutionPlanner): generates instructions to implement the required plan
 //
+// if the driver reports a failure while running the instructions the RecoveryAgent,
+// if any, decides whether to retry, skip the transfer or stop
+//
 // The general mechanism by which requests which refer to specific items as opposed to
 // those which only state that an item of a particular kind is required is by the definition
 // of an 'inst' tag in the request structure with a guid. If this is defined and valid
@@ -57,6 +64,7 @@ type Liquidhandler struct {
 	SetupAgent       func(*LHRequest, *liquidhandling.LHProperties) *LHRequest
 	LayoutAgent      func(*LHRequest, *liquidhandling.LHProperties) *LHRequest
 	ExecutionPlanner func(*LHRequest, *liquidhandling.LHProperties) *LHRequest
+	RecoveryAgent    func(*LHRequest, *LHCheckpoint) LHRecoveryAction
 	PolicyManager    *LHPolicyManager
 }
 
@@ -100,7 +108,7 @@ func (this *Liquidhandler) InitializeDriver(request *LHRequest) error {
 
 // high-level function which requests planning and execution for an incoming set of
 // solutions
-func (this *Liquidhandler) MakeSolutions(request *LHRequest) *LHRequest {
+func (this *Liquidhandler) MakeSolutions(request *LHRequest) (*LHRequest, error) {
 	// the minimal request which is possible defines what solutions are to be made
 	if request.Output_solutions == nil {
 		RaiseError("No solutions defined")
@@ -115,25 +123,105 @@ func (this *Liquidhandler) MakeSolutions(request *LHRequest) *LHRequest {
 		}
 	}
 
-	this.Plan(request)
-	this.Execute(request)
-	return request
+	if err := this.Plan(request); err != nil {
+		return request, err
+	}
+
+	// carry on from wherever a previous run stopped
+
+	if err := this.resume_from_file(request); err != nil {
+		return request, err
+	}
+
+	err := this.Execute(request)
+	return request, err
 }
 
-// run the request via the driver
+// run the request via the driver; if the request has a checkpoint we
+// carry on from the moves leading up to the instruction it stopped at,
+// having initialized the robot first. If an instruction fails and we
+// stop, the request is left with a checkpoint which is also saved to
+// Checkpointfn, if set, so it can be resumed once the problem has been
+// dealt with
 func (this *Liquidhandler) Execute(request *LHRequest) error {
+	instructions := (*request).Instructions
+
+	if instructions == nil {
+		RaiseError("Cannot execute request: no instructions")
+	}
+
+	start := 0
+
+	if request.Checkpoint != nil {
+		if request.Checkpoint.RequestID != request.ID {
+			return fmt.Errorf("cannot resume request %s from a checkpoint for request %s", request.ID, request.Checkpoint.RequestID)
+		}
+		start = resume_point(instructions, request.Checkpoint.Instruction)
+	}
+
 	// set up the robot
 
 	this.do_setup(request)
 
-	instructions := (*request).Instructions
+	for i := 0; i < start; i++ {
+		if instructions[i].InstructionType() != liquidhandling.INI {
+			continue
+		}
 
-	if instructions == nil {
-		RaiseError("Cannot execute request: no instructions")
+		if status := instructions[i].OutputTo(this.Properties.Driver); !status.OK {
+			return fmt.Errorf("cannot initialize to resume request %s: %s", request.ID, status.Msg)
+		}
+	}
+
+	for i := start; i < len(instructions); {
+		status := instructions[i].OutputTo(this.Properties.Driver)
+
+		if status.OK {
+			i += 1
+			continue
+		}
+
+		cp := make_checkpoint(request, this.Properties, i, status)
+
+		if request.Checkpointfn != "" {
+			if err := cp.Save(request.Checkpointfn); err != nil {
+				return fmt.Errorf("instruction %d (%s) failed: %s; cannot save checkpoint: %s", i, cp.Name, status.Msg, err.Error())
+			}
+		}
+
+		action := LHAbort
+
+		if this.RecoveryAgent != nil {
+			action = this.RecoveryAgent(request, cp)
+		}
+
+		if action == LHRetry {
+			continue
+		}
+
+		if action == LHSkip {
+			next, err := skip_to(instructions, i)
+
+			if err == nil {
+				i = next
+				continue
+			}
+
+			status.Msg += fmt.Sprintf("; cannot skip it: %s", err.Error())
+		}
+
+		request.Checkpoint = cp
+		return fmt.Errorf("instruction %d (%s) failed: %s", i, cp.Name, status.Msg)
 	}
 
-	for _, ins := range instructions {
-		ins.(liquidhandling.TerminalRobotInstruction).OutputTo(this.Properties.Driver)
+	// all done: nothing to resume from
+
+	request.Checkpoint = nil
+
+	if request.Checkpointfn != "" {
+		if err := os.Remove(request.Checkpointfn); err != nil && !os.IsNotExist(err) {
+			return err
+		}
 	}
 
 	return nil
@@ -141,7 +229,16 @@ func (this *Liquidhandler) Execute(request *LHRequest) error {
 
 func (this *Liquidhandler) do_setup(rq *LHRequest) {
 	this.Properties.Driver.RemoveAllPlates()
-	for position, plateid := range this.Properties.PosLookup {
+
+	// put things on the deck in the same order every time
+	positions := make([]string, 0, len(this.Properties.PosLookup))
+	for position, _ := range this.Properties.PosLookup {
+		positions = append(positions, position)
+	}
+	sort.Strings(positions)
+
+	for _, position := range positions {
+		plateid := this.Properties.PosLookup[position]
 		if plateid == "" {
 			continue
 		}
@@ -175,26 +272,73 @@ func (this *Liquidhandler) do_setup(rq *LHRequest) {
 //
 // this should be OK since the LHRequest parameterises all state including instructions
 // for asynchronous drivers we have to determine how far the program got before it was
-// paused, which should be tricky but possible.
+// paused: Execute keeps a checkpoint of the instruction which failed and the state of
+// the tips so it can carry on from there.
 //
 // need to find a good way to codify the rules of the system:
 // essentially the question is what happens to inputs pre-defined.
 // I will define this asap
 //
 
-func (this *Liquidhandler) Plan(request *LHRequest) {
+// Plan returns an error if the request can't be planned, e.g. if the
+// inputs don't fit within the limits given
+func (this *Liquidhandler) Plan(request *LHRequest) error {
+	// policies can come straight from the protocol so need checking
+	// before anything gets generated from them
+
+	if request.Policies != nil {
+		warnings, err := request.Policies.Validate()
+
+		for _, w := range warnings {
+			wutil.Warn(w)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// solutions can be made from each other but not in circles
+
+	stages, err := solution_stages(request.Output_solutions)
+
+	if err != nil {
+		return err
+	}
+
+	request.Solution_stages = stages
+
 	// convert requests to volumes and determine required stock concentrations
-	solutions, stockconcs := solution_setup(request, this.Properties)
+	solutions, stockconcs, err := solution_setup(request, this.Properties)
+
+	if err != nil {
+		return err
+	}
+
 	request.Output_solutions = solutions
 	request.Stockconcs = stockconcs
 
+	// anything used to make something else needs enough in it
+
+	if err := check_intermediate_volumes(request); err != nil {
+		return err
+	}
+
 	// looks at components, determines what inputs are required and
 	// requests them
-	request = this.GetInputs(request)
+	request, err = this.GetInputs(request)
+
+	if err != nil {
+		return err
+	}
 
 	// define the input plates
 
-	request = input_plate_setup(request)
+	request, err = input_plate_setup(request)
+
+	if err != nil {
+		return err
+	}
 
 	// set up the mapping of the outputs
 	// this assumes the input plates are set
@@ -213,45 +357,64 @@ func (this *Liquidhandler) Plan(request *LHRequest) {
 	// define the tip boxes - this will depend on the execution plan
 	request = this.Tip_box_setup(request)
 
+	// everything needed to run the plan later goes with it
+
+	request.Properties = this.Properties
+
+	return nil
 }
 
 // request the inputs which are needed to run the plan, unless they have already
-// been requested
-func (this *Liquidhandler) GetInputs(request *LHRequest) *LHRequest {
+// been requested; the stage each solution is made in must already be known
+func (this *Liquidhandler) GetInputs(request *LHRequest) (*LHRequest, error) {
 	solutions := (*request).Output_solutions
 	inputs := make(map[string][]*wtype.LHComponent, 3)
 
-	order := make(map[string]map[string]int, 3)
+	// solutions made from other solutions have to wait for them
+	// so the ordering is worked out separately for each stage
 
-	for _, solution := range solutions {
+	stages := request.Solution_stages
+	order := make([]map[string]map[string]int, count_stages(stages))
+
+	for i := 0; i < len(order); i++ {
+		order[i] = make(map[string]map[string]int, 3)
+	}
+
+	for _, id := range sorted_solution_ids(solutions) {
+		solution := solutions[id]
 		// components are either other solutions or come in as inputs
-		// this needs solving too
 		components := solution.Components
+		stageorder := order[stages[solution.ID]]
 
 		for _, component := range components {
 			component.Destination = solution.ID
-			cmps, ok := inputs[component.CName]
-			if !ok {
-				cmps = make([]*wtype.LHComponent, 0, 3)
-			}
 
-			cmps = append(cmps, component)
-			inputs[component.CName] = cmps
+			// anything made earlier in this request is not an input
+
+			if component.Source == "" {
+				cmps, ok := inputs[component.CName]
+				if !ok {
+					cmps = make([]*wtype.LHComponent, 0, 3)
+				}
+
+				cmps = append(cmps, component)
+				inputs[component.CName] = cmps
+			}
 
 			for j := 0; j < len(components); j++ {
 				if component.Order < components[j].Order {
-					m, ok := order[component.CName]
+					m, ok := stageorder[component.CName]
 					if !ok {
 						m = make(map[string]int, len(components))
-						order[component.CName] = m
+						stageorder[component.CName] = m
 					}
 
 					m[components[j].CName] += 1
 				} else {
-					m, ok := order[components[j].CName]
+					m, ok := stageorder[components[j].CName]
 					if !ok {
 						m = make(map[string]int, len(components))
-						order[components[j].CName] = m
+						stageorder[components[j].CName] = m
 					}
 					m[component.CName] += 1
 				}
@@ -262,7 +425,27 @@ func (this *Liquidhandler) GetInputs(request *LHRequest) *LHRequest {
 
 	// define component ordering
 
-	component_order := DefineOrderOrFail(order)
+	component_order := make([]string, 0, len(inputs))
+	seen := make(map[string]bool, len(inputs))
+	request.Input_stages = make([][]string, len(order))
+
+	for i, stageorder := range order {
+		stage, err := DefineOrderOrFail(stageorder)
+
+		if err != nil {
+			return request, err
+		}
+
+		request.Input_stages[i] = stage
+
+		for _, name := range request.Input_stages[i] {
+			if !seen[name] {
+				component_order = append(component_order, name)
+				seen[name] = true
+			}
+		}
+	}
+
 	(*request).Input_order = component_order
 
 	var requestinputs map[string][]*wtype.LHComponent
@@ -284,86 +467,111 @@ func (this *Liquidhandler) GetInputs(request *LHRequest) *LHRequest {
 	//return requestinputs
 	(*request).Input_solutions = requestinputs
 
-	// fix some tips in place
-	// TODO this has to be sorted out
+	// tips: anything left from a previous run goes back where it was
+	// and we make sure there's a box of the tips we want, more are set
+	// out as the instructions need them
+
+	// TODO the tip waste should be part of the liquid handler description
+
+	if len(this.Properties.Tipwastes) == 0 {
+		this.Properties.AddTipWaste("position_1", factory.GetTipwasteByType("Gilsontipwaste"))
+	}
 
-	max_n_tipboxes := 2
+	if request.Deck_state != nil {
+		request.Deck_state.setup(this.Properties)
+	}
 
-	for i := 0; i < max_n_tipboxes; i++ {
+	if !has_tipbox_for(this.Properties, request.Tip_Type.Tiptype.Type) {
 		this.Properties.AddTipBox(request.Tip_Type.Dup())
 	}
 
-	// finally we have to add a waste
+	// boxes of the other tips the machine takes go out if a volume
+	// needs them
 
-	waste := factory.GetTipwasteByType("Gilsontipwaste")
+	for _, tip := range this.Properties.Tips {
+		if _, ok := this.Properties.TipboxLibrary[tip.Type]; !ok {
+			if tb := factory.GetTipboxByType(tip.Type); tb != nil {
+				this.Properties.TipboxLibrary[tip.Type] = tb
+			}
+		}
+	}
 
-	this.Properties.AddTipWaste("position_1", waste)
+	return request, nil
+}
 
-	return request
+func has_tipbox_for(properties *liquidhandling.LHProperties, tiptype string) bool {
+	for _, tb := range properties.Tipboxes {
+		if tb.Tiptype.Type == tiptype && tb.NTips > 0 {
+			return true
+		}
+	}
+	return false
 }
 
-func DefineOrderOrFail(mapin map[string]map[string]int) []string {
-	cmps := make([]string, 0, 1)
+// sorts components so that each comes after everything which has to
+// go in before it; mapin[a][b] > 0 means a goes before b
+// ties are broken by name so the order is stable
+func DefineOrderOrFail(mapin map[string]map[string]int) ([]string, error) {
+	// count how many things each component has to wait for
 
-	for name, _ := range mapin {
-		cmps = append(cmps, name)
-	}
+	waitingfor := make(map[string]int, len(mapin))
 
-	ord := make([][]string, len(cmps))
+	for name, after := range mapin {
+		if _, ok := waitingfor[name]; !ok {
+			waitingfor[name] = 0
+		}
 
-	mx := 0
-	for i := 0; i < len(cmps); i++ {
-		cnt := 0
-		for j := 0; j < len(cmps); j++ {
-			if i == j {
+		for next, n := range after {
+			if next == name || n == 0 {
 				continue
 			}
+			waitingfor[next] += 1
+		}
+	}
 
-			// only one side can be > 0
+	ready := make([]string, 0, len(waitingfor))
 
-			c1 := mapin[cmps[i]][cmps[j]]
-			c2 := mapin[cmps[j]][cmps[i]]
+	for name, n := range waitingfor {
+		if n == 0 {
+			ready = append(ready, name)
+		}
+	}
 
-			if c1 > 0 && c2 > 0 {
-				log.Fatal("CANNOT DEAL WITH INCONSISTENT COMPONENT ORDERING")
-			}
+	ret := make([]string, 0, len(waitingfor))
 
-			// if c1 > 0 we add to the count
+	for len(ready) != 0 {
+		sort.Strings(ready)
+		name := ready[0]
+		ready = ready[1:]
+		ret = append(ret, name)
 
-			if c1 > 0 {
-				cnt += 1
+		for next, n := range mapin[name] {
+			if next == name || n == 0 {
+				continue
 			}
-		}
-
-		a := ord[cnt]
 
-		if a == nil {
-			a = make([]string, 0, 3)
-		}
+			waitingfor[next] -= 1
 
-		a = append(a, cmps[i])
-		if cnt > mx {
-			mx = cnt
+			if waitingfor[next] == 0 {
+				ready = append(ready, next)
+			}
 		}
-		ord[cnt] = a
 	}
 
-	ret := make([]string, 0, len(cmps))
+	// anything left over is part of a cycle
 
-	// take in reverse order
-
-	for j := mx; j >= 0; j-- {
-		a := ord[j]
-		if a == nil {
-			continue
-		}
-
-		for _, name := range a {
-			ret = append(ret, name)
+	if len(ret) != len(waitingfor) {
+		left := make([]string, 0, len(waitingfor)-len(ret))
+		for name, n := range waitingfor {
+			if n > 0 {
+				left = append(left, name)
+			}
 		}
+		sort.Strings(left)
+		return nil, fmt.Errorf("inconsistent component ordering: %s have to go in both before and after each other", strings.Join(left, ", "))
 	}
 
-	return ret
+	return ret, nil
 }
 
 /*
@@ -450,5 +658,8 @@ func (this *Liquidhandler) ExecutionPlan(request *LHRequest) *LHRequest {
 	// finally define the instructions which will enact the transfers
 	// this is quite involved, we need a strategy to do this
 
+	// tips are counted as the instructions are made
+	this.Properties.ResetTipTracking()
+
 	return this.ExecutionPlanner(request, this.Properties)
 }
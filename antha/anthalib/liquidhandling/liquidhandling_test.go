@@ -23,12 +23,24 @@
 package liquidhandling
 
 import (
+	"bytes"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"math"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/antha-lang/antha/antha/anthalib/driver"
+	"github.com/antha-lang/antha/antha/anthalib/driver/liquidhandling"
 	"github.com/antha-lang/antha/antha/anthalib/factory"
+	"github.com/antha-lang/antha/antha/anthalib/mixer"
 	"github.com/antha-lang/antha/antha/anthalib/wtype"
 	"github.com/antha-lang/antha/antha/anthalib/wunit"
 )
@@ -53,7 +65,7 @@ func TestStockConcs(*testing.T) {
 		T[name] = 100.0
 	}
 
-	cncs := choose_stock_concentrations(minrequired, maxrequired, Smax, vmin, T)
+	cncs, _ := choose_stock_concentrations(minrequired, maxrequired, Smax, vmin, T)
 	cncs = cncs
 	for k, v := range cncs {
 		fmt.Println(k, " ", minrequired[k], " ", maxrequired[k], " ", T[k], " ", v)
@@ -62,16 +74,28 @@ func TestStockConcs(*testing.T) {
 
 // need to test marshalling components
 
-func _TestMarshal(*testing.T) {
+func TestMarshal(t *testing.T) {
 	lhc := wtype.NewLHComponent()
 
+	lhc.CName = "water"
 	lhc.Vol = 34
 	lhc.Vunit = "ul"
 
-	b, _ := json.Marshal(lhc)
+	b, err := json.Marshal(lhc)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	lhc2 := wtype.NewLHComponent()
 
-	json.Unmarshal(b, &lhc2)
+	if err := json.Unmarshal(b, &lhc2); err != nil {
+		t.Fatal(err)
+	}
+
+	if lhc2.CName != "water" || lhc2.Vol != 34 || lhc2.Vunit != "ul" {
+		t.Errorf("component did not survive marshalling: %v", lhc2)
+	}
 }
 
 func TestIPLinear(*testing.T) {
@@ -106,7 +130,7 @@ func TestIPLinear(*testing.T) {
 	wtc["MAX_N_PLATES"] = 2.0
 	wtc["MAX_N_WELLS"] = 96.0
 	wtc["RESIDUAL_VOLUME_WEIGHT"] = 1.0
-	ass := choose_plate_assignments(cmps, plates, wtc)
+	ass, _ := choose_plate_assignments(cmps, plates, wtc)
 	ass = ass
 	/*
 		for component, cmap := range ass {
@@ -118,3 +142,1543 @@ func TestIPLinear(*testing.T) {
 		}
 	*/
 }
+
+func makeTestTransfer(wellfrom, wellto []string, vols []float64) *liquidhandling.TransferInstruction {
+	n := len(wellto)
+	whats := make([]string, n)
+	pltfrom := make([]string, n)
+	pltto := make([]string, n)
+	fptype := make([]string, n)
+	tptype := make([]string, n)
+	v := make([]*wunit.Volume, n)
+	fv := make([]*wunit.Volume, n)
+	tv := make([]*wunit.Volume, n)
+
+	for i := 0; i < n; i++ {
+		whats[i] = "water"
+		pltfrom[i] = "position_4"
+		pltto[i] = "position_7"
+		fptype[i] = "DWST12"
+		tptype[i] = "pcrplate"
+		vl := wunit.NewVolume(vols[i], "ul")
+		fvl := wunit.NewVolume(0.0, "ul")
+		tvl := wunit.NewVolume(0.0, "ul")
+		v[i] = &vl
+		fv[i] = &fvl
+		tv[i] = &tvl
+	}
+
+	return liquidhandling.NewTransferInstruction(whats, pltfrom, pltto, wellfrom, wellto, fptype, tptype, v, fv, tv)
+}
+
+func TestRobotInstructionNames(t *testing.T) {
+	names := liquidhandling.Robotinstructionnames
+
+	if len(names) != liquidhandling.MDT+1 {
+		t.Fatalf("%d instruction types but %d names", liquidhandling.MDT+1, len(names))
+	}
+
+	// a missing name shifts every one after it
+
+	for typ, name := range map[int]string{liquidhandling.UAD: "UAD", liquidhandling.MMX: "MMX", liquidhandling.MIX: "MIX", liquidhandling.ETW: "ETW", liquidhandling.MDT: "MDT"} {
+		if names[typ] != name {
+			t.Errorf("instruction type %d should be called %s, not %s", typ, name, names[typ])
+		}
+	}
+}
+
+func TestMixInstructionType(t *testing.T) {
+	mix := liquidhandling.NewMixInstruction()
+
+	if mix.InstructionType() != liquidhandling.MIX {
+		t.Fatalf("a mix should be a MIX, not %s", liquidhandling.Robotinstructionnames[mix.InstructionType()])
+	}
+
+	// its type says what it is read back as
+
+	b, err := liquidhandling.MarshalRobotInstruction(mix)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ins, err := liquidhandling.UnmarshalRobotInstruction(b)
+
+	if _, ok := ins.(*liquidhandling.MixInstruction); err != nil || !ok {
+		t.Errorf("a mix should be read back as a mix, got %T %v", ins, err)
+	}
+}
+
+func TestDilutionSeries(t *testing.T) {
+	sample := factory.GetComponentByType("tartrazine")
+	diluent := factory.GetComponentByType("water")
+
+	ds := NewDilutionSeries(sample, diluent, 10.0, 3, wunit.NewVolume(100.0, "ul"))
+	ds.MixCycles = 3
+
+	rq := NewLHRequest()
+	rq.Policies = liquidhandling.GetLHPolicyForTest()
+	sols, err := rq.AddDilutionSeries(ds)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sols) != 3 || len(rq.Output_solutions) != 3 {
+		t.Fatal("expected three solutions in the series")
+	}
+
+	// series which can't be made are refused without adding anything
+
+	for _, bad := range []*DilutionSeries{
+		NewDilutionSeries(sample, diluent, 1.0, 3, wunit.NewVolume(100.0, "ul")),
+		NewDilutionSeries(sample, diluent, 10.0, 0, wunit.NewVolume(100.0, "ul")),
+	} {
+		if _, err := rq.AddDilutionSeries(bad); err == nil || len(rq.Output_solutions) != 3 {
+			t.Errorf("a %g fold series of %d steps should be an error", bad.Fold, bad.Steps)
+		}
+	}
+
+	// every step should end up with 100ul after the next has taken its share
+
+	for i, sol := range sols {
+		total := 0.0
+		for _, c := range sol.Components {
+			total += c.Vol
+		}
+
+		taken := 0.0
+		if i < len(sols)-1 {
+			taken = sols[i+1].GetComponentVolume(sol.ID)
+		}
+
+		if math.Abs(total-taken-100.0) > 0.000001 {
+			t.Errorf("step %d ends up with %g ul, expected 100", i, total-taken)
+		}
+
+		if i > 0 && sol.GetComponentVolume(sols[i-1].ID)*10.0-total > 0.000001 {
+			t.Errorf("step %d is not a tenfold dilution of step %d", i, i-1)
+		}
+	}
+
+	if _, ok := rq.Policies.Rules[sols[0].ID]; !ok {
+		t.Error("expected a mixing rule for taking from the first step")
+	}
+
+	// each step has to be scheduled after the one before
+
+	lh := Init(factory.GetLiquidhandlerByType("GilsonPipetmax"))
+	rq.Tip_Type = factory.GetTipboxByType("Gilson50")
+	rq.Solution_stages, err = solution_stages(rq.Output_solutions)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rq, err = lh.GetInputs(rq)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pos := make(map[string]int, len(rq.Input_order))
+	for i, name := range rq.Input_order {
+		pos[name] = i
+	}
+
+	if pos["water"] > pos["tartrazine"] || pos["tartrazine"] > pos[sols[0].ID] || pos[sols[0].ID] > pos[sols[1].ID] {
+		t.Errorf("wrong component order %v", rq.Input_order)
+	}
+
+	if _, ok := rq.Input_solutions[sols[0].ID]; ok {
+		t.Error("intermediate solutions should not be requested as inputs")
+	}
+}
+
+func TestSolutionStages(t *testing.T) {
+	water := factory.GetComponentByType("water")
+	dna := factory.GetComponentByType("dna_part")
+
+	// assemble then dilute the assembly, then use both
+
+	w1 := water.Dup()
+	w1.Vol = 20.0
+	w1.Vunit = "ul"
+	d1 := dna.Dup()
+	d1.Vol = 5.0
+	d1.Vunit = "ul"
+	assembly := mixer.Mix(w1, d1)
+
+	w2 := water.Dup()
+	w2.Vol = 45.0
+	w2.Vunit = "ul"
+	dilution := mixer.Mix(w2, mixer.SampleSolution(assembly, wunit.NewVolume(5.0, "ul")))
+
+	w3 := water.Dup()
+	w3.Vol = 10.0
+	w3.Vunit = "ul"
+	final := mixer.Mix(w3, mixer.SampleSolution(dilution, wunit.NewVolume(10.0, "ul")), mixer.SampleSolution(assembly, wunit.NewVolume(2.0, "ul")))
+
+	rq := NewLHRequest()
+	for _, s := range []*wtype.LHSolution{assembly, dilution, final} {
+		rq.Output_solutions[s.ID] = s
+	}
+
+	stages, err := solution_stages(rq.Output_solutions)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stages[assembly.ID] != 0 || stages[dilution.ID] != 1 || stages[final.ID] != 2 {
+		t.Errorf("wrong stages %v", stages)
+	}
+
+	if err := check_intermediate_volumes(rq); err != nil {
+		t.Error(err)
+	}
+
+	// take too much of the assembly
+
+	final.Components[2].Vol = 30.0
+
+	if err := check_intermediate_volumes(rq); err == nil {
+		t.Error("expected the assembly to run short")
+	}
+
+	// water goes in at every stage: this must not be an ordering conflict
+
+	lh := Init(factory.GetLiquidhandlerByType("GilsonPipetmax"))
+	rq.Tip_Type = factory.GetTipboxByType("Gilson50")
+	rq.Solution_stages, err = solution_stages(rq.Output_solutions)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rq, err = lh.GetInputs(rq)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rq.Input_stages) != 3 {
+		t.Errorf("expected three stages, got %d", len(rq.Input_stages))
+	}
+
+	// now make a cycle
+
+	assembly.Components = append(assembly.Components, mixer.SampleSolution(final, wunit.NewVolume(1.0, "ul")))
+
+	if _, err := solution_stages(rq.Output_solutions); err == nil {
+		t.Error("expected a cycle to be found")
+	}
+
+	// two solutions in the same stage wanting their components in
+	// opposite orders can't be planned, but it's an error not a crash
+
+	w4, d4 := water.Dup(), dna.Dup()
+	w5, d5 := water.Dup(), dna.Dup()
+	for _, c := range []*wtype.LHComponent{w4, d4, w5, d5} {
+		c.Vol = 10.0
+		c.Vunit = "ul"
+	}
+
+	rq = NewLHRequest()
+	rq.Tip_Type = factory.GetTipboxByType("Gilson50")
+	for _, s := range []*wtype.LHSolution{mixer.Mix(w4, d4), mixer.Mix(d5, w5)} {
+		rq.Output_solutions[s.ID] = s
+	}
+
+	if rq.Solution_stages, err = solution_stages(rq.Output_solutions); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := lh.GetInputs(rq); err == nil || !strings.Contains(err.Error(), d4.CName) || !strings.Contains(err.Error(), w4.CName) {
+		t.Errorf("expected an error naming both components, got %v", err)
+	}
+}
+
+func TestLossModel(t *testing.T) {
+	lm := NewLHLossModel()
+	lm.Hours = 2.0
+	lm.Default = LHLossParameters{TransferLoss: 1.0, Evaporation: 0.5, Vunit: "ul"}
+	lm.LiquidClasses["glycerol"] = LHLossParameters{TransferLoss: 5.0, Vunit: "ul"}
+	lm.Labware["DWST12"] = LHLossParameters{Evaporation: 0.01, Vunit: "ml"}
+
+	// 10 transfers of water from two deep wells: 10 x 1ul + 2 x 2h x 0.5ul
+
+	if v := lm.RequiredVolume("water", "DSW96", 100.0, 10, 2); math.Abs(v-112.0) > 0.000001 {
+		t.Errorf("expected 112 ul for water, got %g", v)
+	}
+
+	// glycerol has its own transfer loss and no evaporation, the trough adds 10ul/h
+
+	if v := lm.RequiredVolume("glycerol", "DWST12", 100.0, 10, 1); math.Abs(v-170.0) > 0.000001 {
+		t.Errorf("expected 170 ul for glycerol, got %g", v)
+	}
+
+	assignments := map[*wtype.LHPlate]int{
+		factory.GetPlateByType("DSW96"):  2,
+		factory.GetPlateByType("DWST12"): 1,
+	}
+
+	nwells, labware := worst_case_labware(lm, "water", 10, assignments)
+
+	if nwells != 3 || labware != "DWST12" {
+		t.Errorf("expected 3 wells with DWST12 worst, got %d %s", nwells, labware)
+	}
+
+	// no model means no losses
+
+	var nolosses *LHLossModel
+
+	if v := nolosses.RequiredVolume("water", "DSW96", 100.0, 10, 2); v != 100.0 {
+		t.Errorf("expected no losses, got %g", v)
+	}
+}
+
+func TestGetAssignment(t *testing.T) {
+	plate := factory.GetPlateByType("DSW96")
+	well := plate.Wellcoords["A:1"]
+	well.Currvol = well.Rvol + 100.0
+
+	plates := map[string]*wtype.LHPlate{plate.ID: plate}
+	assignments := []string{plate.ID + ":" + well.Crds}
+
+	// each transfer takes what it needs and leaves the rest for the next
+
+	for i := 0; i < 5; i++ {
+		if _, ok := get_assignment(assignments, &plates, 20.0, nil, "water"); !ok {
+			t.Fatalf("transfer %d of 20ul should be assigned to a well with %g ul left", i+1, well.Currvol-well.Rvol)
+		}
+	}
+
+	if math.Abs(well.Currvol-well.Rvol) > 0.000001 {
+		t.Errorf("five transfers of 20ul should empty a well of 100ul, %g ul left", well.Currvol-well.Rvol)
+	}
+
+	if _, ok := get_assignment(assignments, &plates, 20.0, nil, "water"); ok {
+		t.Errorf("an empty well should not be assigned")
+	}
+}
+
+func TestSimplexSolver(t *testing.T) {
+	ss := NewSimplexSolver()
+
+	// maximise 3x + 2y st x + y <= 4, x + 3y <= 6, x <= 3
+
+	lp := NewLPProblem("lp", LP_MAX, 2, 2)
+	lp.Objective = []float64{3.0, 2.0}
+	lp.Matrix = [][]float64{{1.0, 1.0}, {1.0, 3.0}}
+	lp.RowBounds = []LPBounds{{Type: LP_UP, Upper: 4.0}, {Type: LP_UP, Upper: 6.0}}
+	lp.ColBounds = []LPBounds{{Type: LP_DB, Lower: 0.0, Upper: 3.0}, {Type: LP_LO}}
+
+	sol := ss.Solve(lp)
+
+	if sol.Status != LP_OPT || math.Abs(sol.Objective-11.0) > 0.000001 || math.Abs(sol.Cols[0]-3.0) > 0.000001 || math.Abs(sol.Cols[1]-1.0) > 0.000001 {
+		t.Errorf("expected x=3 y=1 z=11, got %v", sol)
+	}
+
+	// minimise with equality and >= rows: x + y = 10, x - y >= 2, z = 2x + 3y
+
+	lp = NewLPProblem("eq", LP_MIN, 2, 2)
+	lp.Objective = []float64{2.0, 3.0}
+	lp.Matrix = [][]float64{{1.0, 1.0}, {1.0, -1.0}}
+	lp.RowBounds = []LPBounds{{Type: LP_FX, Lower: 10.0, Upper: 10.0}, {Type: LP_LO, Lower: 2.0}}
+	lp.ColBounds = []LPBounds{{Type: LP_LO}, {Type: LP_LO}}
+
+	sol = ss.Solve(lp)
+
+	if sol.Status != LP_OPT || math.Abs(sol.Objective-20.0) > 0.000001 {
+		t.Errorf("expected z=20, got %v", sol)
+	}
+
+	// knapsack: the relaxation takes part of the last item
+
+	lp = NewLPProblem("mip", LP_MAX, 1, 3)
+	lp.Objective = []float64{5.0, 4.0, 3.0}
+	lp.Matrix = [][]float64{{2.0, 3.0, 1.0}}
+	lp.RowBounds = []LPBounds{{Type: LP_UP, Upper: 5.0}}
+	for j := 0; j < 3; j++ {
+		lp.ColBounds[j] = LPBounds{Type: LP_DB, Lower: 0.0, Upper: 1.0}
+		lp.Integer[j] = true
+	}
+
+	sol = ss.Solve(lp)
+
+	if sol.Status != LP_OPT || math.Abs(sol.Objective-9.0) > 0.000001 {
+		t.Errorf("expected knapsack value 9, got %v", sol)
+	}
+
+	// no way to satisfy this one
+
+	lp = NewLPProblem("nofeas", LP_MIN, 1, 1)
+	lp.Objective = []float64{1.0}
+	lp.Matrix = [][]float64{{1.0}}
+	lp.RowBounds = []LPBounds{{Type: LP_LO, Lower: 5.0}}
+	lp.ColBounds = []LPBounds{{Type: LP_DB, Lower: 0.0, Upper: 2.0}}
+
+	if sol = ss.Solve(lp); sol.Status != LP_NOFEAS {
+		t.Errorf("expected no feasible solution, got %v", sol)
+	}
+}
+
+func TestSolverDiagnostics(t *testing.T) {
+	// each of these needs half of the volume at least so they can't both fit
+
+	names := []string{"tea", "milk", "sugar"}
+	minrequired := make(map[string]float64, len(names))
+	maxrequired := make(map[string]float64, len(names))
+	Smax := make(map[string]float64, len(names))
+	T := make(map[string]float64, len(names))
+
+	for _, name := range names {
+		minrequired[name] = 10.0
+		maxrequired[name] = 20.0
+		Smax[name] = 40.0
+		T[name] = 100.0
+	}
+
+	_, result := choose_stock_concentrations(minrequired, maxrequired, Smax, 1.0, T)
+
+	if result.OK() || result.Status != LP_NOFEAS {
+		t.Fatalf("expected no solution, got %s", result.String())
+	}
+
+	if len(result.Reasons) != 4 || !strings.Contains(result.Reasons[0], "150.0% of the total volume") {
+		t.Errorf("wrong reasons: %s", result.String())
+	}
+
+	// too much for twelve wells
+
+	cmps := map[string]wunit.Volume{"water": wunit.NewVolume(100000.0, "ul")}
+	plates := []*wtype.LHPlate{factory.GetPlateByType("DSW96")}
+	wtc := map[string]float64{"MAX_N_PLATES": 2.0, "MAX_N_WELLS": 12.0, "RESIDUAL_VOLUME_WEIGHT": 1.0}
+
+	_, result = choose_plate_assignments(cmps, plates, wtc)
+
+	if result.OK() {
+		t.Fatalf("expected no solution, got %s", result.String())
+	}
+
+	if err := result.Err(); err == nil || !strings.Contains(err.Error(), "MAX_N_WELLS allows 12") || !strings.Contains(err.Error(), "component water needs 100000.0 ul but MAX_N_WELLS allows") {
+		t.Errorf("wrong reasons: %v", err)
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	rq := dilutionSeriesRequest()
+	lh := Init(factory.GetLiquidhandlerByType("GilsonPipetmax"))
+
+	if err := lh.Plan(rq); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rq.Instruction_stages) != len(rq.Instructions) {
+		t.Fatalf("%d instructions but %d stages", len(rq.Instructions), len(rq.Instruction_stages))
+	}
+
+	est := lh.Estimate(rq)
+
+	if est.Time <= 0.0 {
+		t.Errorf("estimated time should be positive, got %f", est.Time)
+	}
+
+	// tips and volumes must add up to what the instructions ask for
+
+	ntips := 0
+	vol := 0.0
+	for _, ins := range rq.Instructions {
+		switch ins.InstructionType() {
+		case liquidhandling.LOD:
+			ntips += ins.GetParameter("MULTI").(int)
+		case liquidhandling.ASP:
+			for _, v := range ins.GetParameter("VOLUME").([]*wunit.Volume) {
+				vol += v.ConvertTo(wunit.ParsePrefixedUnit("ul"))
+			}
+		}
+	}
+
+	esttips := 0
+	for _, n := range est.Tips {
+		esttips += n
+	}
+
+	if esttips != ntips {
+		t.Errorf("expected %d tips, estimate has %d", ntips, esttips)
+	}
+
+	estvol := 0.0
+	for _, v := range est.Reagents {
+		estvol += v
+	}
+
+	srcvol := 0.0
+	for _, v := range est.Sources {
+		srcvol += v
+	}
+
+	if math.Abs(estvol-vol) > 0.0001 || math.Abs(srcvol-vol) > 0.0001 {
+		t.Errorf("expected %f ul to be taken, estimate has %f by reagent and %f by source", vol, estvol, srcvol)
+	}
+
+	// a dilution series is made one step at a time
+	if len(est.Stages) != 4 {
+		t.Errorf("expected 4 stages, got %d", len(est.Stages))
+	}
+
+	stagetime := 0.0
+	for _, st := range est.Stages {
+		stagetime += st.Time
+	}
+
+	tp := GetLHTimingParameters(lh.Properties)
+	if math.Abs(stagetime+tp.InitTime+tp.FinaliseTime-est.Time) > 0.0001 {
+		t.Errorf("stage times %f don't add up to total %f", stagetime, est.Time)
+	}
+
+	if est.Plates["pcrplate"] == 0 {
+		t.Errorf("estimate should count the pcrplate outputs: %v", est.Plates)
+	}
+
+	// what has to be loaded covers what is taken
+
+	for _, name := range []string{"water", "tartrazine"} {
+		if est.Load[name] <= 0.0 || est.Load[name] < est.Reagents[name]-0.0001 {
+			t.Errorf("should load at least the %f ul of %s taken, estimate has %f", est.Reagents[name], name, est.Load[name])
+		}
+	}
+
+	if !strings.Contains(est.String(), "Load:") {
+		t.Errorf("estimate should say how much of each input to load:\n%s", est.String())
+	}
+}
+
+func TestTipTracking(t *testing.T) {
+	// three single channel transfers of water from one source
+	// into wells which may already have something in them
+
+	gen := func(pol liquidhandling.LHPolicy, full bool) (int, *liquidhandling.LHProperties) {
+		params := factory.GetLiquidhandlerByType("GilsonPipetmax")
+		tb := factory.GetTipboxByType("Gilson50")
+		params.AddTipBox(tb)
+		params.AddTipWaste("position_1", factory.GetTipwasteByType("Gilsontipwaste"))
+		params.Tips = []*wtype.LHTip{tb.Tiptype}
+		params.ResetTipTracking()
+
+		policies := liquidhandling.NewLHPolicyRuleSet()
+		policies.Policies["default"] = pol
+
+		scb := liquidhandling.NewSingleChannelBlockInstruction()
+		scb.Prms = params.HeadsLoaded[0].Params
+
+		for _, well := range []string{"A1", "B2", "C3"} {
+			if full {
+				params.TipTracking().AddTo("position_7", well, "tartrazine")
+			}
+			var tp liquidhandling.TransferParams
+			tp.What = "water"
+			tp.PltFrom = "position_5"
+			tp.PltTo = "position_7"
+			tp.WellFrom = "A1"
+			tp.WellTo = well
+			v := wunit.NewVolume(20.0, "ul")
+			fv := wunit.NewVolume(200.0, "ul")
+			tv := wunit.NewVolume(0.0, "ul")
+			tp.Volume = &v
+			tp.FVolume = &fv
+			tp.TVolume = &tv
+			tp.FPlateType = "DWST12"
+			tp.TPlateType = "pcrplate"
+			scb.AddTransferParams(tp)
+		}
+
+		n := 0
+		for _, ins := range scb.Generate(policies, params) {
+			if ins.InstructionType() == liquidhandling.LDT {
+				n += 1
+			}
+		}
+		return n, params
+	}
+
+	pol := liquidhandling.MakeDefaultPolicy()
+
+	if n, params := gen(pol, false); n != 1 || params.TipTracking().TipsUsed["Gilson50"] != 1 {
+		t.Errorf("clean transfers should share a tip, got %d tips", n)
+	}
+
+	// dispensing from the top keeps the tip out of the destination
+
+	if n, _ := gen(pol, true); n != 1 {
+		t.Errorf("dispensing from the top of the well should not dirty the tip, got %d tips", n)
+	}
+
+	// going into the well means the tip picks up tartrazine and
+	// must not go back into the water
+
+	pol = liquidhandling.MakeDefaultPolicy()
+	pol["DSPREFERENCE"] = 0
+
+	if n, params := gen(pol, true); n != 3 || params.TipTracking().TipsUsed["Gilson50"] != 3 {
+		t.Errorf("dirty tips should be changed before going back to the source, got %d tips", n)
+	}
+
+	pol = liquidhandling.MakeDefaultPolicy()
+	pol["TIP_REUSE_LIMIT"] = 1
+
+	if n, _ := gen(pol, false); n != 2 {
+		t.Errorf("a tip reuse limit of 1 allows two uses per tip, got %d tips", n)
+	}
+
+	pol = liquidhandling.MakeDefaultPolicy()
+	pol["TIP_REUSE_SAME_SOURCE"] = false
+
+	if n, _ := gen(pol, false); n != 3 {
+		t.Errorf("tips should not be reused at all, got %d tips", n)
+	}
+}
+
+func TestTipboxState(t *testing.T) {
+	// single tips come from the same column, a full column of eight
+	// goes to the next one with all its tips
+
+	tb := factory.GetTipboxByType("Gilson50")
+
+	got := make([]string, 0, 10)
+	got = append(got, tb.GetTips(false, 1, wtype.LHVChannel)...)
+	got = append(got, tb.GetTips(false, 1, wtype.LHVChannel)...)
+	got = append(got, tb.GetTips(false, 8, wtype.LHVChannel)...)
+
+	expected := []string{"A1", "B1", "A2", "B2", "C2", "D2", "E2", "F2", "G2", "H2"}
+
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("expected tips %v got %v", expected, got)
+	}
+
+	if fmt.Sprint(tb.UsedTips()) != fmt.Sprint(expected) || tb.NTips != 86 {
+		t.Errorf("box should have %v used and 86 left, has %v used and %d left", expected, tb.UsedTips(), tb.NTips)
+	}
+
+	// the state goes to a file and comes back onto a new deck
+
+	params := factory.GetLiquidhandlerByType("GilsonPipetmax")
+	params.AddTipBoxTo("position_2", tb)
+	params.AddTipWaste("position_1", factory.GetTipwasteByType("Gilsontipwaste"))
+	params.Tipwastes["position_1"].Contents = 10
+
+	dir, err := ioutil.TempDir("", "deckstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fn := filepath.Join(dir, "deckstate.json")
+
+	if err := GetDeckState(params).Save(fn); err != nil {
+		t.Fatal(err)
+	}
+
+	ds, err := LoadDeckState(fn)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params = factory.GetLiquidhandlerByType("GilsonPipetmax")
+	params.AddTipWaste("position_1", factory.GetTipwasteByType("Gilsontipwaste"))
+	ds.setup(params)
+
+	tb2, ok := params.Tipboxes["position_2"]
+
+	if !ok || tb2.NTips != 86 || tb2.HasTipAt("B1") || !tb2.HasTipAt("C1") {
+		t.Errorf("tip box not set up from deck state")
+	}
+
+	if params.Tipwastes["position_1"].Contents != 10 {
+		t.Errorf("tip waste should have 10 tips, has %d", params.Tipwastes["position_1"].Contents)
+	}
+
+	// a full waste gets emptied before tips are dropped into it
+
+	tw := params.Tipwastes["position_1"]
+	tw.Contents = tw.Capacity
+
+	ins := liquidhandling.DropTips(tb2.Tiptype.Type, params, params.HeadsLoaded[0].Params, 1)
+
+	n := 0
+	for _, i := range ins.Generate(liquidhandling.NewLHPolicyRuleSet(), params) {
+		if i.InstructionType() == liquidhandling.ETW {
+			n += 1
+		}
+	}
+
+	if n != 1 || tw.Contents != 1 {
+		t.Errorf("full tip waste should be emptied once and then hold 1 tip, got %d empties and %d tips", n, tw.Contents)
+	}
+}
+
+func TestExport(t *testing.T) {
+	rq := dilutionSeriesRequest()
+	lh := Init(factory.GetLiquidhandlerByType("GilsonPipetmax"))
+
+	if err := lh.Plan(rq); err != nil {
+		t.Fatal(err)
+	}
+
+	// one worklist line for each channel of each dispense
+
+	ndsp := 0
+	for _, ins := range rq.Instructions {
+		if ins.InstructionType() == liquidhandling.DSP {
+			ndsp += len(ins.GetParameter("VOLUME").([]*wunit.Volume))
+		}
+	}
+
+	var buf bytes.Buffer
+
+	if err := lh.Export(rq, "csv", &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != ndsp+1 {
+		t.Fatalf("expected %d worklist lines, got %d", ndsp+1, len(rows))
+	}
+
+	for _, row := range rows[1:] {
+		if strings.HasPrefix(row[0], "position_") || strings.HasPrefix(row[2], "position_") || row[1] == "" || row[3] == "" || row[5] == "" {
+			t.Errorf("incomplete worklist line %v", row)
+		}
+		if v, err := strconv.ParseFloat(row[4], 64); err != nil || v <= 0.0 {
+			t.Errorf("bad volume in worklist line %v", row)
+		}
+	}
+
+	buf.Reset()
+
+	if err := lh.Export(rq, "json", &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var dump []map[string]interface{}
+
+	if err := json.Unmarshal(buf.Bytes(), &dump); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dump) != len(rq.Instructions) || dump[0]["Name"] != "INI" || dump[len(dump)-1]["Name"] != "FIN" {
+		t.Errorf("instruction dump should have all %d instructions from INI to FIN", len(rq.Instructions))
+	}
+
+	if err := lh.Export(rq, "nosuchformat", &buf); err == nil {
+		t.Errorf("exporting to an unknown format should fail")
+	}
+}
+
+func TestRequestRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lhrequest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, mk := range map[string]func() *LHRequest{"dilutionseries": dilutionSeriesRequest, "constructassembly": func() *LHRequest { return constructAssemblyRequest(1) }} {
+		rq := mk()
+		lh := Init(factory.GetLiquidhandlerByType("GilsonPipetmax"))
+
+		if err := lh.Plan(rq); err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := json.Marshal(rq)
+
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		// a plan is saved to be run later
+
+		fn := filepath.Join(dir, name+".json")
+
+		if err := rq.Save(fn); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		rq2, err := LoadLHRequest(fn)
+
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		// what comes back has to be written out the same
+
+		b2, err := json.Marshal(rq2)
+
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		if !bytes.Equal(b, b2) {
+			t.Errorf("%s: request differs after a round trip", name)
+		}
+
+		if len(rq2.Instructions) != len(rq.Instructions) {
+			t.Fatalf("%s: expected %d instructions got %d", name, len(rq.Instructions), len(rq2.Instructions))
+		}
+
+		for i, ins := range rq.Instructions {
+			if reflect.TypeOf(ins) != reflect.TypeOf(rq2.Instructions[i]) {
+				t.Errorf("%s: instruction %d should be %T not %T", name, i, ins, rq2.Instructions[i])
+			}
+		}
+
+		// policy items keep their types so instructions can be made
+		// from them again
+
+		if !reflect.DeepEqual(rq.Policies, rq2.Policies) {
+			t.Errorf("%s: policies differ after a round trip", name)
+		}
+
+		// everything on the deck can be found to set the robot up
+
+		for pos, id := range rq2.Properties.PosLookup {
+			if id == "" {
+				continue
+			}
+			if _, ok := rq2.Properties.PlateLookup[id].(wtype.Named); !ok {
+				t.Errorf("%s: nothing found at %s after a round trip", name, pos)
+			}
+		}
+	}
+}
+
+// a driver which counts what it is asked to do and fails the nth call
+// of failIn, or of Aspirate if that isn't set, the first time it is
+// sent. Anything done with the tips in the wrong state, e.g. loading
+// tips with some already on or aspirating with tips a dispense failed
+// in, is noted in wrong
+type testDriver struct {
+	liquidhandling.LiquidhandlingDriver
+	failIn    string
+	failAt    int
+	failed    bool
+	aspirates int
+	dispenses int
+	loads     int
+	unloads   int
+	tips      bool
+	dirty     bool
+	wrong     []string
+	calls     []string
+}
+
+func ok() driver.CommandStatus {
+	return driver.CommandStatus{OK: true, Errorcode: 0, Msg: "OK"}
+}
+
+func (d *testDriver) fail(method string, n int) bool {
+	m := d.failIn
+	if m == "" {
+		m = "Aspirate"
+	}
+	if m == method && n == d.failAt && !d.failed {
+		d.failed = true
+		return true
+	}
+	return false
+}
+
+func (d *testDriver) Aspirate(volume []float64, overstroke []bool, head int, multi int, platetype []string, what []string, llf []bool) driver.CommandStatus {
+	d.calls = append(d.calls, "Aspirate")
+	d.aspirates += 1
+	if d.fail("Aspirate", d.aspirates) {
+		return driver.CommandStatus{OK: false, Errorcode: 1, Msg: "no liquid detected"}
+	}
+	if !d.tips || d.dirty {
+		d.wrong = append(d.wrong, fmt.Sprintf("aspirate %d with tips on %v and dirty %v", d.aspirates, d.tips, d.dirty))
+	}
+	return ok()
+}
+
+func (d *testDriver) Dispense(volume []float64, blowout []bool, head int, multi int, platetype []string, what []string, llf []bool) driver.CommandStatus {
+	d.calls = append(d.calls, "Dispense")
+	if !blowout[0] {
+		d.dispenses += 1
+		if d.fail("Dispense", d.dispenses) {
+			d.dirty = true
+			return driver.CommandStatus{OK: false, Errorcode: 1, Msg: "clot detected"}
+		}
+	}
+	if !d.tips {
+		d.wrong = append(d.wrong, fmt.Sprintf("dispense %d with no tips", d.dispenses))
+	}
+	return ok()
+}
+
+func (d *testDriver) LoadTips(channels []int, head, multi int, platetype, position, well []string) driver.CommandStatus {
+	d.calls = append(d.calls, "LoadTips")
+	d.loads += 1
+	if d.fail("LoadTips", d.loads) {
+		return driver.CommandStatus{OK: false, Errorcode: 1, Msg: "no tip"}
+	}
+	if d.tips {
+		d.wrong = append(d.wrong, fmt.Sprintf("load %d with tips on", d.loads))
+	}
+	d.tips = true
+	return ok()
+}
+
+func (d *testDriver) UnloadTips(channels []int, head, multi int, platetype, position, well []string) driver.CommandStatus {
+	d.calls = append(d.calls, "UnloadTips")
+	d.unloads += 1
+	if d.fail("UnloadTips", d.unloads) {
+		return driver.CommandStatus{OK: false, Errorcode: 1, Msg: "tip stuck"}
+	}
+	if !d.tips {
+		d.wrong = append(d.wrong, fmt.Sprintf("unload %d with no tips", d.unloads))
+	}
+	d.tips, d.dirty = false, false
+	return ok()
+}
+
+func (d *testDriver) Move(deckposition []string, wellcoords []string, reference []int, offsetX, offsetY, offsetZ []float64, plate_type []string, head int) driver.CommandStatus {
+	d.calls = append(d.calls, "Move")
+	return ok()
+}
+func (d *testDriver) SetPipetteSpeed(head, channel int, rate float64) driver.CommandStatus {
+	return ok()
+}
+func (d *testDriver) SetDriveSpeed(drive string, rate float64) driver.CommandStatus { return ok() }
+func (d *testDriver) Initialize() driver.CommandStatus {
+	d.calls = append(d.calls, "Initialize")
+	return ok()
+}
+func (d *testDriver) Finalize() driver.CommandStatus                      { return ok() }
+func (d *testDriver) ResetPistons(head, channel int) driver.CommandStatus { return ok() }
+func (d *testDriver) SetPositionState(position string, state driver.PositionState) driver.CommandStatus {
+	return ok()
+}
+func (d *testDriver) Mix(head int, volume []float64, fvolume []float64, platetype []string, cycles []int, multi int, prms map[string]interface{}) driver.CommandStatus {
+	return ok()
+}
+func (d *testDriver) AddPlateTo(position string, plate interface{}, name string) driver.CommandStatus {
+	return ok()
+}
+func (d *testDriver) RemoveAllPlates() driver.CommandStatus { return ok() }
+
+func TestResumeExecution(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	plan := func() (*Liquidhandler, *LHRequest, *testDriver, int) {
+		rq := dilutionSeriesRequest()
+		lh := Init(factory.GetLiquidhandlerByType("GilsonPipetmax"))
+		if err := lh.Plan(rq); err != nil {
+			t.Fatal(err)
+		}
+		d := &testDriver{failAt: 2}
+		lh.Properties.Driver = d
+		n := 0
+		for _, ins := range rq.Instructions {
+			if ins.InstructionType() == liquidhandling.ASP {
+				n += 1
+			}
+		}
+		return lh, rq, d, n
+	}
+
+	// with nobody to ask we stop at the failed aspirate and can carry
+	// on from there
+
+	lh, rq, d, n := plan()
+	rq.Checkpointfn = filepath.Join(dir, "checkpoint.json")
+
+	if err := lh.Execute(rq); err == nil {
+		t.Fatal("execution should stop when an instruction fails")
+	}
+
+	cp, err := LoadCheckpoint(rq.Checkpointfn)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cp.Name != "ASP" || rq.Checkpoint == nil || cp.Instruction != rq.Checkpoint.Instruction || rq.Instructions[cp.Instruction].InstructionType() != liquidhandling.ASP {
+		t.Fatalf("checkpoint should be at the failed aspirate, got %s at %d", cp.Name, cp.Instruction)
+	}
+
+	used := 0
+	for _, tb := range cp.Deck_state.Tipboxes {
+		used += len(tb.Used)
+	}
+
+	if used != 2 {
+		t.Errorf("two tips should have been used before the failure, got %d", used)
+	}
+
+	// a new run plans it again and carries on from the saved checkpoint
+
+	lh2, rq2, d2, _ := plan()
+	d2.failed = true
+	d2.tips = true // still on from the first run
+	rq2.Checkpointfn = rq.Checkpointfn
+
+	if err := lh2.resume_from_file(rq2); err != nil || rq2.Checkpoint == nil || rq2.Checkpoint.Instruction != cp.Instruction {
+		t.Fatalf("a new run should pick up the checkpoint: %v", err)
+	}
+
+	// leave the file for the rest of the test
+	rq2.Checkpointfn = ""
+
+	if err := lh2.Execute(rq2); err != nil || d2.aspirates != n-1 || d2.dispenses != n-1 || len(d2.wrong) != 0 {
+		t.Errorf("a new run should carry on from the failed aspirate: %v, %d aspirates and %d dispenses for %d transfers, %v", err, d2.aspirates, d2.dispenses, n, d2.wrong)
+	}
+
+	// having started the robot and gone back to the well
+
+	if len(d2.calls) < 3 || d2.calls[0] != "Initialize" || d2.calls[1] != "Move" || d2.calls[2] != "Aspirate" {
+		t.Errorf("a new run should initialize and move to the well before aspirating again, got %v", d2.calls)
+	}
+
+	// but not if it was planned differently
+
+	lh2, rq2, _, _ = plan()
+	bad, _ := LoadCheckpoint(rq.Checkpointfn)
+	for pos, st := range bad.Deck_state.Tipboxes {
+		st.Used = append(st.Used, "H12")
+		bad.Deck_state.Tipboxes[pos] = st
+	}
+
+	if err := lh2.ResumeFrom(rq2, bad); err == nil || rq2.Checkpoint != nil {
+		t.Errorf("a checkpoint with different tips used should not be resumed from")
+	}
+
+	if err := lh.Execute(rq); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.aspirates != n+1 || d.dispenses != n || rq.Checkpoint != nil {
+		t.Errorf("resuming should send the failed aspirate again and finish: %d aspirates and %d dispenses for %d transfers", d.aspirates, d.dispenses, n)
+	}
+
+	if _, err := os.Stat(rq.Checkpointfn); !os.IsNotExist(err) {
+		t.Errorf("checkpoint should be removed once execution has finished")
+	}
+
+	// retrying goes straight on
+
+	lh, rq, d, n = plan()
+	lh.RecoveryAgent = func(*LHRequest, *LHCheckpoint) LHRecoveryAction { return LHRetry }
+
+	if err := lh.Execute(rq); err != nil || d.aspirates != n+1 || d.dispenses != n {
+		t.Errorf("retrying should finish every transfer: %v, %d aspirates and %d dispenses for %d transfers", err, d.aspirates, d.dispenses, n)
+	}
+
+	// skipping leaves out the dispense
+
+	lh, rq, d, n = plan()
+	lh.RecoveryAgent = func(*LHRequest, *LHCheckpoint) LHRecoveryAction { return LHSkip }
+
+	if err := lh.Execute(rq); err != nil || d.aspirates != n || d.dispenses != n-1 || len(d.wrong) != 0 {
+		t.Errorf("skipping should leave out one transfer: %v, %d aspirates and %d dispenses for %d transfers, %v", err, d.aspirates, d.dispenses, n, d.wrong)
+	}
+
+	// the tips are always unloaded after skipping a failed dispense, and
+	// getting tips on or off can't be skipped at all
+
+	for _, method := range []string{"Dispense", "LoadTips", "UnloadTips"} {
+		lh, rq, d, n = plan()
+		d.failIn = method
+		lh.RecoveryAgent = func(*LHRequest, *LHCheckpoint) LHRecoveryAction { return LHSkip }
+
+		err := lh.Execute(rq)
+
+		if method == "Dispense" {
+			if err != nil || d.aspirates != n || d.dispenses != n || d.unloads != d.loads {
+				t.Errorf("skipping a failed dispense should unload the tips and go on: %v, %d aspirates, %d dispenses, %d loads and %d unloads for %d transfers", err, d.aspirates, d.dispenses, d.loads, d.unloads, n)
+			}
+		} else if err == nil || rq.Checkpoint == nil || !strings.Contains(err.Error(), "cannot skip") {
+			t.Errorf("a failed %s should not be skipped, got %v", method, err)
+		}
+
+		if len(d.wrong) != 0 {
+			t.Errorf("skipping a failed %s: %v", method, d.wrong)
+		}
+	}
+
+	// asking does whatever the answer says
+
+	lh, rq, d, n = plan()
+	lh.RecoveryAgent = PromptRecoveryAgent(strings.NewReader("s\n"), ioutil.Discard)
+
+	if err := lh.Execute(rq); err != nil || d.aspirates != n || d.dispenses != n-1 {
+		t.Errorf("answering skip should leave out one transfer: %v, %d aspirates and %d dispenses for %d transfers", err, d.aspirates, d.dispenses, n)
+	}
+
+	lh, rq, d, n = plan()
+	lh.RecoveryAgent = PromptRecoveryAgent(strings.NewReader(""), ioutil.Discard)
+
+	if err := lh.Execute(rq); err == nil || rq.Checkpoint == nil {
+		t.Errorf("no answer should stop")
+	}
+}
+
+func TestBadPolicies(t *testing.T) {
+	// bad policies put in by hand are caught when merged or planned with
+
+	rule := liquidhandling.NewLHPolicyRule("bad")
+	rule.AddCategoryConditionOn("LIQUIDCLASS", "bad")
+
+	byhand := liquidhandling.NewLHPolicyRuleSet()
+	byhand.Rules["bad"] = rule
+	byhand.Policies["bad"] = liquidhandling.LHPolicy{"ASPZOFFSET": "low"}
+
+	mgr := LHPolicyManager{SystemPolicies: liquidhandling.GetLHPolicyForTest(), UserPolicies: byhand}
+
+	if _, err := mgr.MergePolicies(nil); err == nil || !strings.Contains(err.Error(), "user policies") {
+		t.Errorf("merging bad user policies should fail, got %v", err)
+	}
+
+	mgr.UserPolicies = nil
+
+	if _, err := mgr.MergePolicies(byhand); err == nil || !strings.Contains(err.Error(), "protocol policies") {
+		t.Errorf("merging bad protocol policies should fail, got %v", err)
+	}
+
+	rq := dilutionSeriesRequest()
+	rq.Policies = byhand
+
+	if err := Init(factory.GetLiquidhandlerByType("GilsonPipetmax")).Plan(rq); err == nil || !strings.Contains(err.Error(), "ASPZOFFSET") {
+		t.Errorf("planning with a bad policy should fail, got %v", err)
+	}
+}
+
+func TestExplainPolicies(t *testing.T) {
+	// the user changes the water policy, the protocol adds a rule
+
+	user := liquidhandling.NewLHPolicyRuleSet()
+	rule := liquidhandling.NewLHPolicyRule("mywater")
+	rule.AddCategoryConditionOn("LIQUIDCLASS", "water")
+	if err := user.AddRule(rule, liquidhandling.LHPolicy{"ASPSPEED": 2.0}); err != nil {
+		t.Fatal(err)
+	}
+
+	protocol := liquidhandling.NewLHPolicyRuleSet()
+	rule = liquidhandling.NewLHPolicyRule("slowglycerol")
+	rule.Priority = 1
+	rule.AddRegexpConditionOn("LIQUIDCLASS", "^glycerol")
+	if err := protocol.AddRule(rule, liquidhandling.LHPolicy{"ASP_WAIT": 2.0}); err != nil {
+		t.Fatal(err)
+	}
+
+	mgr := LHPolicyManager{SystemPolicies: liquidhandling.GetLHPolicyForTest(), UserPolicies: user}
+	lhpr, err := mgr.MergePolicies(protocol)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.Source != "" || protocol.Source != "" {
+		t.Errorf("merging should not relabel the sets merged in")
+	}
+
+	// but what comes out says where each item came from
+
+	ins := liquidhandling.NewSingleChannelTransferInstruction()
+	v := wunit.NewVolume(5.0, "ul")
+	ins.What = "water"
+	ins.Volume = &v
+
+	sources := make(map[string]string)
+	for _, it := range lhpr.Explain(ins).Items {
+		sources[it.Name] = it.Source
+	}
+
+	if sources["ASPSPEED"] != "user" || sources["DSPREFERENCE"] != "system" {
+		t.Errorf("expected the user's ASPSPEED and the system's DSPREFERENCE, got %s and %s", sources["ASPSPEED"], sources["DSPREFERENCE"])
+	}
+
+	// a saved plan can be explained the same as one just made
+
+	dir, err := ioutil.TempDir("", "lhexplain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rq := dilutionSeriesRequest()
+	lh := Init(factory.GetLiquidhandlerByType("GilsonPipetmax"))
+
+	if err := lh.Plan(rq); err != nil {
+		t.Fatal(err)
+	}
+
+	fn := filepath.Join(dir, "plan.json")
+
+	if err := lh.ExportFile(rq, "plan", fn); err != nil {
+		t.Fatal(err)
+	}
+
+	rq2, err := LoadLHRequest(fn)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lhpes, err := ExplainPolicies(rq)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lhpes2, err := ExplainPolicies(rq2)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(lhpes) == 0 || lhpes[0].Step != "1" || lhpes[0].Instruction != "TFR" {
+		t.Fatalf("expected the first transfer to be explained first")
+	}
+
+	if !reflect.DeepEqual(lhpes, lhpes2) {
+		t.Errorf("explanations differ after a round trip")
+	}
+
+	// tartrazine has no rule of its own so only gets the default
+
+	winners := make(map[string]bool)
+	for _, lhpe := range lhpes {
+		winners[lhpe.Winner] = true
+		if !strings.Contains(lhpe.String(), "winning rule ") {
+			t.Errorf("step %s: %s should say which rule won", lhpe.Step, lhpe.Instruction)
+		}
+	}
+
+	if !winners["water"] || !winners[""] {
+		t.Errorf("expected water and the default to be used, got %v", winners)
+	}
+
+	if _, err := ExplainPolicies(dilutionSeriesRequest()); err == nil {
+		t.Errorf("requests which have not been planned cannot be explained")
+	}
+}
+
+// counts instructions by type and remembers anything it doesn't know
+type countingVisitor struct {
+	liquidhandling.RobotInstructionBaseVisitor
+	aspirates int
+	dispenses int
+	other     []liquidhandling.RobotInstruction
+}
+
+func (cv *countingVisitor) VisitAspirate(*liquidhandling.AspirateInstruction) { cv.aspirates += 1 }
+func (cv *countingVisitor) VisitDispense(*liquidhandling.DispenseInstruction) { cv.dispenses += 1 }
+func (cv *countingVisitor) VisitOther(ins liquidhandling.RobotInstruction) {
+	cv.other = append(cv.other, ins)
+}
+
+func TestInstructionTree(t *testing.T) {
+	rq := dilutionSeriesRequest()
+	lh := Init(factory.GetLiquidhandlerByType("GilsonPipetmax"))
+
+	if err := lh.Plan(rq); err != nil {
+		t.Fatal(err)
+	}
+
+	// the leaves of the tree are the instructions which were planned
+
+	intree := &countingVisitor{}
+	nodes := 0
+	rq.InstructionSet.Walk(func(ins liquidhandling.RobotInstruction, step []int) {
+		liquidhandling.VisitRobotInstruction(ins, intree)
+		nodes += 1
+	})
+
+	planned := &countingVisitor{}
+	for _, ins := range rq.Instructions {
+		liquidhandling.VisitRobotInstruction(ins, planned)
+	}
+
+	if intree.aspirates == 0 || intree.aspirates != planned.aspirates || intree.dispenses != planned.dispenses {
+		t.Errorf("expected %d aspirates and %d dispenses in the tree, got %d and %d", planned.aspirates, planned.dispenses, intree.aspirates, intree.dispenses)
+	}
+
+	if len(intree.other) != 0 || len(planned.other) != 0 {
+		t.Errorf("unexpected instructions %v %v", intree.other, planned.other)
+	}
+
+	var tree, trace bytes.Buffer
+
+	if err := lh.Export(rq, "tree", &tree); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lh.Export(rq, "trace", &trace); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(tree.String(), "\n"), "\n")
+
+	if len(lines) != nodes {
+		t.Fatalf("expected a line for each of %d instructions got %d", nodes, len(lines))
+	}
+
+	if !strings.HasPrefix(lines[0], "1 TFR What=[water] ") || !strings.HasPrefix(lines[1], "  1.1 SCB What=[water] ") {
+		t.Errorf("tree should start with the first transfer, got\n%s\n%s", lines[0], lines[1])
+	}
+
+	// transfers say where from and to, instructions the describer has
+	// no method for still get all their fields
+
+	if !strings.Contains(lines[0], " From=[position_") || !strings.Contains(lines[0], " To=[position_") {
+		t.Errorf("transfers should show where from and to, got %s", lines[0])
+	}
+
+	for _, l := range lines {
+		if strings.Contains(l, " RST ") && !strings.Contains(l, " PltFrom=") {
+			t.Errorf("other instructions should show every field, got %s", l)
+		}
+	}
+
+	dec := json.NewDecoder(&trace)
+
+	for i := 0; dec.More(); i++ {
+		var e liquidhandling.InstructionTraceEntry
+
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+
+		// the trace says the same as the tree
+
+		if !strings.HasPrefix(strings.TrimSpace(lines[i]), e.Step+" "+e.Instruction) {
+			t.Errorf("trace line %d is %s %s, tree says %s", i, e.Step, e.Instruction, lines[i])
+		}
+
+		if e.Depth != strings.Count(e.Step, ".")+1 || (e.Depth > 1) != (e.Parent != "") || !strings.HasPrefix(e.Step, e.Parent) {
+			t.Errorf("step %s has parent %s at depth %d", e.Step, e.Parent, e.Depth)
+		}
+
+		if e.Instruction == "ASP" && !strings.Contains(string(e.Parameters), `"Volume"`) {
+			t.Errorf("trace should have the parameters of each instruction, got %s", e.Parameters)
+		}
+	}
+}
+
+func TestOptimisedPlan(t *testing.T) {
+	simulate := func(instrx []liquidhandling.TerminalRobotInstruction) *liquidhandling.DeckSimulator {
+		ds, err := liquidhandling.SimulateInstructions(instrx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ds
+	}
+
+	// planning either way does the same things on the robot
+
+	plan := func(optimise bool) *LHRequest {
+		rq := dilutionSeriesRequest()
+		rq.Optimise_instructions = optimise
+		lh := Init(factory.GetLiquidhandlerByType("GilsonPipetmax"))
+
+		if err := lh.Plan(rq); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(rq.Instruction_stages) != len(rq.Instructions) {
+			t.Fatalf("expected a stage for each of %d instructions, got %d", len(rq.Instructions), len(rq.Instruction_stages))
+		}
+
+		return rq
+	}
+
+	rq, rq2 := plan(false), plan(true)
+
+	if len(rq2.Instructions) >= len(rq.Instructions) {
+		t.Errorf("expected fewer than %d instructions, got %d", len(rq.Instructions), len(rq2.Instructions))
+	}
+
+	opt, _ := liquidhandling.OptimiseInstructions(rq.Instructions)
+
+	if len(opt) != len(rq2.Instructions) {
+		t.Errorf("expected %d instructions got %d", len(opt), len(rq2.Instructions))
+	}
+
+	if err := simulate(rq.Instructions).EquivalentTo(simulate(opt)); err != nil {
+		t.Errorf("optimised plan is different: %s", err)
+	}
+
+	// the tree only has what is left to run
+
+	var leaves func(ris *liquidhandling.RobotInstructionSet) []liquidhandling.RobotInstruction
+	leaves = func(ris *liquidhandling.RobotInstructionSet) []liquidhandling.RobotInstruction {
+		if len(ris.Children()) == 0 {
+			return []liquidhandling.RobotInstruction{ris.Parent()}
+		}
+		ret := make([]liquidhandling.RobotInstruction, 0)
+		for _, c := range ris.Children() {
+			ret = append(ret, leaves(c)...)
+		}
+		return ret
+	}
+
+	left := leaves(rq2.InstructionSet)
+
+	if len(left) != len(rq2.Instructions)-2 {
+		t.Fatalf("expected %d instructions in the tree, got %d", len(rq2.Instructions)-2, len(left))
+	}
+
+	for i, ins := range left {
+		if ins != rq2.Instructions[i+1] {
+			t.Errorf("instruction %d in the tree is not the one run", i+1)
+		}
+	}
+}
+
+func TestAirGapWorklist(t *testing.T) {
+	params := factory.GetLiquidhandlerByType("GilsonPipetmax")
+	params.AddPlate("position_4", factory.GetPlateByType("DSW96"))
+	params.AddPlate("position_7", factory.GetPlateByType("pcrplate"))
+
+	policies := liquidhandling.GetLHPolicyForTest()
+	policies.Policies["culture"]["ASP_LEADING_AIR"] = 5.0
+	policies.Policies["culture"]["ASP_TRAILING_AIR"] = 2
+
+	// a single channel transfer of culture with air gaps either side
+
+	minvol, maxvol := wunit.NewVolume(0.5, "ul"), wunit.NewVolume(200.0, "ul")
+	minspd, maxspd := wunit.NewFlowRate(0.1, "ml/min"), wunit.NewFlowRate(10.0, "ml/min")
+	prms := wtype.NewLHChannelParameter("test", &minvol, &maxvol, &minspd, &maxspd, 1, false, wtype.LHVChannel, 1)
+
+	v := wunit.NewVolume(10.0, "ul")
+	fv := wunit.NewVolume(1000.0, "ul")
+	tv := wunit.NewVolume(20.0, "ul")
+	tp := liquidhandling.TransferParams{What: "culture", PltFrom: "position_4", PltTo: "position_7", WellFrom: "A1", WellTo: "A1", Volume: &v, FPlateType: "DSW96", TPlateType: "pcrplate", FVolume: &fv, TVolume: &tv, Channel: prms}
+
+	suck := liquidhandling.NewSuckInstruction()
+	suck.AddTransferParams(tp)
+	suck.Multi, suck.Head, suck.Prms = 1, 1, prms
+	blow := liquidhandling.NewBlowInstruction()
+	blow.AddTransferParams(tp)
+	blow.Multi, blow.Head, blow.Prms = 1, 1, prms
+	reset := liquidhandling.NewResetInstruction()
+	reset.AddTransferParams(tp)
+	reset.Prms = prms
+
+	instrx := make([]liquidhandling.TerminalRobotInstruction, 0)
+	for _, ins := range []liquidhandling.RobotInstruction{suck, blow, reset} {
+		for _, r := range liquidhandling.NewRobotInstructionSet(ins).Generate(policies, params) {
+			instrx = append(instrx, r.(liquidhandling.TerminalRobotInstruction))
+		}
+	}
+
+	// only the culture goes in the worklist
+
+	rq := NewLHRequest()
+	rq.Instructions = instrx
+
+	var buf bytes.Buffer
+
+	if err := ExportCSVWorklist(&buf, rq, params); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 2 || rows[1][1] != "A1" || rows[1][3] != "A1" || rows[1][4] != "10.00" || rows[1][5] != "culture" {
+		t.Errorf("expected one worklist line for 10 ul of culture, got %v", rows)
+	}
+
+	// all of that is given up on if any of it fails, the tips it was
+	// done with are unloaded
+
+	cycle := append([]liquidhandling.TerminalRobotInstruction{liquidhandling.NewLoadTipsInstruction()}, instrx...)
+	cycle = append(cycle, liquidhandling.NewUnloadTipsInstruction())
+	two := append(append([]liquidhandling.TerminalRobotInstruction{}, cycle...), cycle...)
+
+	for n := 1; n < len(cycle)-1; n++ {
+		if next, err := skip_to(two, n); err != nil || next != len(cycle)-1 {
+			t.Errorf("failing at %d should skip to unloading the tips at %d, got %d %v", n, len(cycle)-1, next, err)
+		}
+	}
+
+	if _, err := skip_to(two, 0); err == nil {
+		t.Errorf("failing to load tips should not be skipped")
+	}
+}
+
+func TestMultiDispenseWorklist(t *testing.T) {
+	// a plate's worth of buffer out of one trough well, the worklist
+	// only has the transfers asked for and not the excess put back
+
+	wellfrom := make([]string, 0, 96)
+	wellto := make([]string, 0, 96)
+	vols := make([]float64, 0, 96)
+	for c := 1; c <= 12; c++ {
+		for _, r := range "ABCDEFGH" {
+			wellfrom = append(wellfrom, "A1")
+			wellto = append(wellto, fmt.Sprintf("%c%d", r, c))
+			vols = append(vols, 20.0)
+		}
+	}
+
+	params := factory.GetLiquidhandlerByType("GilsonPipetmax")
+	params.AddPlate("position_4", factory.GetPlateByType("DWST12"))
+	params.AddPlate("position_7", factory.GetPlateByType("pcrplate"))
+	tb := factory.GetTipboxByType("Gilson200")
+	params.AddTipBox(tb)
+	params.AddTipWaste("position_1", factory.GetTipwasteByType("Gilsontipwaste"))
+	params.Tips = []*wtype.LHTip{tb.Tiptype}
+	params.ResetTipTracking()
+
+	policies := liquidhandling.GetLHPolicyForTest()
+	policies.Policies["water"]["MULTI_DISPENSE"] = true
+	policies.Policies["water"]["MULTI_DISPENSE_EXCESS"] = 5
+
+	tfr := makeTestTransfer(wellfrom, wellto, vols)
+	for _, v := range tfr.FVolume {
+		*v = wunit.NewVolume(5000.0, "ul")
+	}
+
+	rq := NewLHRequest()
+	for _, ins := range liquidhandling.NewRobotInstructionSet(tfr).Generate(policies, params) {
+		rq.Instructions = append(rq.Instructions, ins.(liquidhandling.TerminalRobotInstruction))
+	}
+
+	var buf bytes.Buffer
+
+	if err := ExportCSVWorklist(&buf, rq, params); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 97 {
+		t.Errorf("expected 96 worklist lines, got %d", len(rows)-1)
+	}
+
+	for _, row := range rows[1:] {
+		if row[1] != "A1" || row[4] != "20.00" {
+			t.Errorf("unexpected worklist line %v", row)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// /anthalib/liquidhandling/loss_model.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+import (
+	"github.com/antha-lang/antha/antha/anthalib/wunit"
+)
+
+// volumes lost from a source over and above what is actually moved
+// all volumes are in Vunit
+type LHLossParameters struct {
+	TransferLoss float64 // lost each time we take from the source: tip wetting, pre-aspiration, mixing etc.
+	Evaporation  float64 // lost from each source well per hour
+	Vunit        string
+}
+
+func (lp LHLossParameters) transferLossUl() float64 {
+	return lossVolumeUl(lp.TransferLoss, lp.Vunit)
+}
+
+func (lp LHLossParameters) evaporationUl() float64 {
+	return lossVolumeUl(lp.Evaporation, lp.Vunit)
+}
+
+func lossVolumeUl(v float64, unit string) float64 {
+	if v == 0.0 {
+		return 0.0
+	}
+
+	if unit == "" {
+		unit = "ul"
+	}
+
+	vol := wunit.NewVolume(v, unit)
+	return vol.ConvertToString("ul")
+}
+
+// losses are set by liquid class and by labware (plate type) and the two
+// are added together; anything not listed gets the defaults
+type LHLossModel struct {
+	LiquidClasses map[string]LHLossParameters
+	Labware       map[string]LHLossParameters
+	Default       LHLossParameters // liquid classes with nothing set
+	Hours         float64          // how long sources sit on the deck
+}
+
+func NewLHLossModel() *LHLossModel {
+	var lm LHLossModel
+	lm.LiquidClasses = make(map[string]LHLossParameters)
+	lm.Labware = make(map[string]LHLossParameters)
+	return &lm
+}
+
+func (lm *LHLossModel) parametersFor(liquidclass, labware string) (LHLossParameters, LHLossParameters) {
+	lcp, ok := lm.LiquidClasses[liquidclass]
+
+	if !ok {
+		lcp = lm.Default
+	}
+
+	return lcp, lm.Labware[labware]
+}
+
+// volume in ul lost each time liquidclass is taken from labware
+func (lm *LHLossModel) TransferLoss(liquidclass, labware string) float64 {
+	if lm == nil {
+		return 0.0
+	}
+
+	lcp, lwp := lm.parametersFor(liquidclass, labware)
+
+	return lcp.transferLossUl() + lwp.transferLossUl()
+}
+
+// volume in ul lost to evaporation from each well of labware holding
+// liquidclass over the length of the run
+func (lm *LHLossModel) EvaporationLoss(liquidclass, labware string) float64 {
+	if lm == nil {
+		return 0.0
+	}
+
+	lcp, lwp := lm.parametersFor(liquidclass, labware)
+
+	return (lcp.evaporationUl() + lwp.evaporationUl()) * lm.Hours
+}
+
+// total volume in ul which has to be loaded to deliver vol in ntransfers
+// from nwells wells of labware
+func (lm *LHLossModel) RequiredVolume(liquidclass, labware string, vol float64, ntransfers, nwells int) float64 {
+	return vol + float64(ntransfers)*lm.TransferLoss(liquidclass, labware) + float64(nwells)*lm.EvaporationLoss(liquidclass, labware)
+}
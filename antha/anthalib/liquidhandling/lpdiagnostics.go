@@ -0,0 +1,118 @@
+// /anthalib/liquidhandling/lpdiagnostics.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// what came of one of the optimisations in planning
+// if there is no solution Reasons says which constraints
+// couldn't be met in terms of the request
+type LHSolverResult struct {
+	Problem   string
+	Status    int
+	Objective float64
+	Reasons   []string
+}
+
+func (r LHSolverResult) OK() bool {
+	return r.Status == LP_OPT || r.Status == LP_FEAS
+}
+
+func (r LHSolverResult) String() string {
+	s := fmt.Sprintf("%s: %s", r.Problem, LPStatusName(r.Status))
+
+	if len(r.Reasons) != 0 {
+		s += ": " + strings.Join(r.Reasons, "; ")
+	}
+
+	return s
+}
+
+// nil if there is a solution
+func (r LHSolverResult) Err() error {
+	if r.OK() {
+		return nil
+	}
+	return errors.New(r.String())
+}
+
+func solver_result(name string, sol LPSolution) LHSolverResult {
+	return LHSolverResult{Problem: name, Status: sol.Status, Objective: sol.Objective}
+}
+
+// finds out which rows stop a problem having a solution
+// every row is allowed to be broken at a cost per unit given by its
+// weight and we look for the cheapest way to do it: rows which are
+// cheap to break are blamed first
+// returns how far each row is broken along with the column values
+func lp_explain(lpp *LPProblem, weights []float64) ([]float64, []float64, bool) {
+	nrows := lpp.NRows()
+	ncols := lpp.NCols()
+
+	// two extra columns per row, taking it below and above its bounds
+
+	el := NewLPProblem(lpp.Name+"_elastic", LP_MIN, nrows, ncols+2*nrows)
+
+	for j := 0; j < ncols; j++ {
+		el.ColBounds[j] = lpp.ColBounds[j]
+		el.Integer[j] = lpp.Integer[j]
+	}
+
+	for i := 0; i < nrows; i++ {
+		copy(el.Matrix[i], lpp.Matrix[i])
+		el.RowBounds[i] = lpp.RowBounds[i]
+
+		w := 1.0
+		if weights != nil {
+			w = weights[i]
+		}
+
+		lo := ncols + 2*i
+		up := lo + 1
+
+		el.Matrix[i][lo] = 1.0
+		el.Matrix[i][up] = -1.0
+		el.Objective[lo] = w
+		el.Objective[up] = w
+		el.ColBounds[lo] = LPBounds{Type: LP_LO}
+		el.ColBounds[up] = LPBounds{Type: LP_LO}
+	}
+
+	sol := DefaultLPSolver.Solve(el)
+
+	if sol.Status != LP_OPT && sol.Status != LP_FEAS {
+		return nil, nil, false
+	}
+
+	broken := make([]float64, nrows)
+
+	for i := 0; i < nrows; i++ {
+		broken[i] = sol.Cols[ncols+2*i] + sol.Cols[ncols+2*i+1]
+	}
+
+	return broken, sol.Cols[:ncols], true
+}
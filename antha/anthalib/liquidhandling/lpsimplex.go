@@ -0,0 +1,572 @@
+// /anthalib/liquidhandling/lpsimplex.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+import "math"
+
+// a pure go LP solver: a dense two-phase simplex using Bland's rule,
+// with depth-first branch and bound on top for integer columns
+// the problems we have to solve in planning are small so simplicity
+// and determinism matter more here than speed
+
+const (
+	lp_eps       = 1e-9
+	lp_feas_eps  = 1e-7
+	lp_int_eps   = 1e-6
+	lp_max_iter  = 100000
+	lp_max_nodes = 100000
+)
+
+type SimplexSolver struct {
+	MaxNodes int // limit on branch and bound nodes
+}
+
+func NewSimplexSolver() *SimplexSolver {
+	return &SimplexSolver{MaxNodes: lp_max_nodes}
+}
+
+func (ss *SimplexSolver) Solve(lpp *LPProblem) LPSolution {
+	if !lpp.IsMIP() {
+		return solve_relaxation(lpp)
+	}
+
+	bb := branch_and_bound{max_nodes: ss.MaxNodes}
+	bb.best.Status = LP_NOFEAS
+
+	complete := bb.search(lpp)
+
+	if !complete {
+		// ran out of nodes: whatever we have isn't known to be optimal
+		if bb.best.Status == LP_OPT {
+			bb.best.Status = LP_FEAS
+		} else if bb.best.Status == LP_NOFEAS {
+			bb.best.Status = LP_UNDEF
+		}
+	}
+
+	return bb.best
+}
+
+type branch_and_bound struct {
+	max_nodes int
+	nodes     int
+	best      LPSolution
+}
+
+// returns false if the search had to stop early
+func (bb *branch_and_bound) search(lpp *LPProblem) bool {
+	if bb.nodes >= bb.max_nodes {
+		return false
+	}
+
+	bb.nodes += 1
+
+	sol := solve_relaxation(lpp)
+
+	switch sol.Status {
+	case LP_NOFEAS:
+		return true
+	case LP_UNBND:
+		// we don't try to deal with unbounded integer programs
+		if bb.best.Status != LP_OPT {
+			bb.best = sol
+		}
+		return true
+	}
+
+	// can't do better than we already have down here
+
+	if bb.best.Status == LP_OPT && !lp_better(sol.Objective, bb.best.Objective, lpp.Direction) {
+		return true
+	}
+
+	// branch on the first fractional column
+
+	for j, isint := range lpp.Integer {
+		if !isint {
+			continue
+		}
+
+		v := sol.Cols[j]
+		f := math.Floor(v + lp_int_eps)
+
+		if v-f <= lp_int_eps {
+			continue
+		}
+
+		complete := true
+
+		down := lpp.Dup()
+		if b, ok := lp_with_upper(down.ColBounds[j], f); ok {
+			down.ColBounds[j] = b
+			complete = bb.search(down) && complete
+		}
+
+		up := lpp.Dup()
+		if b, ok := lp_with_lower(up.ColBounds[j], f+1.0); ok {
+			up.ColBounds[j] = b
+			complete = bb.search(up) && complete
+		}
+
+		return complete
+	}
+
+	// everything is integral: this is the best so far
+
+	for j, isint := range lpp.Integer {
+		if isint {
+			sol.Cols[j] = math.Floor(sol.Cols[j] + 0.5)
+		}
+	}
+
+	sol.Objective, sol.Rows = lp_evaluate(lpp, sol.Cols)
+	bb.best = sol
+
+	return true
+}
+
+func lp_better(a, b float64, direction int) bool {
+	tol := lp_feas_eps * math.Max(1.0, math.Abs(b))
+	if direction == LP_MAX {
+		return a > b+tol
+	}
+	return a < b-tol
+}
+
+func lp_with_upper(b LPBounds, u float64) (LPBounds, bool) {
+	switch b.Type {
+	case LP_FR:
+		return LPBounds{Type: LP_UP, Upper: u}, true
+	case LP_UP:
+		return LPBounds{Type: LP_UP, Upper: math.Min(u, b.Upper)}, true
+	case LP_DB, LP_FX:
+		u = math.Min(u, b.Upper)
+	}
+
+	// there is a lower bound
+
+	if u < b.Lower-lp_feas_eps {
+		return b, false
+	}
+
+	if u <= b.Lower {
+		return LPBounds{Type: LP_FX, Lower: b.Lower, Upper: b.Lower}, true
+	}
+
+	return LPBounds{Type: LP_DB, Lower: b.Lower, Upper: u}, true
+}
+
+func lp_with_lower(b LPBounds, l float64) (LPBounds, bool) {
+	switch b.Type {
+	case LP_FR:
+		return LPBounds{Type: LP_LO, Lower: l}, true
+	case LP_LO:
+		return LPBounds{Type: LP_LO, Lower: math.Max(l, b.Lower)}, true
+	case LP_DB, LP_FX:
+		l = math.Max(l, b.Lower)
+	}
+
+	// there is an upper bound
+
+	if l > b.Upper+lp_feas_eps {
+		return b, false
+	}
+
+	if l >= b.Upper {
+		return LPBounds{Type: LP_FX, Lower: b.Upper, Upper: b.Upper}, true
+	}
+
+	return LPBounds{Type: LP_DB, Lower: l, Upper: b.Upper}, true
+}
+
+// objective value and row activities for a given set of column values
+func lp_evaluate(lpp *LPProblem, x []float64) (float64, []float64) {
+	obj := 0.0
+	for j, c := range lpp.Objective {
+		obj += c * x[j]
+	}
+
+	rows := make([]float64, lpp.NRows())
+	for i, row := range lpp.Matrix {
+		for j, a := range row {
+			rows[i] += a * x[j]
+		}
+	}
+
+	return obj, rows
+}
+
+// the simplex works on y >= 0 so each column is mapped onto one or two
+// of these:
+//	x = offset + sign * y[first] (- y[second] for free columns)
+type lp_column_map struct {
+	offset float64
+	sign   float64
+	first  int
+	second int // -1 unless free
+}
+
+const (
+	lp_le int = iota
+	lp_ge
+	lp_eq
+)
+
+type lp_constraint struct {
+	coefs []float64
+	sense int
+	rhs   float64
+}
+
+func solve_relaxation(lpp *LPProblem) LPSolution {
+	ncols := lpp.NCols()
+
+	// map the columns onto non-negative variables
+
+	cmap := make([]lp_column_map, ncols)
+	ny := 0
+	upper := make(map[int]float64)
+
+	for j, b := range lpp.ColBounds {
+		switch b.Type {
+		case LP_FR:
+			cmap[j] = lp_column_map{0.0, 1.0, ny, ny + 1}
+			ny += 2
+		case LP_LO:
+			cmap[j] = lp_column_map{b.Lower, 1.0, ny, -1}
+			ny += 1
+		case LP_UP:
+			cmap[j] = lp_column_map{b.Upper, -1.0, ny, -1}
+			ny += 1
+		case LP_DB:
+			if b.Upper < b.Lower-lp_feas_eps {
+				return LPSolution{Status: LP_NOFEAS}
+			}
+			cmap[j] = lp_column_map{b.Lower, 1.0, ny, -1}
+			upper[ny] = math.Max(b.Upper-b.Lower, 0.0)
+			ny += 1
+		case LP_FX:
+			cmap[j] = lp_column_map{b.Lower, 0.0, -1, -1}
+		}
+	}
+
+	// rewrite a row in terms of y, along with the constant left over
+
+	transform := func(row []float64) ([]float64, float64) {
+		coefs := make([]float64, ny)
+		c0 := 0.0
+		for j, a := range row {
+			if a == 0.0 {
+				continue
+			}
+			m := cmap[j]
+			c0 += a * m.offset
+			if m.first != -1 {
+				coefs[m.first] += a * m.sign
+			}
+			if m.second != -1 {
+				coefs[m.second] -= a
+			}
+		}
+		return coefs, c0
+	}
+
+	constraints := make([]lp_constraint, 0, lpp.NRows()+len(upper))
+
+	for i, row := range lpp.Matrix {
+		b := lpp.RowBounds[i]
+		coefs, c0 := transform(row)
+
+		switch b.Type {
+		case LP_LO:
+			constraints = append(constraints, lp_constraint{coefs, lp_ge, b.Lower - c0})
+		case LP_UP:
+			constraints = append(constraints, lp_constraint{coefs, lp_le, b.Upper - c0})
+		case LP_DB:
+			if b.Upper < b.Lower-lp_feas_eps {
+				return LPSolution{Status: LP_NOFEAS}
+			}
+			constraints = append(constraints, lp_constraint{coefs, lp_ge, b.Lower - c0})
+			constraints = append(constraints, lp_constraint{coefs, lp_le, b.Upper - c0})
+		case LP_FX:
+			constraints = append(constraints, lp_constraint{coefs, lp_eq, b.Lower - c0})
+		}
+	}
+
+	for k := 0; k < ny; k++ {
+		u, ok := upper[k]
+		if !ok {
+			continue
+		}
+		coefs := make([]float64, ny)
+		coefs[k] = 1.0
+		constraints = append(constraints, lp_constraint{coefs, lp_le, u})
+	}
+
+	// we always minimise
+
+	cost, _ := transform(lpp.Objective)
+
+	if lpp.Direction == LP_MAX {
+		for k := range cost {
+			cost[k] = -cost[k]
+		}
+	}
+
+	y, status := lp_simplex(constraints, cost, ny)
+
+	if status != LP_OPT {
+		return LPSolution{Status: status}
+	}
+
+	x := make([]float64, ncols)
+
+	for j, m := range cmap {
+		x[j] = m.offset
+		if m.first != -1 {
+			x[j] += m.sign * y[m.first]
+		}
+		if m.second != -1 {
+			x[j] -= y[m.second]
+		}
+	}
+
+	obj, rows := lp_evaluate(lpp, x)
+
+	return LPSolution{Status: LP_OPT, Objective: obj, Cols: x, Rows: rows}
+}
+
+type lp_tableau struct {
+	t     [][]float64 // constraint rows then the objective row; rhs is the last column
+	basis []int
+	rhs   int
+}
+
+func (tb *lp_tableau) pivot(r, c int) {
+	pr := tb.t[r]
+	p := pr[c]
+
+	for j := range pr {
+		pr[j] /= p
+	}
+
+	for i, row := range tb.t {
+		if i == r || row[c] == 0.0 {
+			continue
+		}
+		f := row[c]
+		for j := range row {
+			row[j] -= f * pr[j]
+		}
+	}
+
+	tb.basis[r] = c
+}
+
+// minimises the objective row over columns 0..ncols-1
+func (tb *lp_tableau) optimise(ncols int) int {
+	m := len(tb.basis)
+	obj := tb.t[m]
+
+	for iter := 0; iter < lp_max_iter; iter++ {
+		// entering column: the first with a negative reduced cost
+
+		c := -1
+		for j := 0; j < ncols; j++ {
+			if obj[j] < -lp_eps {
+				c = j
+				break
+			}
+		}
+
+		if c == -1 {
+			return LP_OPT
+		}
+
+		// leaving row: minimum ratio, ties go to the lowest basic variable
+
+		r := -1
+		best := 0.0
+
+		for i := 0; i < m; i++ {
+			a := tb.t[i][c]
+			if a <= lp_eps {
+				continue
+			}
+			ratio := tb.t[i][tb.rhs] / a
+			if r == -1 || ratio < best-lp_eps || (ratio <= best+lp_eps && tb.basis[i] < tb.basis[r]) {
+				r = i
+				best = ratio
+			}
+		}
+
+		if r == -1 {
+			return LP_UNBND
+		}
+
+		tb.pivot(r, c)
+	}
+
+	return LP_UNDEF
+}
+
+// minimise cost.y subject to the constraints and y >= 0
+func lp_simplex(constraints []lp_constraint, cost []float64, ny int) ([]float64, int) {
+	m := len(constraints)
+
+	// make all the right hand sides non-negative
+
+	nslack := 0
+	nart := 0
+
+	for i := range constraints {
+		c := &constraints[i]
+		if c.rhs < 0.0 {
+			for k := range c.coefs {
+				c.coefs[k] = -c.coefs[k]
+			}
+			c.rhs = -c.rhs
+			if c.sense == lp_le {
+				c.sense = lp_ge
+			} else if c.sense == lp_ge {
+				c.sense = lp_le
+			}
+		}
+
+		if c.sense != lp_eq {
+			nslack += 1
+		}
+		if c.sense != lp_le {
+			nart += 1
+		}
+	}
+
+	// columns: y, slacks, artificials, rhs
+
+	nreal := ny + nslack
+	ntot := nreal + nart
+
+	tb := lp_tableau{t: make([][]float64, m+1), basis: make([]int, m), rhs: ntot}
+
+	for i := 0; i <= m; i++ {
+		tb.t[i] = make([]float64, ntot+1)
+	}
+
+	s := ny
+	a := nreal
+
+	for i, c := range constraints {
+		row := tb.t[i]
+		copy(row, c.coefs)
+		row[tb.rhs] = c.rhs
+
+		switch c.sense {
+		case lp_le:
+			row[s] = 1.0
+			tb.basis[i] = s
+			s += 1
+		case lp_ge:
+			row[s] = -1.0
+			s += 1
+			row[a] = 1.0
+			tb.basis[i] = a
+			a += 1
+		case lp_eq:
+			row[a] = 1.0
+			tb.basis[i] = a
+			a += 1
+		}
+	}
+
+	obj := tb.t[m]
+
+	// phase one: find a feasible point by driving the artificials to zero
+
+	if nart > 0 {
+		for i := 0; i < m; i++ {
+			if tb.basis[i] < nreal {
+				continue
+			}
+			for j := 0; j < nreal; j++ {
+				obj[j] -= tb.t[i][j]
+			}
+			obj[tb.rhs] -= tb.t[i][tb.rhs]
+		}
+
+		if status := tb.optimise(nreal); status != LP_OPT {
+			return nil, status
+		}
+
+		if -obj[tb.rhs] > lp_feas_eps {
+			return nil, LP_NOFEAS
+		}
+
+		// get any artificials left at zero out of the basis
+		// if they won't go the row is redundant and can stay as it is
+
+		for i := 0; i < m; i++ {
+			if tb.basis[i] < nreal {
+				continue
+			}
+			for j := 0; j < nreal; j++ {
+				if math.Abs(tb.t[i][j]) > lp_eps {
+					tb.pivot(i, j)
+					break
+				}
+			}
+		}
+	}
+
+	// phase two: the real objective
+
+	for j := range obj {
+		obj[j] = 0.0
+	}
+
+	copy(obj, cost)
+
+	for i := 0; i < m; i++ {
+		b := tb.basis[i]
+		if b >= ny || cost[b] == 0.0 {
+			continue
+		}
+		f := cost[b]
+		for j := range obj {
+			obj[j] -= f * tb.t[i][j]
+		}
+	}
+
+	if status := tb.optimise(nreal); status != LP_OPT {
+		return nil, status
+	}
+
+	y := make([]float64, ny)
+
+	for i := 0; i < m; i++ {
+		if b := tb.basis[i]; b < ny {
+			y[b] = math.Max(tb.t[i][tb.rhs], 0.0)
+		}
+	}
+
+	return y, LP_OPT
+}
@@ -0,0 +1,138 @@
+// /anthalib/liquidhandling/lpsolver.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+// linear and integer programs used in planning are written out as an
+// LPProblem and handed to whichever solver is built in: by default this
+// is the pure go simplex solver in lpsimplex.go, building with -tags glpk
+// uses GLPK instead
+
+// direction of optimisation
+const (
+	LP_MIN int = iota
+	LP_MAX
+)
+
+// bounds on rows and columns, as in GLPK
+const (
+	LP_FR int = iota // free
+	LP_LO            // lower bound only
+	LP_UP            // upper bound only
+	LP_DB            // both
+	LP_FX            // fixed
+)
+
+// solution status
+const (
+	LP_UNDEF int = iota
+	LP_OPT
+	LP_FEAS   // feasible but not shown to be optimal
+	LP_NOFEAS // no feasible solution
+	LP_UNBND  // unbounded
+)
+
+type LPBounds struct {
+	Type  int
+	Lower float64
+	Upper float64
+}
+
+// rows are constraints, columns are variables
+// Matrix[i][j] is the coefficient of column j in row i
+type LPProblem struct {
+	Name      string
+	Direction int
+	Objective []float64
+	Matrix    [][]float64
+	RowBounds []LPBounds
+	ColBounds []LPBounds
+	Integer   []bool
+}
+
+func NewLPProblem(name string, direction, nrows, ncols int) *LPProblem {
+	var lpp LPProblem
+	lpp.Name = name
+	lpp.Direction = direction
+	lpp.Objective = make([]float64, ncols)
+	lpp.Matrix = make([][]float64, nrows)
+	for i := 0; i < nrows; i++ {
+		lpp.Matrix[i] = make([]float64, ncols)
+	}
+	lpp.RowBounds = make([]LPBounds, nrows)
+	lpp.ColBounds = make([]LPBounds, ncols)
+	lpp.Integer = make([]bool, ncols)
+	return &lpp
+}
+
+func (lpp *LPProblem) NRows() int {
+	return len(lpp.Matrix)
+}
+
+func (lpp *LPProblem) NCols() int {
+	return len(lpp.Objective)
+}
+
+func (lpp *LPProblem) IsMIP() bool {
+	for _, b := range lpp.Integer {
+		if b {
+			return true
+		}
+	}
+	return false
+}
+
+// copies the problem, the matrix is shared
+func (lpp *LPProblem) Dup() *LPProblem {
+	r := *lpp
+	r.ColBounds = make([]LPBounds, len(lpp.ColBounds))
+	copy(r.ColBounds, lpp.ColBounds)
+	return &r
+}
+
+type LPSolution struct {
+	Status    int
+	Objective float64
+	Cols      []float64
+	Rows      []float64
+}
+
+type LPSolver interface {
+	Solve(*LPProblem) LPSolution
+}
+
+// the solver used for planning
+var DefaultLPSolver LPSolver = NewLPSolver()
+
+func LPStatusName(status int) string {
+	switch status {
+	case LP_OPT:
+		return "optimal"
+	case LP_FEAS:
+		return "feasible"
+	case LP_NOFEAS:
+		return "infeasible"
+	case LP_UNBND:
+		return "unbounded"
+	}
+	return "undefined"
+}
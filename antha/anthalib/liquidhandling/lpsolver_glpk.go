@@ -0,0 +1,135 @@
+// /anthalib/liquidhandling/lpsolver_glpk.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+// +build glpk
+
+package liquidhandling
+
+import (
+	"github.com/antha-lang/antha/antha/anthalib/wutil"
+	"github.com/antha-lang/antha/internal/github.com/lukpank/go-glpk/glpk"
+)
+
+// build with -tags glpk to solve using GLPK; this needs cgo and the
+// native library
+func NewLPSolver() LPSolver {
+	return &GLPKSolver{}
+}
+
+type GLPKSolver struct {
+}
+
+var glpk_bounds = map[int]glpk.BndsType{
+	LP_FR: glpk.FR,
+	LP_LO: glpk.LO,
+	LP_UP: glpk.UP,
+	LP_DB: glpk.DB,
+	LP_FX: glpk.FX,
+}
+
+func glpk_status(stat glpk.SolStat) int {
+	switch stat {
+	case glpk.OPT:
+		return LP_OPT
+	case glpk.FEAS:
+		return LP_FEAS
+	case glpk.NOFEAS, glpk.INFEAS:
+		return LP_NOFEAS
+	case glpk.UNBND:
+		return LP_UNBND
+	}
+	return LP_UNDEF
+}
+
+func (gs *GLPKSolver) Solve(lpp *LPProblem) LPSolution {
+	lp := glpk.New()
+	defer lp.Delete()
+
+	lp.SetProbName(lpp.Name)
+	lp.SetObjName("Z")
+
+	if lpp.Direction == LP_MAX {
+		lp.SetObjDir(glpk.MAX)
+	} else {
+		lp.SetObjDir(glpk.MIN)
+	}
+
+	nrows := lpp.NRows()
+	ncols := lpp.NCols()
+
+	lp.AddRows(nrows)
+
+	for i, b := range lpp.RowBounds {
+		lp.SetRowBnds(i+1, glpk_bounds[b.Type], b.Lower, b.Upper)
+	}
+
+	lp.AddCols(ncols)
+
+	for j, b := range lpp.ColBounds {
+		lp.SetObjCoef(j+1, lpp.Objective[j])
+		lp.SetColBnds(j+1, glpk_bounds[b.Type], b.Lower, b.Upper)
+		if lpp.Integer[j] {
+			lp.SetColKind(j+1, glpk.IV)
+		}
+	}
+
+	// glpk counts from 1
+
+	ind := wutil.Series(0, ncols)
+
+	for i, row := range lpp.Matrix {
+		val := make([]float64, ncols+1)
+		copy(val[1:], row)
+		lp.SetMatRow(i+1, ind, val)
+	}
+
+	sol := LPSolution{Cols: make([]float64, ncols), Rows: make([]float64, nrows)}
+
+	if lpp.IsMIP() {
+		iocp := glpk.NewIocp()
+		iocp.SetPresolve(true)
+		iocp.SetMsgLev(0)
+		lp.Intopt(iocp)
+
+		sol.Status = glpk_status(lp.MipStatus())
+		sol.Objective = lp.MipObjVal()
+		for j := 0; j < ncols; j++ {
+			sol.Cols[j] = lp.MipColVal(j + 1)
+		}
+		for i := 0; i < nrows; i++ {
+			sol.Rows[i] = lp.MipRowVal(i + 1)
+		}
+	} else {
+		prm := glpk.NewSmcp()
+		prm.SetMsgLev(0)
+		lp.Simplex(prm)
+
+		sol.Status = glpk_status(lp.Status())
+		sol.Objective = lp.ObjVal()
+		for j := 0; j < ncols; j++ {
+			sol.Cols[j] = lp.ColPrim(j + 1)
+		}
+		_, sol.Rows = lp_evaluate(lpp, sol.Cols)
+	}
+
+	return sol
+}
@@ -0,0 +1,128 @@
+// anthalib/liquidhandling/lpsolver_glpk_test.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+//
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+// +build glpk
+
+package liquidhandling
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/antha-lang/antha/antha/anthalib/factory"
+	"github.com/antha-lang/antha/antha/anthalib/wtype"
+	"github.com/antha-lang/antha/antha/anthalib/wunit"
+)
+
+// both solvers must give the same answers to the planning problems
+
+func TestSolversAgree(t *testing.T) {
+	defer withLPSolver(DefaultLPSolver)()
+
+	// the same problems every time so a difference can be looked into
+	rnd := rand.New(rand.NewSource(1))
+
+	names := []string{"tea", "milk", "sugar"}
+
+	minrequired := make(map[string]float64, len(names))
+	maxrequired := make(map[string]float64, len(names))
+	Smax := make(map[string]float64, len(names))
+	T := make(map[string]float64, len(names))
+
+	for _, name := range names {
+		r := rnd.Float64() + 1.0
+		r2 := rnd.Float64() + 1.0
+		r3 := rnd.Float64() + 1.0
+
+		minrequired[name] = r * r2 * 20.0
+		maxrequired[name] = r * r2 * 30.0
+		Smax[name] = r * r2 * r3 * 70.0
+		T[name] = 100.0
+	}
+
+	DefaultLPSolver = NewSimplexSolver()
+	cncs, _ := choose_stock_concentrations(minrequired, maxrequired, Smax, 10.0, T)
+	DefaultLPSolver = NewLPSolver()
+	cncs2, _ := choose_stock_concentrations(minrequired, maxrequired, Smax, 10.0, T)
+
+	for _, name := range names {
+		if math.Abs(cncs[name]-cncs2[name]) > 0.000001 {
+			t.Errorf("%s: simplex gives %g, glpk %g", name, cncs[name], cncs2[name])
+		}
+	}
+
+	cmps := make(map[string]wunit.Volume)
+
+	for _, cmpn := range factory.GetComponentList() {
+		cmps[cmpn] = wunit.NewVolume(rnd.Float64()*10000.0, "ul")
+	}
+
+	plates := make([]*wtype.LHPlate, 0)
+
+	for _, p := range factory.GetPlateList() {
+		plates = append(plates, factory.GetPlateByType(p))
+	}
+
+	wtc := map[string]float64{"MAX_N_PLATES": 2.0, "MAX_N_WELLS": 96.0, "RESIDUAL_VOLUME_WEIGHT": 1.0}
+
+	// the objective first, so a difference there shows up as such
+
+	objective := func(ass map[string]map[*wtype.LHPlate]int) float64 {
+		z := 0.0
+		for _, cmap := range ass {
+			for plt, nw := range cmap {
+				rv := plt.Welltype.ResidualVolume()
+				z += float64(nw) * rv.ConvertTo(wunit.ParsePrefixedUnit("ul"))
+			}
+		}
+		return z
+	}
+
+	DefaultLPSolver = NewSimplexSolver()
+	ass, _ := choose_plate_assignments(cmps, plates, wtc)
+	z := objective(ass)
+	DefaultLPSolver = NewLPSolver()
+	ass2, _ := choose_plate_assignments(cmps, plates, wtc)
+	z2 := objective(ass2)
+
+	if math.Abs(z-z2) > 0.000001 {
+		t.Errorf("plate assignments: simplex gives %g, glpk %g", z, z2)
+	}
+
+	// then which plates each component is put in
+
+	for _, cmpn := range factory.GetComponentList() {
+		for _, plt := range plates {
+			if ass[cmpn][plt] != ass2[cmpn][plt] {
+				t.Errorf("%s: simplex puts it in %d wells of %s, glpk %d", cmpn, ass[cmpn][plt], plt.Type, ass2[cmpn][plt])
+			}
+		}
+	}
+}
+
+// plans made with GLPK must be the same as the golden files, which are
+// made with the pure go solver
+func TestGoldenPlansGLPK(t *testing.T) {
+	defer withLPSolver(NewLPSolver())()
+	checkGoldenPlans(t, false)
+}
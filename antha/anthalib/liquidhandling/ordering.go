@@ -0,0 +1,191 @@
+// /anthalib/liquidhandling/ordering.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+import (
+	"fmt"
+	"github.com/antha-lang/antha/antha/anthalib/wtype"
+	"sort"
+	"strings"
+)
+
+// planning has to give the same answer every time it sees the same
+// request, so nothing may depend on the order in which go hands back
+// map entries. Solution IDs are generated afresh on every run so we
+// can't just sort on those: instead each solution gets a key made up of
+// what it is, and the ID only breaks ties between identical solutions
+
+// returns the IDs of the solutions in a stable order
+func sorted_solution_ids(solutions map[string]*wtype.LHSolution) []string {
+	keys := make(map[string]string, len(solutions))
+	ids := make([]string, 0, len(solutions))
+
+	for id, _ := range solutions {
+		keys[id] = solution_key(solutions, id, keys, 0)
+		ids = append(ids, id)
+	}
+
+	sort.Sort(byKey{ids, keys})
+
+	return ids
+}
+
+func solution_key(solutions map[string]*wtype.LHSolution, id string, keys map[string]string, depth int) string {
+	if k, ok := keys[id]; ok {
+		return k
+	}
+
+	sol := solutions[id]
+
+	// stop at anything we don't know about or which loops back on itself
+	if sol == nil || depth > len(solutions) {
+		return ""
+	}
+
+	s := make([]string, 0, len(sol.Components)+2)
+	s = append(s, sol.SName, sol.Platetype)
+
+	for _, component := range sol.Components {
+		name := component.CName
+
+		// components made earlier in the request are named for
+		// where they come from
+		if component.Source != "" {
+			name = "<" + solution_key(solutions, component.Source, keys, depth+1) + ">"
+		}
+
+		s = append(s, fmt.Sprintf("%s/%d/%g/%g/%g", name, component.Order, component.Vol, component.Conc, component.Tvol))
+	}
+
+	return strings.Join(s, ",")
+}
+
+type byKey struct {
+	ids  []string
+	keys map[string]string
+}
+
+func (bk byKey) Len() int {
+	return len(bk.ids)
+}
+
+func (bk byKey) Swap(i, j int) {
+	bk.ids[i], bk.ids[j] = bk.ids[j], bk.ids[i]
+}
+
+func (bk byKey) Less(i, j int) bool {
+	a := bk.keys[bk.ids[i]]
+	b := bk.keys[bk.ids[j]]
+
+	if a != b {
+		return a < b
+	}
+
+	return bk.ids[i] < bk.ids[j]
+}
+
+// returns the names of the inputs in a stable order
+func sorted_input_names(inputs map[string][]*wtype.LHComponent) []string {
+	names := make([]string, 0, len(inputs))
+
+	for name, _ := range inputs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// plates in order of name, then type, then ID
+func sorted_plates(plates map[string]*wtype.LHPlate) []*wtype.LHPlate {
+	ret := make([]*wtype.LHPlate, 0, len(plates))
+
+	for _, p := range plates {
+		ret = append(ret, p)
+	}
+
+	sort.Sort(byPlateName(ret))
+
+	return ret
+}
+
+type byPlateName []*wtype.LHPlate
+
+func (bpn byPlateName) Len() int {
+	return len(bpn)
+}
+
+func (bpn byPlateName) Swap(i, j int) {
+	bpn[i], bpn[j] = bpn[j], bpn[i]
+}
+
+func (bpn byPlateName) Less(i, j int) bool {
+	if bpn[i].PlateName != bpn[j].PlateName {
+		return bpn[i].PlateName < bpn[j].PlateName
+	}
+	if bpn[i].Type != bpn[j].Type {
+		return bpn[i].Type < bpn[j].Type
+	}
+	return bpn[i].ID < bpn[j].ID
+}
+
+// layout groups in ascending order
+func sorted_group_keys(groups map[int][]string) []int {
+	keys := make([]int, 0, len(groups))
+
+	for k, _ := range groups {
+		keys = append(keys, k)
+	}
+
+	sort.Ints(keys)
+
+	return keys
+}
+
+func sorted_int_keys(m map[string]int) []string {
+	ret := make([]string, 0, len(m))
+	for k, _ := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func sorted_float_keys(m map[string]float64) []string {
+	ret := make([]string, 0, len(m))
+	for k, _ := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func sorted_tipbox_positions(m map[string]LHTipboxState) []string {
+	ret := make([]string, 0, len(m))
+	for k, _ := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
@@ -25,10 +25,9 @@ package liquidhandling
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+
 	"github.com/antha-lang/antha/antha/anthalib/driver/liquidhandling"
-	"github.com/antha-lang/antha/antha/anthalib/wtype"
-	"github.com/antha-lang/antha/antha/anthalib/wutil"
-	"strconv"
 )
 
 // functions to deal with how to serialize / deserialize the relevant objects.
@@ -38,91 +37,93 @@ import (
 
 // marshal / unmarshal methods for the top-level lhrequest class
 
+// lhrequest has all the fields of LHRequest but none of its methods so
+// it can be written the usual way
+type lhrequest LHRequest
+
+// everything in the request is written as it is apart from the
+// instructions, which are tagged with their type so they can be read
+// back
 type SLHRequest struct {
-	ID                         string
-	Output_solutions           map[string]*wtype.LHSolution
-	Input_solutions            map[string][]*wtype.LHComponent
-	Plates                     map[string]*wtype.LHPlate
-	Tips                       []*wtype.LHTipbox
-	Locats                     []string
-	Setup                      wtype.LHSetup
-	InstructionSet             *liquidhandling.RobotInstructionSet
-	Instructions               []liquidhandling.TerminalRobotInstruction
-	Robotfn                    string
-	Input_assignments          map[string][]string
-	Output_plates              map[string]*wtype.LHPlate
-	Input_platetypes           []*wtype.LHPlate
-	Input_major_group_layouts  map[string][]string
-	Input_minor_group_layouts  [][]string
-	Input_plate_layout         map[string]string
-	Output_platetype           *wtype.LHPlate
-	Output_major_group_layouts map[string][]string
-	Output_minor_group_layouts [][]string
-	Output_plate_layout        map[string]string
-	Plate_lookup               map[string]string
-	Stockconcs                 map[string]float64
-	Policies                   *liquidhandling.LHPolicyRuleSet
+	*lhrequest
+	Instructions []json.RawMessage
 }
 
 func (req *LHRequest) MarshalJSON() ([]byte, error) {
-	new_input_major_layouts := make(map[string][]string, len(req.Input_major_group_layouts))
+	slhr := SLHRequest{lhrequest: (*lhrequest)(req)}
 
-	for k, v := range req.Input_major_group_layouts {
-		new_input_major_layouts[strconv.Itoa(k)] = v
+	if req.Instructions != nil {
+		slhr.Instructions = make([]json.RawMessage, 0, len(req.Instructions))
 	}
 
-	new_input_plate_layout := make(map[string]string, len(req.Input_plate_layout))
-
-	for k, v := range req.Input_plate_layout {
-		new_input_plate_layout[strconv.Itoa(k)] = v
-	}
-	new_output_major_layouts := make(map[string][]string, len(req.Output_major_group_layouts))
+	for _, ins := range req.Instructions {
+		b, err := liquidhandling.MarshalRobotInstruction(ins)
 
-	for k, v := range req.Output_major_group_layouts {
-		new_output_major_layouts[strconv.Itoa(k)] = v
-	}
-	new_output_plate_layout := make(map[string]string, len(req.Output_plate_layout))
+		if err != nil {
+			return nil, err
+		}
 
-	for k, v := range req.Output_plate_layout {
-		new_output_plate_layout[strconv.Itoa(k)] = v
+		slhr.Instructions = append(slhr.Instructions, b)
 	}
 
-	slhr := SLHRequest{req.ID, req.Output_solutions, req.Input_solutions, req.Plates, req.Tips, req.Locats, req.Setup, req.InstructionSet, req.Instructions, req.Robotfn, req.Input_assignments, req.Output_plates, req.Input_platetypes, new_input_major_layouts, req.Input_minor_group_layouts, new_input_plate_layout, req.Output_platetype, new_output_major_layouts, req.Output_minor_group_layouts, new_output_plate_layout, req.Plate_lookup, req.Stockconcs, req.Policies}
-
 	return json.Marshal(slhr)
 }
 
 func (req *LHRequest) UnmarshalJSON(ar []byte) error {
-	var slhr SLHRequest
-	e := json.Unmarshal(ar, req)
+	slhr := SLHRequest{lhrequest: (*lhrequest)(req)}
+
+	if err := json.Unmarshal(ar, &slhr); err != nil {
+		return err
+	}
 
-	fmt.Println("ERR: ", e)
+	req.Instructions = nil
 
-	e = json.Unmarshal(ar, slhr)
+	if slhr.Instructions != nil {
+		req.Instructions = make([]liquidhandling.TerminalRobotInstruction, 0, len(slhr.Instructions))
+	}
+
+	for _, b := range slhr.Instructions {
+		ins, err := liquidhandling.UnmarshalRobotInstruction(b)
+
+		if err != nil {
+			return err
+		}
+
+		tins, ok := ins.(liquidhandling.TerminalRobotInstruction)
 
-	req.Input_major_group_layouts = make(map[int][]string, len(slhr.Input_major_group_layouts))
+		if !ok {
+			return fmt.Errorf("instruction %s in request %s cannot be run", liquidhandling.Robotinstructionnames[ins.InstructionType()], req.ID)
+		}
 
-	for k, v := range slhr.Input_major_group_layouts {
-		req.Input_major_group_layouts[wutil.ParseInt(k)] = v
+		req.Instructions = append(req.Instructions, tins)
 	}
 
-	req.Input_plate_layout = make(map[int]string, len(slhr.Input_plate_layout))
+	return nil
+}
+
+// a planned request can be saved and run later
+func (req *LHRequest) Save(filename string) error {
+	dat, err := json.Marshal(req)
 
-	for k, v := range slhr.Input_plate_layout {
-		req.Input_plate_layout[wutil.ParseInt(k)] = v
+	if err != nil {
+		return err
 	}
 
-	req.Output_major_group_layouts = make(map[int][]string, len(slhr.Output_major_group_layouts))
+	return ioutil.WriteFile(filename, dat, 0644)
+}
+
+func LoadLHRequest(filename string) (*LHRequest, error) {
+	dat, err := ioutil.ReadFile(filename)
 
-	for k, v := range slhr.Output_major_group_layouts {
-		req.Output_major_group_layouts[wutil.ParseInt(k)] = v
+	if err != nil {
+		return nil, err
 	}
 
-	req.Output_plate_layout = make(map[int]string, len(slhr.Output_plate_layout))
+	req := NewLHRequest()
 
-	for k, v := range slhr.Output_plate_layout {
-		req.Output_plate_layout[wutil.ParseInt(k)] = v
+	if err := json.Unmarshal(dat, req); err != nil {
+		return nil, err
 	}
 
-	return e
+	return req, nil
 }
@@ -68,7 +68,7 @@ func BasicSetupAgent(request *LHRequest, params *liquidhandling.LHProperties) *L
 
 	for _, tb := range tips {
 		// get the first available position from the preferences
-		pos := get_first_available_preference(tip_preferences, setup)
+		pos := get_first_available_preference(tip_preferences, setup, params)
 		if pos == -1 {
 			RaiseError("No positions left for tipbox")
 		}
@@ -87,8 +87,8 @@ func BasicSetupAgent(request *LHRequest, params *liquidhandling.LHProperties) *L
 
 	// outputs
 
-	for _, p := range output_plates {
-		pos := get_first_available_preference(output_preferences, setup)
+	for _, p := range sorted_plates(output_plates) {
+		pos := get_first_available_preference(output_preferences, setup, params)
 		if pos == -1 {
 			RaiseError("No positions left for output")
 		}
@@ -100,8 +100,8 @@ func BasicSetupAgent(request *LHRequest, params *liquidhandling.LHProperties) *L
 
 	// inputs
 
-	for _, p := range input_plates {
-		pos := get_first_available_preference(input_preferences, setup)
+	for _, p := range sorted_plates(input_plates) {
+		pos := get_first_available_preference(input_preferences, setup, params)
 		if pos == -1 {
 			RaiseError("No positions left for input")
 		}
@@ -116,13 +116,18 @@ func BasicSetupAgent(request *LHRequest, params *liquidhandling.LHProperties) *L
 	return request
 }
 
-func get_first_available_preference(prefs []int, setup map[string]interface{}) int {
+func get_first_available_preference(prefs []int, setup map[string]interface{}, params *liquidhandling.LHProperties) int {
 	for _, pref := range prefs {
-		position := "position_" + string(pref)
+		position := "position_" + strconv.Itoa(pref)
 		_, ok := setup[position]
-		if !ok {
-			return pref
+		if ok {
+			continue
 		}
+		// the tip boxes and waste go on before we get here
+		if params.PosLookup[position] != "" {
+			continue
+		}
+		return pref
 	}
 	return -1
 }
@@ -0,0 +1,207 @@
+// /anthalib/liquidhandling/solution_dependencies.go: Part of the Antha language
+// Copyright (C) 2015 The Antha authors. All rights reserved.
+// 
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+// 
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+// 
+// For more information relating to the software or licensing issues please
+// contact license@antha-lang.org or write to the Antha team c/o 
+// Synthace Ltd. The London Bioscience Innovation Centre
+// 1 Royal College St, London NW1 0NH UK
+
+package liquidhandling
+
+import (
+	"errors"
+	"fmt"
+	"github.com/antha-lang/antha/antha/anthalib/wtype"
+	"github.com/antha-lang/antha/antha/anthalib/wunit"
+	"strings"
+)
+
+// solutions can be made from other solutions in the same request
+// these form a graph which has to be acyclic; we make solutions in
+// stages, each stage only takes from solutions made in earlier ones
+
+// works out which stage each solution is made in: solutions made only
+// from inputs are in stage 0, anything else is one stage after the
+// latest of its sources
+func solution_stages(solutions map[string]*wtype.LHSolution) (map[string]int, error) {
+	stages := make(map[string]int, len(solutions))
+
+	// 0: not visited 1: in progress 2: done
+	state := make(map[string]int, len(solutions))
+
+	var visit func(id string, path []string) error
+
+	visit = func(id string, path []string) error {
+		switch state[id] {
+		case 2:
+			return nil
+		case 1:
+			return errors.New(fmt.Sprintf("Solutions depend on each other in a cycle: %s", describe_cycle(solutions, append(path, id))))
+		}
+
+		state[id] = 1
+		path = append(path, id)
+		stage := 0
+
+		for _, component := range solutions[id].Components {
+			if component.Source == "" {
+				continue
+			}
+
+			source, ok := solutions[component.Source]
+
+			if !ok {
+				return errors.New(fmt.Sprintf("Component %s of solution %s is taken from solution %s which is not part of this request", component.CName, solution_name(solutions[id]), component.Source))
+			}
+
+			if component.Conc != 0.0 {
+				return errors.New(fmt.Sprintf("Component %s of solution %s is taken from solution %s: this must be done by volume, not concentration", component.CName, solution_name(solutions[id]), solution_name(source)))
+			}
+
+			err := visit(source.ID, path)
+
+			if err != nil {
+				return err
+			}
+
+			if stages[source.ID]+1 > stage {
+				stage = stages[source.ID] + 1
+			}
+		}
+
+		stages[id] = stage
+		state[id] = 2
+		return nil
+	}
+
+	// go through in a fixed order so any error is reported consistently
+	for _, id := range sorted_solution_ids(solutions) {
+		err := visit(id, make([]string, 0, 4))
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return stages, nil
+}
+
+// the number of stages needed to make all the solutions
+func count_stages(stages map[string]int) int {
+	n := 0
+	for _, s := range stages {
+		if s+1 > n {
+			n = s + 1
+		}
+	}
+	return n
+}
+
+// path ends with the solution which closes the cycle
+func describe_cycle(solutions map[string]*wtype.LHSolution, path []string) string {
+	last := path[len(path)-1]
+
+	start := 0
+	for i, id := range path {
+		if id == last {
+			start = i
+			break
+		}
+	}
+
+	names := make([]string, 0, len(path)-start)
+
+	for _, id := range path[start:] {
+		names = append(names, solution_name(solutions[id]))
+	}
+
+	return strings.Join(names, " -> ")
+}
+
+func solution_name(sol *wtype.LHSolution) string {
+	if sol.SName != "" {
+		return sol.SName
+	}
+	return sol.ID
+}
+
+// makes sure every solution which is taken from by a later stage has
+// enough in it to cover what is taken plus whatever has to be left behind
+// in the well. Needs to be called once all volumes are known
+func check_intermediate_volumes(request *LHRequest) error {
+	solutions := request.Output_solutions
+
+	// how much of each is used later on, including losses
+
+	needed := make(map[string]float64, len(solutions))
+	labware := ""
+
+	if request.Output_platetype != nil {
+		labware = request.Output_platetype.Type
+	}
+
+	ids := sorted_solution_ids(solutions)
+
+	for _, id := range ids {
+		for _, component := range solutions[id].Components {
+			if component.Source == "" {
+				continue
+			}
+			needed[component.Source] += component_volume_ul(component) + request.Loss_model.TransferLoss(component.CName, labware)
+		}
+	}
+
+	for id, _ := range needed {
+		needed[id] += request.Loss_model.EvaporationLoss(id, labware)
+	}
+
+	residual := 0.0
+
+	if request.Output_platetype != nil && request.Output_platetype.Welltype != nil {
+		rv := wunit.NewVolume(request.Output_platetype.Welltype.Rvol, request.Output_platetype.Welltype.Vunit)
+		residual = rv.ConvertToString("ul")
+	}
+
+	for _, id := range ids {
+		if _, ok := needed[id]; !ok {
+			continue
+		}
+
+		made := 0.0
+
+		for _, component := range solutions[id].Components {
+			made += component_volume_ul(component)
+		}
+
+		if needed[id] > made-residual {
+			return errors.New(fmt.Sprintf("Solution %s: later steps need %-6.2f ul but only %-6.2f ul can be taken from %-6.2f ul made", solution_name(solutions[id]), needed[id], made-residual, made))
+		}
+	}
+
+	return nil
+}
+
+func component_volume_ul(component *wtype.LHComponent) float64 {
+	unit := component.Vunit
+
+	if unit == "" {
+		unit = "ul"
+	}
+
+	v := wunit.NewVolume(component.Vol, unit)
+	return v.ConvertToString("ul")
+}
@@ -29,6 +29,7 @@ import (
 	"github.com/antha-lang/antha/antha/anthalib/wtype"
 	"github.com/antha-lang/antha/antha/anthalib/wunit"
 	"github.com/antha-lang/antha/antha/anthalib/wutil"
+	"sort"
 )
 
 // determines how to
@@ -37,7 +38,7 @@ import (
 // WHERE DO WE GET THE STOCK CONCENTRATIONS FROM???
 // NEED TO SPECIFY THESE
 
-func solution_setup(request *LHRequest, prms *liquidhandling.LHProperties) (map[string]*wtype.LHSolution, map[string]float64) {
+func solution_setup(request *LHRequest, prms *liquidhandling.LHProperties) (map[string]*wtype.LHSolution, map[string]float64, error) {
 	solutions := request.Output_solutions
 
 	// index of components used to make up to a total volume, along with the required total
@@ -51,9 +52,13 @@ func solution_setup(request *LHRequest, prms *liquidhandling.LHProperties) (map[
 	// maximum total volume of any solution containing each component
 	hshTVol := make(map[string]float64)
 
+	// always go through the solutions in the same order
+	solution_ids := sorted_solution_ids(solutions)
+
 	// find the minimum and maximum required concentrations
 	// across all the solutions
-	for _, solution := range solutions {
+	for _, id := range solution_ids {
+		solution := solutions[id]
 		components := solution.Components
 
 		// we need to identify the concentration components
@@ -152,7 +157,14 @@ func solution_setup(request *LHRequest, prms *liquidhandling.LHProperties) (map[
 		vmin = *(prms.CurrConf.Minvol)
 	}
 
-	for cmp, arr := range mconcs {
+	concnames := make([]string, 0, len(mconcs))
+	for cmp, _ := range mconcs {
+		concnames = append(concnames, cmp)
+	}
+	sort.Strings(concnames)
+
+	for _, cmp := range concnames {
+		arr := mconcs[cmp]
 		min := wutil.FMin(arr)
 		max := wutil.FMax(arr)
 		minrequired[cmp] = min
@@ -168,10 +180,14 @@ func solution_setup(request *LHRequest, prms *liquidhandling.LHProperties) (map[
 
 	}
 
-	stockconcs := choose_stock_concentrations(minrequired, maxrequired, Smax, vmin.RawValue(), hshTVol)
+	stockconcs, result := choose_stock_concentrations(minrequired, maxrequired, Smax, vmin.RawValue(), hshTVol)
 
 	// handle any errors here
 
+	if err := result.Err(); err != nil {
+		return solutions, stockconcs, err
+	}
+
 	// add the fixed concentrations into stockconcs
 
 	for _, cmp := range fixconcs {
@@ -184,7 +200,8 @@ func solution_setup(request *LHRequest, prms *liquidhandling.LHProperties) (map[
 
 	newSolutions := make(map[string]*wtype.LHSolution, len(solutions))
 
-	for _, solution := range solutions {
+	for _, id := range solution_ids {
+		solution := solutions[id]
 		components := solution.Components
 		arrCncs := make([]*wtype.LHComponent, 0, len(components))
 		arrTvol := make([]*wtype.LHComponent, 0, len(components))
@@ -251,5 +268,5 @@ func solution_setup(request *LHRequest, prms *liquidhandling.LHProperties) (map[
 		newSolutions[solution.ID] = solution
 	}
 
-	return newSolutions, stockconcs
+	return newSolutions, stockconcs, nil
 }
@@ -24,9 +24,9 @@ package liquidhandling
 
 import (
 	"errors"
+	"fmt"
+	"sort"
 
-	lhdriver "github.com/antha-lang/antha/antha/anthalib/driver/liquidhandling"
-	"github.com/antha-lang/antha/antha/anthalib/factory"
 	"github.com/antha-lang/antha/antha/anthalib/wtype"
 	"github.com/antha-lang/antha/antha/anthalib/wutil"
 )
@@ -34,37 +34,65 @@ import (
 //  TASK: 	Determine number of tip boxes of each type
 // INPUT: 	instructions
 //OUTPUT: 	arrays of tip boxes
+// the boxes set out while the instructions were made are the ones we
+// need; they go back on the deck as they were before the run and how
+// they will be left is kept as the final deck state
 func (lh *Liquidhandler) Tip_box_setup(request *LHRequest) *LHRequest {
 	tip_box_type := (*request).Tip_Type
 	if tip_box_type == nil || tip_box_type.ID == "" {
 		wutil.Error(errors.New("tip_box_setup: No tip_box type defined"))
 	}
-	tip_boxes := (*request).Tips
-	if len(tip_boxes) == 0 {
-		tip_boxes = make([]*wtype.LHTipbox, 0)
+
+	request.Final_deck_state = GetDeckState(lh.Properties)
+
+	start := request.Deck_state
+
+	if start == nil {
+		start = NewLHDeckState()
 	}
 
-	// the instructions are generated at this point so we just need to go through and count the tips used
-	// of each type
+	positions := make([]string, 0, len(lh.Properties.Tipboxes))
+	for pos, _ := range lh.Properties.Tipboxes {
+		positions = append(positions, pos)
+	}
+	sort.Strings(positions)
+
+	tip_boxes := make([]*wtype.LHTipbox, 0, len(positions))
+	tip_positions := make([]string, 0, len(positions))
+	taken := make(map[string]int)
+
+	for _, pos := range positions {
+		tb := lh.Properties.Tipboxes[pos]
+		before := tb.Dup()
+
+		// empty boxes were taken off at the start so a box here now
+		// may be a fresh one
+		st, ok := start.Tipboxes[pos]
+		ok = ok && st.Type == tb.Type && len(st.Used) < before.NTips
 
-	instrx := request.Instructions
+		if ok {
+			before.RemoveTipsAt(st.Used)
+		}
 
-	ntips := make(map[string]int)
+		n := before.NTips - tb.NTips
 
-	for _, ins := range instrx {
-		if ins.InstructionType() == lhdriver.LOD {
-			ttype := ins.GetParameter("TIPTYPE").([]string)[0]
-			ntips[ttype] += ins.GetParameter("MULTI").(int)
+		// boxes nobody took anything from needn't go out
+		if n == 0 && !ok {
+			continue
 		}
+
+		taken[tb.Tiptype.Type] += n
+		tip_boxes = append(tip_boxes, before)
+		tip_positions = append(tip_positions, pos)
 	}
 
-	for tiptype, ntip := range ntips {
-		// need to make sure the names match up here
-		tbt := factory.GetTipByType(tiptype)
-		ntbx := ntip/tbt.NTips + 1
-		for i := 0; i < ntbx; i++ {
-			tbt2 := factory.GetTipByType(tiptype)
-			tip_boxes = append(tip_boxes, tbt2)
+	// the instructions must have used exactly the tips taken
+
+	ntips := lh.Properties.TipTracking().TipsUsed
+
+	for tiptype, n := range ntips {
+		if taken[tiptype] != n {
+			wutil.Error(fmt.Errorf("tip_box_setup: instructions use %d %s tips but %d were taken from the boxes", n, tiptype, taken[tiptype]))
 		}
 	}
 
@@ -74,8 +102,14 @@ func (lh *Liquidhandler) Tip_box_setup(request *LHRequest) *LHRequest {
 
 	lh.Properties.RemoveTipBoxes()
 
-	for _, tb := range tip_boxes {
-		lh.Properties.AddTipBox(tb)
+	for i, tb := range tip_boxes {
+		lh.Properties.AddTipBoxTo(tip_positions[i], tb)
+	}
+
+	// the waste starts off as it was too
+
+	for pos, tw := range lh.Properties.Tipwastes {
+		tw.Contents = start.Tipwastes[pos]
 	}
 
 	return request
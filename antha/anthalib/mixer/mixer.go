@@ -55,6 +55,20 @@ func Sample(l wtype.Liquid, v wunit.Volume) *wtype.LHComponent {
 	return ret
 }
 
+// take a sample of volume v from a solution made earlier in the same
+// request; the planner schedules this after the solution is complete
+// and takes it from wherever the solution ends up
+func SampleSolution(s *wtype.LHSolution, v wunit.Volume) *wtype.LHComponent {
+	ret := wtype.NewLHComponent()
+
+	ret.CName = s.ID
+	ret.Source = s.ID
+	ret.Vol = v.RawValue()
+	ret.Vunit = v.Unit().PrefixedSymbol()
+
+	return ret
+}
+
 // take a sample of this liquid and aim for a particular concentration
 func SampleForConcentration(l wtype.Liquid, c wunit.Concentration) *wtype.LHComponent {
 	ret := wtype.NewLHComponent()
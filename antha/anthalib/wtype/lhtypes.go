@@ -29,6 +29,7 @@ import (
 	"fmt"
 	"github.com/antha-lang/antha/antha/anthalib/wunit"
 	"github.com/antha-lang/antha/antha/anthalib/wutil"
+	"math"
 	"strconv"
 	"strings"
 )
@@ -253,7 +254,10 @@ type LHComponent struct {
 	StockConcentration float64
 	LContainer         *LHWell
 	Destination        string
-	Extra              map[string]interface{}
+	// ID of the solution this component is taken from, if it is
+	// made earlier in the same request rather than coming in as an input
+	Source string
+	Extra  map[string]interface{}
 }
 
 func (lhc *LHComponent) Dup() *LHComponent {
@@ -270,6 +274,7 @@ func (lhc *LHComponent) Dup() *LHComponent {
 	c.Visc = lhc.Visc
 	c.LContainer = lhc.LContainer
 	c.Destination = lhc.Destination
+	c.Source = lhc.Source
 	c.StockConcentration = lhc.StockConcentration
 	c.Extra = make(map[string]interface{}, len(lhc.Extra))
 	for k, v := range lhc.Extra {
@@ -564,6 +569,47 @@ func (w *LHWell) updateVolume() {
 	}
 }
 
+// height of the liquid surface above the well bottom when the well
+// holds v - dimensions are taken to be in mm so that ul and cubic mm
+// agree. Shaped bottoms are treated as tapering to a point over
+// Bottomh, flat ones hold liquid all the way down
+func (w *LHWell) LiquidHeight(v *wunit.Volume) float64 {
+	vol := v.ConvertTo(wunit.ParsePrefixedUnit("ul"))
+
+	area := w.Xdim * w.Ydim
+
+	if w.WShape != nil && w.WShape.ShapeName() == "cylinder" {
+		area = math.Pi * w.Xdim * w.Xdim / 4.0
+	}
+
+	if vol <= 0.0 || area <= 0.0 {
+		return 0.0
+	}
+
+	h := 0.0
+
+	if w.Bottom != 0 && w.Bottomh > 0.0 {
+		// a cone or pyramid holds a third of the volume of
+		// the prism around it
+		bvol := area * w.Bottomh / 3.0
+
+		if vol < bvol {
+			return w.Bottomh * math.Cbrt(vol/bvol)
+		}
+
+		vol -= bvol
+		h = w.Bottomh
+	}
+
+	h += vol / area
+
+	if w.Zdim > 0.0 && h > w.Zdim {
+		h = w.Zdim
+	}
+
+	return h
+}
+
 //@implement Location
 
 func (lhw *LHWell) Location_ID() string {
@@ -877,56 +923,121 @@ func (tb *LHTipbox) GetName() string {
 // actually useful functions
 // TODO implement Mirror
 
+// tips are taken in runs of multi along the line the channels lie in:
+// columns for heads in the vertical orientation, rows in the horizontal.
+// Runs shorter than a line come out of lines which have already been
+// started so that full lines are left for multichannel use
 func (tb *LHTipbox) GetTips(mirror bool, multi, orient int) []string {
 	// this removes the tips as well
-	var ret []string = nil
+	nlines, linelen := tb.Ncols, tb.Nrows
+
 	if orient == LHHChannel {
-		for j := 0; j < tb.Nrows; j++ {
-			c := 0
-			for i := 0; i < tb.Ncols; i++ {
-				if tb.Tips[i][j] != nil && !tb.Tips[i][j].Dirty {
-					c += 1
-				}
-			}
+		nlines, linelen = tb.Nrows, tb.Ncols
+	} else if orient != LHVChannel {
+		return nil
+	}
 
-			if c >= multi {
-				ret = make([]string, multi)
-				for i := 0; i < multi; i++ {
-					tb.Tips[i][j] = nil
-					wc := WellCoords{j, i}
-					ret[i] = wc.FormatA1()
-				}
-				break
-			}
+	if multi <= 0 || multi > linelen {
+		return nil
+	}
+
+	// column and row of tip k on line l
+	at := func(l, k int) (int, int) {
+		if orient == LHHChannel {
+			return k, l
 		}
+		return l, k
+	}
+
+	best, beststart, bestfree := -1, -1, 0
 
-	} else if orient == LHVChannel {
-		for i := 0; i < tb.Ncols; i++ {
-			c := 0
-			for j := 0; j < tb.Nrows; j++ {
-				if tb.Tips[i][j] != nil && !tb.Tips[i][j].Dirty {
-					c += 1
+	for l := 0; l < nlines; l++ {
+		free, run, start := 0, 0, -1
+		for k := 0; k < linelen; k++ {
+			if tb.hasCleanTip(at(l, k)) {
+				free += 1
+				run += 1
+				if run >= multi && start < 0 {
+					start = k - multi + 1
 				}
+			} else {
+				run = 0
 			}
+		}
 
-			if c >= multi {
-				ret = make([]string, multi)
+		if start < 0 {
+			continue
+		}
 
-				for j := 0; j < multi; j++ {
-					tb.Tips[i][j] = nil
-					wc := WellCoords{j, i}
-					ret[j] = wc.FormatA1()
-				}
-				break
-			}
+		if best < 0 || free < bestfree {
+			best, beststart, bestfree = l, start, free
 		}
+	}
+
+	if best < 0 {
+		return nil
+	}
+
+	ret := make([]string, multi)
 
+	for k := 0; k < multi; k++ {
+		c, r := at(best, beststart+k)
+		tb.Tips[c][r] = nil
+		wc := WellCoords{c, r}
+		ret[k] = wc.FormatA1()
 	}
 
 	tb.NTips -= multi
 	return ret
 }
 
+func (tb *LHTipbox) hasCleanTip(col, row int) bool {
+	return tb.Tips[col][row] != nil && !tb.Tips[col][row].Dirty
+}
+
+func (tb *LHTipbox) wellIndex(well string) (int, int, bool) {
+	if len(well) < 2 {
+		return 0, 0, false
+	}
+	wc := MakeWellCoordsA1(well)
+	if wc.X < 0 || wc.X >= tb.Ncols || wc.Y < 0 || wc.Y >= tb.Nrows {
+		return 0, 0, false
+	}
+	return wc.X, wc.Y, true
+}
+
+// is there a tip at this well, e.g. "A1"
+func (tb *LHTipbox) HasTipAt(well string) bool {
+	c, r, ok := tb.wellIndex(well)
+	return ok && tb.Tips[c][r] != nil
+}
+
+// take tips out without using them, e.g. to match a box which
+// has been partly used already
+func (tb *LHTipbox) RemoveTipsAt(wells []string) {
+	for _, well := range wells {
+		c, r, ok := tb.wellIndex(well)
+		if ok && tb.Tips[c][r] != nil {
+			tb.Tips[c][r] = nil
+			tb.NTips -= 1
+		}
+	}
+}
+
+// the wells tips have been taken from, column by column
+func (tb *LHTipbox) UsedTips() []string {
+	ret := make([]string, 0, tb.Nrows*tb.Ncols-tb.NTips)
+	for c := 0; c < tb.Ncols; c++ {
+		for r := 0; r < tb.Nrows; r++ {
+			if tb.Tips[c][r] == nil {
+				wc := WellCoords{c, r}
+				ret = append(ret, wc.FormatA1())
+			}
+		}
+	}
+	return ret
+}
+
 func initialize_tips(tipbox *LHTipbox, tiptype *LHTip) *LHTipbox {
 	nr := tipbox.Nrows
 	nc := tipbox.Ncols
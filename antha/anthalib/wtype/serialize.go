@@ -93,6 +93,11 @@ type SLHPlate struct {
 	Welltype       *LHWell
 	Wellcoords     map[string]*LHWell
 	Welldimensions *LHWellType
+	WellXOffset    float64
+	WellYOffset    float64
+	WellXStart     float64
+	WellYStart     float64
+	WellZStart     float64
 }
 
 func (slhp SLHPlate) FillPlate(plate *LHPlate) {
@@ -109,6 +114,11 @@ func (slhp SLHPlate) FillPlate(plate *LHPlate) {
 	plate.Hunit = slhp.Hunit
 	plate.Welltype = slhp.Welltype
 	plate.Wellcoords = slhp.Wellcoords
+	plate.WellXOffset = slhp.WellXOffset
+	plate.WellYOffset = slhp.WellYOffset
+	plate.WellXStart = slhp.WellXStart
+	plate.WellYStart = slhp.WellYStart
+	plate.WellZStart = slhp.WellZStart
 }
 
 // this is for keeping track of the well type
@@ -146,7 +156,7 @@ func (plate *LHPlate) Welldimensions() *LHWellType {
 }
 
 func (plate *LHPlate) MarshalJSON() ([]byte, error) {
-	slp := SLHPlate{plate.ID, plate.Inst, plate.Loc, plate.PlateName, plate.Type, plate.Mnfr, plate.WlsX, plate.WlsY, plate.Nwells, plate.Height, plate.Hunit, plate.Welltype, plate.Wellcoords, plate.Welldimensions()}
+	slp := SLHPlate{plate.ID, plate.Inst, plate.Loc, plate.PlateName, plate.Type, plate.Mnfr, plate.WlsX, plate.WlsY, plate.Nwells, plate.Height, plate.Hunit, plate.Welltype, plate.Wellcoords, plate.Welldimensions(), plate.WellXOffset, plate.WellYOffset, plate.WellXStart, plate.WellYStart, plate.WellZStart}
 
 	return json.Marshal(slp)
 }
@@ -180,6 +190,8 @@ func (plate *LHPlate) UnmarshalJSON(b []byte) error {
 		}
 
 		plate.HWells[w.ID] = w
+		w.Plate = plate
+		w.Platetype = plate.Type
 
 		// give w its properties back
 
@@ -212,6 +224,7 @@ type SLHWell struct {
 	Coords    string
 	Contents  []*LHComponent
 	Currvol   float64
+	Extra     map[string]interface{}
 }
 
 func (slw SLHWell) FillWell(lw *LHWell) {
@@ -222,6 +235,7 @@ func (slw SLHWell) FillWell(lw *LHWell) {
 	lw.Crds = slw.Coords
 	lw.WContents = slw.Contents
 	lw.Currvol = slw.Currvol
+	lw.Extra = slw.Extra
 	for _, c := range lw.WContents {
 		c.LContainer = lw
 	}
@@ -229,11 +243,20 @@ func (slw SLHWell) FillWell(lw *LHWell) {
 
 func (well *LHWell) MarshalJSON() ([]byte, error) {
 	// make sure we don't cause an infinite loop
-	for _, c := range well.WContents {
+	containers := make([]*LHWell, len(well.WContents))
+	for i, c := range well.WContents {
+		containers[i] = c.LContainer
 		c.LContainer = nil
 	}
-	slw := SLHWell{well.ID, well.Inst, well.Plateinst, well.Plateid, well.Crds, well.WContents, well.Currvol}
-	return json.Marshal(slw)
+	slw := SLHWell{well.ID, well.Inst, well.Plateinst, well.Plateid, well.Crds, well.WContents, well.Currvol, well.Extra}
+	b, err := json.Marshal(slw)
+
+	// and put things back as they were
+	for i, c := range well.WContents {
+		c.LContainer = containers[i]
+	}
+
+	return b, err
 }
 
 func (well *LHWell) UnmarshalJSON(ar []byte) error {
@@ -262,3 +285,52 @@ func (f *FromFactory) UnmarshalJSON(b []byte) error {
 	f.String = s
 	return nil
 }
+
+// the setup holds plates and tip boxes by position, which can be told
+// apart by what they have in them, and the list of tip boxes
+func (setup *LHSetup) UnmarshalJSON(b []byte) error {
+	var m map[string]json.RawMessage
+
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+
+	s := make(LHSetup, len(m))
+
+	for k, raw := range m {
+		var fields map[string]json.RawMessage
+		var v interface{}
+
+		if err := json.Unmarshal(raw, &fields); err == nil && fields != nil {
+			if _, ok := fields["Tiptype"]; ok {
+				v = &LHTipbox{}
+			} else if _, ok := fields["Wellcoords"]; ok {
+				v = &LHPlate{}
+			}
+		} else if k == "tip_lookup" {
+			v = &[]*LHTipbox{}
+		}
+
+		if v == nil {
+			if err := json.Unmarshal(raw, &v); err != nil {
+				return err
+			}
+			s[k] = v
+			continue
+		}
+
+		if err := json.Unmarshal(raw, v); err != nil {
+			return err
+		}
+
+		if tl, ok := v.(*[]*LHTipbox); ok {
+			s[k] = *tl
+		} else {
+			s[k] = v
+		}
+	}
+
+	*setup = s
+
+	return nil
+}
@@ -25,84 +25,92 @@ package wunit
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 )
 
-func (m *Volume) MarshalJSON() ([]byte, error) {
-	return []byte(fmt.Sprintf("\"%s\"", m.ToString())), nil
+// measurements are written as value and unit; unlike ToString nothing
+// is rounded off so they come back as they went
+// measurements without units are left empty
+func marshal_measurement(cm *ConcreteMeasurement) ([]byte, error) {
+	if cm.Munit == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(strconv.FormatFloat(cm.RawValue(), 'g', -1, 64) + " " + cm.Unit().PrefixedSymbol())
 }
 
-func (m *Volume) UnmarshalJSON(b []byte) error {
+// reads "12 ul" as well as the old "12.000ul" from ToString, and the
+// {"Mvalue": ..., "Munit": ...} which measurements held by value used to
+// be written as before they had a MarshalJSON of their own
+func unmarshal_measurement(b []byte) (value float64, unit string, ok bool, err error) {
+	if t := strings.TrimSpace(string(b)); strings.HasPrefix(t, "{") {
+		var cm ConcreteMeasurement
+		if err = json.Unmarshal(b, &cm); err != nil || cm.Munit == nil {
+			return
+		}
+		return cm.Mvalue, cm.Munit.PrefixedSymbol(), true, nil
+	}
+
 	var s string
-	if err := json.Unmarshal(b, &s); err != nil {
-		return err
+	if err = json.Unmarshal(b, &s); err != nil || s == "" {
+		return
 	}
 
-	var value float64
-	var unit string
-	if _, err := fmt.Fscanf(strings.NewReader(s), "%e%s", &value, &unit); err != nil {
-		return err
+	if _, err = fmt.Fscanf(strings.NewReader(s), "%e%s", &value, &unit); err != nil {
+		return
 	}
-	*m = NewVolume(value, unit)
-	return nil
-}
 
-func (m *Temperature) MarshalJSON() ([]byte, error) {
-	return []byte(fmt.Sprintf("\"%s\"", m.ToString())), nil
+	ok = true
+	return
+}
 
+func (m Volume) MarshalJSON() ([]byte, error) {
+	return marshal_measurement(&m.ConcreteMeasurement)
 }
 
-func (m *Temperature) UnmarshalJSON(b []byte) error {
-	var s string
-	if err := json.Unmarshal(b, &s); err != nil {
-		return err
+func (m *Volume) UnmarshalJSON(b []byte) error {
+	value, unit, ok, err := unmarshal_measurement(b)
+	if ok {
+		*m = NewVolume(value, unit)
 	}
+	return err
+}
 
-	var value float64
-	var unit string
-	if _, err := fmt.Fscanf(strings.NewReader(s), "%e%s", &value, &unit); err != nil {
-		return err
+func (m Temperature) MarshalJSON() ([]byte, error) {
+	return marshal_measurement(&m.ConcreteMeasurement)
+
+}
+
+func (m *Temperature) UnmarshalJSON(b []byte) error {
+	value, unit, ok, err := unmarshal_measurement(b)
+	if ok {
+		*m = NewTemperature(value, unit)
 	}
-	*m = NewTemperature(value, unit)
-	return nil
+	return err
 }
 
-func (m *Concentration) MarshalJSON() ([]byte, error) {
-	return []byte(fmt.Sprintf("\"%s\"", m.ToString())), nil
+func (m Concentration) MarshalJSON() ([]byte, error) {
+	return marshal_measurement(&m.ConcreteMeasurement)
 
 }
 
 func (m *Concentration) UnmarshalJSON(b []byte) error {
-	var s string
-	if err := json.Unmarshal(b, &s); err != nil {
-		return err
+	value, unit, ok, err := unmarshal_measurement(b)
+	if ok {
+		*m = NewConcentration(value, unit)
 	}
-
-	var value float64
-	var unit string
-	if _, err := fmt.Fscanf(strings.NewReader(s), "%e%s", &value, &unit); err != nil {
-		return err
-	}
-	*m = NewConcentration(value, unit)
-	return nil
+	return err
 }
 
-func (m *Time) MarshalJSON() ([]byte, error) {
-	return []byte(fmt.Sprintf("\"%s\"", m.ToString())), nil
+func (m Time) MarshalJSON() ([]byte, error) {
+	return marshal_measurement(&m.ConcreteMeasurement)
 
 }
 
 func (m *Time) UnmarshalJSON(b []byte) error {
-	var s string
-	if err := json.Unmarshal(b, &s); err != nil {
-		return err
-	}
-
-	var value float64
-	var unit string
-	if _, err := fmt.Fscanf(strings.NewReader(s), "%e%s", &value, &unit); err != nil {
-		return err
+	value, unit, ok, err := unmarshal_measurement(b)
+	if ok {
+		*m = NewTime(value, unit)
 	}
-	*m = NewTime(value, unit)
-	return nil
+	return err
 }
@@ -25,6 +25,7 @@ package wunit
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"testing"
 )
 
@@ -57,6 +58,34 @@ func TestJSON(*testing.T) {
 	ExampleNine()
 }
 
+func TestMeasurementJSON(t *testing.T) {
+	type holder struct {
+		V Volume
+		P *Volume
+	}
+
+	v := NewVolume(12.0, "ul")
+	b, err := json.Marshal(holder{V: v, P: &v})
+
+	if err != nil || string(b) != `{"V":"12 ul","P":"12 ul"}` {
+		t.Errorf("volumes should be written as value and unit whether or not they are pointers, got %s %v", b, err)
+	}
+
+	// and what was written before that can still be read
+
+	for _, s := range []string{
+		`"12 ul"`,
+		`"12.000ul"`,
+		`"0.012 ml"`,
+		`{"Mvalue":12,"Munit":{"StrName":"litre","StrSymbol":"l","FltConversionfactor":1,"StrBaseUnit":"l","SPrefix":{"Name":"u","Value":0.000001}}}`,
+	} {
+		var v2 Volume
+		if err := json.Unmarshal([]byte(s), &v2); err != nil || math.Abs(v2.ConvertTo(ParsePrefixedUnit("ul"))-12.0) > 0.000001 {
+			t.Errorf("%s should read as 12 ul, got %s %v", s, v2.ToString(), err)
+		}
+	}
+}
+
 func ExampleBasic() {
 	degreeC := GenericPrefixedUnit{GenericUnit{"DegreeC", "C", 1.0, "C"}, SIPrefix{"m", 1e-03}}
 	TdegreeC := Temperature{ConcreteMeasurement{1.0, &degreeC}}
@@ -612,7 +612,18 @@ func (p *compiler) addPreamble(decls []ast.Decl) {
 				TokPos: d.TokPos,
 				Tok:    token.ASSIGN,
 			}
-			d.Body.List = append([]ast.Stmt{s1, s2}, d.Body.List...)
+			stmts := []ast.Stmt{s1, s2}
+			// `defer _wrapper.SetError(&r.Error)` where there are results
+			// for a failed mix to spoil
+			if d.Tok != token.SETUP {
+				s3Call, _ := parser.ParseExpr("_wrapper.SetError(&r.Error)")
+				s3 := &ast.DeferStmt{
+					Defer: d.TokPos,
+					Call:  s3Call.(*ast.CallExpr),
+				}
+				stmts = append(stmts, s3)
+			}
+			d.Body.List = append(stmts, d.Body.List...)
 		default:
 			continue
 		}
@@ -37,6 +37,8 @@ func (e *TypeIISConstructAssembly) steps(p TypeIISConstructAssemblyParamBlock, r
 	_wrapper := execution.NewWrapper(p.ID)
 	_ = _wrapper
 
+	defer _wrapper.SetError(&r.Error)
+
 	samples := make([]*wtype.LHComponent, 0)
 	bufferSample := mixer.SampleForTotalVolume(p.Buffer, p.ReactionVolume)
 	samples = append(samples, bufferSample)
@@ -74,6 +76,8 @@ func (e *TypeIISConstructAssembly) analysis(p TypeIISConstructAssemblyParamBlock
 	_wrapper := execution.NewWrapper(p.ID)
 	_ = _wrapper
 
+	defer _wrapper.SetError(&r.Error)
+
 }
 
 // A block of tests to perform to validate that the sample was processed correctly
@@ -83,6 +87,8 @@ func (e *TypeIISConstructAssembly) validation(p TypeIISConstructAssemblyParamBlo
 	_wrapper := execution.NewWrapper(p.ID)
 	_ = _wrapper
 
+	defer _wrapper.SetError(&r.Error)
+
 }
 
 // AsyncBag functions
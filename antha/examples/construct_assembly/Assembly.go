@@ -190,7 +190,9 @@ func Steps(params Parameters, inputs Inputs) Outputs {
 	}
 
 	rq2.Tip_Type = inputs.TipType
-	liquidhandler.Run()
+	if err := liquidhandler.Run(); err != nil {
+		log.Fatal(err)
+	}
 
 	// incubate the reaction mixtures
 
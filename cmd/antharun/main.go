@@ -28,10 +28,19 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+
+	"github.com/antha-lang/antha/antha/anthalib/liquidhandling"
 )
 
 var (
+	checkpointFile string
+	estimateOnly   bool
+	explainFile    string
+	noOptimise     bool
+	exportDir      string
+	exportFormats  string
 	logFile        string
+	onFailure      string
 	parametersFile string
 	workflowFile   string
 )
@@ -59,6 +68,47 @@ func run() error {
 		return err
 	}
 
+	// instructions are exported as each liquid handling block is planned
+	if exportFormats != "" {
+		if cf.Config == nil {
+			cf.Config = make(map[string]interface{})
+		}
+		cf.Config["EXPORT_FORMATS"] = exportFormats
+		cf.Config["EXPORT_DIR"] = exportDir
+	}
+
+	if noOptimise {
+		if cf.Config == nil {
+			cf.Config = make(map[string]interface{})
+		}
+		cf.Config["OPTIMISE_INSTRUCTIONS"] = false
+	}
+
+	// a run which stops part way leaves a checkpoint here and the next
+	// one carries on from it
+	if checkpointFile != "" || onFailure != "" {
+		if cf.Config == nil {
+			cf.Config = make(map[string]interface{})
+		}
+		if checkpointFile != "" {
+			cf.Config["CHECKPOINT_FILE"] = checkpointFile
+		}
+		if onFailure != "" {
+			cf.Config["ON_FAILURE"] = onFailure
+		}
+	}
+
+	// estimates are printed as each liquid handling block is planned,
+	// nothing is run so there is no need for the frontend
+	if estimateOnly {
+		if cf.Config == nil {
+			cf.Config = make(map[string]interface{})
+		}
+		cf.Config["ESTIMATE_ONLY"] = true
+		_, err := wf.Run(cf)
+		return err
+	}
+
 	fmt.Println("Press [Enter] to load antha workflow with manual driver...")
 	fmt.Println("Reminder: press [Control-X] to exit the workflow interface")
 	if _, err := fmt.Scanln(); err != nil {
@@ -83,12 +133,46 @@ func run() error {
 	return nil
 }
 
+// prints which policy rules gave each instruction in a saved plan
+// its parameters
+func explain() error {
+	request, err := liquidhandling.LoadLHRequest(explainFile)
+	if err != nil {
+		return err
+	}
+
+	lhpes, err := liquidhandling.ExplainPolicies(request)
+	if err != nil {
+		return err
+	}
+
+	for _, lhpe := range lhpes {
+		fmt.Println(lhpe)
+	}
+
+	return nil
+}
+
 func main() {
 	flag.StringVar(&parametersFile, "parameters", "", "parameters to workflow")
 	flag.StringVar(&workflowFile, "workflow", "", "workflow definition file")
 	flag.StringVar(&logFile, "log", "", "log file")
+	flag.BoolVar(&estimateOnly, "estimate", false, "print time and consumables estimates instead of running")
+	flag.StringVar(&exportFormats, "export", "", "export liquid handling instructions in these formats, comma separated (csv, json, plan, tree, trace)")
+	flag.StringVar(&exportDir, "exportdir", ".", "directory for exported instructions")
+	flag.BoolVar(&noOptimise, "nooptimise", false, "leave liquid handling instructions as they are generated")
+	flag.StringVar(&explainFile, "explain", "", "explain the liquid handling policies used in a saved plan")
+	flag.StringVar(&checkpointFile, "checkpoint", "", "save liquid handling progress here if a run stops part way and carry on from it next time")
+	flag.StringVar(&onFailure, "onfailure", "", "what to do when a liquid handling instruction fails: abort, skip or ask")
 	flag.Parse()
 
+	if len(explainFile) != 0 {
+		if err := explain(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	if len(parametersFile) == 0 || len(workflowFile) == 0 {
 		flag.PrintDefaults()
 		os.Exit(1)